@@ -0,0 +1,264 @@
+package alerts
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// states of alerts
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is a notification of rule fired or resolved by series of metric, alerts of new condition are never
+// resolved
+type Alert struct {
+	Rule   string            `json:"rule"`
+	State  string            `json:"state"`
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+	Time   time.Time         `json:"timestamp"`
+}
+
+// Notifier delivers alerts, e.g. into log, webhook or kafka topic
+type Notifier interface {
+	Notify(a *Alert) error
+}
+
+// Engine periodically evaluates rules against metrics of gatherer and notifies about alerts
+type Engine struct {
+	gatherer  prometheus.Gatherer
+	rules     []Rule
+	notifiers []Notifier
+
+	states []*ruleState
+}
+
+// ruleState is a state of rule between evaluations by key of series
+type ruleState struct {
+	// seen are series of the previous evaluation, it's nil before the first evaluation
+	seen map[string]bool
+
+	firing  map[string]*Alert
+	samples map[string][]sample
+}
+
+// sample is a value of counter series at the time of evaluation
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// series is a value of metric with labels
+type series struct {
+	key    string
+	labels map[string]string
+	value  float64
+}
+
+// NewEngine creates new Engine
+func NewEngine(gatherer prometheus.Gatherer, rules []Rule, notifiers []Notifier) *Engine {
+	e := &Engine{
+		gatherer:  gatherer,
+		rules:     rules,
+		notifiers: notifiers,
+		states:    make([]*ruleState, len(rules)),
+	}
+	for i := range e.states {
+		e.states[i] = &ruleState{
+			firing:  make(map[string]*Alert),
+			samples: make(map[string][]sample),
+		}
+	}
+	return e
+}
+
+// Run evaluates rules every interval until ctx is done
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.Evaluate(now)
+		}
+	}
+}
+
+// Evaluate evaluates all rules at the time and notifies about fired and resolved alerts. Series which exist at
+// the first evaluation aren't considered new.
+func (e *Engine) Evaluate(now time.Time) {
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		log.Printf("alerts: could not gather all metrics: %s", err)
+	}
+
+	families := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		families[mf.GetName()] = mf
+	}
+
+	for i := range e.rules {
+		rule, state := &e.rules[i], e.states[i]
+		current := matchingSeries(families[rule.Metric], rule.Labels)
+
+		switch rule.Condition {
+		case ConditionNew:
+			e.evaluateNew(rule, state, current, now)
+		case ConditionValue:
+			e.evaluateThreshold(rule, state, current, now, func(s series) (float64, bool) { return s.value, true })
+		case ConditionRate:
+			e.evaluateThreshold(rule, state, current, now, func(s series) (float64, bool) {
+				return state.rate(s, rule.Window, now)
+			})
+		}
+	}
+}
+
+// evaluateNew fires alert for every series which wasn't seen by the previous evaluation
+func (e *Engine) evaluateNew(rule *Rule, state *ruleState, current []series, now time.Time) {
+	seen := make(map[string]bool, len(current))
+	for _, s := range current {
+		seen[s.key] = true
+		if state.seen != nil && !state.seen[s.key] {
+			e.notify(&Alert{Rule: rule.Name, State: StateFiring, Metric: rule.Metric, Labels: s.labels, Value: s.value, Time: now})
+		}
+	}
+	state.seen = seen
+}
+
+// evaluateThreshold fires alert when value of series exceeds thresholds and resolves it when value returns
+// back or series disappears
+func (e *Engine) evaluateThreshold(rule *Rule, state *ruleState, current []series, now time.Time, value func(s series) (float64, bool)) {
+	seen := make(map[string]bool, len(current))
+	for _, s := range current {
+		seen[s.key] = true
+
+		v, ok := value(s)
+		if !ok {
+			continue
+		}
+
+		alert, firing := state.firing[s.key]
+		switch {
+		case rule.exceeds(v) && !firing:
+			alert = &Alert{Rule: rule.Name, State: StateFiring, Metric: rule.Metric, Labels: s.labels, Value: v, Time: now}
+			state.firing[s.key] = alert
+			e.notify(alert)
+		case !rule.exceeds(v) && firing:
+			delete(state.firing, s.key)
+			e.notify(&Alert{Rule: rule.Name, State: StateResolved, Metric: rule.Metric, Labels: alert.Labels, Value: v, Time: now})
+		}
+	}
+
+	for key, alert := range state.firing {
+		if !seen[key] {
+			delete(state.firing, key)
+			e.notify(&Alert{Rule: rule.Name, State: StateResolved, Metric: rule.Metric, Labels: alert.Labels, Value: alert.Value, Time: now})
+		}
+	}
+	for key := range state.samples {
+		if !seen[key] {
+			delete(state.samples, key)
+		}
+	}
+}
+
+// rate returns per-second rate of counter series during window, it's false until there are two samples.
+// Samples are reset when counter is reset.
+func (s *ruleState) rate(cur series, window time.Duration, now time.Time) (float64, bool) {
+	samples := s.samples[cur.key]
+	if len(samples) > 0 && cur.value < samples[len(samples)-1].value {
+		samples = nil
+	}
+
+	// the oldest sample is kept at or before the start of window
+	since := now.Add(-window)
+	i := 0
+	for i+1 < len(samples) && !samples[i+1].time.After(since) {
+		i++
+	}
+	samples = append(samples[i:], sample{value: cur.value, time: now})
+	s.samples[cur.key] = samples
+
+	if len(samples) < 2 {
+		return 0, false
+	}
+
+	first := samples[0]
+	return (cur.value - first.value) / now.Sub(first.time).Seconds(), true
+}
+
+func (e *Engine) notify(alert *Alert) {
+	for _, n := range e.notifiers {
+		if err := n.Notify(alert); err != nil {
+			log.Printf("alerts: could not notify about %s: %s", alert.Rule, err)
+		}
+	}
+}
+
+// matchingSeries returns series of metric family with labels, counts of observations are values of histograms
+// and summaries
+func matchingSeries(mf *dto.MetricFamily, labels map[string]string) []series {
+	if mf == nil {
+		return nil
+	}
+
+	var out []series
+	for _, m := range mf.Metric {
+		values := make(map[string]string, len(m.Label))
+		for _, p := range m.Label {
+			values[p.GetName()] = p.GetValue()
+		}
+
+		matches := true
+		for name, value := range labels {
+			if values[name] != value {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		var value float64
+		switch {
+		case m.Gauge != nil:
+			value = m.Gauge.GetValue()
+		case m.Counter != nil:
+			value = m.Counter.GetValue()
+		case m.Untyped != nil:
+			value = m.Untyped.GetValue()
+		case m.Histogram != nil:
+			value = float64(m.Histogram.GetSampleCount())
+		case m.Summary != nil:
+			value = float64(m.Summary.GetSampleCount())
+		}
+
+		out = append(out, series{key: seriesKey(values), labels: values, value: value})
+	}
+
+	return out
+}
+
+// seriesKey returns key of series by its labels
+func seriesKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,186 @@
+package alerts
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const testMetric = "kafka_sniffer_test"
+
+// recorder is a notifier recording alerts
+type recorder struct {
+	alerts []*Alert
+}
+
+func (r *recorder) Notify(a *Alert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+// evaluation is a state of metric at the time since the first evaluation and alerts it must notify about
+type evaluation struct {
+	at time.Duration
+
+	// values are values of series by client_ip label
+	values map[string]float64
+
+	// want are alerts formatted as state, client_ip and value
+	want []string
+}
+
+// testGatherer returns gatherer of metric with series of values, series are counters or gauges
+func testGatherer(values *map[string]float64, counter bool) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mf := &dto.MetricFamily{Name: proto.String(testMetric), Type: dto.MetricType_GAUGE.Enum()}
+		if counter {
+			mf.Type = dto.MetricType_COUNTER.Enum()
+		}
+
+		for client, value := range *values {
+			m := &dto.Metric{Label: []*dto.LabelPair{
+				{Name: proto.String("client_ip"), Value: proto.String(client)},
+				{Name: proto.String("topic"), Value: proto.String("payments")},
+			}}
+			if counter {
+				m.Counter = &dto.Counter{Value: proto.Float64(value)}
+			} else {
+				m.Gauge = &dto.Gauge{Value: proto.Float64(value)}
+			}
+			mf.Metric = append(mf.Metric, m)
+		}
+
+		return []*dto.MetricFamily{mf}, nil
+	})
+}
+
+func threshold(v float64) *float64 {
+	return &v
+}
+
+func TestEvaluate(t *testing.T) {
+	cases := []struct {
+		name        string
+		rule        Rule
+		counter     bool
+		evaluations []evaluation
+	}{
+		{
+			name: "new series",
+			rule: Rule{Condition: ConditionNew},
+			evaluations: []evaluation{
+				// series of the first evaluation aren't new
+				{at: 0, values: map[string]float64{"a": 1}},
+				{at: time.Minute, values: map[string]float64{"a": 1, "b": 2}, want: []string{"firing b 2.00"}},
+				{at: 2 * time.Minute, values: map[string]float64{"b": 2}},
+				// series which returns after it has disappeared is new again
+				{at: 3 * time.Minute, values: map[string]float64{"a": 3, "b": 2}, want: []string{"firing a 3.00"}},
+			},
+		},
+		{
+			name: "value above threshold",
+			rule: Rule{Condition: ConditionValue, Above: threshold(10)},
+			evaluations: []evaluation{
+				{at: 0, values: map[string]float64{"a": 5}},
+				{at: time.Minute, values: map[string]float64{"a": 20}, want: []string{"firing a 20.00"}},
+				{at: 2 * time.Minute, values: map[string]float64{"a": 30}},
+				{at: 3 * time.Minute, values: map[string]float64{"a": 10}, want: []string{"resolved a 10.00"}},
+			},
+		},
+		{
+			name: "value below threshold of series with labels",
+			rule: Rule{Condition: ConditionValue, Labels: map[string]string{"client_ip": "a", "topic": "payments"}, Below: threshold(1)},
+			evaluations: []evaluation{
+				{at: 0, values: map[string]float64{"a": 0, "b": 0}, want: []string{"firing a 0.00"}},
+				{at: time.Minute, values: map[string]float64{"a": 2, "b": 0}, want: []string{"resolved a 2.00"}},
+			},
+		},
+		{
+			name:    "rate",
+			rule:    Rule{Condition: ConditionRate, Window: time.Minute, Above: threshold(1)},
+			counter: true,
+			evaluations: []evaluation{
+				// rate needs two samples
+				{at: 0, values: map[string]float64{"a": 0}},
+				{at: 30 * time.Second, values: map[string]float64{"a": 60}, want: []string{"firing a 2.00"}},
+				{at: time.Minute, values: map[string]float64{"a": 90}},
+				// the oldest sample is at the start of window
+				{at: 90 * time.Second, values: map[string]float64{"a": 100}, want: []string{"resolved a 0.67"}},
+			},
+		},
+		{
+			name:    "rate of reset counter",
+			rule:    Rule{Condition: ConditionRate, Window: time.Minute, Below: threshold(1)},
+			counter: true,
+			evaluations: []evaluation{
+				{at: 0, values: map[string]float64{"a": 100}},
+				{at: 30 * time.Second, values: map[string]float64{"a": 160}},
+				// decrease of counter is a reset, not a negative rate
+				{at: time.Minute, values: map[string]float64{"a": 10}},
+				{at: 90 * time.Second, values: map[string]float64{"a": 70}},
+				{at: 2 * time.Minute, values: map[string]float64{"a": 75}},
+				{at: 150 * time.Second, values: map[string]float64{"a": 80}, want: []string{"firing a 0.17"}},
+			},
+		},
+		{
+			name: "disappeared series",
+			rule: Rule{Condition: ConditionValue, Above: threshold(10)},
+			evaluations: []evaluation{
+				{at: 0, values: map[string]float64{"a": 20, "b": 30}, want: []string{"firing a 20.00", "firing b 30.00"}},
+				// alert is resolved with the last value of series
+				{at: time.Minute, values: map[string]float64{"b": 30}, want: []string{"resolved a 20.00"}},
+				{at: 2 * time.Minute, values: map[string]float64{}, want: []string{"resolved b 30.00"}},
+				{at: 3 * time.Minute, values: map[string]float64{"a": 20}, want: []string{"firing a 20.00"}},
+			},
+		},
+		{
+			name:    "rate of disappeared series",
+			rule:    Rule{Condition: ConditionRate, Window: time.Minute, Above: threshold(1)},
+			counter: true,
+			evaluations: []evaluation{
+				{at: 0, values: map[string]float64{"a": 0}},
+				{at: 30 * time.Second, values: map[string]float64{"a": 60}, want: []string{"firing a 2.00"}},
+				{at: time.Minute, values: map[string]float64{}, want: []string{"resolved a 2.00"}},
+				// samples of disappeared series are forgotten
+				{at: 90 * time.Second, values: map[string]float64{"a": 0}},
+				{at: 2 * time.Minute, values: map[string]float64{"a": 90}, want: []string{"firing a 3.00"}},
+			},
+		},
+	}
+
+	start := time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rule := c.rule
+			rule.Name, rule.Metric = c.name, testMetric
+
+			var values map[string]float64
+			rec := &recorder{}
+			e := NewEngine(testGatherer(&values, c.counter), []Rule{rule}, []Notifier{rec})
+
+			for _, ev := range c.evaluations {
+				values = ev.values
+				rec.alerts = nil
+				e.Evaluate(start.Add(ev.at))
+
+				var got []string
+				for _, a := range rec.alerts {
+					if a.Rule != c.name || a.Metric != testMetric || !a.Time.Equal(start.Add(ev.at)) {
+						t.Errorf("%s: unexpected alert %+v", ev.at, a)
+					}
+					got = append(got, fmt.Sprintf("%s %s %.2f", a.State, a.Labels["client_ip"], a.Value))
+				}
+				sort.Strings(got)
+
+				if fmt.Sprint(got) != fmt.Sprint(ev.want) {
+					t.Errorf("%s: got alerts %v, want %v", ev.at, got, ev.want)
+				}
+			}
+		})
+	}
+}
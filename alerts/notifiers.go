@@ -0,0 +1,103 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// webhookTimeout limits delivery of alert to webhook
+const webhookTimeout = 10 * time.Second
+
+// LogNotifier logs alerts
+type LogNotifier struct{}
+
+// Notify implements Notifier
+func (LogNotifier) Notify(a *Alert) error {
+	labels := make([]string, 0, len(a.Labels))
+	for name, value := range a.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(labels)
+
+	log.Printf("alert %s %s: %s{%s} = %g", a.Rule, a.State, a.Metric, strings.Join(labels, ","), a.Value)
+	return nil
+}
+
+// WebhookNotifier posts alerts as JSON to webhook URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates new WebhookNotifier
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// KafkaNotifier produces alerts as JSON messages into kafka topic, messages are keyed by rule name
+type KafkaNotifier struct {
+	topic    string
+	producer sarama.SyncProducer
+}
+
+// NewKafkaNotifier creates new KafkaNotifier
+func NewKafkaNotifier(brokers []string, topic string) (*KafkaNotifier, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-sniffer"
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Return.Successes = true
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaNotifier{topic: topic, producer: producer}, nil
+}
+
+// Notify implements Notifier
+func (n *KafkaNotifier) Notify(a *Alert) error {
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = n.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: n.topic,
+		Key:   sarama.StringEncoder(a.Rule),
+		Value: sarama.ByteEncoder(value),
+	})
+	return err
+}
+
+// Close closes producer
+func (n *KafkaNotifier) Close() error {
+	return n.producer.Close()
+}
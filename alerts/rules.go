@@ -0,0 +1,132 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// conditions of rules
+const (
+	// ConditionNew fires once for every new series of metric, e.g. a new producer of topic
+	ConditionNew = "new"
+
+	// ConditionValue fires while value of series is above or below threshold
+	ConditionValue = "value"
+
+	// ConditionRate fires while per-second rate of counter series during window is above or below threshold
+	ConditionRate = "rate"
+)
+
+// defaultRateWindow is a window of rate condition if it isn't set
+const defaultRateWindow = 5 * time.Minute
+
+// Rule is an alert rule evaluated against series of sniffer metric matching labels
+type Rule struct {
+	Name string
+
+	// Metric is a full name of metric, e.g. kafka_sniffer_producer_topic_relation_info, counts of observations
+	// are evaluated for histograms and summaries
+	Metric string
+
+	// Labels are values of labels series must have, all series of metric are evaluated if it's empty
+	Labels map[string]string
+
+	Condition string
+	Window    time.Duration
+
+	// Above and Below are thresholds of value and rate conditions, any of them may be nil
+	Above *float64
+	Below *float64
+}
+
+// ruleJSON is a rule in rules file, window is a duration string, e.g. 5m
+type ruleJSON struct {
+	Name      string            `json:"name"`
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels"`
+	Condition string            `json:"condition"`
+	Window    string            `json:"window"`
+	Above     *float64          `json:"above"`
+	Below     *float64          `json:"below"`
+}
+
+// LoadRules loads JSON array of rules, e.g.:
+//
+//	[
+//	  {"name": "new payments producer", "metric": "kafka_sniffer_producer_topic_relation_info",
+//	   "labels": {"topic": "payments"}, "condition": "new"},
+//	  {"name": "unexpected access", "metric": "kafka_sniffer_acl_unexpected_access_total",
+//	   "condition": "rate", "window": "5m", "above": 0},
+//	  {"name": "produce errors", "metric": "kafka_sniffer_response_errors_total",
+//	   "labels": {"request_type": "produce"}, "condition": "rate", "window": "5m", "above": 0.1},
+//	  {"name": "consumer lag", "metric": "kafka_sniffer_consumer_lag_estimate",
+//	   "condition": "value", "above": 10000}
+//	]
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []ruleJSON
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse rules: %s", err)
+	}
+
+	rules := make([]Rule, 0, len(raw))
+	for i, r := range raw {
+		rule := Rule{
+			Name:      r.Name,
+			Metric:    r.Metric,
+			Labels:    r.Labels,
+			Condition: r.Condition,
+			Above:     r.Above,
+			Below:     r.Below,
+		}
+
+		if r.Window != "" {
+			if rule.Window, err = time.ParseDuration(r.Window); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid window: %s", i, err)
+			}
+		}
+		if err = rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %s", i, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// validate validates rule and sets defaults
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("metric is required")
+	}
+
+	switch r.Condition {
+	case ConditionNew:
+	case ConditionValue, ConditionRate:
+		if r.Above == nil && r.Below == nil {
+			return fmt.Errorf("%s condition requires above or below threshold", r.Condition)
+		}
+		if r.Condition == ConditionRate && r.Window == 0 {
+			r.Window = defaultRateWindow
+		}
+	default:
+		return fmt.Errorf("unknown condition %q, expected new, value or rate", r.Condition)
+	}
+
+	return nil
+}
+
+// exceeds returns true if value is above or below thresholds of the rule
+func (r *Rule) exceeds(value float64) bool {
+	return r.Above != nil && value > *r.Above || r.Below != nil && value < *r.Below
+}
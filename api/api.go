@@ -0,0 +1,334 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// Prefix is a path prefix of all API endpoints
+const Prefix = "/api/v1/"
+
+// ClientTopics is a client with topics it talks to
+type ClientTopics struct {
+	ClientIP string      `json:"client_ip"`
+	Topics   []TopicSeen `json:"topics"`
+}
+
+// TopicSeen is a topic with the last time the client was seen talking to it, principal is a user the client
+// is authenticated as
+type TopicSeen struct {
+	Topic      string    `json:"topic"`
+	Principal  string    `json:"principal,omitempty"`
+	Connection string    `json:"connection"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// TopicClients is a topic with its producers and consumers
+type TopicClients struct {
+	Topic     string       `json:"topic"`
+	Producers []ClientSeen `json:"producers"`
+	Consumers []ClientSeen `json:"consumers"`
+}
+
+// ClientSeen is a client with the last time it was seen talking to the topic
+type ClientSeen struct {
+	ClientIP   string    `json:"client_ip"`
+	Principal  string    `json:"principal,omitempty"`
+	Connection string    `json:"connection"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// ClientVersions is a client with versions of apis it uses, deprecated is true if any of versions is deprecated
+type ClientVersions struct {
+	ClientIP   string        `json:"client_ip"`
+	ClientID   string        `json:"client_id"`
+	Deprecated bool          `json:"deprecated"`
+	APIs       []APIVersions `json:"apis"`
+}
+
+// APIVersions are the minimal and maximal versions of api used by client with its deprecated versions and the
+// last time the api was used
+type APIVersions struct {
+	API        string    `json:"api"`
+	MinVersion int16     `json:"min_version"`
+	MaxVersion int16     `json:"max_version"`
+	Deprecated []int16   `json:"deprecated,omitempty"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// TopicActivity is a topic with the last times it was produced to and fetched from by any client and by every
+// client, times are null if the topic wasn't produced to or fetched from during retention of activity
+type TopicActivity struct {
+	Topic        string           `json:"topic"`
+	LastProduced *time.Time       `json:"last_produced"`
+	LastFetched  *time.Time       `json:"last_fetched"`
+	Producers    []ClientActivity `json:"producers"`
+	Consumers    []ClientActivity `json:"consumers"`
+}
+
+// ClientActivity is a client with the last time it was producing to or fetching from the topic
+type ClientActivity struct {
+	ClientIP string    `json:"client_ip"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Capabilities are api keys known by sniffer with versions of decoded ones
+type Capabilities struct {
+	APIKeys []kafka.APIKey `json:"api_keys"`
+}
+
+// Handler serves current producers, consumers and topics relations as JSON
+type Handler struct {
+	storage *metrics.Storage
+	mux     *http.ServeMux
+}
+
+// NewHandler creates new Handler
+func NewHandler(storage *metrics.Storage) *Handler {
+	h := &Handler{storage: storage, mux: http.NewServeMux()}
+
+	h.mux.HandleFunc(Prefix+"producers", h.producers)
+	h.mux.HandleFunc(Prefix+"consumers", h.consumers)
+	h.mux.HandleFunc(Prefix+"topics", h.topics)
+	h.mux.HandleFunc(Prefix+"versions", h.versions)
+	h.mux.HandleFunc(Prefix+"capabilities", h.capabilities)
+	h.mux.HandleFunc(Prefix+"activity", h.activity)
+
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) producers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, groupByClient(h.storage.ProducerTopicRelations()))
+}
+
+func (h *Handler) consumers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, groupByClient(h.storage.ConsumerTopicRelations()))
+}
+
+func (h *Handler) topics(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, Topics(h.storage))
+}
+
+// versions returns versions of apis used by clients, only clients using deprecated versions are returned with
+// deprecated=true parameter
+func (h *Handler) versions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, Versions(h.storage, r.FormValue("deprecated") == "true"))
+}
+
+// activity returns the last activity of topics, only topics idle for at least idle parameter (e.g. 72h) are
+// returned if it's set
+func (h *Handler) activity(w http.ResponseWriter, r *http.Request) {
+	var idle time.Duration
+	if s := r.FormValue("idle"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid idle "+strconv.Quote(s), http.StatusBadRequest)
+			return
+		}
+		idle = d
+	}
+
+	writeJSON(w, Activity(h.storage, idle, time.Now()))
+}
+
+func (h *Handler) capabilities(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, Capabilities{APIKeys: kafka.APIKeys})
+}
+
+// NewTopHandler creates handler serving the last report of top topics and clients as JSON
+func NewTopHandler(top *metrics.TopN) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, top.Last())
+	})
+}
+
+// DashboardPath is a path of Grafana dashboard of sniffer metrics
+const DashboardPath = "/dashboard.json"
+
+// NewDashboardHandler creates handler serving Grafana dashboard generated for metrics enabled by options
+func NewDashboardHandler(opts metrics.DashboardOptions) http.Handler {
+	dashboard := metrics.NewDashboard(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, dashboard)
+	})
+}
+
+// Topics returns current topics of storage with their producers and consumers sorted by name and client ip
+func Topics(storage *metrics.Storage) []*TopicClients {
+	topics := make(map[string]*TopicClients)
+	topic := func(name string) *TopicClients {
+		t, ok := topics[name]
+		if !ok {
+			t = &TopicClients{Topic: name, Producers: []ClientSeen{}, Consumers: []ClientSeen{}}
+			topics[name] = t
+		}
+		return t
+	}
+
+	for _, r := range storage.ProducerTopicRelations() {
+		t := topic(r.Topic)
+		t.Producers = append(t.Producers, ClientSeen{ClientIP: r.ClientIP, Principal: r.Principal, Connection: r.Connection, LastSeen: r.LastSeen})
+	}
+	for _, r := range storage.ConsumerTopicRelations() {
+		t := topic(r.Topic)
+		t.Consumers = append(t.Consumers, ClientSeen{ClientIP: r.ClientIP, Principal: r.Principal, Connection: r.Connection, LastSeen: r.LastSeen})
+	}
+
+	out := make([]*TopicClients, 0, len(topics))
+	for _, t := range topics {
+		sort.Slice(t.Producers, func(i, j int) bool { return t.Producers[i].ClientIP < t.Producers[j].ClientIP })
+		sort.Slice(t.Consumers, func(i, j int) bool { return t.Consumers[i].ClientIP < t.Consumers[j].ClientIP })
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+
+	return out
+}
+
+// Versions returns versions of apis used by clients sorted by client ip, client id and api, only clients using
+// deprecated versions are returned if deprecatedOnly is true
+func Versions(storage *metrics.Storage, deprecatedOnly bool) []*ClientVersions {
+	type clientKey struct{ ip, id string }
+
+	clients := make(map[clientKey]*ClientVersions)
+	apis := make(map[clientKey]map[string]*APIVersions)
+	for _, r := range storage.ClientAPIVersionRelations() {
+		key := clientKey{r.ClientIP, r.ClientID}
+		c, ok := clients[key]
+		if !ok {
+			c = &ClientVersions{ClientIP: r.ClientIP, ClientID: r.ClientID}
+			clients[key] = c
+			apis[key] = make(map[string]*APIVersions)
+		}
+
+		a, ok := apis[key][r.API]
+		if !ok {
+			a = &APIVersions{API: r.API, MinVersion: r.Version, MaxVersion: r.Version}
+			apis[key][r.API] = a
+		}
+		if r.Version < a.MinVersion {
+			a.MinVersion = r.Version
+		}
+		if r.Version > a.MaxVersion {
+			a.MaxVersion = r.Version
+		}
+		if r.Deprecated {
+			a.Deprecated = append(a.Deprecated, r.Version)
+			c.Deprecated = true
+		}
+		if r.LastSeen.After(a.LastSeen) {
+			a.LastSeen = r.LastSeen
+		}
+	}
+
+	out := make([]*ClientVersions, 0, len(clients))
+	for key, c := range clients {
+		if deprecatedOnly && !c.Deprecated {
+			continue
+		}
+		for _, a := range apis[key] {
+			sort.Slice(a.Deprecated, func(i, j int) bool { return a.Deprecated[i] < a.Deprecated[j] })
+			c.APIs = append(c.APIs, *a)
+		}
+		sort.Slice(c.APIs, func(i, j int) bool { return c.APIs[i].API < c.APIs[j].API })
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ClientIP != out[j].ClientIP {
+			return out[i].ClientIP < out[j].ClientIP
+		}
+		return out[i].ClientID < out[j].ClientID
+	})
+
+	return out
+}
+
+// Activity returns the last activity of topics of storage sorted by name with their clients sorted by client ip,
+// only topics without requests since now-idle are returned if idle isn't zero
+func Activity(storage *metrics.Storage, idle time.Duration, now time.Time) []*TopicActivity {
+	topics := make(map[string]*TopicActivity)
+	topic := func(name string) *TopicActivity {
+		t, ok := topics[name]
+		if !ok {
+			t = &TopicActivity{Topic: name, Producers: []ClientActivity{}, Consumers: []ClientActivity{}}
+			topics[name] = t
+		}
+		return t
+	}
+
+	for _, a := range storage.TopicActivity() {
+		t, seen := topic(a.Topic), a.LastSeen
+		if a.Role == "producer" {
+			t.LastProduced = &seen
+		} else {
+			t.LastFetched = &seen
+		}
+	}
+	for _, a := range storage.ClientTopicActivity() {
+		t := topic(a.Topic)
+		if a.Role == "producer" {
+			t.Producers = append(t.Producers, ClientActivity{ClientIP: a.ClientIP, LastSeen: a.LastSeen})
+		} else {
+			t.Consumers = append(t.Consumers, ClientActivity{ClientIP: a.ClientIP, LastSeen: a.LastSeen})
+		}
+	}
+
+	out := make([]*TopicActivity, 0, len(topics))
+	for _, t := range topics {
+		if idle > 0 && !t.idleSince(now.Add(-idle)) {
+			continue
+		}
+		sort.Slice(t.Producers, func(i, j int) bool { return t.Producers[i].ClientIP < t.Producers[j].ClientIP })
+		sort.Slice(t.Consumers, func(i, j int) bool { return t.Consumers[i].ClientIP < t.Consumers[j].ClientIP })
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+
+	return out
+}
+
+// idleSince returns true if topic wasn't produced to or fetched from since the time
+func (t *TopicActivity) idleSince(since time.Time) bool {
+	return (t.LastProduced == nil || t.LastProduced.Before(since)) && (t.LastFetched == nil || t.LastFetched.Before(since))
+}
+
+// groupByClient groups relations by client ip
+func groupByClient(relations []metrics.Relation) []*ClientTopics {
+	clients := make(map[string]*ClientTopics)
+	for _, r := range relations {
+		c, ok := clients[r.ClientIP]
+		if !ok {
+			c = &ClientTopics{ClientIP: r.ClientIP}
+			clients[r.ClientIP] = c
+		}
+		c.Topics = append(c.Topics, TopicSeen{Topic: r.Topic, Principal: r.Principal, Connection: r.Connection, LastSeen: r.LastSeen})
+	}
+
+	out := make([]*ClientTopics, 0, len(clients))
+	for _, c := range clients {
+		sort.Slice(c.Topics, func(i, j int) bool { return c.Topics[i].Topic < c.Topics[j].Topic })
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ClientIP < out[j].ClientIP })
+
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("could not write API response: %s\n", err)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+// Comparison is a difference of clients and topics observed by sniffers of old and new clusters during
+// migration of clients from the old cluster to the new one
+type Comparison struct {
+	// Remaining are clients still talking to the old cluster, they are to be switched to the new one
+	Remaining []*MigrationClient `json:"remaining"`
+
+	// Migrated are clients talking to the new cluster only
+	Migrated []*MigrationClient `json:"migrated"`
+
+	// OldTopics are topics used on the old cluster only, NewTopics are topics used on the new cluster only
+	OldTopics []string `json:"old_topics"`
+	NewTopics []string `json:"new_topics"`
+}
+
+// MigrationClient is a client with its topics on a cluster
+type MigrationClient struct {
+	ClientIP  string `json:"client_ip"`
+	Principal string `json:"principal,omitempty"`
+
+	// Produces and Consumes are topics the client produces to and consumes from
+	Produces []string `json:"produces,omitempty"`
+	Consumes []string `json:"consumes,omitempty"`
+
+	LastSeen time.Time `json:"last_seen"`
+
+	// OnBoth is true for remaining clients which talk to the new cluster too, e.g. consumers draining topics
+	// of the old cluster
+	OnBoth bool `json:"on_both"`
+}
+
+// Compare compares topics with their producers and consumers reported by sniffers of old and new clusters,
+// e.g. by /api/v1/topics. Clients are identified by addresses, inter-broker and replication connections are
+// skipped.
+func Compare(oldTopics, newTopics []*TopicClients) *Comparison {
+	oldClients, newClients := migrationClients(oldTopics), migrationClients(newTopics)
+
+	c := &Comparison{
+		Remaining: []*MigrationClient{},
+		Migrated:  []*MigrationClient{},
+		OldTopics: topicsDiff(oldTopics, newTopics),
+		NewTopics: topicsDiff(newTopics, oldTopics),
+	}
+	for ip, client := range oldClients {
+		_, client.OnBoth = newClients[ip]
+		c.Remaining = append(c.Remaining, client)
+	}
+	for ip, client := range newClients {
+		if _, ok := oldClients[ip]; !ok {
+			c.Migrated = append(c.Migrated, client)
+		}
+	}
+
+	sort.Slice(c.Remaining, func(i, j int) bool { return c.Remaining[i].ClientIP < c.Remaining[j].ClientIP })
+	sort.Slice(c.Migrated, func(i, j int) bool { return c.Migrated[i].ClientIP < c.Migrated[j].ClientIP })
+
+	return c
+}
+
+// migrationClients groups relations of topics by clients
+func migrationClients(topics []*TopicClients) map[string]*MigrationClient {
+	clients := make(map[string]*MigrationClient)
+	client := func(seen ClientSeen) *MigrationClient {
+		c, ok := clients[seen.ClientIP]
+		if !ok {
+			c = &MigrationClient{ClientIP: seen.ClientIP}
+			clients[seen.ClientIP] = c
+		}
+		if c.Principal == "" {
+			c.Principal = seen.Principal
+		}
+		if seen.LastSeen.After(c.LastSeen) {
+			c.LastSeen = seen.LastSeen
+		}
+		return c
+	}
+
+	for _, t := range topics {
+		for _, seen := range t.Producers {
+			if isClientConnection(seen) {
+				c := client(seen)
+				c.Produces = append(c.Produces, t.Topic)
+			}
+		}
+		for _, seen := range t.Consumers {
+			if isClientConnection(seen) {
+				c := client(seen)
+				c.Consumes = append(c.Consumes, t.Topic)
+			}
+		}
+	}
+
+	for _, c := range clients {
+		sort.Strings(c.Produces)
+		sort.Strings(c.Consumes)
+	}
+
+	return clients
+}
+
+// isClientConnection returns false for connections between brokers
+func isClientConnection(seen ClientSeen) bool {
+	return seen.Connection != string(stream.ConnectionInterBroker) && seen.Connection != string(stream.ConnectionReplication)
+}
+
+// topicsDiff returns sorted topics of a missing in b
+func topicsDiff(a, b []*TopicClients) []string {
+	set := make(map[string]bool, len(b))
+	for _, t := range b {
+		set[t.Topic] = true
+	}
+
+	out := []string{}
+	for _, t := range a {
+		if !set[t.Topic] {
+			out = append(out, t.Topic)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
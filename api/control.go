@@ -0,0 +1,175 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+// ControlPrefix is a path prefix of all control API endpoints
+const ControlPrefix = "/control/v1/"
+
+// Controls are settings of sniffer changed at runtime by control API
+type Controls struct {
+	Verbose *stream.Switch
+	Topics  *stream.TopicFilter
+
+	// SampleRate is the current sample rate, it's changed by SetSampleRate, e.g. in cooperation with watchdog
+	SampleRate    *stream.SampleRate
+	SetSampleRate func(n int)
+
+	// Storage is dumped by dump endpoint and saved into state file by Persister if it isn't nil
+	Storage   *metrics.Storage
+	Persister *metrics.Persister
+}
+
+// ControlStatus is a current state of runtime settings
+type ControlStatus struct {
+	Verbose       bool     `json:"verbose"`
+	SampleRate    string   `json:"sample_rate"`
+	TopicsInclude []string `json:"topics_include"`
+	TopicsExclude []string `json:"topics_exclude"`
+}
+
+// ControlHandler serves control API changing runtime settings of sniffer, requests are authenticated by bearer
+// token
+type ControlHandler struct {
+	token    string
+	controls Controls
+	mux      *http.ServeMux
+}
+
+// NewControlHandler creates new ControlHandler authenticating requests with token
+func NewControlHandler(token string, controls Controls) *ControlHandler {
+	h := &ControlHandler{token: token, controls: controls, mux: http.NewServeMux()}
+
+	h.mux.HandleFunc(ControlPrefix+"status", h.status)
+	h.mux.HandleFunc(ControlPrefix+"verbose", h.verbose)
+	h.mux.HandleFunc(ControlPrefix+"sample", h.sample)
+	h.mux.HandleFunc(ControlPrefix+"topics/include", h.topics(h.controls.Topics.Include, h.controls.Topics.RemoveInclude))
+	h.mux.HandleFunc(ControlPrefix+"topics/exclude", h.topics(h.controls.Topics.Exclude, h.controls.Topics.RemoveExclude))
+	h.mux.HandleFunc(ControlPrefix+"dump", h.dump)
+
+	return h
+}
+
+// ServeHTTP implements http.Handler
+func (h *ControlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *ControlHandler) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.writeStatus(w)
+}
+
+// writeStatus writes current state of runtime settings
+func (h *ControlHandler) writeStatus(w http.ResponseWriter) {
+	include, exclude := h.controls.Topics.Patterns()
+	writeJSON(w, ControlStatus{
+		Verbose:       h.controls.Verbose.On(),
+		SampleRate:    fmt.Sprintf("1/%d", h.controls.SampleRate.Get()),
+		TopicsInclude: include,
+		TopicsExclude: exclude,
+	})
+}
+
+// verbose switches logging of every request by enabled parameter
+func (h *ControlHandler) verbose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if err != nil {
+		http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+		return
+	}
+
+	h.controls.Verbose.Set(enabled)
+	log.Printf("control: verbose logging is set to %t\n", enabled)
+
+	h.writeStatus(w)
+}
+
+// sample changes sample rate by rate parameter, e.g. 1/10
+func (h *ControlHandler) sample(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, err := stream.ParseSampleRate(r.FormValue("rate"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.controls.SetSampleRate(n)
+	log.Printf("control: sample rate is set to 1/%d\n", n)
+
+	h.writeStatus(w)
+}
+
+// topics adds topic pattern by POST and removes it by DELETE, pattern is a pattern parameter
+func (h *ControlHandler) topics(add func(pattern string) error, remove func(pattern string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.FormValue("pattern")
+		if pattern == "" {
+			http.Error(w, "pattern is required", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			if err := add(pattern); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("control: topic pattern %q is added to %s\n", pattern, r.URL.Path)
+		case http.MethodDelete:
+			if !remove(pattern) {
+				http.Error(w, "no such pattern", http.StatusNotFound)
+				return
+			}
+			log.Printf("control: topic pattern %q is removed from %s\n", pattern, r.URL.Path)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		h.writeStatus(w)
+	}
+}
+
+// dump saves relations into state file if it's configured and returns them by metric name
+func (h *ControlHandler) dump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.controls.Persister != nil {
+		if err := h.controls.Persister.Save(); err != nil {
+			http.Error(w, fmt.Sprintf("could not save relations: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	writeJSON(w, h.controls.Storage.Snapshot())
+}
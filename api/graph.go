@@ -0,0 +1,228 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// GraphPath is a path of topology graph page
+const GraphPath = "/graph"
+
+// kinds of nodes and edges of graph
+const (
+	nodeClient  = "client"
+	nodeTopic   = "topic"
+	edgeProduce = "produce"
+	edgeConsume = "consume"
+)
+
+// Graph is a graph of data flows between clients and topics: producers write to topics, topics are read by
+// consumers, so edges follow data
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a client or a topic, id is unique among both of them, e.g. client:10.0.0.1 or topic:orders
+type GraphNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+// GraphEdge is a data flow from producer to topic or from topic to consumer
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// NewGraph builds graph of topics with their producers and consumers, nodes and edges are sorted
+func NewGraph(topics []*TopicClients) *Graph {
+	g := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	clients := make(map[string]bool)
+	addClient := func(ip string) string {
+		id := nodeClient + ":" + ip
+		if !clients[ip] {
+			clients[ip] = true
+			g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: nodeClient, Label: ip})
+		}
+		return id
+	}
+
+	for _, t := range topics {
+		topicID := nodeTopic + ":" + t.Topic
+		g.Nodes = append(g.Nodes, GraphNode{ID: topicID, Kind: nodeTopic, Label: t.Topic})
+
+		// a client has a relation per principal and connection, it's one edge of the graph
+		produces, consumes := make(map[string]bool), make(map[string]bool)
+		for _, p := range t.Producers {
+			if !produces[p.ClientIP] {
+				produces[p.ClientIP] = true
+				g.Edges = append(g.Edges, GraphEdge{From: addClient(p.ClientIP), To: topicID, Kind: edgeProduce})
+			}
+		}
+		for _, c := range t.Consumers {
+			if !consumes[c.ClientIP] {
+				consumes[c.ClientIP] = true
+				g.Edges = append(g.Edges, GraphEdge{From: topicID, To: addClient(c.ClientIP), Kind: edgeConsume})
+			}
+		}
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+
+	return g
+}
+
+// WriteDOT writes graph in DOT language of Graphviz, e.g. for dot -Tsvg
+func (g *Graph) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph kafka {\n  rankdir=LR;\n  node [shape=box];"); err != nil {
+		return err
+	}
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Kind == nodeTopic {
+			shape = "ellipse"
+		}
+		if _, err := fmt.Fprintf(w, "  %s [label=%s, shape=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Label), shape); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := fmt.Fprintf(w, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// NewGraphHandler creates handler serving producer -> topic -> consumer graph of current relations as HTML
+// page, as JSON with format=json parameter or in DOT language with format=dot
+func NewGraphHandler(storage *metrics.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch format := r.FormValue("format"); format {
+		case "", "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, graphPage)
+		case "json":
+			writeJSON(w, NewGraph(Topics(storage)))
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			NewGraph(Topics(storage)).WriteDOT(w)
+		default:
+			http.Error(w, "unknown format "+strconv.Quote(format)+", expected html, json or dot", http.StatusBadRequest)
+		}
+	})
+}
+
+// graphPage renders graph fetched as JSON in three columns: producers, topics and consumers. Clients which
+// produce and consume are shown in both outer columns. Hovering a node highlights its flows.
+const graphPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kafka-sniffer topology</title>
+<style>
+body { font: 13px sans-serif; margin: 16px; }
+svg text { dominant-baseline: middle; }
+.edge { fill: none; stroke: #9aa; stroke-width: 1.2; opacity: .6; }
+.edge.on { stroke: #d33; opacity: 1; stroke-width: 2; }
+.node rect { fill: #eef3fb; stroke: #6b8cc7; rx: 4; }
+.node.topic rect { fill: #fdf3e1; stroke: #d9a441; rx: 10; }
+.node.on rect { stroke: #d33; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h3>Producers &rarr; topics &rarr; consumers</h3>
+<p>Current relations, refreshed every 30s. Also available as <a href="?format=json">JSON</a> and <a href="?format=dot">DOT</a>.</p>
+<svg id="graph"></svg>
+<script>
+const W = 260, H = 22, GAP = 6, COL = 420;
+
+function render(g) {
+  const producers = new Set(), consumers = new Set(), topics = [];
+  for (const e of g.edges) {
+    if (e.kind === "produce") producers.add(e.from); else consumers.add(e.to);
+  }
+  for (const n of g.nodes) if (n.kind === "topic") topics.push(n);
+  const label = id => id.slice(id.indexOf(":") + 1);
+  const columns = [
+    [...producers].sort().map(id => ({id: id, key: "p|" + id, label: label(id), kind: "client"})),
+    topics.map(n => ({id: n.id, key: "t|" + n.id, label: n.label, kind: "topic"})),
+    [...consumers].sort().map(id => ({id: id, key: "c|" + id, label: label(id), kind: "client"})),
+  ];
+
+  const pos = {};
+  let rows = 0;
+  columns.forEach((col, i) => {
+    col.forEach((n, j) => { pos[n.key] = {x: i * COL, y: j * (H + GAP)}; });
+    rows = Math.max(rows, col.length);
+  });
+
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.getElementById("graph");
+  svg.innerHTML = "";
+  svg.setAttribute("width", 2 * COL + W + 2);
+  svg.setAttribute("height", rows * (H + GAP) + 2);
+
+  const edges = g.edges.map(e => {
+    const from = e.kind === "produce" ? pos["p|" + e.from] : pos["t|" + e.from];
+    const to = e.kind === "produce" ? pos["t|" + e.to] : pos["c|" + e.to];
+    const x1 = from.x + W, y1 = from.y + H / 2, x2 = to.x, y2 = to.y + H / 2, mid = (x1 + x2) / 2;
+    const path = document.createElementNS(ns, "path");
+    path.setAttribute("class", "edge");
+    path.setAttribute("d", "M" + x1 + "," + y1 + " C" + mid + "," + y1 + " " + mid + "," + y2 + " " + x2 + "," + y2);
+    svg.appendChild(path);
+    return {from: e.from, to: e.to, path: path};
+  });
+
+  columns.forEach(col => col.forEach(n => {
+    const p = pos[n.key];
+    const node = document.createElementNS(ns, "g");
+    node.setAttribute("class", "node " + n.kind);
+    node.setAttribute("transform", "translate(" + (p.x + 1) + "," + (p.y + 1) + ")");
+    const rect = document.createElementNS(ns, "rect");
+    rect.setAttribute("width", W);
+    rect.setAttribute("height", H);
+    const text = document.createElementNS(ns, "text");
+    text.setAttribute("x", 8);
+    text.setAttribute("y", H / 2);
+    text.textContent = n.label.length > 38 ? n.label.slice(0, 37) + "…" : n.label;
+    const title = document.createElementNS(ns, "title");
+    title.textContent = n.label;
+    node.append(rect, text, title);
+    node.onmouseenter = () => {
+      node.classList.add("on");
+      edges.forEach(e => { if (e.from === n.id || e.to === n.id) e.path.classList.add("on"); });
+    };
+    node.onmouseleave = () => {
+      node.classList.remove("on");
+      edges.forEach(e => e.path.classList.remove("on"));
+    };
+    svg.appendChild(node);
+  }));
+}
+
+function refresh() {
+  fetch("?format=json").then(r => r.json()).then(render);
+}
+refresh();
+setInterval(refresh, 30000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+)
+
+// defaultRecentLimit is a count of events returned by recent requests API if limit parameter isn't set
+const defaultRecentLimit = 100
+
+// NewRecentHandler creates handler serving the newest events of ring as JSON, the newest event is the first one.
+// Events are selected by topic, client (address or client id) and api (name) parameters, count of events is
+// limited by limit parameter, limit=0 returns all matched events.
+func NewRecentHandler(ring *events.Ring) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultRecentLimit
+		if s := r.FormValue("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit "+strconv.Quote(s), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		topic, client, apiName := r.FormValue("topic"), r.FormValue("client"), r.FormValue("api")
+		writeJSON(w, ring.Recent(func(e *events.Event) bool {
+			return matchRecent(e, topic, client, apiName)
+		}, limit))
+	})
+}
+
+// matchRecent returns true if event matches parameters of recent requests API, empty parameters match any event
+func matchRecent(e *events.Event, topic, client, apiName string) bool {
+	if client != "" && e.SrcIP != client && e.ClientID != client {
+		return false
+	}
+	if apiName != "" && !strings.EqualFold(e.APIName, apiName) {
+		return false
+	}
+	if topic == "" {
+		return true
+	}
+	for _, t := range e.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
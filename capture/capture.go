@@ -0,0 +1,30 @@
+// Package capture contains packet capture backends alternative to libpcap
+package capture
+
+// AnyInterface is a pseudo-interface capturing packets of all interfaces on linux
+const AnyInterface = "any"
+
+// Backend is a name of packet capture backend
+type Backend string
+
+const (
+	// BackendPcap captures packets with libpcap
+	BackendPcap Backend = "pcap"
+	// BackendEBPF captures packets with AF_PACKET socket filtered by eBPF program in kernel, linux only
+	BackendEBPF Backend = "ebpf"
+)
+
+// EBPFOptions are options of eBPF capture backend
+type EBPFOptions struct {
+	// Snaplen is a max captured length of packet
+	Snaplen int
+
+	// Fanout joins socket into PACKET_FANOUT group of the process
+	Fanout bool
+
+	// Promisc enables promiscuous mode of the interface
+	Promisc bool
+
+	// Responses accepts packets sent from ports besides packets sent to them
+	Responses bool
+}
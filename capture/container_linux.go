@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// minContainerIDLen is length of short container ID printed by docker ps
+const minContainerIDLen = 12
+
+// ContainerPid returns pid of the first process of container, docker, containerd and cri-o containers are found
+// by container ID (full or short) in cgroup paths of processes, so no container runtime client is needed
+func ContainerPid(id string) (int, error) {
+	if len(id) < minContainerIDLen {
+		return 0, fmt.Errorf("container ID %q is shorter than %d characters", id, minContainerIDLen)
+	}
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		// process may exit while reading
+		cgroup, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err != nil {
+			continue
+		}
+
+		if strings.Contains(string(cgroup), id) && (found == 0 || pid < found) {
+			found = pid
+		}
+	}
+
+	if found == 0 {
+		return 0, errors.New("no processes of container " + id)
+	}
+
+	return found, nil
+}
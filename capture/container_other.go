@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+)
+
+// ContainerPid returns error on platforms other than linux
+func ContainerPid(id string) (int, error) {
+	return 0, errors.New("containers are supported only on linux")
+}
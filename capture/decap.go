@@ -0,0 +1,38 @@
+package capture
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// VXLANPort is IANA assigned UDP port of VXLAN
+	VXLANPort = 4789
+	// GenevePort is IANA assigned UDP port of Geneve
+	GenevePort = 6081
+)
+
+// Decapsulate returns the innermost TCP layer of the packet with its network layer, so TCP segments
+// tunneled in VXLAN, Geneve or GRE are found. gopacket decodes overlays by itself, but NetworkLayer()
+// and TransportLayer() of the packet return the outer layers. It returns nil TCP layer if there is no one.
+func Decapsulate(packet gopacket.Packet) (gopacket.NetworkLayer, *layers.TCP) {
+	var (
+		network, tcpNetwork gopacket.NetworkLayer
+		tcp                 *layers.TCP
+	)
+
+	for _, layer := range packet.Layers() {
+		switch l := layer.(type) {
+		case *layers.TCP:
+			tcp, tcpNetwork = l, network
+		case gopacket.NetworkLayer:
+			network = l
+		}
+	}
+
+	if tcpNetwork == nil {
+		return nil, nil
+	}
+
+	return tcpNetwork, tcp
+}
@@ -0,0 +1,218 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/google/gopacket"
+	"golang.org/x/sys/unix"
+)
+
+// EBPFSource reads packets from AF_PACKET socket with attached eBPF socket filter. The filter accepts only
+// TCP packets to broker port (IPv4 without fragments and IPv6 without extension headers), so other traffic
+// of the host isn't copied to userspace. Socket is cooked, so packets start with IP header (LinkTypeRaw)
+// on any interface, including "any" pseudo-interface. It's experimental.
+type EBPFSource struct {
+	fd   int
+	prog *ebpf.Program
+	buf  []byte
+
+	// loopbacks contains indexes of loopback interfaces
+	loopbacks map[int]bool
+}
+
+// OpenEBPF opens AF_PACKET socket on the interface and attaches filter of packets to ports. With fanout
+// the socket joins PACKET_FANOUT group of the process, packets are spread between sockets of the group
+// by flow hash, so every socket can be read by its own goroutine. Promiscuous mode isn't supported by "any".
+func OpenEBPF(iface string, ports []uint16, opts EBPFOptions) (*EBPFSource, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ifindex   int
+		loopbacks = make(map[int]bool)
+	)
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			loopbacks[ifi.Index] = true
+		}
+		if ifi.Name == iface {
+			ifindex = ifi.Index
+		}
+	}
+	// zero index binds the socket to all interfaces
+	if ifindex == 0 && iface != AnyInterface {
+		return nil, fmt.Errorf("no such interface %s", iface)
+	}
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "kafka_filter",
+		Type:         ebpf.SocketFilter,
+		Instructions: portFilter(ports, opts.Responses),
+		License:      "GPL",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load ebpf program: %s", err)
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		prog.Close()
+		return nil, fmt.Errorf("could not open AF_PACKET socket: %s", err)
+	}
+
+	s := &EBPFSource{
+		fd:        fd,
+		prog:      prog,
+		buf:       make([]byte, opts.Snaplen),
+		loopbacks: loopbacks,
+	}
+
+	// filter is attached before binding to the interface, so unfiltered packets aren't queued
+	if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ATTACH_BPF, prog.FD()); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("could not attach ebpf program: %s", err)
+	}
+
+	if err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("could not bind to interface %s: %s", iface, err)
+	}
+
+	// membership is dropped by kernel when the socket is closed
+	if opts.Promisc && ifindex != 0 {
+		mreq := &unix.PacketMreq{Ifindex: int32(ifindex), Type: unix.PACKET_MR_PROMISC}
+		if err = unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, mreq); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("could not enable promiscuous mode on interface %s: %s", iface, err)
+		}
+	}
+
+	if opts.Fanout {
+		// hash of flow is symmetric, fragments are defragmented before hashing
+		group := os.Getpid() & 0xffff
+		if err = unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, (unix.PACKET_FANOUT_HASH|unix.PACKET_FANOUT_FLAG_DEFRAG)<<16|group); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("could not join fanout group: %s", err)
+		}
+	}
+
+	return s, nil
+}
+
+// ReadPacketData implements gopacket.PacketDataSource
+func (s *EBPFSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		// MSG_TRUNC returns the real length of the packet when it's larger than buffer
+		n, from, err := unix.Recvfrom(s.fd, s.buf, unix.MSG_TRUNC)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, gopacket.CaptureInfo{}, err
+		}
+
+		// loopback delivers every packet twice: as outgoing and as incoming one, as libpcap does keep only incoming
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING && s.loopbacks[ll.Ifindex] {
+			continue
+		}
+
+		captured := n
+		if captured > len(s.buf) {
+			captured = len(s.buf)
+		}
+
+		data := make([]byte, captured)
+		copy(data, s.buf)
+
+		return data, gopacket.CaptureInfo{
+			Timestamp:     time.Now(),
+			CaptureLength: captured,
+			Length:        n,
+		}, nil
+	}
+}
+
+// Close closes socket and unloads eBPF program
+func (s *EBPFSource) Close() error {
+	err := unix.Close(s.fd)
+	if progErr := s.prog.Close(); err == nil {
+		err = progErr
+	}
+	return err
+}
+
+// portFilter returns socket filter accepting TCP packets with one of destination ports. Packets of cooked
+// socket start with IP header.
+func portFilter(ports []uint16, responses bool) asm.Instructions {
+	insns := asm.Instructions{
+		// legacy packet access instructions require context in R6
+		asm.Mov.Reg(asm.R6, asm.R1),
+
+		// IP version
+		asm.LoadAbs(0, asm.Byte),
+		asm.RSh.Imm(asm.R0, 4),
+		asm.JEq.Imm(asm.R0, 6, "ipv6"),
+		asm.JNE.Imm(asm.R0, 4, "drop"),
+
+		// IPv4: protocol, fragment offset, then TCP header after IP header of IHL*4 bytes
+		asm.LoadAbs(9, asm.Byte),
+		asm.JNE.Imm(asm.R0, unix.IPPROTO_TCP, "drop"),
+		asm.LoadAbs(6, asm.Half),
+		asm.And.Imm(asm.R0, 0x1fff),
+		asm.JNE.Imm(asm.R0, 0, "drop"),
+		asm.LoadAbs(0, asm.Byte),
+		asm.And.Imm(asm.R0, 0x0f),
+		asm.LSh.Imm(asm.R0, 2),
+		asm.Mov.Reg(asm.R7, asm.R0),
+		asm.Ja.Label("tcp"),
+
+		// IPv6: next header, then TCP header after fixed 40 bytes header
+		asm.LoadAbs(6, asm.Byte).Sym("ipv6"),
+		asm.JNE.Imm(asm.R0, unix.IPPROTO_TCP, "drop"),
+		asm.Mov.Imm(asm.R7, 40),
+
+		// destination port
+		asm.LoadInd(asm.R0, asm.R7, 2, asm.Half).Sym("tcp"),
+	}
+	insns = append(insns, portsEqual(ports)...)
+
+	// source port of responses
+	if responses {
+		insns = append(insns, asm.LoadInd(asm.R0, asm.R7, 0, asm.Half))
+		insns = append(insns, portsEqual(ports)...)
+	}
+
+	return append(insns,
+		asm.Ja.Label("drop"),
+
+		// return value is a count of bytes to keep, -1 keeps the whole packet
+		asm.Mov.Imm(asm.R0, -1).Sym("accept"),
+		asm.Return(),
+
+		asm.Mov.Imm(asm.R0, 0).Sym("drop"),
+		asm.Return(),
+	)
+}
+
+// portsEqual compares port in R0 with every port and jumps to accept if it's equal
+func portsEqual(ports []uint16) asm.Instructions {
+	insns := make(asm.Instructions, 0, len(ports))
+	for _, port := range ports {
+		insns = append(insns, asm.JEq.Imm(asm.R0, int32(port), "accept"))
+	}
+	return insns
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+
+	"github.com/google/gopacket"
+)
+
+// EBPFSource is supported only on linux
+type EBPFSource struct{}
+
+// OpenEBPF returns error on platforms other than linux
+func OpenEBPF(iface string, ports []uint16, opts EBPFOptions) (*EBPFSource, error) {
+	return nil, errors.New("ebpf capture backend is supported only on linux")
+}
+
+// ReadPacketData implements gopacket.PacketDataSource
+func (s *EBPFSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return nil, gopacket.CaptureInfo{}, errors.New("ebpf capture backend is supported only on linux")
+}
+
+// Close does nothing
+func (s *EBPFSource) Close() error {
+	return nil
+}
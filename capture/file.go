@@ -0,0 +1,72 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	pcapMagic          = 0xa1b2c3d4
+	pcapMagicNanosec   = 0xa1b23c4d
+	pcapngSectionBlock = 0x0a0d0d0a
+	pcapngByteOrder    = 0x1a2b3c4d
+	pcapngIfaceBlock   = 1
+)
+
+// FileLinkType reads link type of pcap or pcapng file (of its first interface). libpcap reports link type
+// as int, but layers.LinkType is uint8, so link types above 255 (e.g. LinkTypeLinuxSLL2) are read from the file.
+func FileLinkType(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var header [24]byte
+	if _, err = io.ReadFull(f, header[:]); err != nil {
+		return 0, err
+	}
+
+	if linkType, ok := pcapHeaderLinkType(header[:]); ok {
+		return linkType, nil
+	}
+
+	if binary.LittleEndian.Uint32(header[0:4]) != pcapngSectionBlock {
+		return 0, errors.New("unknown capture file format")
+	}
+
+	// pcapng: section header block is followed by interface description blocks
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.BigEndian.Uint32(header[8:12]) == pcapngByteOrder {
+		order = binary.BigEndian
+	}
+
+	offset := int64(order.Uint32(header[4:8]))
+	for {
+		var block [10]byte
+		if _, err = f.ReadAt(block[:], offset); err != nil {
+			return 0, err
+		}
+
+		blockType, blockLen := order.Uint32(block[0:4]), order.Uint32(block[4:8])
+		if blockType == pcapngIfaceBlock {
+			return uint32(order.Uint16(block[8:10])), nil
+		}
+		if blockLen == 0 {
+			return 0, errors.New("invalid pcapng block")
+		}
+		offset += int64(blockLen)
+	}
+}
+
+// pcapHeaderLinkType returns link type of pcap file header: magic, version, timezone, sigfigs, snaplen and link type
+func pcapHeaderLinkType(header []byte) (uint32, bool) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if magic := order.Uint32(header[0:4]); magic == pcapMagic || magic == pcapMagicNanosec {
+			return order.Uint32(header[20:24]), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package capture
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// NetnsPath returns path of network namespace: pid is resolved to /proc/<pid>/ns/net, name without slashes
+// to namespace created by "ip netns add", path is returned as is
+func NetnsPath(netns string) string {
+	if _, err := strconv.Atoi(netns); err == nil {
+		return fmt.Sprintf("/proc/%s/ns/net", netns)
+	}
+	if !strings.Contains(netns, "/") {
+		return "/var/run/netns/" + netns
+	}
+	return netns
+}
+
+// InNetns calls fn in network namespace of path. Sockets opened by fn stay in the namespace after return,
+// so capture is opened inside namespace while the rest of the sniffer runs in its own namespace.
+func InNetns(path string, fn func() error) error {
+	target, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open network namespace: %s", err)
+	}
+	defer target.Close()
+
+	// namespace is an attribute of OS thread, the goroutine mustn't move to another thread while inside it
+	runtime.LockOSThread()
+
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not open current network namespace: %s", err)
+	}
+	defer origin.Close()
+
+	if err = unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("could not enter network namespace %s: %s", path, err)
+	}
+
+	fnErr := fn()
+
+	// thread which can't return to its namespace stays locked, runtime terminates it when goroutine exits
+	if err = unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("could not leave network namespace %s: %s", path, err)
+	}
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
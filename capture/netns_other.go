@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+)
+
+// NetnsPath returns netns as is on platforms other than linux
+func NetnsPath(netns string) string {
+	return netns
+}
+
+// InNetns returns error on platforms other than linux
+func InNetns(path string, fn func() error) error {
+	return errors.New("network namespaces are supported only on linux")
+}
@@ -0,0 +1,131 @@
+package capture
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	reopenMinBackoff = time.Second
+	reopenMaxBackoff = 30 * time.Second
+)
+
+// ReopeningSource reads packets from underlying live source and reopens it with exponential backoff when reading
+// fails, e.g. when capture interface disappears because of bond flap or container restart or when remote capture
+// command exits. Without it gopacket.PacketSource retries reading of the broken source forever.
+type ReopeningSource struct {
+	open     func() (gopacket.PacketDataSource, error)
+	onReopen func()
+
+	mux    sync.Mutex
+	source gopacket.PacketDataSource
+	closed bool
+
+	// TimeoutErr is an error returned by reads of the source when its poll timeout expires without packets,
+	// e.g. pcap.NextErrorTimeoutExpired, such reads are retried since idle link isn't broken
+	TimeoutErr error
+}
+
+// NewReopeningSource creates ReopeningSource, open is called to reopen the source and onReopen is called
+// after every successful reopening
+func NewReopeningSource(source gopacket.PacketDataSource, open func() (gopacket.PacketDataSource, error), onReopen func()) *ReopeningSource {
+	return &ReopeningSource{source: source, open: open, onReopen: onReopen}
+}
+
+// ReadPacketData implements gopacket.PacketDataSource, it returns io.EOF after the source is closed
+func (s *ReopeningSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	source, closed := s.current()
+	if closed {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	data, ci, err := source.ReadPacketData()
+	for s.TimeoutErr != nil && err == s.TimeoutErr {
+		data, ci, err = source.ReadPacketData()
+	}
+	if err == nil || isTemporary(err) {
+		return data, ci, err
+	}
+	if _, closed = s.current(); closed {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+
+	// live source ends only when it's broken, e.g. remote capture command exits
+	log.Printf("could not read packet, reopening capture: %s\n", err)
+	closeSource(source)
+
+	for backoff := reopenMinBackoff; ; backoff *= 2 {
+		if backoff > reopenMaxBackoff {
+			backoff = reopenMaxBackoff
+		}
+		time.Sleep(backoff)
+
+		if _, closed = s.current(); closed {
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+		source, err := s.open()
+		if err != nil {
+			log.Printf("could not reopen capture, retry in %s: %s\n", backoff, err)
+			continue
+		}
+
+		s.mux.Lock()
+		s.source = source
+		closed = s.closed
+		s.mux.Unlock()
+		if closed {
+			closeSource(source)
+			return nil, gopacket.CaptureInfo{}, io.EOF
+		}
+
+		s.onReopen()
+		log.Println("capture is reopened")
+
+		return s.ReadPacketData()
+	}
+}
+
+// Close closes the source, the pending read returns io.EOF and the source isn't reopened anymore
+func (s *ReopeningSource) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		closeSource(s.source)
+	}
+	return nil
+}
+
+func (s *ReopeningSource) current() (gopacket.PacketDataSource, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.source, s.closed
+}
+
+func isTemporary(err error) bool {
+	if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+		return true
+	}
+	return err == syscall.EAGAIN || err == syscall.EINTR
+}
+
+// closeSource closes pcap handle (without returned error) or any other closable source
+func closeSource(source gopacket.PacketDataSource) {
+	switch c := source.(type) {
+	case io.Closer:
+		if err := c.Close(); err != nil {
+			log.Printf("could not close capture: %s\n", err)
+		}
+	case interface{ Close() }:
+		c.Close()
+	}
+}
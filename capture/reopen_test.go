@@ -0,0 +1,86 @@
+package capture
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+var errTimeout = errors.New("timeout expired")
+
+// idleSource returns timeout errors for the first reads, then a packet
+type idleSource struct {
+	timeouts int
+	reads    int
+}
+
+func (s *idleSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	s.reads++
+	if s.reads <= s.timeouts {
+		return nil, gopacket.CaptureInfo{}, errTimeout
+	}
+	return []byte("packet"), gopacket.CaptureInfo{}, nil
+}
+
+func TestReopeningSourceIdleLink(t *testing.T) {
+	source := &idleSource{timeouts: 3}
+	s := NewReopeningSource(source, func() (gopacket.PacketDataSource, error) {
+		t.Fatal("idle source is reopened")
+		return nil, nil
+	}, func() {})
+	s.TimeoutErr = errTimeout
+
+	data, _, err := s.ReadPacketData()
+	if err != nil {
+		t.Fatalf("could not read packet: %s", err)
+	}
+	if string(data) != "packet" || source.reads != 4 {
+		t.Errorf("read %q after %d reads, want packet after 4 reads", data, source.reads)
+	}
+}
+
+// blockingSource blocks reads until it's closed like pcap handle of idle link
+type blockingSource struct {
+	done chan struct{}
+}
+
+func (s *blockingSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	<-s.done
+	return nil, gopacket.CaptureInfo{}, io.EOF
+}
+
+func (s *blockingSource) Close() {
+	close(s.done)
+}
+
+func TestReopeningSourceClose(t *testing.T) {
+	s := NewReopeningSource(&blockingSource{done: make(chan struct{})}, func() (gopacket.PacketDataSource, error) {
+		t.Error("closed source is reopened")
+		return nil, nil
+	}, func() {})
+
+	read := make(chan error)
+	go func() {
+		_, _, err := s.ReadPacketData()
+		read <- err
+	}()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("could not close source: %s", err)
+	}
+	select {
+	case err := <-read:
+		if err != io.EOF {
+			t.Errorf("read of closed source returns %v, want %v", err, io.EOF)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read isn't finished after closing")
+	}
+
+	if _, _, err := s.ReadPacketData(); err != io.EOF {
+		t.Errorf("read of closed source returns %v, want %v", err, io.EOF)
+	}
+}
@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// LinkTypeLinuxSLL2 is a link type of linux cooked capture v2 used by `tcpdump -i any` since tcpdump 4.99.
+// It doesn't fit into layers.LinkType, which is uint8.
+const LinkTypeLinuxSLL2 = 276
+
+const linuxSLL2HeaderLen = 20
+
+// LayerTypeLinuxSLL2 is a layer type of linux cooked capture v2 header
+var LayerTypeLinuxSLL2 = gopacket.RegisterLayerType(2609, gopacket.LayerTypeMetadata{
+	Name:    "LinuxSLL2",
+	Decoder: gopacket.DecodeFunc(decodeLinuxSLL2),
+})
+
+// LinuxSLL2 is a linux cooked capture v2 header. Unlike v1 it contains index of the interface packet was captured on.
+type LinuxSLL2 struct {
+	layers.BaseLayer
+	EthernetType   layers.EthernetType
+	InterfaceIndex uint32
+	ARPHRDType     uint16
+	PacketType     layers.LinuxSLLPacketType
+	Addr           net.HardwareAddr
+}
+
+// LayerType implements gopacket.Layer
+func (sll *LinuxSLL2) LayerType() gopacket.LayerType {
+	return LayerTypeLinuxSLL2
+}
+
+// LinkFlow implements gopacket.LinkLayer
+func (sll *LinuxSLL2) LinkFlow() gopacket.Flow {
+	return gopacket.NewFlow(layers.EndpointMAC, sll.Addr, nil)
+}
+
+// CanDecode implements gopacket.DecodingLayer
+func (sll *LinuxSLL2) CanDecode() gopacket.LayerClass {
+	return LayerTypeLinuxSLL2
+}
+
+// NextLayerType implements gopacket.DecodingLayer
+func (sll *LinuxSLL2) NextLayerType() gopacket.LayerType {
+	return sll.EthernetType.LayerType()
+}
+
+// DecodeFromBytes implements gopacket.DecodingLayer
+func (sll *LinuxSLL2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < linuxSLL2HeaderLen {
+		return errors.New("linux SLL2 packet too small")
+	}
+
+	sll.EthernetType = layers.EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	sll.InterfaceIndex = binary.BigEndian.Uint32(data[4:8])
+	sll.ARPHRDType = binary.BigEndian.Uint16(data[8:10])
+	sll.PacketType = layers.LinuxSLLPacketType(data[10])
+
+	addrLen := int(data[11])
+	if addrLen > 8 {
+		addrLen = 8
+	}
+	sll.Addr = net.HardwareAddr(data[12 : 12+addrLen])
+
+	sll.BaseLayer = layers.BaseLayer{Contents: data[:linuxSLL2HeaderLen], Payload: data[linuxSLL2HeaderLen:]}
+
+	return nil
+}
+
+func decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder) error {
+	sll := &LinuxSLL2{}
+	if err := sll.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+
+	p.AddLayer(sll)
+	p.SetLinkLayer(sll)
+
+	return p.NextDecoder(sll.EthernetType)
+}
+
+// Decoder returns decoder of packets with the link type
+func Decoder(linkType uint32) gopacket.Decoder {
+	if linkType == LinkTypeLinuxSLL2 {
+		return LayerTypeLinuxSLL2
+	}
+	return layers.LinkType(linkType)
+}
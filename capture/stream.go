@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// StreamSource reads packets from pcap stream, e.g. from stdin piped from `ssh host tcpdump -U -w -`
+// or from stdout of a command. Packets of the stream aren't filtered by capture filter.
+type StreamSource struct {
+	r        *pcapgo.Reader
+	closer   io.Closer
+	cmd      *exec.Cmd
+	linkType uint32
+}
+
+// NewStreamSource reads pcap header of the stream, pcapng streams aren't supported
+func NewStreamSource(r io.ReadCloser) (*StreamSource, error) {
+	br := bufio.NewReader(r)
+
+	// link type is read from the header since pcapgo.Reader doesn't support link types above 255
+	header, err := br.Peek(24)
+	if err != nil {
+		return nil, err
+	}
+	linkType, ok := pcapHeaderLinkType(header)
+	if !ok {
+		return nil, errors.New("not a pcap stream, pcapng isn't supported")
+	}
+
+	pr, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamSource{r: pr, closer: r, linkType: linkType}, nil
+}
+
+// StartCommand runs shell command and reads pcap stream from its stdout, stderr of the command is
+// passed through. The command is killed when the source is closed.
+func StartCommand(command string) (*StreamSource, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s, err := NewStreamSource(stdout)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	s.cmd = cmd
+
+	return s, nil
+}
+
+// LinkType returns link type of the stream
+func (s *StreamSource) LinkType() uint32 {
+	return s.linkType
+}
+
+// Snaplen returns snaplen of the stream
+func (s *StreamSource) Snaplen() int {
+	return int(s.r.Snaplen())
+}
+
+// ReadPacketData implements gopacket.PacketDataSource
+func (s *StreamSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	return s.r.ReadPacketData()
+}
+
+// Close closes the stream and kills the command
+func (s *StreamSource) Close() error {
+	err := s.closer.Close()
+	if s.cmd != nil {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"time"
+)
+
+// cpuTime returns zero on windows, CPU time isn't reported there
+func cpuTime() time.Duration {
+	return 0
+}
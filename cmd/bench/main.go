@@ -0,0 +1,201 @@
+// Command bench replays pcap file at max speed through decoding pipeline of sniffer: packet decoding, TCP
+// assembly and decoding of requests and responses. It reports requests per second, allocations and CPU usage,
+// so performance regressions of decoding are measurable.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/reassembly"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	readFile   = flag.String("r", "", "Pcap file to replay, pcapng files aren't supported")
+	dstports   = flag.String("p", "9092", "Comma separated list of kafka broker ports")
+	responses  = flag.Bool("responses", false, "Decode responses too, the file must contain both directions of connections")
+	iterations = flag.Int("n", 10, "Count of replays of the file")
+	shallow    = flag.Bool("decode.shallow", false, "Decode only headers and topics of produce requests")
+	zeroCopy   = flag.Bool("decode.zero-copy", false, "Decode strings of produce and fetch requests without copies")
+)
+
+// packet is a captured packet kept in memory, so reading of the file isn't measured
+type packet struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// assemblerContext implements reassembly.AssemblerContext
+type assemblerContext gopacket.CaptureInfo
+
+// GetCaptureInfo returns capture info of the segment
+func (c *assemblerContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+func main() {
+	flag.Parse()
+
+	if *readFile == "" {
+		log.Fatalln("pcap file must be set with -r")
+	}
+	if *iterations < 1 {
+		log.Fatalln("-n must be positive")
+	}
+
+	brokerPorts := make(map[uint16]bool)
+	for _, s := range strings.Split(*dstports, ",") {
+		port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+		if err != nil {
+			log.Fatalf("invalid port %q: %s", s, err)
+		}
+		brokerPorts[uint16(port)] = true
+	}
+
+	kafka.SetShallowDecode(*shallow)
+	kafka.ZeroCopyDecode = *zeroCopy
+
+	packets, linkType, err := readPackets(*readFile)
+	if err != nil {
+		log.Fatalln("could not read pcap file:", err)
+	}
+
+	// relations aren't exported, their metrics are registered in a separate registry
+	registry := prometheus.NewRegistry()
+	factory := stream.NewKafkaStreamFactory(
+		metrics.NewStorage(registry, time.Hour),
+		metrics.NewRebalanceDetector(registry, 0),
+		nil,
+		stream.Config{BrokerPorts: brokerPorts, Responses: *responses},
+	)
+
+	// output of streams isn't a part of benchmark
+	log.SetOutput(ioutil.Discard)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	cpuBefore := cpuTime()
+	start := time.Now()
+
+	var bytes int64
+	for i := 0; i < *iterations; i++ {
+		bytes += replay(packets, linkType, brokerPorts, factory)
+	}
+
+	elapsed := time.Since(start)
+	cpu := cpuTime() - cpuBefore
+	runtime.ReadMemStats(&after)
+
+	log.SetOutput(os.Stderr)
+
+	requests, err := requestsCount()
+	if err != nil {
+		log.Fatalln("could not count requests:", err)
+	}
+
+	fmt.Printf("packets:      %d\n", len(packets)*(*iterations))
+	fmt.Printf("requests:     %d\n", requests)
+	fmt.Printf("time:         %s\n", elapsed)
+	fmt.Printf("requests/s:   %.0f\n", float64(requests)/elapsed.Seconds())
+	fmt.Printf("MB/s:         %.1f\n", float64(bytes)/elapsed.Seconds()/(1<<20))
+	if requests > 0 {
+		fmt.Printf("allocs/req:   %.1f\n", float64(after.Mallocs-before.Mallocs)/float64(requests))
+		fmt.Printf("bytes/req:    %.0f\n", float64(after.TotalAlloc-before.TotalAlloc)/float64(requests))
+	}
+	fmt.Printf("GC cycles:    %d\n", after.NumGC-before.NumGC)
+	if cpu > 0 {
+		fmt.Printf("CPU:          %s (%.2f cores)\n", cpu, cpu.Seconds()/elapsed.Seconds())
+	}
+}
+
+// readPackets reads all packets of pcap file into memory
+func readPackets(path string) ([]packet, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	source, err := capture.NewStreamSource(f)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	defer source.Close()
+
+	var packets []packet
+	for {
+		data, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			return packets, source.LinkType(), nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		packets = append(packets, packet{data: append([]byte(nil), data...), ci: ci})
+	}
+}
+
+// replay passes packets through a new assembler and waits until all streams are decoded, it returns bytes
+// of replayed packets
+func replay(packets []packet, linkType uint32, brokerPorts map[uint16]bool, factory *stream.KafkaStreamFactory) int64 {
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+	decoder := capture.Decoder(linkType)
+
+	var bytes int64
+	for _, p := range packets {
+		bytes += int64(len(p.data))
+
+		pkt := gopacket.NewPacket(p.data, decoder, gopacket.NoCopy)
+		network, tcp := capture.Decapsulate(pkt)
+		if tcp == nil {
+			continue
+		}
+		if !brokerPorts[uint16(tcp.DstPort)] && !(*responses && brokerPorts[uint16(tcp.SrcPort)]) {
+			continue
+		}
+
+		ctx := assemblerContext(p.ci)
+		assembler.AssembleWithContext(network.NetworkFlow(), tcp, &ctx)
+	}
+
+	assembler.FlushAll()
+	factory.Wait()
+
+	return bytes
+}
+
+// requestsCount returns total count of requests decoded by all replays
+func requestsCount() (int, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return 0, err
+	}
+
+	var count float64
+	for _, family := range families {
+		if family.GetName() != "kafka_sniffer_typed_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			count += m.GetCounter().GetValue()
+		}
+	}
+
+	return int(count), nil
+}
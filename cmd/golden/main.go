@@ -0,0 +1,377 @@
+// Command golden replays recorded pcap files through the whole pipeline of sniffer: packet decoding, TCP
+// assembly, decoding of requests and reporting of events and metrics. Events and metrics extracted from every
+// pcap file are compared with its golden file, so changes of decoding are checked against traffic of real
+// clients without live clusters.
+//
+// Golden file of case.pcap is case.golden next to it, it's created or rewritten by -update:
+//
+//	golden -dir testdata/golden -update
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/reassembly"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	dir      = flag.String("dir", "testdata/golden", "Directory of pcap files and their golden files")
+	run      = flag.String("run", "", "Regexp selecting cases by names of pcap files without extension, all cases run if it's empty")
+	dstports = flag.String("p", "9092", "Comma separated list of kafka broker ports")
+	update   = flag.Bool("update", false, "Rewrite golden files by results of replays instead of comparing them")
+	verbose  = flag.Bool("v", false, "Print logs of streams, e.g. decoding errors")
+)
+
+const (
+	eventsHeader  = "# events"
+	metricsHeader = "# metrics"
+
+	// maxMessageSize is small, so oversized batches of cases fit into single packets
+	maxMessageSize = 16 << 10
+)
+
+// assemblerContext implements reassembly.AssemblerContext
+type assemblerContext gopacket.CaptureInfo
+
+// GetCaptureInfo returns capture info of the segment
+func (c *assemblerContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// collector is a sink of events keeping them in memory
+type collector struct {
+	mux    sync.Mutex
+	events []*events.Event
+}
+
+// Write implements events.Sink
+func (c *collector) Write(e *events.Event) error {
+	c.mux.Lock()
+	c.events = append(c.events, e)
+	c.mux.Unlock()
+	return nil
+}
+
+// Close implements events.Sink
+func (c *collector) Close() error {
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	brokerPorts := make(map[uint16]bool)
+	for _, s := range strings.Split(*dstports, ",") {
+		port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+		if err != nil {
+			log.Fatalf("invalid port %q: %s", s, err)
+		}
+		brokerPorts[uint16(port)] = true
+	}
+
+	match, err := regexp.Compile(*run)
+	if err != nil {
+		log.Fatalf("invalid -run %q: %s", *run, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(*dir, "*.pcap"))
+	if err != nil {
+		log.Fatalln("could not list pcap files:", err)
+	}
+
+	var cases, failed int
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".pcap")
+		if !match.MatchString(name) {
+			continue
+		}
+		cases++
+
+		if err := runCase(file, brokerPorts); err != nil {
+			failed++
+			fmt.Printf("FAIL %s\n%s\n", name, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	if cases == 0 {
+		log.Fatalf("no pcap files matched in %s", *dir)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d cases failed\n", failed, cases)
+		os.Exit(1)
+	}
+}
+
+// runCase replays pcap file and compares its results with golden file or rewrites golden file by them
+func runCase(file string, brokerPorts map[uint16]bool) error {
+	before, err := gatherSamples(prometheus.DefaultGatherer)
+	if err != nil {
+		return err
+	}
+
+	// relations are registered in a registry of the case, global metrics are accumulated by all cases in the
+	// default registry, so their deltas are reported
+	registry := prometheus.NewRegistry()
+	sink := &collector{}
+	factory := stream.NewKafkaStreamFactory(
+		metrics.NewStorage(registry, time.Hour),
+		metrics.NewRebalanceDetector(registry, 0),
+		sink,
+		stream.Config{
+			BrokerPorts:    brokerPorts,
+			RenderRequests: true,
+			ProducerIDs:    metrics.NewProducerIDs(registry, time.Hour),
+			MaxMessageSize: maxMessageSize,
+		},
+	)
+
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	err = replay(file, brokerPorts, factory)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("could not replay pcap file: %s", err)
+	}
+
+	after, err := gatherSamples(prometheus.DefaultGatherer)
+	if err != nil {
+		return err
+	}
+	relations, err := gatherSamples(registry)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range after {
+		if delta := value - before[key]; delta != 0 {
+			relations[key] = delta
+		}
+	}
+
+	got, err := render(sink.events, relations)
+	if err != nil {
+		return err
+	}
+
+	goldenFile := strings.TrimSuffix(file, ".pcap") + ".golden"
+	if *update {
+		return ioutil.WriteFile(goldenFile, got, 0644)
+	}
+
+	want, err := ioutil.ReadFile(goldenFile)
+	if err != nil {
+		return fmt.Errorf("could not read golden file, it's created by -update: %s", err)
+	}
+	return diff(want, got)
+}
+
+// replay passes packets of pcap file through a new assembler and waits until all streams are decoded
+func replay(file string, brokerPorts map[uint16]bool, factory *stream.KafkaStreamFactory) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	source, err := capture.NewStreamSource(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer source.Close()
+
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+	decoder := capture.Decoder(source.LinkType())
+
+	for {
+		data, ci, err := source.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		pkt := gopacket.NewPacket(data, decoder, gopacket.Default)
+		network, tcp := capture.Decapsulate(pkt)
+		if tcp == nil || !brokerPorts[uint16(tcp.DstPort)] {
+			continue
+		}
+
+		ctx := assemblerContext(ci)
+		assembler.AssembleWithContext(network.NetworkFlow(), tcp, &ctx)
+	}
+
+	assembler.FlushAll()
+	factory.Wait()
+
+	return nil
+}
+
+// gatherSamples returns values of metrics by their names with labels. Counters, gauges and untyped metrics are
+// reported by values, histograms and summaries by counts and sums of observations. Internal metrics of sniffer
+// and durations depend on the host and time of replay, so they are skipped.
+func gatherSamples(gatherer prometheus.Gatherer) (map[string]float64, error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("could not gather metrics: %s", err)
+	}
+
+	samples := make(map[string]float64)
+	for _, family := range families {
+		name := family.GetName()
+		if !strings.HasPrefix(name, "kafka_sniffer_") || strings.HasPrefix(name, "kafka_sniffer_internal_") ||
+			name == "kafka_sniffer_build_info" || strings.HasSuffix(name, "_seconds") {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			labels := renderLabels(m.GetLabel())
+			switch {
+			case m.Counter != nil:
+				samples[name+labels] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				samples[name+labels] = m.GetGauge().GetValue()
+			case m.Untyped != nil:
+				samples[name+labels] = m.GetUntyped().GetValue()
+			case m.Histogram != nil:
+				samples[name+"_count"+labels] = float64(m.GetHistogram().GetSampleCount())
+				samples[name+"_sum"+labels] = m.GetHistogram().GetSampleSum()
+			case m.Summary != nil:
+				samples[name+"_count"+labels] = float64(m.GetSummary().GetSampleCount())
+				samples[name+"_sum"+labels] = m.GetSummary().GetSampleSum()
+			}
+		}
+	}
+
+	return samples, nil
+}
+
+// renderLabels renders labels in exposition format, labels are sorted by names by client library
+func renderLabels(pairs []*dto.LabelPair) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		labels = append(labels, pair.GetName()+"="+strconv.Quote(pair.GetValue()))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// render renders events and metrics of the case deterministically: events are sorted by connections and
+// correlation ids, topics of events are sorted as they are extracted from maps of requests, time of events is
+// the time of replay, so it's cleared
+func render(evts []*events.Event, samples map[string]float64) ([]byte, error) {
+	sort.SliceStable(evts, func(i, j int) bool {
+		a, b := evts[i], evts[j]
+		if a.SrcIP != b.SrcIP {
+			return a.SrcIP < b.SrcIP
+		}
+		if a.SrcPort != b.SrcPort {
+			return a.SrcPort < b.SrcPort
+		}
+		return a.CorrelationID < b.CorrelationID
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(eventsHeader + "\n")
+	for _, e := range evts {
+		e.Time = time.Time{}
+		sort.Strings(e.Topics)
+		sort.Strings(e.InvalidTopics)
+		sort.Strings(e.Schemas)
+		b, err := json.Marshal(e)
+		if err != nil {
+			return nil, fmt.Errorf("could not render event: %s", err)
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	keys := make([]string, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteString(metricsHeader + "\n")
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s %s\n", key, formatValue(samples[key]))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// diff returns error listing lines missing in got and unexpected lines of got, order of lines is compared by
+// equality of the whole output
+func diff(want, got []byte) error {
+	if bytes.Equal(want, got) {
+		return nil
+	}
+
+	wantLines := countLines(want)
+	gotLines := countLines(got)
+
+	var report strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(string(want), "\n"), "\n") {
+		if gotLines[line] > 0 {
+			gotLines[line]--
+			continue
+		}
+		fmt.Fprintf(&report, "  - %s\n", line)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(got), "\n"), "\n") {
+		if wantLines[line] > 0 {
+			wantLines[line]--
+			continue
+		}
+		fmt.Fprintf(&report, "  + %s\n", line)
+	}
+
+	if report.Len() == 0 {
+		return fmt.Errorf("  lines are reordered")
+	}
+	return fmt.Errorf("%s", strings.TrimSuffix(report.String(), "\n"))
+}
+
+func countLines(b []byte) map[string]int {
+	counts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		counts[line]++
+	}
+	return counts
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+var (
+	aclBrokers         = flag.String("acl.brokers", "", "Comma separated list of brokers ACLs are fetched from by admin client to audit observed access of SASL principals against them, disabled if empty")
+	aclRefreshInterval = flag.Duration("acl.refresh-interval", 5*time.Minute, "Interval of fetching ACLs of the audit")
+	aclTLS             = flag.Bool("acl.tls", false, "Connect to -acl.brokers with TLS")
+	aclSASLUser        = flag.String("acl.sasl.user", "", "SASL/PLAIN user of admin client of the audit, SASL is disabled if empty, the user must be allowed to describe ACLs")
+	aclSASLPassword    = flag.String("acl.sasl.password", "", "SASL/PLAIN password of admin client of the audit")
+)
+
+// aclOperations are names of operations of ACLs which access is observed
+var aclOperations = map[sarama.AclOperation]string{
+	sarama.AclOperationRead:  metrics.ACLRead,
+	sarama.AclOperationWrite: metrics.ACLWrite,
+	sarama.AclOperationAll:   metrics.ACLAll,
+}
+
+// aclResourceTypes are names of resource types of ACLs which access is observed
+var aclResourceTypes = map[sarama.AclResourceType]string{
+	sarama.AclResourceTopic: metrics.ACLTopic,
+	sarama.AclResourceGroup: metrics.ACLGroup,
+}
+
+// fetchACLs fetches declared ACLs of topics and groups by admin client, ACLs of other resources and operations
+// aren't audited and are skipped
+func fetchACLs(brokers []string) ([]metrics.ACL, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-sniffer"
+	// prefixed ACLs are returned since DescribeAcls v1
+	config.Version = sarama.V2_0_0_0
+	if *aclTLS {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = &tls.Config{}
+	}
+	if *aclSASLUser != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = *aclSASLUser
+		config.Net.SASL.Password = *aclSASLPassword
+	}
+
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	resources, err := admin.ListAcls(sarama.AclFilter{
+		ResourceType:              sarama.AclResourceAny,
+		ResourcePatternTypeFilter: sarama.AclPatternAny,
+		Operation:                 sarama.AclOperationAny,
+		PermissionType:            sarama.AclPermissionAny,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	acls := make([]metrics.ACL, 0)
+	for _, resource := range resources {
+		resourceType, ok := aclResourceTypes[resource.ResourceType]
+		if !ok {
+			continue
+		}
+
+		patternType := "literal"
+		if resource.ResourcePatternType == sarama.AclPatternPrefixed {
+			patternType = "prefixed"
+		}
+
+		for _, acl := range resource.Acls {
+			operation, ok := aclOperations[acl.Operation]
+			if !ok {
+				continue
+			}
+			acls = append(acls, metrics.ACL{
+				Principal:    acl.Principal,
+				ResourceType: resourceType,
+				ResourceName: resource.ResourceName,
+				PatternType:  patternType,
+				Host:         acl.Host,
+				Operation:    operation,
+				Allow:        acl.PermissionType == sarama.AclPermissionAllow,
+			})
+		}
+	}
+
+	return acls, nil
+}
+
+// startACLAudit fetches ACLs the first time and refreshes them every interval in background, failed refreshes
+// are logged and the previous ACLs are kept
+func startACLAudit(audit *metrics.ACLAudit, brokers []string, interval time.Duration) error {
+	acls, err := fetchACLs(brokers)
+	if err != nil {
+		return fmt.Errorf("could not fetch ACLs: %s", err)
+	}
+	audit.SetACLs(acls)
+	log.Printf("acl audit: %d ACLs of topics and groups are fetched from %s", len(acls), strings.Join(brokers, ","))
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			acls, err := fetchACLs(brokers)
+			if err != nil {
+				log.Printf("acl audit: could not refresh ACLs: %s", err)
+				continue
+			}
+			audit.SetACLs(acls)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/alerts"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	alertsRules        = flag.String("alerts.rules", "", "JSON file of alert rules evaluated against sniffer metrics, e.g. new producer of topic or rate of counter above threshold, alerts are logged, disabled if empty")
+	alertsInterval     = flag.Duration("alerts.interval", 30*time.Second, "Interval of evaluation of alert rules")
+	alertsWebhook      = flag.String("alerts.webhook", "", "URL alerts are posted to as JSON in addition to log, disabled if empty")
+	alertsKafkaBrokers = flag.String("alerts.kafka.brokers", "", "Comma separated list of brokers alerts are produced to as JSON in addition to log, disabled if empty")
+	alertsKafkaTopic   = flag.String("alerts.kafka.topic", "kafka-sniffer-alerts", "Topic of alerts produced to -alerts.kafka.brokers")
+)
+
+// startAlerts loads alert rules and evaluates them against metrics of gatherer in background
+func startAlerts(gatherer prometheus.Gatherer) error {
+	rules, err := alerts.LoadRules(*alertsRules)
+	if err != nil {
+		return fmt.Errorf("could not load alert rules: %s", err)
+	}
+
+	notifiers := []alerts.Notifier{alerts.LogNotifier{}}
+	if *alertsWebhook != "" {
+		notifiers = append(notifiers, alerts.NewWebhookNotifier(*alertsWebhook))
+	}
+	if *alertsKafkaBrokers != "" {
+		notifier, err := alerts.NewKafkaNotifier(strings.Split(*alertsKafkaBrokers, ","), *alertsKafkaTopic)
+		if err != nil {
+			return fmt.Errorf("could not create kafka notifier of alerts: %s", err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	log.Printf("alerts: %d rules are evaluated every %s", len(rules), *alertsInterval)
+	go alerts.NewEngine(gatherer, rules, notifiers).Run(context.Background(), *alertsInterval)
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/api"
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/sniffer"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	analyzeFlags = flag.NewFlagSet("analyze", flag.ExitOnError)
+
+	analyzeFile      = analyzeFlags.String("r", "", "Pcap file to analyze (\"-\" for stdin), pcapng files aren't supported")
+	analyzePorts     = analyzeFlags.String("p", "9092", "Comma separated list of kafka broker ports")
+	analyzeBrokers   = analyzeFlags.String("brokers", "", "Comma separated list of broker addresses classifying connections as client or inter-broker ones")
+	analyzeResponses = analyzeFlags.Bool("responses", false, "Decode responses too, the file must contain both directions of connections")
+	analyzeFormat    = analyzeFlags.String("format", "text", "Format of the report: text (table) or json (as /api/v1/topics or /api/v1/versions)")
+	analyzeReport    = analyzeFlags.String("report", "topics", "Report: topics with their producers and consumers or versions of apis used by clients")
+	analyzeVersions  = analyzeFlags.String("min-versions", "kafka-4.0", "Comma separated list of minimal versions of apis by presets (kafka-4.0) and <api>=<version> items, older versions are reported as deprecated")
+)
+
+// analyze decodes pcap file without capture and telemetry and prints topics with their producers and consumers
+// or versions of apis used by clients
+func analyze() error {
+	if *analyzeFile == "" {
+		return fmt.Errorf("pcap file must be set with -r")
+	}
+	if *analyzeFormat != "text" && *analyzeFormat != "json" {
+		return fmt.Errorf("unknown report format %q", *analyzeFormat)
+	}
+	if *analyzeReport != "topics" && *analyzeReport != "versions" {
+		return fmt.Errorf("unknown report %q", *analyzeReport)
+	}
+
+	minVersions, err := stream.ParseMinVersions(*analyzeVersions)
+	if err != nil {
+		return err
+	}
+
+	ports, err := parsePorts(*analyzePorts)
+	if err != nil {
+		return err
+	}
+	brokerPorts := make(map[uint16]bool, len(ports))
+	for _, port := range ports {
+		brokerPorts[port] = true
+	}
+
+	brokerSet, err := stream.NewBrokers(strings.Split(*analyzeBrokers, ","))
+	if err != nil {
+		return err
+	}
+
+	f := os.Stdin
+	if *analyzeFile != "-" {
+		if f, err = os.Open(*analyzeFile); err != nil {
+			return err
+		}
+	}
+	source, err := capture.NewStreamSource(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer source.Close()
+
+	// relations are reported by the command, they don't expire while the file is analyzed
+	storage := metrics.NewStorage(prometheus.NewRegistry(), 24*time.Hour)
+	factory := stream.NewKafkaStreamFactory(storage, metrics.NewRebalanceDetector(prometheus.NewRegistry(), 0), nil, stream.Config{
+		BrokerPorts: brokerPorts,
+		Responses:   *analyzeResponses,
+		Brokers:     brokerSet,
+		MinVersions: minVersions,
+	})
+
+	// pcap streams aren't filtered by capture, packets are filtered by broker ports
+	pipeline := sniffer.NewPipeline(factory, sniffer.PipelineConfig{
+		LinkType:    source.LinkType(),
+		QueueSize:   1000,
+		BrokerPorts: brokerPorts,
+		FilterPorts: true,
+		Responses:   *analyzeResponses,
+	})
+	pipeline.Run(source)
+	pipeline.Close()
+	factory.Wait()
+
+	var report interface{}
+	if *analyzeReport == "versions" {
+		report = api.Versions(storage, false)
+	} else {
+		report = api.Topics(storage)
+	}
+
+	if *analyzeFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	if *analyzeReport == "versions" {
+		return writeVersionsTable(os.Stdout, report.([]*api.ClientVersions))
+	}
+	return writeTopicsTable(os.Stdout, report.([]*api.TopicClients))
+}
+
+// writeTopicsTable writes topics with their producers and consumers as a table, one client per row
+func writeTopicsTable(w io.Writer, topics []*api.TopicClients) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOPIC\tROLE\tCLIENT\tPRINCIPAL\tCONNECTION")
+	for _, t := range topics {
+		for _, c := range t.Producers {
+			fmt.Fprintf(tw, "%s\tproducer\t%s\t%s\t%s\n", t.Topic, c.ClientIP, c.Principal, c.Connection)
+		}
+		for _, c := range t.Consumers {
+			fmt.Fprintf(tw, "%s\tconsumer\t%s\t%s\t%s\n", t.Topic, c.ClientIP, c.Principal, c.Connection)
+		}
+	}
+	return tw.Flush()
+}
+
+// writeVersionsTable writes versions of apis used by clients as a table, one api of client per row
+func writeVersionsTable(w io.Writer, clients []*api.ClientVersions) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT\tCLIENT ID\tAPI\tMIN\tMAX\tDEPRECATED")
+	for _, c := range clients {
+		for _, a := range c.APIs {
+			deprecated := make([]string, 0, len(a.Deprecated))
+			for _, version := range a.Deprecated {
+				deprecated = append(deprecated, strconv.Itoa(int(version)))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", c.ClientIP, c.ClientID, a.API, a.MinVersion, a.MaxVersion, strings.Join(deprecated, ","))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/sniffer"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+var (
+	captureBackend = flag.String("capture.backend", string(capture.BackendPcap), "Packet capture backend: pcap or ebpf (experimental, linux only, filters broker port in kernel)")
+	decap          = flag.Bool("decap", false, "Decapsulate kafka traffic tunneled in VXLAN, Geneve or GRE overlays, not supported by ebpf backend")
+	remoteCommand  = flag.String("remote.cmd", "", "Shell command writing pcap stream of remote capture to stdout, e.g. \"ssh broker1 sudo tcpdump -i eth0 -U -w - tcp dst port 9092\", it's restarted when it exits")
+
+	netns     = flag.String("netns", "", "Network namespace to capture in: path like /proc/<pid>/ns/net, pid or name of \"ip netns\" namespace")
+	container = flag.String("container", "", "Docker, containerd or cri-o container ID (full or short) to capture in its network namespace")
+
+	responses = flag.Bool("responses", false, "Capture responses of brokers besides requests to pair them by correlation id, e.g. for response time metric")
+	promisc   = flag.Bool("promisc", true, "Capture in promiscuous mode, e.g. traffic of SPAN port, \"any\" interface is never promiscuous")
+
+	pcapBufferSize = flag.Int("pcap.buffer-size", 0, "Size of libpcap kernel buffer in bytes, increase it if packets are dropped on traffic bursts, 0 is libpcap default")
+	pcapImmediate  = flag.Bool("pcap.immediate", false, "Deliver packets as soon as they arrive instead of buffering them in libpcap, lowers latency at the cost of CPU")
+	pcapTimeout    = flag.Duration("pcap.timeout", 0, "Libpcap poll timeout of buffered packets delivery, 0 blocks until buffer is full")
+)
+
+// inNetns calls fn in network namespace set by -netns or -container flag. Container is resolved on every call,
+// so capture reopened after restart of container is opened in its new namespace.
+func inNetns(fn func() error) error {
+	switch {
+	case *container != "":
+		pid, err := capture.ContainerPid(*container)
+		if err != nil {
+			return err
+		}
+		return capture.InNetns(capture.NetnsPath(strconv.Itoa(pid)), fn)
+	case *netns != "":
+		return capture.InNetns(capture.NetnsPath(*netns), fn)
+	default:
+		return fn()
+	}
+}
+
+// openLive opens live libpcap capture tuned by -pcap.* flags in network namespace set by -netns flag
+func openLive() (handle *pcap.Handle, err error) {
+	err = inNetns(func() error {
+		handle, err = activateLive()
+		return err
+	})
+	return handle, err
+}
+
+func activateLive() (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle(*iface)
+	if err != nil {
+		return nil, err
+	}
+	defer inactive.CleanUp()
+
+	if err = inactive.SetSnapLen(*snaplen); err != nil {
+		return nil, err
+	}
+	// "any" pseudo-interface doesn't support promiscuous mode, libpcap fails with warning
+	if err = inactive.SetPromisc(*promisc && *iface != capture.AnyInterface); err != nil {
+		return nil, err
+	}
+
+	timeout := pcap.BlockForever
+	if *pcapTimeout > 0 {
+		timeout = *pcapTimeout
+	}
+	if err = inactive.SetTimeout(timeout); err != nil {
+		return nil, err
+	}
+
+	if *pcapBufferSize > 0 {
+		if err = inactive.SetBufferSize(*pcapBufferSize); err != nil {
+			return nil, err
+		}
+	}
+	if *pcapImmediate {
+		if err = inactive.SetImmediateMode(true); err != nil {
+			return nil, err
+		}
+	}
+
+	return inactive.Activate()
+}
+
+// parsePorts parses comma separated list of ports
+func parsePorts(s string) ([]uint16, error) {
+	var ports []uint16
+	for _, item := range strings.Split(s, ",") {
+		port, err := strconv.ParseUint(strings.TrimSpace(item), 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		ports = append(ports, uint16(port))
+	}
+	return ports, nil
+}
+
+// bpfFilter returns capture filter of requests to broker ports, e.g. "tcp and (dst port 9092 or dst port 9093)",
+// so responses and other traffic aren't copied to userspace unless responses are captured. Filter set by -f
+// flag is returned as is. With decapsulation the whole overlay traffic is captured, since BPF can't filter
+// tunneled packets.
+func bpfFilter(ports []uint16) string {
+	if *filter != "" {
+		return *filter
+	}
+
+	filter := sniffer.BPFFilter(ports, *responses)
+
+	if *decap {
+		filter = fmt.Sprintf("(%s) or (udp and (dst port %d or dst port %d)) or (ip proto gre) or (ip6 proto gre)", filter, capture.VXLANPort, capture.GenevePort)
+	}
+
+	return filter
+}
+
+// linkTypeOf returns link type of pcap handle, link type of file set by -r flag is read from the file
+func linkTypeOf(handle *pcap.Handle) (uint32, error) {
+	if *readFile != "" {
+		return capture.FileLinkType(*readFile)
+	}
+	return uint32(handle.LinkType()), nil
+}
+
+// openCapture opens source of packets to broker ports: pcap file or stream, remote capture command, live
+// capture with libpcap (including rpcap:// interfaces) or with eBPF socket filter. It returns the source
+// with its link type and snaplen. Pcap streams aren't filtered by capture filter.
+func openCapture(ports []uint16) (gopacket.PacketDataSource, uint32, int, error) {
+	if *readFile == "-" || *remoteCommand != "" {
+		if *filter != "" {
+			return nil, 0, 0, fmt.Errorf("custom capture filter isn't supported by pcap streams, filter packets by remote capture")
+		}
+
+		var (
+			source *capture.StreamSource
+			err    error
+		)
+		if *readFile == "-" {
+			log.Println("reading packets from stdin")
+			source, err = capture.NewStreamSource(os.Stdin)
+		} else {
+			log.Printf("starting remote capture command %q", *remoteCommand)
+			source, err = capture.StartCommand(*remoteCommand)
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return source, source.LinkType(), source.Snaplen(), nil
+	}
+
+	if *readFile != "" {
+		log.Printf("reading packets from file %q", *readFile)
+
+		handle, err := pcap.OpenOffline(*readFile)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if err = handle.SetBPFFilter(bpfFilter(ports)); err != nil {
+			return nil, 0, 0, err
+		}
+		linkType, err := linkTypeOf(handle)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return handle, linkType, handle.SnapLen(), nil
+	}
+
+	switch {
+	case *container != "":
+		log.Printf("starting capture on interface %q in container %q with %s backend", *iface, *container, *captureBackend)
+	case *netns != "":
+		log.Printf("starting capture on interface %q in network namespace %q with %s backend", *iface, *netns, *captureBackend)
+	default:
+		log.Printf("starting capture on interface %q with %s backend", *iface, *captureBackend)
+	}
+
+	switch capture.Backend(*captureBackend) {
+	case capture.BackendPcap:
+		handle, err := openLive()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if err = handle.SetBPFFilter(bpfFilter(ports)); err != nil {
+			return nil, 0, 0, err
+		}
+		return handle, uint32(handle.LinkType()), *snaplen, nil
+	case capture.BackendEBPF:
+		if *filter != "" {
+			return nil, 0, 0, fmt.Errorf("custom capture filter isn't supported by %s backend", capture.BackendEBPF)
+		}
+		if *decap {
+			return nil, 0, 0, fmt.Errorf("decapsulation isn't supported by %s backend", capture.BackendEBPF)
+		}
+		var source *capture.EBPFSource
+		err := inNetns(func() (err error) {
+			source, err = capture.OpenEBPF(*iface, ports, capture.EBPFOptions{
+				Snaplen:   *snaplen,
+				Fanout:    *workers > 1,
+				Promisc:   *promisc,
+				Responses: *responses,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return source, uint32(layers.LinkTypeRaw), *snaplen, nil
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown capture backend %q", *captureBackend)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/d-ulyanov/kafka-sniffer/alerts"
+
+	"github.com/google/gopacket/pcap"
+)
+
+var check = flag.Bool("check", false, "Validate configuration, open capture and compile capture filter, print the effective configuration and exit without capturing, e.g. in CI before rollout")
+
+// knownOutputs are outputs supported by -output flag
+var knownOutputs = map[string]bool{
+	"json": true, "csv": true, "tsv": true, "file": true, "audit": true, "kafka": true, "nats": true,
+	"websocket": true, "syslog": true, "clickhouse": true, "elasticsearch": true, "otlp": true, "parquet": true,
+	"exec": true,
+}
+
+// checkConfig validates the rest of configuration which isn't validated before capture is started: outputs
+// aren't opened, alert rules are loaded, capture is opened with the capture filter to verify interface and
+// permissions and closed. Pcap streams and remote capture commands aren't opened.
+func checkConfig() error {
+	for _, output := range strings.Split(*output, ",") {
+		output = strings.TrimSpace(output)
+		if output != "" && !knownOutputs[output] {
+			return fmt.Errorf("unknown output %q", output)
+		}
+		if output == "kafka" && *outputKafkaBrokers == "" {
+			return fmt.Errorf("kafka output requires -output.kafka.brokers")
+		}
+		if output == "exec" && *outputExecCommand == "" {
+			return fmt.Errorf("exec output requires -output.exec.command")
+		}
+		if err := checkBatching(output); err != nil {
+			return err
+		}
+	}
+
+	ports, err := parsePorts(*dstports)
+	if err != nil {
+		return err
+	}
+
+	if *alertsRules != "" {
+		if _, err = alerts.LoadRules(*alertsRules); err != nil {
+			return fmt.Errorf("could not load alert rules: %s", err)
+		}
+	}
+
+	if *readFile == "-" || *remoteCommand != "" {
+		return nil
+	}
+
+	source, _, _, err := openCapture(ports)
+	if err != nil {
+		return fmt.Errorf("could not open capture: %s", err)
+	}
+	switch source := source.(type) {
+	case *pcap.Handle:
+		source.Close()
+	case io.Closer:
+		source.Close()
+	}
+
+	return nil
+}
+
+// writeConfig writes effective values of all flags, tokens and passwords are masked
+func writeConfig(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE")
+	flag.VisitAll(func(f *flag.Flag) {
+		value := maskPassword(f.Value.String())
+		if (strings.HasSuffix(f.Name, ".token") || strings.HasSuffix(f.Name, ".password")) && value != "" {
+			value = "xxxxx"
+		}
+		fmt.Fprintf(tw, "-%s\t%s\n", f.Name, value)
+	})
+	return tw.Flush()
+}
+
+// maskPassword masks password of URL, other values are returned as is
+func maskPassword(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); !ok {
+		return value
+	}
+	u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return u.String()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/d-ulyanov/kafka-sniffer/version"
+)
+
+// command is a subcommand of sniffer with its own flags
+type command struct {
+	name        string
+	description string
+	flags       *flag.FlagSet
+
+	// run runs the command after its flags are parsed
+	run func() error
+}
+
+// defaultCommand runs when the first argument isn't a command name, so flags without command work as before
+// subcommands were introduced
+const defaultCommand = "sniff"
+
+// commands are subcommands of sniffer, sniff command uses the default flag set, so flags registered by
+// libraries (e.g. -assembly_debug_log) are available for it
+var commands = []*command{
+	{name: "sniff", description: "Capture kafka traffic and export metrics and events (default)", flags: flag.CommandLine, run: sniff},
+	{name: "analyze", description: "Analyze pcap file offline and print producers and consumers of topics", flags: analyzeFlags, run: analyze},
+	{name: "compare", description: "Compare clients and topics of sniffers of old and new clusters during migration", flags: compareFlags, run: compare},
+	{name: "explain", description: "Decode a single request dumped as hex or base64, e.g. copied from Wireshark, and print its structure", flags: explainFlags, run: explain},
+	{name: "replay", description: "Re-produce captured produce traffic of pcap file or events to a target cluster at original or scaled speed", flags: replayFlags, run: replay},
+	{name: "verify", description: "Verify hash chain and signatures of audit log of audit output", flags: verifyFlags, run: verify},
+	{name: "interfaces", description: "List interfaces available for capture with their addresses", flags: interfacesFlags, run: printInterfaces},
+	{name: "version", description: "Print version of sniffer", flags: versionFlags, run: printVersion},
+}
+
+// findCommand returns command by name, it's nil for unknown name
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// runCommand runs command chosen by the first argument with the rest of arguments
+func runCommand(args []string) error {
+	cmd := findCommand(defaultCommand)
+	if len(args) > 0 {
+		if c := findCommand(args[0]); c != nil {
+			cmd, args = c, args[1:]
+		}
+	}
+
+	cmd.flags.Usage = func() {
+		commandUsage(cmd)
+	}
+
+	// sniff command parses the default flag set itself to start profiling by -cpuprofile flag
+	if cmd.flags != flag.CommandLine {
+		if err := cmd.flags.Parse(args); err != nil {
+			return err
+		}
+		if err := applyEnv(cmd.flags); err != nil {
+			return err
+		}
+	} else {
+		os.Args = append(os.Args[:1], args...)
+	}
+
+	return cmd.run()
+}
+
+// commandUsage prints usage of command with list of all commands
+func commandUsage(cmd *command) {
+	out := cmd.flags.Output()
+
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(out, "\nFlags of %s command (or KAFKA_SNIFFER_* environment variables):\n", cmd.name)
+	cmd.flags.PrintDefaults()
+}
+
+var versionFlags = flag.NewFlagSet("version", flag.ExitOnError)
+
+func printVersion() error {
+	fmt.Printf("kafka-sniffer %s (revision %s, branch %s)\n", version.Version, version.Revision, version.Branch)
+	return nil
+}
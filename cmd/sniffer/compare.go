@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/api"
+)
+
+var (
+	compareFlags = flag.NewFlagSet("compare", flag.ExitOnError)
+
+	compareOld     = compareFlags.String("old", "", "Address of sniffer of the old cluster, e.g. http://old-sniffer:9870")
+	compareNew     = compareFlags.String("new", "", "Address of sniffer of the new cluster, e.g. http://new-sniffer:9870")
+	compareFormat  = compareFlags.String("format", "text", "Format of the report: text (table) or json")
+	compareFail    = compareFlags.Bool("fail", false, "Exit with error if clients still talk to the old cluster, e.g. to gate decommission of the old cluster")
+	compareTimeout = compareFlags.Duration("timeout", 10*time.Second, "Timeout of requests to relations API of sniffers")
+)
+
+// errClientsRemain is returned by compare with -fail flag if clients still talk to the old cluster
+var errClientsRemain = errors.New("clients still talk to the old cluster")
+
+// compare fetches topics with their producers and consumers from relations API of sniffers of old and new
+// clusters and reports clients still pointed at the old cluster
+func compare() error {
+	if *compareOld == "" || *compareNew == "" {
+		return fmt.Errorf("sniffers of old and new clusters must be set with -old and -new")
+	}
+	if *compareFormat != "text" && *compareFormat != "json" {
+		return fmt.Errorf("unknown report format %q", *compareFormat)
+	}
+
+	client := &http.Client{Timeout: *compareTimeout}
+
+	oldTopics, err := fetchTopics(client, *compareOld)
+	if err != nil {
+		return err
+	}
+	newTopics, err := fetchTopics(client, *compareNew)
+	if err != nil {
+		return err
+	}
+
+	c := api.Compare(oldTopics, newTopics)
+
+	if *compareFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(c)
+	} else {
+		err = writeComparison(os.Stdout, c)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *compareFail && len(c.Remaining) > 0 {
+		return errClientsRemain
+	}
+	return nil
+}
+
+// fetchTopics fetches topics with their producers and consumers from /api/v1/topics of sniffer
+func fetchTopics(client *http.Client, addr string) ([]*api.TopicClients, error) {
+	url := strings.TrimSuffix(addr, "/") + api.Prefix + "topics"
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+
+	var topics []*api.TopicClients
+	if err = json.NewDecoder(resp.Body).Decode(&topics); err != nil {
+		return nil, fmt.Errorf("%s: %s", url, err)
+	}
+	return topics, nil
+}
+
+// writeComparison writes clients still talking to the old cluster, clients migrated to the new one and topics
+// used on one of clusters only as tables
+func writeComparison(w io.Writer, c *api.Comparison) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Clients still talking to the old cluster: %d\n", len(c.Remaining))
+	writeMigrationClients(tw, c.Remaining, true)
+
+	fmt.Fprintf(tw, "\nClients migrated to the new cluster: %d\n", len(c.Migrated))
+	writeMigrationClients(tw, c.Migrated, false)
+
+	fmt.Fprintf(tw, "\nTopics used on the old cluster only: %s\n", joinOrNone(c.OldTopics))
+	fmt.Fprintf(tw, "Topics used on the new cluster only: %s\n", joinOrNone(c.NewTopics))
+
+	return tw.Flush()
+}
+
+func writeMigrationClients(w io.Writer, clients []*api.MigrationClient, remaining bool) {
+	if len(clients) == 0 {
+		return
+	}
+
+	if remaining {
+		fmt.Fprintln(w, "CLIENT\tPRINCIPAL\tPRODUCES\tCONSUMES\tLAST SEEN\tON NEW")
+	} else {
+		fmt.Fprintln(w, "CLIENT\tPRINCIPAL\tPRODUCES\tCONSUMES\tLAST SEEN")
+	}
+	for _, c := range clients {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s", c.ClientIP, c.Principal, joinOrNone(c.Produces), joinOrNone(c.Consumes), c.LastSeen.Format(time.RFC3339))
+		if remaining {
+			fmt.Fprintf(w, "\t%t", c.OnBoth)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func joinOrNone(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/sniffer"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+// diagnostics is a registry of internal state of sniffer dumped to the log on demand, e.g. by SIGUSR1
+type diagnostics struct {
+	factory  *stream.KafkaStreamFactory
+	storage  *metrics.Storage
+	pipeline *sniffer.Pipeline
+	started  time.Time
+}
+
+// dump logs active connections, counts of relations and stats of the pipeline
+func (d *diagnostics) dump() {
+	connections := d.factory.Connections()
+
+	log.Printf("==== diagnostics: uptime %s, %d active connections ====\n", time.Since(d.started).Round(time.Second), len(connections))
+	for _, c := range connections {
+		log.Printf("connection %s -> %s: started %s, client id %q, principal %q, encrypted %t, %d requests, %d bytes buffered\n",
+			c.Client, c.Broker, c.Started.Format(time.RFC3339), c.ClientID, c.Principal, c.Encrypted, c.Requests, c.Buffered)
+	}
+
+	counts := d.storage.RelationCounts()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("relations %s: %d\n", name, counts[name])
+	}
+
+	for i, w := range d.pipeline.Workers() {
+		log.Printf("worker %d: %d/%d segments queued, %d segments assembled\n", i, w.Queued, w.QueueSize, w.Assembled)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.Printf("goroutines: %d, heap: %d bytes, GC cycles: %d\n", runtime.NumGoroutine(), mem.HeapAlloc, mem.NumGC)
+	log.Println("==== end of diagnostics ====")
+}
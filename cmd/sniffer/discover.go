@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+var (
+	discoverDuration     = flag.Duration("discover.duration", 0, "Watch all TCP traffic for this duration to detect kafka broker ports instead of -p port, 0 disables discovery")
+	discoverMinResponses = flag.Int("discover.min-responses", 3, "Min count of kafka responses on port to consider it a broker port")
+)
+
+// discoverPorts watches TCP traffic with libpcap and returns detected broker ports. Pcap file
+// set by -r flag is read up to the end regardless of discovery duration.
+func discoverPorts() ([]uint16, error) {
+	if *readFile == "-" || *remoteCommand != "" {
+		return nil, errors.New("discovery isn't supported by pcap streams")
+	}
+
+	var (
+		handle *pcap.Handle
+		err    error
+	)
+	if *readFile != "" {
+		handle, err = pcap.OpenOffline(*readFile)
+	} else {
+		handle, err = openLive()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	if err = handle.SetBPFFilter("tcp"); err != nil {
+		return nil, err
+	}
+
+	linkType, err := linkTypeOf(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("discovering kafka ports during %s", *discoverDuration)
+
+	var (
+		detector = stream.NewPortDetector()
+		packets  = gopacket.NewPacketSource(handle, capture.Decoder(linkType)).Packets()
+		timeout  = time.After(*discoverDuration)
+	)
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				return detector.Ports(*discoverMinResponses), nil
+			}
+			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeTCP {
+				continue
+			}
+			detector.Observe(packet.NetworkLayer().NetworkFlow(), packet.TransportLayer().(*layers.TCP))
+		case <-timeout:
+			if *readFile == "" {
+				return detector.Ports(*discoverMinResponses), nil
+			}
+		}
+	}
+}
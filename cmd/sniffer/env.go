@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is a prefix of environment variables setting flags
+const envPrefix = "KAFKA_SNIFFER_"
+
+// envName returns name of environment variable of flag, e.g. KAFKA_SNIFFER_OUTPUT_FILE_MAX_SIZE for
+// -output.file.max-size
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flagName))
+}
+
+// applyEnv sets flags of the set from environment variables, flags set in command line take precedence
+func applyEnv(set *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	set.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	set.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := set.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q of %s: %s", value, name, setErr)
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+)
+
+var (
+	explainFlags = flag.NewFlagSet("explain", flag.ExitOnError)
+
+	explainEncoding = explainFlags.String("encoding", "auto", "Encoding of the dump: hex (hex stream, bytes separated by spaces or colons, or hex dump with offsets as copied from Wireshark), base64 or auto")
+	explainMaxBytes = explainFlags.Int("max-bytes", 64, "Max bytes of byte fields printed, e.g. of record keys and values, 0 prints all bytes")
+)
+
+// explainMaxDepth limits nesting of printed values
+const explainMaxDepth = 32
+
+// explain decodes a single request dumped as hex or base64 and prints its decoded structure, the dump is read
+// from the argument or stdin. Length prefix of request is optional, so both TCP payloads and request messages
+// can be explained.
+func explain() error {
+	var input []byte
+	switch arg := explainFlags.Arg(0); arg {
+	case "", "-":
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		input = data
+	default:
+		input = []byte(strings.Join(explainFlags.Args(), " "))
+	}
+
+	data, err := decodeDump(string(input), *explainEncoding)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("dump of request is empty")
+	}
+
+	data = frameRequest(data)
+	req, readBytes, err := kafka.DecodeRequest(bytes.NewReader(data))
+	if err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("request is truncated: dump has %d bytes of %d", len(data), binary.BigEndian.Uint32(data)+4)
+	}
+	if err != nil {
+		return fmt.Errorf("could not decode request: %s", err)
+	}
+
+	w := os.Stdout
+	fmt.Fprintf(w, "%s v%d request, %d bytes\n", kafka.APIKeyName(req.Key), req.Version, readBytes)
+	fmt.Fprintf(w, "CorrelationID: %d\n", req.CorrelationID)
+	fmt.Fprintf(w, "ClientID: %s\n", strconv.Quote(req.ClientID))
+	if req.Body == nil {
+		fmt.Fprintln(w, "Body: not decoded, api isn't supported by sniffer")
+	} else {
+		writeExplained(w, "Body", reflect.ValueOf(req.Body), 0)
+	}
+	if trailing := len(data) - readBytes; trailing > 0 {
+		fmt.Fprintf(w, "%d bytes after the request are ignored\n", trailing)
+	}
+
+	return nil
+}
+
+// decodeDump decodes dump of request by encoding, encoding is detected by characters of dump in auto mode
+func decodeDump(dump, encoding string) ([]byte, error) {
+	switch encoding {
+	case "hex":
+		return decodeHexDump(dump)
+	case "base64":
+		return decodeBase64Dump(dump)
+	case "auto":
+		// base64 of request may consist of hex digits only, but their letters are of both cases
+		if !hasMixedCase(dump) {
+			if data, err := decodeHexDump(dump); err == nil {
+				return data, nil
+			}
+		}
+		data, err := decodeBase64Dump(dump)
+		if err != nil {
+			return nil, fmt.Errorf("dump is neither hex nor base64")
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding of dump %q", encoding)
+	}
+}
+
+// decodeHexDump decodes hex stream with optional separators of bytes or hex dump with offsets and ASCII columns
+func decodeHexDump(dump string) ([]byte, error) {
+	var digits strings.Builder
+	for _, line := range strings.Split(dump, "\n") {
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ':'
+		})
+
+		// lines of hex dump are offset, up to 16 bytes and their ASCII
+		if len(fields) > 1 && len(fields[0]) >= 4 && len(fields[1]) == 2 {
+			fields = fields[1:]
+			n := 0
+			for n < len(fields) && n < 16 && len(fields[n]) == 2 && isHex(fields[n]) {
+				n++
+			}
+			fields = fields[:n]
+		}
+
+		for _, f := range fields {
+			digits.WriteString(strings.TrimPrefix(f, "0x"))
+		}
+	}
+
+	return hex.DecodeString(digits.String())
+}
+
+// decodeBase64Dump decodes standard base64 with or without padding
+func decodeBase64Dump(dump string) ([]byte, error) {
+	dump = strings.Join(strings.Fields(dump), "")
+	if data, err := base64.StdEncoding.DecodeString(dump); err == nil {
+		return data, nil
+	}
+	return base64.RawStdEncoding.DecodeString(dump)
+}
+
+func hasMixedCase(s string) bool {
+	return strings.ToLower(s) != s && strings.ToUpper(s) != s
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// frameRequest returns request with length prefix. Dumps of TCP payloads have it and may have more requests
+// after the first one, dumps of request messages start with api key.
+func frameRequest(data []byte) []byte {
+	if len(data) >= 8 {
+		length := int(binary.BigEndian.Uint32(data))
+		if length == len(data)-4 || length < len(data)-4 && isKnownKey(int16(binary.BigEndian.Uint16(data[4:]))) {
+			return data
+		}
+	}
+
+	framed := make([]byte, 4, len(data)+4)
+	binary.BigEndian.PutUint32(framed, uint32(len(data)))
+	return append(framed, data...)
+}
+
+func isKnownKey(key int16) bool {
+	_, ok := kafka.LookupAPIKey(key)
+	return ok
+}
+
+// writeExplained writes named value with all its fields including unexported ones, nested values are indented
+func writeExplained(w io.Writer, name string, v reflect.Value, depth int) {
+	prefix := strings.Repeat("  ", depth) + name + ": "
+	if depth > explainMaxDepth {
+		fmt.Fprintln(w, prefix+"...")
+		return
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			fmt.Fprintln(w, prefix+"nil")
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case time.Time:
+			fmt.Fprintln(w, prefix+value.Format(time.RFC3339Nano))
+			return
+		case []byte:
+			fmt.Fprintln(w, prefix+explainBytes(value))
+			return
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fmt.Fprintln(w, prefix+v.Type().String())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+
+			// values of unexported fields can't be used as interfaces, e.g. to format time or names of codecs,
+			// they are read by their addresses
+			if !field.CanInterface() && field.CanAddr() {
+				field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+			}
+			writeExplained(w, v.Type().Field(i).Name, field, depth+1)
+		}
+	case reflect.Map:
+		fmt.Fprintf(w, "%s%d entries\n", prefix, v.Len())
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return explainScalar(keys[i]) < explainScalar(keys[j]) })
+		for _, key := range keys {
+			writeExplained(w, "["+explainScalar(key)+"]", v.MapIndex(key), depth+1)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			for i := range b {
+				b[i] = byte(v.Index(i).Uint())
+			}
+			fmt.Fprintln(w, prefix+explainBytes(b))
+			return
+		}
+		fmt.Fprintf(w, "%s%d items\n", prefix, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			writeExplained(w, "["+strconv.Itoa(i)+"]", v.Index(i), depth+1)
+		}
+	default:
+		fmt.Fprintln(w, prefix+explainScalar(v))
+	}
+}
+
+// explainScalar returns value of basic kind, names of values of types with String method are added, e.g. of
+// compression codecs
+func explainScalar(v reflect.Value) string {
+	var s string
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Bool:
+		s = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		s = strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	default:
+		return v.Type().String()
+	}
+
+	if v.CanInterface() {
+		if stringer, ok := v.Interface().(fmt.Stringer); ok {
+			if name := stringer.String(); name != s {
+				s += " (" + name + ")"
+			}
+		}
+	}
+	return s
+}
+
+// explainBytes returns bytes as quoted string if they are printable UTF-8 or as hex, bytes over -max-bytes are
+// cut off
+func explainBytes(b []byte) string {
+	size := len(b)
+	if *explainMaxBytes > 0 && size > *explainMaxBytes {
+		b = b[:*explainMaxBytes]
+	}
+
+	var s string
+	if utf8.Valid(b) && isPrintable(string(b)) {
+		s = strconv.Quote(string(b))
+	} else {
+		s = "0x" + hex.EncodeToString(b)
+	}
+	if len(b) < size {
+		s += "..."
+	}
+	return fmt.Sprintf("%s (%d bytes)", s, size)
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// interfacesFlags share -netns and -container flags with sniff command, so interfaces are listed in the namespace
+// sniff would capture in
+var interfacesFlags = flag.NewFlagSet("interfaces", flag.ExitOnError)
+
+func init() {
+	for _, name := range []string{"netns", "container"} {
+		f := flag.CommandLine.Lookup(name)
+		interfacesFlags.Var(f.Value, f.Name, f.Usage)
+	}
+}
+
+// printInterfaces lists interfaces available for capture
+func printInterfaces() error {
+	if *container != "" && *netns != "" {
+		return fmt.Errorf("-container and -netns are mutually exclusive")
+	}
+	if err := listInterfaces(os.Stdout); err != nil {
+		return fmt.Errorf("could not list interfaces: %s", err)
+	}
+	return nil
+}
+
+// listInterfaces writes table of interfaces available for capture with their addresses, interfaces are listed
+// in network namespace set by -netns or -container flag
+func listInterfaces(w io.Writer) error {
+	var devices []pcap.Interface
+	err := inNetns(func() (err error) {
+		devices, err = pcap.FindAllDevs()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INTERFACE\tADDRESSES\tDESCRIPTION")
+	for _, device := range devices {
+		addresses := make([]string, 0, len(device.Addresses))
+		for _, address := range device.Addresses {
+			if address.Netmask == nil {
+				addresses = append(addresses, address.IP.String())
+				continue
+			}
+			ones, _ := address.Netmask.Size()
+			addresses = append(addresses, fmt.Sprintf("%s/%d", address.IP, ones))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", device.Name, strings.Join(addresses, ","), device.Description)
+	}
+
+	return tw.Flush()
+}
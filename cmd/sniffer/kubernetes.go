@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+)
+
+var k8sMode = flag.Bool("k8s", false, "Kubernetes DaemonSet mode: node name and zone are detected from NODE_NAME and NODE_ZONE variables (downward API) or cloud metadata service and attached to metrics as node and zone labels, capture interface is the CNI bridge of the node unless -i is set")
+
+// cniBridges are names of bridges of common CNI plugins connecting pods of the node
+var cniBridges = []string{"cni0", "cbr0", "kube-bridge", "weave", "docker0"}
+
+// metadataTimeout limits requests to every cloud metadata service, they aren't available outside of cloud
+const metadataTimeout = time.Second
+
+// metadata services of AWS and GCP
+var (
+	awsMetadataURL = "http://169.254.169.254"
+	gcpMetadataURL = "http://metadata.google.internal"
+)
+
+// nodeLabels returns node and zone labels of the node sniffer runs on, labels which aren't detected are skipped
+func nodeLabels() map[string]string {
+	labels := make(map[string]string)
+
+	node := os.Getenv("NODE_NAME")
+	if node == "" {
+		// pods of DaemonSet with host network have host name of the node
+		node, _ = os.Hostname()
+	}
+	if node != "" {
+		labels["node"] = node
+	}
+
+	zone := os.Getenv("NODE_ZONE")
+	if zone == "" {
+		zone = metadataZone()
+	}
+	if zone != "" {
+		labels["zone"] = zone
+	}
+
+	return labels
+}
+
+// metadataZone returns availability zone from metadata service of AWS or GCP, it's empty outside of them
+func metadataZone() string {
+	if zone, ok := awsZone(); ok {
+		return zone
+	}
+	return gcpZone()
+}
+
+// awsZone returns availability zone from AWS metadata service and true if sniffer runs in AWS
+func awsZone() (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+
+	// AWS requires session token of IMDSv2
+	token, err := metadataGet(ctx, http.MethodPut, awsMetadataURL+"/latest/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return "", false
+	}
+
+	zone, _ := metadataGet(ctx, http.MethodGet, awsMetadataURL+"/latest/meta-data/placement/availability-zone", map[string]string{"X-aws-ec2-metadata-token": token})
+	return zone, true
+}
+
+// gcpZone returns zone from GCP metadata service, its name is only resolved inside of GCP
+func gcpZone() string {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+
+	u, err := url.Parse(gcpMetadataURL)
+	if err != nil {
+		return ""
+	}
+	if _, err = net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+		return ""
+	}
+
+	// GCP returns projects/<number>/zones/<zone>
+	zone, err := metadataGet(ctx, http.MethodGet, gcpMetadataURL+"/computeMetadata/v1/instance/zone", map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return ""
+	}
+	return zone[strings.LastIndex(zone, "/")+1:]
+}
+
+// metadataGet returns body of successful response of metadata service
+func metadataGet(ctx context.Context, method, rawurl string, headers map[string]string) (string, error) {
+	req, err := http.NewRequest(method, rawurl, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return strings.TrimSpace(string(body)), err
+}
+
+// cniInterface returns the first existing bridge of common CNI plugins, it's "any" if there is no bridge,
+// e.g. with calico or cilium routing pods without bridge
+func cniInterface() string {
+	var ifaces []net.Interface
+	err := inNetns(func() (err error) {
+		ifaces, err = net.Interfaces()
+		return err
+	})
+	if err != nil {
+		return capture.AnyInterface
+	}
+
+	existing := make(map[string]bool, len(ifaces))
+	for _, i := range ifaces {
+		existing[i.Name] = true
+	}
+	for _, name := range cniBridges {
+		if existing[name] {
+			return name
+		}
+	}
+
+	return capture.AnyInterface
+}
+
+// isFlagSet returns true if flag is set in command line or by environment variable
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
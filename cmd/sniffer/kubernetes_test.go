@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+)
+
+// setEnv sets variable and returns function restoring its previous value
+func setEnv(t *testing.T, name, value string) func() {
+	prev, ok := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if ok {
+			os.Setenv(name, prev)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestNodeLabels(t *testing.T) {
+	defer setEnv(t, "NODE_NAME", "node-1")()
+	defer setEnv(t, "NODE_ZONE", "eu-west-1a")()
+
+	labels := nodeLabels()
+	if len(labels) != 2 || labels["node"] != "node-1" || labels["zone"] != "eu-west-1a" {
+		t.Errorf("labels of node are %v, want node-1 in eu-west-1a", labels)
+	}
+
+	// pods with host network have host name of the node
+	defer setEnv(t, "NODE_NAME", "")()
+	hostname, _ := os.Hostname()
+	if labels = nodeLabels(); labels["node"] != hostname {
+		t.Errorf("node label is %q, want host name %q", labels["node"], hostname)
+	}
+}
+
+func TestMetadataZone(t *testing.T) {
+	aws := func(token, zone int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+				w.WriteHeader(token)
+				w.Write([]byte("token\n"))
+			case r.URL.Path == "/latest/meta-data/placement/availability-zone" && r.Header.Get("X-aws-ec2-metadata-token") == "token":
+				w.WriteHeader(zone)
+				w.Write([]byte("eu-west-1a\n"))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	}
+
+	var gcpRequests int
+	gcp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gcpRequests++
+		if r.URL.Path != "/computeMetadata/v1/instance/zone" || r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("projects/123/zones/europe-west1-b"))
+	}))
+	defer gcp.Close()
+
+	defer func(aws, gcp string) {
+		awsMetadataURL, gcpMetadataURL = aws, gcp
+	}(awsMetadataURL, gcpMetadataURL)
+
+	for _, c := range []struct {
+		name       string
+		aws        *httptest.Server
+		gcpURL     string
+		zone       string
+		gcpQueried bool
+	}{
+		{"aws", aws(http.StatusOK, http.StatusOK), gcp.URL, "eu-west-1a", false},
+		// sniffer runs in AWS when the token is issued, GCP isn't queried even if zone isn't returned
+		{"aws without zone", aws(http.StatusOK, http.StatusInternalServerError), gcp.URL, "", false},
+		{"gcp", aws(http.StatusNotFound, http.StatusNotFound), gcp.URL, "europe-west1-b", true},
+		// name of GCP metadata service isn't resolved outside of GCP
+		{"outside of cloud", aws(http.StatusNotFound, http.StatusNotFound), "http://metadata.invalid", "", false},
+	} {
+		gcpRequests = 0
+		awsMetadataURL, gcpMetadataURL = c.aws.URL, c.gcpURL
+
+		if zone := metadataZone(); zone != c.zone {
+			t.Errorf("%s: zone is %q, want %q", c.name, zone, c.zone)
+		}
+		if queried := gcpRequests > 0; queried != c.gcpQueried {
+			t.Errorf("%s: GCP metadata service is queried %d times", c.name, gcpRequests)
+		}
+		c.aws.Close()
+	}
+}
+
+func TestCNIInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil || len(ifaces) == 0 {
+		t.Skip("no network interfaces:", err)
+	}
+
+	defer func(bridges []string) {
+		cniBridges = bridges
+	}(cniBridges)
+
+	// the first existing bridge is captured
+	cniBridges = []string{"missing0", ifaces[0].Name, "missing1"}
+	if name := cniInterface(); name != ifaces[0].Name {
+		t.Errorf("interface is %q, want %q", name, ifaces[0].Name)
+	}
+
+	cniBridges = []string{"missing0", "missing1"}
+	if name := cniInterface(); name != capture.AnyInterface {
+		t.Errorf("interface without bridges is %q, want %q", name, capture.AnyInterface)
+	}
+}
@@ -1,20 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/d-ulyanov/kafka-sniffer/api"
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/dump"
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	requestfilter "github.com/d-ulyanov/kafka-sniffer/filter"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
 	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/sniffer"
 	"github.com/d-ulyanov/kafka-sniffer/stream"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/examples/util"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/tcpassembly"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -22,84 +35,686 @@ import (
 const (
 	defaultListenAddr = ":9870"
 	defaultExpireTime = 5 * time.Minute
+
+	defaultRebalanceThreshold = 5
+
+	// defaultMaxMessageSize is a default message.max.bytes of brokers
+	defaultMaxMessageSize = 1048588
 )
 
 var (
-	iface      = flag.String("i", "eth0", "Interface to get packets from")
-	dstport    = flag.Uint("p", 9092, "Kafka broker port")
+	iface      = flag.String("i", "eth0", "Interface to get packets from, \"any\" captures on all interfaces on linux, rpcap://host/iface captures remotely with rpcapd")
+	readFile   = flag.String("r", "", "Read packets from pcap file (\"-\" for stdin) instead of interface, sniffer exits when the file is over")
+	dstports   = flag.String("p", "9092", "Comma separated list of kafka broker ports")
+	brokers    = flag.String("brokers", "", "Comma separated list of broker addresses (IPs or hosts, ports are ignored) classifying connections as client or inter-broker ones, brokers from Metadata responses are added with -responses")
+	filter     = flag.String("f", "", "BPF capture filter overriding the one generated from broker ports, e.g. \"tcp and dst port 9092 and not host 10.0.0.1\"")
 	snaplen    = flag.Int("s", 16<<10, "SnapLen for pcap packet capture")
-	filter     = fmt.Sprintf("tcp and dst port %d", *dstport)
 	verbose    = flag.Bool("v", false, "Logs every packet in great detail")
+	workers    = flag.Int("workers", 1, "Count of TCP assembly workers, e.g. count of CPUs, with ebpf backend every worker has its own capture socket")
 	listenAddr = flag.String("addr", defaultListenAddr, "Address on which sniffer listen the requests")
 	expireTime = flag.Duration("metrics.expire-time", defaultExpireTime, "Expiration time of metric.")
+	dataFlow   = flag.Bool("metrics.data-flow", false, "Export data_flow_info metric of flows from producers to consumers through topics joined from relations, it has a series per producer and consumer of every topic")
+
+	activityExpireTime = flag.Duration("activity.expire-time", metrics.ActivityExpireTime, "Expiration time of the last activity of topics and clients, topics idle for longer aren't reported")
+
+	maxMessageSize = flag.Int("produce.max-message-size", defaultMaxMessageSize, "Max size of produced record batch in bytes, e.g. message.max.bytes of brokers, larger batches are counted by oversized_batches_total, 0 disables the check")
+
+	maxRequestSize   = flag.Int("request.max-size", int(kafka.MaxRequestSize), "Max size of request in bytes, larger requests are considered garbage, set it above message.max.bytes of brokers")
+	maxDecompressed  = flag.Int("request.max-decompressed-size", kafka.MaxDecompressedSize, "Max size of decompressed records of a batch in bytes, batches decompressed to more bytes are considered garbage, it protects sniffer from decompression bombs")
+	sampleRate       = flag.String("sample", "1/1", "Sample rate 1/N of decoded requests: every Nth request of connection is decoded, other requests are counted by header only, it saves CPU on very busy clusters at the cost of accuracy of batch metrics")
+	shallowDecode    = flag.Bool("decode.shallow", false, "Decode only headers and topics of produce requests skipping their records, it saves CPU on busy brokers when only relation metrics are needed, batch metrics and records counts of events aren't collected")
+	strictPrivacy    = flag.Bool("privacy.strict", false, "Strict no-payload mode for regulated environments: record keys and values are never decompressed, decoded, stored or logged, only counts and sizes of records from batch headers are reported, schema detection and pcap dump are refused, always on in builds with nopayload tag")
+	zeroCopyDecode   = flag.Bool("decode.zero-copy", false, "Decode strings of produce and fetch requests without copies, they refer to buffers of requests reused after requests are handled")
+	streamBufferSize = flag.Int("stream.buffer-size", stream.DefaultBufferSize, "Size of read buffer of every TCP stream in bytes")
+
+	queueSize             = flag.Int("queue.size", 1000, "Count of packets waiting for TCP assembler of every worker")
+	queueDropWhenFull     = flag.Bool("queue.drop-when-full", false, "Drop packets when queue of worker is full instead of waiting for assembler, so capture doesn't fall behind, dropped packets are counted in dropped_packets_total")
+	assemblerMaxPages     = flag.Int("assembler.max-pages", 10000, "Max pages of out of order TCP segments buffered by assembler of every worker, the oldest gaps are skipped when it's exceeded, 0 means no limit")
+	assemblerMaxPagesConn = flag.Int("assembler.max-pages-per-conn", 16, "Max pages of out of order TCP segments buffered for one connection, 0 means no limit")
+
+	watchdogCPU      = flag.Float64("watchdog.cpu", 0, "Max CPU usage of sniffer in cores, e.g. 0.5, decoding is degraded with -watchdog.sample and shallow decoding while it's exceeded, 0 disables the limit")
+	watchdogRSS      = flag.Int64("watchdog.rss", 0, "Max resident memory of sniffer in bytes, decoding is degraded while it's exceeded, 0 disables the limit")
+	watchdogSample   = flag.String("watchdog.sample", "1/100", "Sample rate 1/N of decoded requests while decoding is degraded by watchdog")
+	watchdogInterval = flag.Duration("watchdog.interval", 5*time.Second, "Interval of checking resource usage by watchdog")
+
+	streamMemoryLimit = flag.Int64("stream.memory-limit", 256<<20, "Max bytes buffered by one direction of TCP stream until they are decoded, the stream is evicted when it's exceeded, 0 means no limit")
+	memoryLimit       = flag.Int64("memory.limit", 1<<30, "Max bytes buffered by all TCP streams, least recently active streams are evicted when it's exceeded, 0 means no limit")
+
+	output = flag.String("output", "", "Comma separated list of outputs of decoded requests events. Supported outputs: json (to stdout), csv and tsv (rows with header to stdout), file (JSON lines to rotated file), audit (hash chained and signed append-only log), kafka (JSON messages to kafka topic), nats (JSON messages to nats subjects), websocket (JSON messages to /stream websocket clients), syslog (RFC5424 messages), clickhouse (batch inserts into table), elasticsearch (bulk indexing, works with opensearch too), otlp (OpenTelemetry spans), parquet (hourly partitioned files), exec (JSON lines to stdin of external command)")
+
+	outputRequest  = flag.Bool("output.request", false, "Add decoded requests rendered as JSON to request field of events, keys and values of records are redacted unless -payloads.render is set, rendered requests contain topics and client ids as is, so it isn't allowed with -anonymize.key-file")
+	renderPayloads = flag.Bool("payloads.render", false, "Render keys and values of records and values of their headers as base64 in verbose logs and requests of events (-output.request), they are redacted by default, SASL authentication bytes are always redacted")
+
+	tlsKeyLog  = flag.String("tls.keylog", "", "Key log file (SSLKEYLOGFILE format) with secrets of TLS sessions to decrypt connections to SSL listeners, requires -responses")
+	tlsRSAKeys = flag.String("tls.rsa-keys", "", "Comma separated list of PEM files with RSA private keys of brokers to decrypt TLS 1.2 connections with RSA key exchange, requires -responses")
+
+	internalTopics = flag.String("topics.internal", string(stream.InternalTopicsInclude), "How to report internal (__-prefixed) topics: include, exclude or separate")
+	topicsInclude  = flag.String("topics.include", "", "Comma separated list of topic patterns reported in metrics and events, globs (e.g. payments.*) or regular expressions in slashes (e.g. /^payments\\./), all topics are reported if empty")
+	topicsExclude  = flag.String("topics.exclude", "", "Comma separated list of topic patterns skipped in metrics and events, globs or regular expressions in slashes, exclusion takes precedence over -topics.include")
+
+	topicNamingCheck     = flag.Bool("topics.naming.check", false, "Validate names of observed topics against kafka naming rules (legal characters, max length, reserved names, mixing of '.' and '_'), violations are reported by topic_name_violation_info metric and invalid_topics field of events")
+	topicNamingPrefixes  = flag.String("topics.naming.prefixes", "", "Comma separated list of allowed prefixes of topic names, e.g. per team (payments.,billing.), enables -topics.naming.check")
+	topicNamingMaxLength = flag.Int("topics.naming.max-length", 0, "Max length of topic names by convention of the cluster, kafka limit (249) is used if 0, enables -topics.naming.check if set")
+	topicNamingPattern   = flag.String("topics.naming.pattern", "", "Regular expression topic names must match by convention of the cluster, e.g. ^[a-z]+\\.[a-z0-9-]+$, enables -topics.naming.check")
+
+	filterExpr = flag.String("filter.expr", "", "Expression selecting decoded requests reported in metrics, events and pcap dump, e.g. 'topic =~ \"payments.*\" && apikey == produce && clientid != \"mirror-maker\"', all requests are reported if empty")
+
+	apiKeys = flag.String("api-keys", "", "Comma separated list of api keys of processed requests by names (e.g. Produce), numbers or groups (group, transactions), other requests are skipped by header without metrics and events, SASL requests are always processed, all requests are processed if empty")
+
+	minVersions = flag.String("min-versions", "kafka-4.0", "Comma separated list of minimal versions of apis of the next broker upgrade by presets (kafka-4.0) and <api>=<version> items, older versions are reported as deprecated in client_api_version_info metric and /api/v1/versions")
+
+	ownersFile = flag.String("owners.file", "", "Mapping file with \"<CIDR or IP> <owner>\" lines adding owner label (team or service) to metrics with client_ip label and owner field to events, disabled if empty")
+
+	detectSchemas     = flag.Bool("schemas.detect", false, "Detect ids of schemas of produced record keys and values in Schema Registry wire format and report them in producer_schema_relation_info metric and schemas field of events")
+	schemaRegistryURL = flag.String("schemas.registry.url", "", "URL of Schema Registry resolving detected schema ids into subjects and versions, may contain user and password, enables -schemas.detect, disabled if empty")
+
+	geoIPCountryDB = flag.String("geoip.country-db", "", "MaxMind GeoLite2/GeoIP2 Country or City database adding country label to metrics with client_ip label and country field to events, disabled if empty")
+	geoIPASNDB     = flag.String("geoip.asn-db", "", "MaxMind GeoLite2/GeoIP2 ASN database adding asn label to metrics with client_ip label and asn and as_org fields to events, disabled if empty")
+
+	ipv6Brackets  = flag.Bool("ipv6.brackets", false, "Render IPv6 client addresses in brackets in metrics labels, e.g. [2001:db8::1]")
+	ipv6PrefixLen = flag.Int("ipv6.prefix-len", 0, "Aggregate IPv6 clients in metrics labels by prefix of this length, e.g. 64, 0 disables aggregation")
+
+	anonymizeKeyFile = flag.String("anonymize.key-file", "", "File with secret key hashing topics, client addresses, client ids, principals and groups by HMAC-SHA256 in metrics, events and APIs, so traffic can be shared without leaking business metadata, hashes are stable while the key is kept, disabled if empty")
+
+	stateFile         = flag.String("state.file", "", "Bolt database file to persist relations between restarts, disabled if empty")
+	stateSaveInterval = flag.Duration("state.save-interval", time.Minute, "Interval of saving relations into state file")
+
+	graphiteAddr     = flag.String("graphite.addr", "", "Graphite host:port to push sniffer metrics to with plaintext protocol, disabled if empty")
+	graphitePrefix   = flag.String("graphite.prefix", "", "Prefix of metrics pushed to graphite")
+	graphiteInterval = flag.Duration("graphite.interval", 15*time.Second, "Interval of pushing metrics to graphite")
+	graphiteTags     = flag.Bool("graphite.tags", false, "Push labels as graphite tags instead of path components")
+
+	topN         = flag.Int("top.n", 10, "Count of top topics, producers, consumers and error sources in periodic traffic report served on /api/v1/top")
+	topNInterval = flag.Duration("top.interval", 0, "Interval of logging report of top topics by produced bytes, producers, consumers and error sources, the report is served on /api/v1/top, 0 disables the report")
+
+	recentSize = flag.Int("recent.size", 0, "Count of the last decoded requests kept in memory and served on /api/v1/recent?topic=X&client=Y, 0 disables the buffer")
+
+	openLineageURL              = flag.String("openlineage.url", "", "URL of OpenLineage API (e.g. http://marquez:5000/api/v1/lineage) observed producer and consumer jobs of topics are posted to as run events, disabled if empty")
+	openLineageToken            = flag.String("openlineage.token", "", "Bearer token of OpenLineage API")
+	openLineageInterval         = flag.Duration("openlineage.interval", 5*time.Minute, "Interval of exporting lineage to OpenLineage API")
+	openLineageJobNamespace     = flag.String("openlineage.job-namespace", "kafka-sniffer", "Namespace of OpenLineage jobs of clients")
+	openLineageDatasetNamespace = flag.String("openlineage.dataset-namespace", "kafka://kafka", "Namespace of OpenLineage datasets of topics, kafka://<bootstrap server host:port> by OpenLineage naming")
+
+	dumpDir            = flag.String("dump.dir", "", "Directory to dump packets of kafka connections into rotating pcap files, disabled if empty")
+	dumpMaxSize        = flag.Int64("dump.max-size", 100<<20, "Max size in bytes of pcap file before rotation, 0 disables size based rotation")
+	dumpRotateInterval = flag.Duration("dump.rotate-interval", time.Hour, "Rotation interval of pcap files, 0 disables time based rotation")
+	dumpMaxFiles       = flag.Int("dump.max-files", 24, "Max count of pcap files kept in dump directory, 0 keeps all files")
+
+	controlToken = flag.String("control.token", "", "Bearer token of control API on /control/v1/ changing verbosity, sampling and topic filters at runtime, the API is disabled if empty, prefer KAFKA_SNIFFER_CONTROL_TOKEN variable to hide it from process list")
+
+	rebalanceThreshold = flag.Int("rebalance.threshold", defaultRebalanceThreshold, "Max rebalances per minute of a consumer group before it's reported as rebalance storm, 0 disables detection")
 )
 
 func main() {
+	if err := runCommand(os.Args[1:]); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// sniff captures kafka traffic from interface or pcap file, exports metrics and writes events to outputs
+func sniff() error {
 	defer util.Run()()
-	log.Printf("starting capture on interface %q", *iface)
+
+	if err := applyEnv(flag.CommandLine); err != nil {
+		log.Fatalln(err)
+	}
+
+	internalTopicsMode, err := stream.ParseInternalTopics(*internalTopics)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	topicFilter, err := stream.NewTopicFilter(strings.Split(*topicsInclude, ","), strings.Split(*topicsExclude, ","))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// topic names are validated if any convention is set
+	var topicNaming *stream.TopicNaming
+	if *topicNamingCheck || *topicNamingPrefixes != "" || *topicNamingMaxLength != 0 || *topicNamingPattern != "" {
+		if topicNaming, err = stream.NewTopicNaming(strings.Split(*topicNamingPrefixes, ","), *topicNamingMaxLength, *topicNamingPattern); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	requestFilter, err := requestfilter.Parse(*filterExpr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// topics and clients are hashed consistently in all outputs
+	var anonymizer *metrics.Anonymizer
+	if *anonymizeKeyFile != "" {
+		if anonymizer, err = metrics.LoadAnonymizer(*anonymizeKeyFile); err != nil {
+			log.Fatalln("could not load anonymization key:", err)
+		}
+		log.Println("anonymization mode: topics, clients, principals and groups are hashed in metrics, events, APIs and logs")
+		if *outputRequest {
+			log.Fatalln("rendered requests contain topics and client ids as is, -output.request isn't allowed in anonymization mode")
+		}
+		if *dumpDir != "" {
+			log.Fatalln("pcap dump stores topics, clients and principals as is, it isn't allowed in anonymization mode")
+		}
+		if topicNaming != nil {
+			topicNaming.Anonymizer = anonymizer
+		}
+	}
+
+	processedKeys, err := stream.ParseAPIKeys(*apiKeys)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	deprecatedVersions, err := stream.ParseMinVersions(*minVersions)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *maxRequestSize <= 0 || *maxRequestSize > math.MaxInt32 {
+		log.Fatalln("-request.max-size must be positive and fit in int32")
+	}
+	kafka.MaxRequestSize = int32(*maxRequestSize)
+	kafka.MaxDecompressedSize = *maxDecompressed
+	kafka.SetShallowDecode(*shallowDecode)
+	kafka.ZeroCopyDecode = *zeroCopyDecode
+	kafka.RenderPayloads = *renderPayloads
+
+	// payloads are never touched in strict privacy mode, features reading or storing them are refused
+	kafka.SetStrictPrivacy(*strictPrivacy)
+	if kafka.StrictPrivacy() {
+		if *detectSchemas || *schemaRegistryURL != "" {
+			log.Fatalln("schema detection reads record keys and values, it isn't allowed in strict privacy mode")
+		}
+		if *dumpDir != "" {
+			log.Fatalln("pcap dump stores record keys and values, it isn't allowed in strict privacy mode")
+		}
+		if *renderPayloads {
+			log.Fatalln("rendering of payloads logs record keys and values, it isn't allowed in strict privacy mode")
+		}
+		log.Println("strict privacy mode: record keys and values are never decompressed, decoded or stored")
+	}
+
+	sampleN, err := stream.ParseSampleRate(*sampleRate)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	sampling := stream.NewSampleRate(sampleN)
+	setSampleRate := sampling.Set
+
+	if *watchdogCPU < 0 || *watchdogRSS < 0 {
+		log.Fatalln("-watchdog.cpu and -watchdog.rss must not be negative")
+	}
+	if *watchdogCPU > 0 || *watchdogRSS > 0 {
+		degradedN, err := stream.ParseSampleRate(*watchdogSample)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if *watchdogInterval <= 0 {
+			log.Fatalln("-watchdog.interval must be positive")
+		}
+
+		w := &watchdog{
+			cpuLimit:      *watchdogCPU,
+			rssLimit:      *watchdogRSS,
+			interval:      *watchdogInterval,
+			sampleRate:    sampling,
+			normalRate:    sampleN,
+			degradedRate:  degradedN,
+			normalShallow: *shallowDecode,
+		}
+		setSampleRate = w.setNormalRate
+		go w.run()
+	}
+
+	if *streamBufferSize < 16 {
+		log.Fatalln("-stream.buffer-size must be at least 16 bytes")
+	}
+
+	// the largest request must fit in the limit together with read buffer of the stream
+	if *streamMemoryLimit < 0 || *streamMemoryLimit > 0 && *streamMemoryLimit < int64(*maxRequestSize)+int64(*streamBufferSize) {
+		log.Fatalln("-stream.memory-limit must be 0 or at least -request.max-size plus -stream.buffer-size")
+	}
+	if *memoryLimit < 0 {
+		log.Fatalln("-memory.limit must not be negative")
+	}
+
+	if *queueSize < 0 {
+		log.Fatalln("-queue.size must not be negative")
+	}
+	if *assemblerMaxPages < 0 || *assemblerMaxPagesConn < 0 {
+		log.Fatalln("-assembler.max-pages and -assembler.max-pages-per-conn must not be negative")
+	}
+
+	if *workers < 1 {
+		log.Fatalln("-workers must be positive")
+	}
+
+	if *container != "" && *netns != "" {
+		log.Fatalln("-container and -netns are mutually exclusive")
+	}
+
+	if *ipv6PrefixLen < 0 || *ipv6PrefixLen > 128 {
+		log.Fatalln("-ipv6.prefix-len must be between 0 and 128")
+	}
+
+	var tlsKeys *stream.TLSKeys
+	if *tlsKeyLog != "" || *tlsRSAKeys != "" {
+		if !*responses {
+			log.Fatalln("-tls.keylog and -tls.rsa-keys require -responses, handshake of both directions is needed for decryption")
+		}
+
+		var rsaKeys []string
+		if *tlsRSAKeys != "" {
+			rsaKeys = strings.Split(*tlsRSAKeys, ",")
+		}
+		if tlsKeys, err = stream.LoadTLSKeys(*tlsKeyLog, rsaKeys); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	brokerSet, err := stream.NewBrokers(strings.Split(*brokers, ","))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// metrics of clients are labeled by their owners
+	var (
+		gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+		owners   *metrics.Owners
+	)
+	if *ownersFile != "" {
+		if owners, err = metrics.LoadOwners(*ownersFile); err != nil {
+			log.Fatalln("could not load owners:", err)
+		}
+		gatherer = metrics.NewOwnerGatherer(gatherer, owners)
+	}
+
+	// metrics of clients are labeled by their country and autonomous system
+	var geoIP *metrics.GeoIP
+	if *geoIPCountryDB != "" || *geoIPASNDB != "" {
+		if geoIP, err = metrics.OpenGeoIP(*geoIPCountryDB, *geoIPASNDB); err != nil {
+			log.Fatalln(err)
+		}
+		defer geoIP.Close()
+		gatherer = metrics.NewGeoIPGatherer(gatherer, geoIP)
+	}
+
+	// metrics of DaemonSet pods are labeled by node, capture is bound to CNI bridge of the node
+	var nodeLabelNames []string
+	if *k8sMode {
+		labels := nodeLabels()
+		log.Printf("kubernetes mode: metrics are labeled with %v", labels)
+		gatherer = metrics.NewLabeledGatherer(gatherer, labels)
+		for name := range labels {
+			nodeLabelNames = append(nodeLabelNames, name)
+		}
+		sort.Strings(nodeLabelNames)
+
+		if !isFlagSet("i") {
+			*iface = cniInterface()
+			log.Printf("kubernetes mode: capturing on interface %q", *iface)
+		}
+	}
+
+	if *check {
+		if err := checkConfig(); err != nil {
+			log.Fatalln("configuration is invalid:", err)
+		}
+		log.Println("configuration is valid")
+		return writeConfig(os.Stdout)
+	}
+
+	sink, err := newEventSink(*output)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// the last requests are kept as events besides outputs
+	if *recentSize > 0 {
+		recent := events.NewRing(*recentSize)
+		http.Handle(api.Prefix+"recent", api.NewRecentHandler(recent))
+		if sink != nil {
+			sink = events.MultiSink{sink, recent}
+		} else {
+			sink = recent
+		}
+	}
+
+	// events exported as spans are traced, so metrics are served with exemplars of their trace ids
+	tracing := hasOutput(*output, "otlp")
 
 	// run telemetry
-	go runTelemetry()
+	go runTelemetry(gatherer, tracing)
 
-	// Set up pcap packet capture
-	handle, err := pcap.OpenLive(*iface, int32(*snaplen), true, pcap.BlockForever)
+	// detect broker ports or use the configured one
+	ports, err := parsePorts(*dstports)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
+	}
+	if *discoverDuration > 0 {
+		if ports, err = discoverPorts(); err != nil {
+			log.Fatalln("could not discover kafka ports:", err)
+		}
+		if len(ports) == 0 {
+			log.Fatalln("no kafka ports discovered")
+		}
+		log.Printf("discovered kafka ports: %v", ports)
 	}
 
-	if err := handle.SetBPFFilter(filter); err != nil {
-		panic(err)
+	brokerPorts := make(map[uint16]bool, len(ports))
+	for _, port := range ports {
+		brokerPorts[port] = true
+	}
+
+	// Set up packet capture, ebpf backend opens socket per worker joined into fanout group
+	sources := make([]gopacket.PacketDataSource, 1)
+	if *readFile == "" && *remoteCommand == "" && capture.Backend(*captureBackend) == capture.BackendEBPF {
+		sources = make([]gopacket.PacketDataSource, *workers)
+	}
+
+	var (
+		linkType       uint32
+		captureSnaplen int
+	)
+	for i := range sources {
+		if sources[i], linkType, captureSnaplen, err = openCapture(ports); err != nil {
+			panic(err)
+		}
+
+		// reopen live capture when interface disappears
+		if *readFile == "" {
+			reopening := capture.NewReopeningSource(sources[i], func() (gopacket.PacketDataSource, error) {
+				source, _, _, err := openCapture(ports)
+				return source, err
+			}, func() {
+				metrics.CaptureReopens.WithLabelValues(*iface).Inc()
+			})
+
+			// libpcap returns timeout error on every -pcap.timeout of idle link
+			reopening.TimeoutErr = pcap.NextErrorTimeoutExpired
+			sources[i] = reopening
+		}
 	}
 
 	// init metrics storage
+	metrics.ActivityExpireTime = *activityExpireTime
 	metricsStorage := metrics.NewStorage(prometheus.DefaultRegisterer, *expireTime)
+	rebalanceDetector := metrics.NewRebalanceDetector(prometheus.DefaultRegisterer, *rebalanceThreshold)
+	producerIDs := metrics.NewProducerIDs(prometheus.DefaultRegisterer, *expireTime)
+	if *dataFlow {
+		metrics.NewDataFlow(prometheus.DefaultRegisterer, metricsStorage)
+	}
+
+	// schemas of records are resolved by registry
+	var schemaRegistry *metrics.SchemaRegistry
+	if *schemaRegistryURL != "" {
+		if schemaRegistry, err = metrics.NewSchemaRegistry(*schemaRegistryURL); err != nil {
+			log.Fatalln("invalid schema registry url:", err)
+		}
+		*detectSchemas = true
+	}
+
+	// observed access of principals is audited against declared ACLs
+	var aclAudit *metrics.ACLAudit
+	if *aclBrokers != "" {
+		aclAudit = metrics.NewACLAudit(prometheus.DefaultRegisterer, anonymizer)
+		if err = startACLAudit(aclAudit, strings.Split(*aclBrokers, ","), *aclRefreshInterval); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// restore relations saved before restart
+	var persister *metrics.Persister
+	if *stateFile != "" {
+		persister, err = metrics.OpenPersister(*stateFile, metricsStorage)
+		if err != nil {
+			log.Fatalln("could not open state file:", err)
+		}
+		if err = persister.Restore(); err != nil {
+			log.Printf("could not restore relations from state file: %s\n", err)
+		}
+		go persister.Run(*stateSaveInterval)
+	}
+
+	// push metrics to graphite
+	if *graphiteAddr != "" {
+		bridge, err := metrics.NewGraphiteBridge(gatherer, *graphiteAddr, *graphitePrefix, *graphiteInterval, *graphiteTags)
+		if err != nil {
+			log.Fatalln("could not create graphite bridge:", err)
+		}
+		go bridge.Run(context.Background())
+	}
+
+	// export data flows of relations to lineage catalog
+	if *openLineageURL != "" {
+		exporter := metrics.NewOpenLineageExporter(*openLineageURL, *openLineageToken, *openLineageJobNamespace, *openLineageDatasetNamespace, metricsStorage, owners)
+		go exporter.Run(*openLineageInterval)
+	}
+
+	// alert rules are evaluated against the same metrics as scraped ones
+	if *alertsRules != "" {
+		if err = startAlerts(gatherer); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// dump packets of kafka connections
+	var (
+		pcapDump   *dump.RotatingPcap
+		kafkaFlows *stream.KafkaFlows
+	)
+	if *dumpDir != "" {
+		pcapDump, err = dump.NewRotatingPcap(*dumpDir, uint32(captureSnaplen), linkType, *dumpMaxSize, *dumpRotateInterval, *dumpMaxFiles)
+		if err != nil {
+			log.Fatalln("could not create pcap dump:", err)
+		}
+		kafkaFlows = stream.NewKafkaFlows()
+	}
+
+	// serve relations API and topology graph
+	http.Handle(api.Prefix, api.NewHandler(metricsStorage))
+	http.Handle(api.GraphPath, api.NewGraphHandler(metricsStorage))
+
+	// dashboard is generated for metrics and labels enabled by flags
+	http.Handle(api.DashboardPath, api.NewDashboardHandler(metrics.DashboardOptions{
+		Owners:      owners != nil,
+		Country:     *geoIPCountryDB != "",
+		ASN:         *geoIPASNDB != "",
+		Labels:      nodeLabelNames,
+		Batches:     !*shallowDecode,
+		Responses:   *responses,
+		Rebalances:  *rebalanceThreshold > 0,
+		Schemas:     *detectSchemas,
+		TopicNaming: topicNaming != nil,
+		ACLAudit:    aclAudit != nil,
+		DataFlow:    *dataFlow,
+	}))
+
+	// digest of traffic is logged and served periodically, terminal UI rotates it by itself on every refresh
+	var topTraffic *metrics.TopN
+	if *topNInterval > 0 || *tuiMode {
+		topTraffic = metrics.NewTopN(*topN)
+		http.Handle(api.Prefix+"top", api.NewTopHandler(topTraffic))
+		if !*tuiMode {
+			go topTraffic.Run(*topNInterval)
+		}
+	}
+
+	// verbosity is shared with control API changing it at runtime
+	verbosity := stream.NewSwitch(*verbose)
+
+	// serve control API
+	if *controlToken != "" {
+		http.Handle(api.ControlPrefix, api.NewControlHandler(*controlToken, api.Controls{
+			Verbose:       verbosity,
+			Topics:        topicFilter,
+			SampleRate:    sampling,
+			SetSampleRate: setSampleRate,
+			Storage:       metricsStorage,
+			Persister:     persister,
+		}))
+	}
+
+	// handlers registered by packages of custom build are called besides the built-in ones
+	if names := stream.Handlers(); len(names) > 0 {
+		log.Printf("registered request handlers: %s", strings.Join(names, ", "))
+	}
 
 	// Set up assembly
-	streamPool := tcpassembly.NewStreamPool(stream.NewKafkaStreamFactory(metricsStorage, *verbose))
-	assembler := tcpassembly.NewAssembler(streamPool)
+	factory := stream.NewKafkaStreamFactory(metricsStorage, rebalanceDetector, sink, stream.Config{
+		Verbose:        verbosity,
+		InternalTopics: internalTopicsMode,
+		Topics:         topicFilter,
+		TopicNaming:    topicNaming,
+		Filter:         requestFilter,
+		APIKeys:        processedKeys,
+		MinVersions:    deprecatedVersions,
+		ClientIP: stream.ClientIPFormat{
+			IPv6Brackets:  *ipv6Brackets,
+			IPv6PrefixLen: *ipv6PrefixLen,
+		},
+		Owners:      owners,
+		GeoIP:       geoIP,
+		ACLAudit:    aclAudit,
+		ProducerIDs: producerIDs,
+		Anonymizer:  anonymizer,
+		TopN:        topTraffic,
+		Flows:       kafkaFlows,
+		BrokerPorts: brokerPorts,
+		Responses:   *responses,
+		Tracing:     tracing,
+		BufferSize:  *streamBufferSize,
+		SampleRate:  sampling,
+
+		RenderRequests: *outputRequest,
+		MaxMessageSize: *maxMessageSize,
+
+		StreamMemoryLimit: *streamMemoryLimit,
+		MemoryLimit:       *memoryLimit,
+
+		Brokers: brokerSet,
+		TLSKeys: tlsKeys,
+
+		DetectSchemas:  *detectSchemas,
+		SchemaRegistry: schemaRegistry,
+	})
 
-	// Auto-flushing connection state to get packets
-	// without waiting SYN
-	assembler.MaxBufferedPagesTotal = 1000
-	assembler.MaxBufferedPagesPerConnection = 1
+	pipeline := sniffer.NewPipeline(factory, sniffer.PipelineConfig{
+		LinkType:              linkType,
+		Workers:               *workers,
+		QueueSize:             *queueSize,
+		MaxPages:              *assemblerMaxPages,
+		MaxPagesPerConnection: *assemblerMaxPagesConn,
+		DropWhenFull:          *queueDropWhenFull,
+		BrokerPorts:           brokerPorts,
+		FilterPorts:           *filter == "" || *decap,
+		Responses:             *responses,
+		Verbose:               verbosity,
+		PcapDump:              pcapDump,
+		KafkaFlows:            kafkaFlows,
+	})
+
+	go handleSignals(&diagnostics{
+		factory:  factory,
+		storage:  metricsStorage,
+		pipeline: pipeline,
+		started:  time.Now(),
+	}, verbosity)
+
+	// capture is stopped on SIGINT and SIGTERM, so the rest of streams, events and relations are flushed
+	go handleShutdown(func() {
+		for _, source := range sources {
+			closeCapture(source)
+		}
+	})
+
+	// systemd is notified when capture is opened, its watchdog is notified while shards aren't stuck
+	sdNotify("READY=1")
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go runSdWatchdog(interval, func() bool { return !pipeline.Stuck() })
+	}
 
 	log.Println("reading in packets")
 
+	stopTUI, tuiDone := make(chan struct{}), make(chan struct{})
+	if *tuiMode {
+		go func() {
+			newTUI(factory, topTraffic, *topN).run(stopTUI)
+			close(tuiDone)
+		}()
+	} else {
+		close(tuiDone)
+	}
+
 	// Read in packets, pass to assembler.
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	packets := packetSource.Packets()
-	ticker := time.Tick(time.Minute)
+	var readers sync.WaitGroup
+	for _, source := range sources {
+		readers.Add(1)
+		go func(source gopacket.PacketDataSource) {
+			defer readers.Done()
+			pipeline.Run(source)
+		}(source)
+	}
+	readers.Wait()
+	close(stopTUI)
+	<-tuiDone
 
-	for {
-		select {
-		case packet := <-packets:
-			if *verbose {
-				log.Println(packet)
-			}
+	// pcap file is over or capture is stopped by signal, flush the rest of streams, events and relations
+	sdNotify("STOPPING=1")
+	pipeline.Close()
+	factory.Wait()
+	if pcapDump != nil {
+		if err := pcapDump.Close(); err != nil {
+			log.Println("could not close pcap dump:", err)
+		}
+	}
+	if sink != nil {
+		if err := sink.Close(); err != nil {
+			log.Println("could not close events output:", err)
+		}
+	}
+	if persister != nil {
+		if err := persister.Close(); err != nil {
+			log.Println("could not save relations to state file:", err)
+		}
+	}
+	log.Println("capture is over")
 
-			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeTCP {
-				if *verbose {
-					log.Println("Unusable packet")
-				}
-				continue
-			}
+	return nil
+}
 
-			tcp := packet.TransportLayer().(*layers.TCP)
+// handleShutdown calls stop on the first SIGINT or SIGTERM, the second one exits without flushing
+func handleShutdown(stop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-			assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, packet.Metadata().Timestamp)
+	<-signals
+	log.Println("interrupted, stopping capture")
+	stop()
+
+	<-signals
+	log.Fatalln("interrupted again, exiting without flushing")
+}
 
-		case <-ticker:
-			// Every minute, flush connections that haven't seen activity in the past 2 minutes.
-			assembler.FlushOlderThan(time.Now().Add(time.Minute * -2))
-			log.Println("---- FLUSHING ----")
+// closeCapture closes pcap handle (without returned error) or any other closable source, reading of the closed
+// source ends with io.EOF
+func closeCapture(source gopacket.PacketDataSource) {
+	switch c := source.(type) {
+	case io.Closer:
+		if err := c.Close(); err != nil {
+			log.Println("could not close capture:", err)
 		}
+	case interface{ Close() }:
+		c.Close()
 	}
 }
 
-func runTelemetry() {
-	fmt.Printf("serving metrics on %s\n", *listenAddr)
+// runTelemetry serves metrics, exemplars are exposed in OpenMetrics format only, so it's negotiated if
+// exemplars is true
+func runTelemetry(gatherer prometheus.Gatherer, exemplars bool) {
+	log.Printf("serving metrics on %s", *listenAddr)
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: exemplars})))
 	if err := http.ListenAndServe(*listenAddr, nil); err != nil {
 		panic(err)
 	}
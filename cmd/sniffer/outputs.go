@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+)
+
+var (
+	outputFilePath           = flag.String("output.file.path", "kafka_sniffer_events.log", "Path of the file output")
+	outputFileMaxSize        = flag.Int64("output.file.max-size", 100<<20, "Max size in bytes of the file output before rotation, 0 disables size based rotation")
+	outputFileRotateInterval = flag.Duration("output.file.rotate-interval", 24*time.Hour, "Rotation interval of the file output, 0 disables time based rotation")
+	outputFileCompress       = flag.Bool("output.file.compress", true, "Compress rotated files of the file output with gzip")
+
+	outputAuditPath         = flag.String("output.audit.path", "kafka_sniffer_audit.log", "Path of the append-only hash chained audit log")
+	outputAuditKey          = flag.String("output.audit.key", "", "PEM encoded PKCS #8 ed25519 private key signing the audit log, signing is disabled if empty")
+	outputAuditSignInterval = flag.Duration("output.audit.sign-interval", time.Minute, "Interval of signing the head of the audit log")
+
+	outputKafkaBrokers = flag.String("output.kafka.brokers", "", "Comma separated list of brokers of the kafka output")
+	outputKafkaTopic   = flag.String("output.kafka.topic", "kafka-sniffer-events", "Topic of the kafka output")
+
+	outputNATSURL     = flag.String("output.nats.url", "nats://127.0.0.1:4222", "Server URL of the nats output")
+	outputNATSCluster = flag.String("output.nats.cluster", "default", "Name of the sniffed cluster in subjects kafka.sniffer.<cluster>.<api name> of the nats output")
+
+	outputSyslogAddr     = flag.String("output.syslog.addr", "unix:///dev/log", "Address of the syslog output: udp://host:port, tcp://host:port or unix:///path")
+	outputSyslogFacility = flag.String("output.syslog.facility", "local0", "Facility of the syslog output messages")
+
+	outputClickHouseURL           = flag.String("output.clickhouse.url", "http://127.0.0.1:8123", "HTTP interface URL of the clickhouse output, may contain user and password")
+	outputClickHouseTable         = flag.String("output.clickhouse.table", "kafka_sniffer_events", "Table of the clickhouse output")
+	outputClickHouseBatchSize     = flag.Int("output.clickhouse.batch-size", 10000, "Max count of events inserted into clickhouse at once")
+	outputClickHouseFlushInterval = flag.Duration("output.clickhouse.flush-interval", 10*time.Second, "Max interval between inserts into clickhouse")
+
+	outputElasticsearchURL           = flag.String("output.elasticsearch.url", "http://127.0.0.1:9200", "URL of the elasticsearch (opensearch) output, may contain user and password")
+	outputElasticsearchIndex         = flag.String("output.elasticsearch.index", "kafka-sniffer-events", "Index prefix of the elasticsearch output, events are written into daily indices")
+	outputElasticsearchBatchSize     = flag.Int("output.elasticsearch.batch-size", 5000, "Max count of events indexed by one bulk request")
+	outputElasticsearchFlushInterval = flag.Duration("output.elasticsearch.flush-interval", 10*time.Second, "Max interval between bulk requests")
+
+	outputOTLPEndpoint      = flag.String("output.otlp.endpoint", "http://127.0.0.1:4318", "Base URL of OTLP/HTTP receiver of the otlp output")
+	outputOTLPServiceName   = flag.String("output.otlp.service-name", "kafka-sniffer", "service.name resource attribute of exported spans")
+	outputOTLPBatchSize     = flag.Int("output.otlp.batch-size", 1000, "Max count of spans exported at once")
+	outputOTLPFlushInterval = flag.Duration("output.otlp.flush-interval", 5*time.Second, "Max interval between spans exports")
+
+	outputExecCommand = flag.String("output.exec.command", "", "Shell command of the exec output receiving events as JSON lines on stdin, it's restarted with backoff when it exits")
+
+	outputParquetDir           = flag.String("output.parquet.dir", "kafka_sniffer_events", "Directory of hourly partitioned files of the parquet output")
+	outputParquetBatchSize     = flag.Int("output.parquet.batch-size", 10000, "Max count of events written into parquet file at once")
+	outputParquetFlushInterval = flag.Duration("output.parquet.flush-interval", 10*time.Second, "Max interval between writes into parquet file")
+)
+
+// hasOutput returns true if comma separated list of outputs contains the output
+func hasOutput(outputs, output string) bool {
+	for _, o := range strings.Split(outputs, ",") {
+		if strings.TrimSpace(o) == output {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBatching returns an error if batch size or flush interval of batching output isn't positive
+func checkBatching(output string) error {
+	var size int
+	var interval time.Duration
+	switch output {
+	case "clickhouse":
+		size, interval = *outputClickHouseBatchSize, *outputClickHouseFlushInterval
+	case "elasticsearch":
+		size, interval = *outputElasticsearchBatchSize, *outputElasticsearchFlushInterval
+	case "otlp":
+		size, interval = *outputOTLPBatchSize, *outputOTLPFlushInterval
+	case "parquet":
+		size, interval = *outputParquetBatchSize, *outputParquetFlushInterval
+	default:
+		return nil
+	}
+
+	if size <= 0 {
+		return fmt.Errorf("-output.%s.batch-size must be positive", output)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("-output.%s.flush-interval must be positive", output)
+	}
+	return nil
+}
+
+// newEventSink creates events sink for comma separated list of outputs, it returns nil if no outputs are set
+func newEventSink(outputs string) (events.Sink, error) {
+	var sinks events.MultiSink
+
+	for _, output := range strings.Split(outputs, ",") {
+		output = strings.TrimSpace(output)
+		if err := checkBatching(output); err != nil {
+			return nil, err
+		}
+
+		switch output {
+		case "":
+			continue
+		case "json":
+			sinks = append(sinks, events.NewJSONSink(os.Stdout))
+		case "csv":
+			sinks = append(sinks, events.NewCSVSink(os.Stdout, ','))
+		case "tsv":
+			sinks = append(sinks, events.NewCSVSink(os.Stdout, '\t'))
+		case "file":
+			file, err := events.NewRotatingFile(*outputFilePath, *outputFileMaxSize, *outputFileRotateInterval, *outputFileCompress)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, events.NewJSONSink(file))
+		case "audit":
+			sink, err := events.NewAuditSink(*outputAuditPath, *outputAuditKey, *outputAuditSignInterval)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "kafka":
+			if *outputKafkaBrokers == "" {
+				return nil, fmt.Errorf("kafka output requires -output.kafka.brokers")
+			}
+			sink, err := events.NewKafkaSink(strings.Split(*outputKafkaBrokers, ","), *outputKafkaTopic)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "nats":
+			sink, err := events.NewNATSSink(*outputNATSURL, *outputNATSCluster)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "websocket":
+			broadcaster := events.NewBroadcaster()
+			http.Handle("/stream", broadcaster.WebSocketHandler())
+			sinks = append(sinks, broadcaster)
+		case "syslog":
+			sink, err := events.NewSyslogSink(*outputSyslogAddr, *outputSyslogFacility)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "elasticsearch":
+			sinks = append(sinks, events.NewElasticsearchSink(*outputElasticsearchURL, *outputElasticsearchIndex, *outputElasticsearchBatchSize, *outputElasticsearchFlushInterval))
+		case "otlp":
+			sinks = append(sinks, events.NewOTLPSink(*outputOTLPEndpoint, *outputOTLPServiceName, *outputOTLPBatchSize, *outputOTLPFlushInterval))
+		case "parquet":
+			sink, err := events.NewParquetSink(*outputParquetDir, *outputParquetBatchSize, *outputParquetFlushInterval)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "exec":
+			if *outputExecCommand == "" {
+				return nil, fmt.Errorf("exec output requires -output.exec.command")
+			}
+			sink, err := events.NewExecSink(*outputExecCommand)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "clickhouse":
+			sink, err := events.NewClickHouseSink(*outputClickHouseURL, *outputClickHouseTable, *outputClickHouseBatchSize, *outputClickHouseFlushInterval)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown output %q", output)
+		}
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	return sinks, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/sniffer"
+
+	"github.com/Shopify/sarama"
+)
+
+// produceKey is an api key of produce requests
+const produceKey = 0
+
+var (
+	replayFlags = flag.NewFlagSet("replay", flag.ExitOnError)
+
+	replayFile        = replayFlags.String("r", "", "Pcap file with captured produce requests, records are replayed with their keys, values and headers")
+	replayEvents      = replayFlags.String("events", "", "File with JSON lines of events of json or file output (\"-\" for stdin), records of produce events are synthesized by their counts and sizes")
+	replayParquetPath = replayFlags.String("parquet", "", "Parquet file or directory of parquet output, records of produce events are synthesized by their counts and sizes")
+	replayPorts       = replayFlags.String("p", "9092", "Comma separated list of kafka broker ports of pcap file")
+
+	replayBrokers        = replayFlags.String("brokers", os.Getenv("KAFKA_PEERS"), "Comma separated list of brokers of the target cluster")
+	replaySpeed          = replayFlags.Float64("speed", 1, "Replay speed relative to the captured one, e.g. 2 replays twice as fast, 0 produces as fast as possible")
+	replayTopicPrefix    = replayFlags.String("topic.prefix", "", "Prefix added to topics of replayed records, e.g. replay., so replay doesn't mix with real traffic")
+	replayKeepPartitions = replayFlags.Bool("keep-partitions", false, "Produce records to their captured partitions instead of partitioning by keys, target topics must have as many partitions, events are always partitioned by the producer")
+)
+
+// replay re-produces captured produce traffic to a target cluster at original or scaled speed, for load testing
+// and migration validation with real traffic shapes. Records of pcap files are produced with their keys, values
+// and headers; events of json, file and parquet outputs don't contain payloads, so records of produce events are
+// synthesized with random values of captured counts and sizes.
+func replay() error {
+	inputs := 0
+	for _, input := range []string{*replayFile, *replayEvents, *replayParquetPath} {
+		if input != "" {
+			inputs++
+		}
+	}
+	if inputs != 1 {
+		return fmt.Errorf("exactly one of -r, -events and -parquet must be set")
+	}
+	if *replayBrokers == "" {
+		return fmt.Errorf("brokers of the target cluster must be set with -brokers")
+	}
+	if *replaySpeed < 0 {
+		return fmt.Errorf("-speed must not be negative")
+	}
+
+	r, err := newReplayer(strings.Split(*replayBrokers, ","))
+	if err != nil {
+		return fmt.Errorf("could not create producer: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("interrupted, waiting for produced records")
+		cancel()
+	}()
+
+	start := time.Now()
+	switch {
+	case *replayFile != "":
+		err = r.replayPcap(ctx, *replayFile)
+	case *replayEvents != "":
+		err = r.replayJSON(ctx, *replayEvents)
+	default:
+		err = r.replayParquet(ctx, *replayParquetPath)
+	}
+	if err != nil && err != context.Canceled {
+		log.Println(err)
+	}
+
+	r.close()
+	log.Printf("replayed %d records (%d bytes) in %s, %d failed", atomic.LoadInt64(&r.records), atomic.LoadInt64(&r.bytes),
+		time.Since(start).Round(time.Millisecond), atomic.LoadInt64(&r.failed))
+
+	return nil
+}
+
+// replayer produces records of captured requests and events
+type replayer struct {
+	producer sarama.AsyncProducer
+	errors   sync.WaitGroup
+	pacer    *pacer
+
+	// records, bytes and failed are counters of produced records, they are accessed atomically
+	records int64
+	bytes   int64
+	failed  int64
+
+	// random is a buffer of values of synthesized records, it only grows
+	randomMux sync.Mutex
+	random    []byte
+}
+
+func newReplayer(brokerList []string) (*replayer, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-sniffer-replay"
+	config.Version = sarama.V0_11_0_0 // record headers
+	config.Producer.Return.Errors = true
+	if *replayKeepPartitions {
+		config.Producer.Partitioner = sarama.NewManualPartitioner
+	}
+
+	producer, err := sarama.NewAsyncProducer(brokerList, config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replayer{producer: producer, pacer: &pacer{speed: *replaySpeed}}
+
+	r.errors.Add(1)
+	go func() {
+		defer r.errors.Done()
+		for err := range producer.Errors() {
+			if atomic.AddInt64(&r.failed, 1) == 1 {
+				log.Printf("could not produce record: %s", err.Err)
+			}
+		}
+	}()
+
+	return r, nil
+}
+
+// close flushes records being produced
+func (r *replayer) close() {
+	if err := r.producer.Close(); err != nil {
+		log.Println("could not close producer:", err)
+	}
+	r.errors.Wait()
+}
+
+// produce sends record asynchronously, key, value and headers mustn't be changed after the call
+func (r *replayer) produce(topic string, partition int32, key, value []byte, headers []sarama.RecordHeader) {
+	msg := &sarama.ProducerMessage{
+		Topic:     *replayTopicPrefix + topic,
+		Partition: partition,
+		Headers:   headers,
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
+	}
+
+	r.producer.Input() <- msg
+
+	atomic.AddInt64(&r.records, 1)
+	atomic.AddInt64(&r.bytes, int64(len(key)+len(value)))
+}
+
+// replayPcap decodes produce requests of pcap file and produces their records, requests are paced by their
+// capture times
+func (r *replayer) replayPcap(ctx context.Context, path string) error {
+	if kafka.StrictPrivacy() {
+		return fmt.Errorf("records of pcap file can't be replayed by build with nopayload tag")
+	}
+
+	ports, err := parsePorts(*replayPorts)
+	if err != nil {
+		return err
+	}
+
+	s, err := sniffer.New(sniffer.Config{
+		File:  path,
+		Ports: ports,
+		OnRequest: func(ctx context.Context, conn sniffer.ConnInfo, req *kafka.Request) {
+			body, ok := req.Body.(*kafka.ProduceRequest)
+			if !ok || ctx.Err() != nil {
+				return
+			}
+
+			r.pacer.wait(conn.Seen)
+
+			// request buffers are reused after the call, so records are copied
+			body.ProducedRecords(func(record kafka.ProducedRecord) {
+				if kafka.IsInternalTopic(record.Topic) {
+					return
+				}
+
+				var headers []sarama.RecordHeader
+				for _, h := range record.Headers {
+					headers = append(headers, sarama.RecordHeader{Key: clone(h.Key), Value: clone(h.Value)})
+				}
+				r.produce(record.Topic, record.Partition, clone(record.Key), clone(record.Value), headers)
+			})
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Run(ctx)
+}
+
+// replayJSON replays produce events of JSON lines file
+func (r *replayer) replayJSON(ctx context.Context, path string) error {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	return events.ReadJSON(in, func(e *events.Event) error {
+		return r.replayEvent(ctx, e)
+	})
+}
+
+// replayParquet replays produce events of parquet file or all parquet files of directory in order of their
+// hourly partitions
+func (r *replayer) replayParquet(ctx context.Context, path string) error {
+	var files []string
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(name) == ".parquet" {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no parquet files in %s", path)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		err = events.ReadParquet(file, func(e *events.Event) error {
+			return r.replayEvent(ctx, e)
+		})
+		if err != nil {
+			return fmt.Errorf("could not read %s: %s", file, err)
+		}
+	}
+
+	return nil
+}
+
+// replayEvent synthesizes records of produce event: records are spread evenly by topics of the event, their
+// values are random bytes of the average size. Events without counts of records, e.g. of shallow decoding,
+// are replayed as one record per topic of the request size.
+func (r *replayer) replayEvent(ctx context.Context, e *events.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if e.APIKey != produceKey || len(e.Topics) == 0 {
+		return nil
+	}
+
+	r.pacer.wait(e.Time)
+
+	count, size := e.RecordsCount, 0
+	if count > 0 {
+		size = e.RecordsSize / count
+	} else {
+		count, size = len(e.Topics), e.Size/len(e.Topics)
+	}
+
+	perTopic := count / len(e.Topics)
+	if perTopic == 0 {
+		perTopic = 1
+	}
+	value := r.randomValue(size)
+
+	for _, topic := range e.Topics {
+		for i := 0; i < perTopic; i++ {
+			r.produce(topic, 0, nil, value, nil)
+		}
+	}
+
+	return nil
+}
+
+// randomValue returns random bytes of size, values share the buffer and mustn't be changed
+func (r *replayer) randomValue(size int) []byte {
+	r.randomMux.Lock()
+	defer r.randomMux.Unlock()
+
+	if size > len(r.random) {
+		r.random = make([]byte, size)
+		rand.Read(r.random)
+	}
+	return r.random[:size]
+}
+
+// pacer delays records to keep intervals between them as captured, scaled by speed
+type pacer struct {
+	speed float64
+
+	mux   sync.Mutex
+	first time.Time // capture time of the first record
+	start time.Time // time the first record was replayed at
+}
+
+// wait waits until record captured at the time is due
+func (p *pacer) wait(at time.Time) {
+	if p.speed == 0 {
+		return
+	}
+
+	p.mux.Lock()
+	if p.start.IsZero() {
+		p.first, p.start = at, time.Now()
+	}
+	due := p.start.Add(time.Duration(float64(at.Sub(p.first)) / p.speed))
+	p.mux.Unlock()
+
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+// handleSignals dumps diagnostics on SIGUSR1 and toggles verbose logging on SIGUSR2
+func handleSignals(diag *diagnostics, verbosity *stream.Switch) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGUSR1:
+			diag.dump()
+		case syscall.SIGUSR2:
+			verbosity.Set(!verbosity.On())
+			log.Printf("verbose logging is set to %t by signal\n", verbosity.On())
+		}
+	}
+}
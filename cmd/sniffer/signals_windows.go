@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+// handleSignals does nothing on windows, there are no SIGUSR1 and SIGUSR2 signals
+func handleSignals(diag *diagnostics, verbosity *stream.Switch) {}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state notification (e.g. READY=1) to systemd, it does nothing if sniffer isn't run by systemd
+// service with notify type or watchdog
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// socket in abstract namespace starts with @
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if socket[0] == '@' {
+		addr.Name = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		log.Printf("could not notify systemd: %s\n", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Printf("could not notify systemd: %s\n", err)
+	}
+}
+
+// sdWatchdogInterval returns interval of watchdog notifications required by systemd, it's zero if watchdog
+// isn't enabled for the sniffer
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// watchdog may be enabled for another process of the service
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runSdWatchdog notifies systemd watchdog twice per its interval while alive returns true, so the service is
+// restarted when the pipeline is stuck
+func runSdWatchdog(interval time.Duration, alive func() bool) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if alive() {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+var (
+	tuiMode     = flag.Bool("tui", false, "Render live tables of top topics, clients and connections with their rates in terminal instead of logging, like iftop for kafka, logs are shown at the bottom")
+	tuiInterval = flag.Duration("tui.interval", 2*time.Second, "Refresh interval of terminal UI, rates are averaged over it")
+)
+
+const (
+	// ansiClear moves cursor home and clears the screen, ansiHideCursor and ansiShowCursor hide cursor while
+	// the screen is redrawn
+	ansiClear      = "\033[H\033[2J"
+	ansiHideCursor = "\033[?25l"
+	ansiShowCursor = "\033[?25h"
+
+	// tuiLogLines is a count of the last log lines shown at the bottom of the screen
+	tuiLogLines = 5
+)
+
+// tui renders live tables of traffic in terminal, top topics and clients are taken from TopN rotated every
+// refresh, request rates of connections are deltas of their request counts
+type tui struct {
+	out      io.Writer
+	factory  *stream.KafkaStreamFactory
+	top      *metrics.TopN
+	logs     *logTail
+	interval time.Duration
+	rows     int
+	started  time.Time
+
+	// requests are counts of requests of connections by the previous refresh
+	requests map[string]int64
+}
+
+// run redraws the screen every interval until stop is closed, log output and cursor are restored then
+func (t *tui) run(stop <-chan struct{}) {
+	log.SetOutput(t.logs)
+	fmt.Fprint(t.out, ansiHideCursor)
+	defer func() {
+		fmt.Fprint(t.out, ansiShowCursor)
+		log.SetOutput(os.Stderr)
+	}()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			var screen bytes.Buffer
+			t.render(&screen, now, now.Sub(last))
+			t.out.Write(screen.Bytes())
+			last = now
+		}
+	}
+}
+
+// render writes the whole screen, rates are per second of elapsed time since the previous refresh
+func (t *tui) render(w io.Writer, now time.Time, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	report := t.top.Rotate(now)
+	connections := t.factory.Connections()
+
+	fmt.Fprint(w, ansiClear)
+	fmt.Fprintf(w, "kafka-sniffer  %s  uptime %s  %d connections\n\n", now.Format("15:04:05"), now.Sub(t.started).Round(time.Second), len(connections))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "TOPIC\tPRODUCED/S\t")
+	for _, e := range report.Topics {
+		fmt.Fprintf(tw, "%s\t%s\t\n", e.Name, formatBytes(e.Value/seconds))
+	}
+	fmt.Fprintln(tw, "\t\t")
+
+	fmt.Fprintln(tw, "PRODUCER\tPRODUCED/S\t")
+	for _, e := range report.Producers {
+		fmt.Fprintf(tw, "%s\t%s\t\n", e.Name, formatBytes(e.Value/seconds))
+	}
+	fmt.Fprintln(tw, "\t\t")
+
+	fmt.Fprintln(tw, "CONSUMER\tFETCHES/S\t")
+	for _, e := range report.Consumers {
+		fmt.Fprintf(tw, "%s\t%.1f\t\n", e.Name, e.Value/seconds)
+	}
+	fmt.Fprintln(tw, "\t\t")
+
+	if len(report.ErrorSources) > 0 {
+		fmt.Fprintln(tw, "ERROR SOURCE\tERRORS/S\t")
+		for _, e := range report.ErrorSources {
+			fmt.Fprintf(tw, "%s\t%.1f\t\n", e.Name, e.Value/seconds)
+		}
+		fmt.Fprintln(tw, "\t\t")
+	}
+	tw.Flush()
+
+	// connections with the most requests during the refresh go first
+	type connectionRate struct {
+		stream.ConnectionState
+		rate float64
+	}
+	rates := make([]connectionRate, 0, len(connections))
+	requests := make(map[string]int64, len(connections))
+	for _, c := range connections {
+		key := c.Client + "->" + c.Broker
+		requests[key] = c.Requests
+		rates = append(rates, connectionRate{ConnectionState: c, rate: float64(c.Requests-t.requests[key]) / seconds})
+	}
+	t.requests = requests
+	sort.Slice(rates, func(i, j int) bool { return rates[i].rate > rates[j].rate })
+	if len(rates) > t.rows {
+		rates = rates[:t.rows]
+	}
+
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT\tBROKER\tCLIENT ID\tPRINCIPAL\tREQUESTS/S\tBUFFERED\t")
+	for _, c := range rates {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f\t%s\t\n", c.Client, c.Broker, c.ClientID, c.Principal, c.rate, formatBytes(float64(c.Buffered)))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	for _, line := range t.logs.lines() {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// formatBytes formats count of bytes with binary units, e.g. 1.5 MiB
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 4 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTP"[exp])
+}
+
+// logTail keeps the last lines written to log, so they are shown by terminal UI instead of breaking the screen
+type logTail struct {
+	mux  sync.Mutex
+	tail []string
+	max  int
+}
+
+// Write implements io.Writer
+func (l *logTail) Write(p []byte) (int, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.tail = append(l.tail, line)
+	}
+	if len(l.tail) > l.max {
+		l.tail = append(l.tail[:0:0], l.tail[len(l.tail)-l.max:]...)
+	}
+
+	return len(p), nil
+}
+
+// lines returns the last lines
+func (l *logTail) lines() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return append([]string(nil), l.tail...)
+}
+
+// newTUI creates terminal UI writing to stdout, connections table is limited by rows like tables of top
+func newTUI(factory *stream.KafkaStreamFactory, top *metrics.TopN, rows int) *tui {
+	return &tui{
+		out:      os.Stdout,
+		factory:  factory,
+		top:      top,
+		logs:     &logTail{max: tuiLogLines},
+		interval: *tuiInterval,
+		rows:     rows,
+		started:  time.Now(),
+		requests: make(map[string]int64),
+	}
+}
@@ -0,0 +1,59 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is a count of clock ticks per second of CPU times in /proc, it's 100 on all usual kernels
+const clockTicks = 100
+
+// resourceUsage returns total CPU time and resident set size of the process
+func resourceUsage() (time.Duration, int64, error) {
+	stat, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// fields follow command name in parentheses, utime and stime are the 14th and 15th fields
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, 0, errors.New("unexpected format of /proc/self/stat")
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 13 {
+		return 0, 0, errors.New("unexpected format of /proc/self/stat")
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// resident pages are the second field of statm
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, 0, err
+	}
+	pages := strings.Fields(string(statm))
+	if len(pages) < 2 {
+		return 0, 0, errors.New("unexpected format of /proc/self/statm")
+	}
+	rss, err := strconv.ParseInt(pages[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cpu := time.Duration(utime+stime) * time.Second / clockTicks
+	return cpu, rss * int64(os.Getpagesize()), nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// resourceUsage returns error on platforms other than linux
+func resourceUsage() (time.Duration, int64, error) {
+	return 0, 0, errors.New("resource usage is supported only on linux")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+)
+
+var (
+	verifyFlags = flag.NewFlagSet("verify", flag.ExitOnError)
+
+	verifyKey      = verifyFlags.String("key", "", "PEM encoded ed25519 public key (or the signing key) verifying signatures of the audit log, signatures are only checked to follow the chain if empty")
+	verifyUnsigned = verifyFlags.Bool("allow-unsigned", false, "Accept records after the last signature, e.g. of a sniffer which didn't stop cleanly, they are reported as a failure otherwise since removal of the last records keeps the chain")
+)
+
+// verify verifies hash chain and signatures of audit log written by audit output, the log is a path of the
+// argument or default -output.audit.path
+func verify() error {
+	path := verifyFlags.Arg(0)
+	if path == "" {
+		path = *outputAuditPath
+	}
+
+	var publicKey ed25519.PublicKey
+	if *verifyKey != "" {
+		key, err := events.ReadAuditPublicKey(*verifyKey)
+		if err != nil {
+			return err
+		}
+		publicKey = key
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	head, err := events.VerifyAudit(file, publicKey)
+	if err != nil {
+		return fmt.Errorf("audit log %s is broken: %s", path, err)
+	}
+
+	fmt.Printf("%s: %d records, chain head %s, signed up to record %d\n", path, head.Events, head.Hash, head.Signed)
+	if publicKey != nil && head.Unsigned() > 0 && !*verifyUnsigned {
+		return fmt.Errorf("%d records after the last signature aren't signed, the log may be truncated", head.Unsigned())
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+)
+
+// watchdogRecovery is a share of limits usage must fall below to restore normal decoding, so decoding
+// doesn't flap around the limits
+const watchdogRecovery = 0.8
+
+// watchdog monitors CPU and memory usage of sniffer and degrades decoding when limits are exceeded: requests
+// are sampled and records of produce requests aren't decoded. Normal decoding is restored when usage falls
+// below the limits.
+type watchdog struct {
+	// cpuLimit is a limit of CPU usage in cores, rssLimit is a limit of resident memory in bytes, zero
+	// disables a limit
+	cpuLimit float64
+	rssLimit int64
+
+	interval time.Duration
+
+	// sampleRate is changed from normalRate to degradedRate, shallow decoding is restored to normalShallow
+	sampleRate    *stream.SampleRate
+	normalRate    int
+	degradedRate  int
+	normalShallow bool
+
+	mux      sync.Mutex
+	degraded bool
+}
+
+func (w *watchdog) run() {
+	cpu, _, err := resourceUsage()
+	if err != nil {
+		log.Println("watchdog is disabled:", err)
+		return
+	}
+	last := time.Now()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		nextCPU, rss, err := resourceUsage()
+		if err != nil {
+			log.Println("watchdog could not get resource usage:", err)
+			continue
+		}
+
+		cores := float64(nextCPU-cpu) / float64(now.Sub(last))
+		cpu, last = nextCPU, now
+
+		w.check(cores, rss)
+	}
+}
+
+// check degrades or restores decoding by usage of CPU cores and resident memory
+func (w *watchdog) check(cores float64, rss int64) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	switch {
+	case !w.degraded && w.exceeds(cores, rss, 1):
+		log.Printf("watchdog: usage of %.2f CPU cores and %d bytes of memory exceeds limits, degrading decoding\n", cores, rss)
+		w.sampleRate.Set(w.degradedRate)
+		kafka.SetShallowDecode(true)
+		w.degraded = true
+		metrics.InternalDegraded.Set(1)
+	case w.degraded && !w.exceeds(cores, rss, watchdogRecovery):
+		log.Printf("watchdog: usage of %.2f CPU cores and %d bytes of memory is below limits, restoring decoding\n", cores, rss)
+		w.sampleRate.Set(w.normalRate)
+		kafka.SetShallowDecode(w.normalShallow)
+		w.degraded = false
+		metrics.InternalDegraded.Set(0)
+	}
+}
+
+// setNormalRate changes sample rate of normal decoding, e.g. by control API, it's applied when decoding
+// isn't degraded
+func (w *watchdog) setNormalRate(n int) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.normalRate = n
+	if !w.degraded {
+		w.sampleRate.Set(n)
+	}
+}
+
+// exceeds returns true if usage exceeds share of any limit
+func (w *watchdog) exceeds(cores float64, rss int64, share float64) bool {
+	return w.cpuLimit > 0 && cores > w.cpuLimit*share || w.rssLimit > 0 && float64(rss) > float64(w.rssLimit)*share
+}
@@ -0,0 +1,176 @@
+// Package dump writes captured kafka traffic into pcap files
+package dump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	pcapFilePrefix     = "kafka-"
+	pcapFileExt        = ".pcap"
+	pcapFileTimeFormat = "20060102T150405"
+	pcapMagic          = 0xa1b2c3d4
+	pcapHeaderSize     = 24
+	pcapRecordHeader   = 16
+)
+
+// RotatingPcap writes packets into <dir>/kafka-<timestamp>.pcap files. The file is rotated when its size exceeds
+// maxSize or when rotation interval is passed, the oldest files are removed when there are more than maxFiles.
+type RotatingPcap struct {
+	dir      string
+	snaplen  uint32
+	linkType uint32
+	maxSize  int64
+	interval time.Duration
+	maxFiles int
+
+	mux      sync.Mutex
+	file     *os.File
+	w        *pcapgo.Writer
+	size     int64
+	openedAt time.Time
+}
+
+// NewRotatingPcap creates dir and opens the first file, zero maxSize, interval or maxFiles disables the corresponding cap
+func NewRotatingPcap(dir string, snaplen uint32, linkType uint32, maxSize int64, interval time.Duration, maxFiles int) (*RotatingPcap, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	p := &RotatingPcap{
+		dir:      dir,
+		snaplen:  snaplen,
+		linkType: linkType,
+		maxSize:  maxSize,
+		interval: interval,
+		maxFiles: maxFiles,
+	}
+
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// WritePacket writes packet to the file, it rotates the file before writing if needed
+func (p *RotatingPcap) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.needsRotation(int64(pcapRecordHeader + len(data))) {
+		if err := p.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if err := p.w.WritePacket(ci, data); err != nil {
+		return err
+	}
+	p.size += int64(pcapRecordHeader + len(data))
+
+	return nil
+}
+
+// Close closes current file
+func (p *RotatingPcap) Close() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	return p.file.Close()
+}
+
+func (p *RotatingPcap) open() error {
+	now := time.Now()
+	path := filepath.Join(p.dir, pcapFilePrefix+now.Format(pcapFileTimeFormat)+pcapFileExt)
+
+	// O_EXCL prevents overwriting of the file rotated during the same second
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		path = filepath.Join(p.dir, fmt.Sprintf("%s%s.%d%s", pcapFilePrefix, now.Format(pcapFileTimeFormat), now.UnixNano(), pcapFileExt))
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = p.writeFileHeader(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	p.file = file
+	p.w = pcapgo.NewWriter(file)
+	p.size = pcapHeaderSize
+	p.openedAt = now
+
+	return nil
+}
+
+// writeFileHeader writes pcap file header, pcapgo.Writer isn't used for it since it doesn't support
+// link types above 255
+func (p *RotatingPcap) writeFileHeader(file *os.File) error {
+	var header [pcapHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], p.snaplen)
+	binary.LittleEndian.PutUint32(header[20:24], p.linkType)
+
+	_, err := file.Write(header[:])
+	return err
+}
+
+func (p *RotatingPcap) needsRotation(writeLen int64) bool {
+	if p.size == pcapHeaderSize {
+		return false
+	}
+	if p.maxSize > 0 && p.size+writeLen > p.maxSize {
+		return true
+	}
+	return p.interval > 0 && time.Since(p.openedAt) >= p.interval
+}
+
+// rotate closes current file, opens a new one and removes the oldest files
+func (p *RotatingPcap) rotate() error {
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+
+	if err := p.open(); err != nil {
+		return err
+	}
+
+	if p.maxFiles > 0 {
+		p.removeOldFiles()
+	}
+
+	return nil
+}
+
+func (p *RotatingPcap) removeOldFiles() {
+	files, err := filepath.Glob(filepath.Join(p.dir, pcapFilePrefix+"*"+pcapFileExt))
+	if err != nil {
+		log.Printf("could not list pcap files: %s\n", err)
+		return
+	}
+
+	// file names start with timestamp, so the oldest files go first
+	sort.Strings(files)
+	for len(files) > p.maxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			log.Printf("could not remove old pcap file: %s\n", err)
+		}
+		files = files[1:]
+	}
+}
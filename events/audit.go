@@ -0,0 +1,356 @@
+package events
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditGenesisHash is a previous hash of the first record of audit log
+var auditGenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// auditRecord is a line of audit log. Event records contain the event and hash of the record, signature
+// records contain ed25519 signature of the hash of the last event record.
+type auditRecord struct {
+	Seq       uint64          `json:"seq"`
+	PrevHash  string          `json:"prev_hash,omitempty"`
+	Event     json.RawMessage `json:"event,omitempty"`
+	Hash      string          `json:"hash"`
+	Signature string          `json:"signature,omitempty"`
+}
+
+// AuditSink appends events into tamper-evident audit log. Every record is chained with the previous one:
+// hash = sha256(seq + "\n" + prev_hash + "\n" + event), so modification, removal or reordering of a record
+// breaks the chain. The head of the chain is periodically signed with ed25519 key, the file is synced to disk on
+// every signature. The chain is continued when the sniffer is restarted with the existing file, the whole file
+// is verified by VerifyAudit before.
+type AuditSink struct {
+	key ed25519.PrivateKey
+
+	mux      sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+	signed   uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAuditSink opens audit log for appending. keyPath is a PEM encoded PKCS #8 ed25519 private key
+// (e.g. generated by `openssl genpkey -algorithm ed25519`), empty keyPath disables signing.
+func NewAuditSink(path, keyPath string, signInterval time.Duration) (*AuditSink, error) {
+	s := &AuditSink{
+		lastHash: auditGenesisHash,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if keyPath != "" {
+		key, err := readEd25519Key(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+
+	if err := s.recover(path); err != nil {
+		return nil, fmt.Errorf("could not read audit log: %s", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+
+	if s.key != nil && signInterval > 0 {
+		go s.runSigning(signInterval)
+	} else {
+		close(s.done)
+	}
+
+	return s, nil
+}
+
+// Write appends event record chained with the previous one
+func (s *AuditSink) Write(e *Event) error {
+	event, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	record := &auditRecord{
+		Seq:      s.seq + 1,
+		PrevHash: s.lastHash,
+		Event:    event,
+		Hash:     auditHash(s.seq+1, s.lastHash, event),
+	}
+	if err = s.append(record); err != nil {
+		return err
+	}
+
+	s.seq, s.lastHash = record.Seq, record.Hash
+
+	return nil
+}
+
+// Close signs the head of the chain and closes the file
+func (s *AuditSink) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	err := s.sign()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (s *AuditSink) runSigning(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mux.Lock()
+			if err := s.sign(); err != nil {
+				log.Printf("could not sign audit log: %s\n", err)
+			}
+			s.mux.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// sign appends signature of the last event record, it does nothing if nothing is written since the last signature
+func (s *AuditSink) sign() error {
+	if s.key == nil || s.seq == s.signed {
+		return nil
+	}
+
+	hash, err := hex.DecodeString(s.lastHash)
+	if err != nil {
+		return err
+	}
+
+	record := &auditRecord{
+		Seq:       s.seq,
+		Hash:      s.lastHash,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, hash)),
+	}
+	if err = s.append(record); err != nil {
+		return err
+	}
+	s.signed = s.seq
+
+	return s.file.Sync()
+}
+
+func (s *AuditSink) append(record *auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// recover verifies existing audit log and continues its chain, signatures are verified if signing is enabled
+func (s *AuditSink) recover(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var publicKey ed25519.PublicKey
+	if s.key != nil {
+		publicKey = s.key.Public().(ed25519.PublicKey)
+	}
+
+	head, err := VerifyAudit(file, publicKey)
+	if err != nil {
+		return err
+	}
+	if head.Events > 0 {
+		s.seq, s.lastHash, s.signed = head.Events, head.Hash, head.Signed
+	}
+
+	return nil
+}
+
+// AuditHead is a head of verified chain of audit log
+type AuditHead struct {
+	// Events is a count of event records, Hash is a hash of the last one
+	Events uint64
+	Hash   string
+
+	// Signed is a seq of the last signed event record, records after it are unsigned, e.g. the log is truncated
+	// or sniffer didn't stop cleanly
+	Signed uint64
+}
+
+// Unsigned returns count of event records after the last signature
+func (h *AuditHead) Unsigned() uint64 {
+	return h.Events - h.Signed
+}
+
+// VerifyAudit reads audit log and verifies its chain: seqs of event records follow each other from 1, every
+// record is chained with the previous one and its hash matches its event, every signature record signs the head of
+// the chain at its position. Signatures are verified by publicKey, they are only checked to follow the chain if
+// it's nil. It returns head of the chain or error describing the first broken record.
+func VerifyAudit(r io.Reader, publicKey ed25519.PublicKey) (*AuditHead, error) {
+	head := &AuditHead{Hash: auditGenesisHash}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		if record.Signature != "" {
+			if err := verifyAuditSignature(head, &record, publicKey); err != nil {
+				return nil, fmt.Errorf("line %d: signature of record %d %s", line, record.Seq, err)
+			}
+			head.Signed = record.Seq
+			continue
+		}
+
+		switch {
+		case record.Seq != head.Events+1:
+			return nil, fmt.Errorf("line %d: record %d follows record %d", line, record.Seq, head.Events)
+		case record.PrevHash != head.Hash:
+			return nil, fmt.Errorf("line %d: previous hash of record %d doesn't match hash of record %d", line, record.Seq, head.Events)
+		case record.Hash != auditHash(record.Seq, record.PrevHash, record.Event):
+			return nil, fmt.Errorf("line %d: hash of record %d doesn't match its event", line, record.Seq)
+		}
+		head.Events, head.Hash = record.Seq, record.Hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// verifyAuditSignature checks signature record signs the head of the chain
+func verifyAuditSignature(head *AuditHead, record *auditRecord, publicKey ed25519.PublicKey) error {
+	if record.Seq != head.Events || record.Hash != head.Hash {
+		return fmt.Errorf("doesn't match head of the chain at record %d", head.Events)
+	}
+	if publicKey == nil {
+		return nil
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(record.Signature)
+	if err != nil {
+		return err
+	}
+	hash, err := hex.DecodeString(record.Hash)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, hash, signature) {
+		return fmt.Errorf("is invalid")
+	}
+	return nil
+}
+
+func auditHash(seq uint64, prevHash string, event []byte) string {
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatUint(seq, 10)))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(prevHash))
+	h.Write([]byte{'\n'})
+	h.Write(event)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readEd25519Key(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", path)
+	}
+
+	return ed25519Key, nil
+}
+
+// ReadAuditPublicKey reads PEM encoded PKIX ed25519 public key verifying audit log (e.g. extracted from the
+// signing key by `openssl pkey -in audit.pem -pubout`), the signing key itself is accepted too
+func ReadAuditPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := readEd25519Key(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ed25519Key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", path)
+	}
+
+	return ed25519Key, nil
+}
@@ -0,0 +1,234 @@
+package events
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAuditKey writes PEM encoded PKCS #8 key into dir
+func writeAuditKey(t *testing.T, dir, name string, key ed25519.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeAuditLog writes events of clients into audit log signed by key, every client is a separate run of sniffer
+func writeAuditLog(t *testing.T, path, keyPath string, runs ...[]string) {
+	for _, clients := range runs {
+		sink, err := NewAuditSink(path, keyPath, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range clients {
+			if err = sink.Write(&Event{ClientID: client, APIName: "Produce", Topics: []string{"orders"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readAuditLines returns lines of audit log with line breaks
+func readAuditLines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	return lines[:len(lines)-1]
+}
+
+// rehash returns line of event record with event modified by replacer and hashes chained again from prevHash
+func rehash(t *testing.T, line, prevHash string, replacer *strings.Replacer) string {
+	var record auditRecord
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatal(err)
+	}
+	record.Event = json.RawMessage(replacer.Replace(string(record.Event)))
+	record.PrevHash = prevHash
+	record.Hash = auditHash(record.Seq, record.PrevHash, record.Event)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
+
+func TestVerifyAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	path := filepath.Join(dir, "audit.log")
+	writeAuditLog(t, path, writeAuditKey(t, dir, "audit.pem", key), []string{"a", "b", "c"}, []string{"d", "e"})
+
+	// events 1-3, signature of 3, events 4-5, signature of 5
+	lines := readAuditLines(t, path)
+	if len(lines) != 7 {
+		t.Fatalf("audit log has %d lines, want 7:\n%s", len(lines), strings.Join(lines, ""))
+	}
+
+	head, err := VerifyAudit(strings.NewReader(strings.Join(lines, "")), publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Events != 5 || head.Signed != 5 || head.Unsigned() != 0 {
+		t.Errorf("head of audit log is %+v, want 5 signed events", head)
+	}
+
+	forged := func(line string) string {
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := hex.DecodeString(record.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, hash))
+		data, _ := json.Marshal(record)
+		return string(data) + "\n"
+	}
+	hashOf := func(line string) string {
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		return record.Hash
+	}
+
+	// event of record 2 is modified and its hash is recomputed, record 3 is chained with it again
+	modified := rehash(t, lines[1], hashOf(lines[0]), strings.NewReplacer(`"client_id":"b"`, `"client_id":"x"`))
+	rechained := rehash(t, lines[2], hashOf(modified), strings.NewReplacer())
+
+	for _, c := range []struct {
+		name   string
+		lines  []string
+		errMsg string
+	}{
+		{"modified event", []string{lines[0], strings.Replace(lines[1], `"client_id":"b"`, `"client_id":"x"`, 1), lines[2]}, "line 2: hash of record 2 doesn't match its event"},
+		{"modified event with its hash", []string{lines[0], modified, lines[2]}, "line 3: previous hash of record 3 doesn't match hash of record 2"},
+		{"modified chain", []string{lines[0], modified, rechained, lines[3]}, "line 4: signature of record 3 doesn't match head of the chain at record 3"},
+		{"removed event", []string{lines[0], lines[2]}, "line 2: record 3 follows record 1"},
+		{"reordered events", []string{lines[1], lines[0], lines[2]}, "line 1: record 2 follows record 0"},
+		{"reordered runs", append(append([]string{}, lines[4:]...), lines[:4]...), "line 1: record 4 follows record 0"},
+		{"truncated record", []string{lines[0], lines[1][:len(lines[1])/2]}, "line 2: unexpected end of JSON input"},
+		{"forged signature", []string{lines[0], lines[1], lines[2], forged(lines[3])}, "line 4: signature of record 3 is invalid"},
+	} {
+		_, err := VerifyAudit(strings.NewReader(strings.Join(c.lines, "")), publicKey)
+		if err == nil || err.Error() != c.errMsg {
+			t.Errorf("%s: verification error is %v, want %s", c.name, err, c.errMsg)
+		}
+	}
+
+	// records removed from the end keep the chain, but they leave unsigned records or no signature of the head
+	head, err = VerifyAudit(strings.NewReader(strings.Join(lines[:5], "")), publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Events != 4 || head.Signed != 3 || head.Unsigned() != 1 {
+		t.Errorf("head of truncated audit log is %+v, want 4 events signed up to 3", head)
+	}
+
+	// signatures are only checked to follow the chain without key
+	if _, err = VerifyAudit(strings.NewReader(strings.Join([]string{lines[0], lines[1], lines[2], forged(lines[3])}, "")), nil); err != nil {
+		t.Errorf("signature is verified without key: %s", err)
+	}
+}
+
+func TestAuditSinkRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writeAuditKey(t, dir, "audit.pem", key)
+	otherKeyPath := writeAuditKey(t, dir, "other.pem", otherKey)
+
+	path := filepath.Join(dir, "audit.log")
+	writeAuditLog(t, path, keyPath, []string{"a", "b"})
+
+	// log signed by another key isn't continued
+	if _, err = NewAuditSink(path, otherKeyPath, time.Hour); err == nil || !strings.Contains(err.Error(), "signature of record 2 is invalid") {
+		t.Errorf("audit log signed by another key is opened with error %v", err)
+	}
+
+	// tampered log isn't continued and isn't modified
+	lines := readAuditLines(t, path)
+	tampered := strings.Join([]string{lines[1], lines[0], lines[2]}, "")
+	if err = ioutil.WriteFile(path, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewAuditSink(path, keyPath, time.Hour); err == nil || !strings.Contains(err.Error(), "record 2 follows record 0") {
+		t.Errorf("tampered audit log is opened with error %v", err)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != tampered {
+		t.Error("tampered audit log is modified")
+	}
+
+	// chain of verified log is continued
+	if err = ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeAuditLog(t, path, keyPath, []string{"c"})
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := VerifyAudit(bytes.NewReader(data), key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Events != 3 || head.Unsigned() != 0 {
+		t.Errorf("head of continued audit log is %+v, want 3 signed events", head)
+	}
+
+	public, err := ReadAuditPublicKey(keyPath)
+	if err != nil || !public.Equal(key.Public()) {
+		t.Errorf("public key of signing key is %x, %v", public, err)
+	}
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// errBufferFull is returned when batcher can't keep up with incoming events
+var errBufferFull = errors.New("events buffer is full, event is dropped")
+
+// batcher collects events and flushes them in batches when batch size is reached or flush
+// interval is passed. Flushing is done in a separate goroutine, so writers aren't blocked.
+type batcher struct {
+	name     string
+	size     int
+	interval time.Duration
+	flush    func([]*Event) error
+
+	ch   chan *Event
+	done chan struct{}
+}
+
+func newBatcher(name string, size int, interval time.Duration, flush func([]*Event) error) *batcher {
+	b := &batcher{
+		name:     name,
+		size:     size,
+		interval: interval,
+		flush:    flush,
+		ch:       make(chan *Event, 2*size),
+		done:     make(chan struct{}),
+	}
+
+	go b.run()
+
+	return b
+}
+
+// Write adds event to the current batch
+func (b *batcher) Write(e *Event) error {
+	select {
+	case b.ch <- e:
+		return nil
+	default:
+		metrics.DroppedEvents.WithLabelValues(b.name).Inc()
+		return errBufferFull
+	}
+}
+
+// Close flushes the rest of events and stops the batcher
+func (b *batcher) Close() error {
+	close(b.ch)
+	<-b.done
+	return nil
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Event, 0, b.size)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := b.flush(batch); err != nil {
+			log.Printf("could not flush %d events to %s: %s\n", len(batch), b.name, err)
+		}
+		batch = make([]*Event, 0, b.size)
+	}
+
+	for {
+		select {
+		case e, ok := <-b.ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
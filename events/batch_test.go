@@ -0,0 +1,36 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBatcherDropsEvents(t *testing.T) {
+	unblock := make(chan struct{})
+	b := newBatcher("test", 1, time.Hour, func([]*Event) error {
+		<-unblock
+		return nil
+	})
+
+	dropped := 0
+	for i := 0; i < 10; i++ {
+		if err := b.Write(&Event{}); err == errBufferFull {
+			dropped++
+		} else if err != nil {
+			t.Fatalf("could not write event: %s", err)
+		}
+	}
+	close(unblock)
+	b.Close()
+
+	if dropped == 0 {
+		t.Fatal("events aren't dropped by blocked batcher")
+	}
+	if got := testutil.ToFloat64(metrics.DroppedEvents.WithLabelValues("test")); got != float64(dropped) {
+		t.Errorf("%v events are counted as dropped, want %d", got, dropped)
+	}
+}
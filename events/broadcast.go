@@ -0,0 +1,68 @@
+package events
+
+import (
+	"sync"
+)
+
+const subscriberBufferSize = 1024
+
+// Broadcaster is a sink which delivers events to all current subscribers. Slow subscribers don't
+// block the sniffer: events are dropped when subscriber's buffer is full.
+type Broadcaster struct {
+	mux         sync.RWMutex
+	subscribers map[chan *Event]struct{}
+}
+
+// NewBroadcaster creates new Broadcaster
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: make(map[chan *Event]struct{})}
+}
+
+// Subscribe returns channel of events, the channel must be released with Unsubscribe
+func (b *Broadcaster) Subscribe() chan *Event {
+	ch := make(chan *Event, subscriberBufferSize)
+
+	b.mux.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mux.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes subscriber and closes its channel
+func (b *Broadcaster) Unsubscribe(ch chan *Event) {
+	b.mux.Lock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	b.mux.Unlock()
+}
+
+// Write delivers event to every subscriber which is ready to receive it
+func (b *Broadcaster) Write(e *Event) error {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Close unsubscribes all subscribers
+func (b *Broadcaster) Close() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package events
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csvHeader contains columns of CSV row, they are named the same way as JSON fields of Event
+var csvHeader = []string{
+	"timestamp", "src_ip", "src_port", "dst_ip", "dst_port",
+	"api_key", "api_name", "api_version", "correlation_id", "client_id",
+	"size", "topics", "records_count", "records_size", "group", "group_instance_id", "principal", "connection",
+	"owner", "country", "asn", "as_org", "acl_unexpected", "schemas",
+	"invalid_topics", "trace_id", "request",
+}
+
+// CSVSink writes every event as one CSV row, the header row is written before the first event.
+// Topics, schemas and invalid topics of the request are joined with ';' into one column.
+type CSVSink struct {
+	mux           sync.Mutex
+	w             io.Writer
+	csv           *csv.Writer
+	headerWritten bool
+}
+
+// NewCSVSink creates new CSVSink with comma as fields delimiter, use '\t' for TSV
+func NewCSVSink(w io.Writer, comma rune) *CSVSink {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+
+	return &CSVSink{w: w, csv: cw}
+}
+
+// Write writes event as CSV row
+func (s *CSVSink) Write(e *Event) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.headerWritten {
+		if err := s.csv.Write(csvHeader); err != nil {
+			return err
+		}
+		s.headerWritten = true
+	}
+
+	if err := s.csv.Write(csvRecord(e)); err != nil {
+		return err
+	}
+	s.csv.Flush()
+
+	return s.csv.Error()
+}
+
+// Close closes underlying writer if it's closable
+func (s *CSVSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.csv.Flush()
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return s.csv.Error()
+}
+
+func csvRecord(e *Event) []string {
+	return []string{
+		e.Time.Format(time.RFC3339Nano),
+		e.SrcIP,
+		e.SrcPort,
+		e.DstIP,
+		e.DstPort,
+		strconv.Itoa(int(e.APIKey)),
+		e.APIName,
+		strconv.Itoa(int(e.APIVersion)),
+		strconv.Itoa(int(e.CorrelationID)),
+		e.ClientID,
+		strconv.Itoa(e.Size),
+		strings.Join(e.Topics, ";"),
+		strconv.Itoa(e.RecordsCount),
+		strconv.Itoa(e.RecordsSize),
+		e.Group,
+		e.GroupInstanceID,
+		e.Principal,
+		e.Connection,
+		e.Owner,
+		e.Country,
+		strconv.FormatUint(uint64(e.ASN), 10),
+		e.ASOrg,
+		strconv.FormatBool(e.ACLUnexpected),
+		strings.Join(e.Schemas, ";"),
+		strings.Join(e.InvalidTopics, ";"),
+		e.TraceID,
+		e.Request,
+	}
+}
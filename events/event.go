@@ -0,0 +1,104 @@
+package events
+
+import (
+	"time"
+)
+
+// Event is a decoded kafka request enriched with connection info
+type Event struct {
+	Time time.Time `json:"timestamp"`
+
+	SrcIP   string `json:"src_ip"`
+	SrcPort string `json:"src_port"`
+	DstIP   string `json:"dst_ip"`
+	DstPort string `json:"dst_port"`
+
+	APIKey        int16  `json:"api_key"`
+	APIName       string `json:"api_name"`
+	APIVersion    int16  `json:"api_version"`
+	CorrelationID int32  `json:"correlation_id"`
+	ClientID      string `json:"client_id"`
+
+	// Principal is a user authenticated by SASL on the connection
+	Principal string `json:"principal,omitempty"`
+
+	// Size is a size of the whole request in bytes
+	Size int `json:"size"`
+
+	Topics []string `json:"topics,omitempty"`
+
+	// Connection is a type of connection: client, inter_broker, replication or unknown
+	Connection string `json:"connection"`
+
+	// Owner is a team or service owning client address by mapping file
+	Owner string `json:"owner,omitempty"`
+
+	// Country, ASN and ASOrg are location of client address by GeoIP databases
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+
+	// Schemas are schemas of keys and values of produced records, e.g. orders-value v7, or ids of schemas which
+	// aren't resolved by Schema Registry
+	Schemas []string `json:"schemas,omitempty"`
+
+	// ACLUnexpected is true if principal accessed a topic or group not allowed by declared ACLs
+	ACLUnexpected bool `json:"acl_unexpected,omitempty"`
+
+	// InvalidTopics are topics of the request violating naming rules of kafka or conventions of the cluster
+	InvalidTopics []string `json:"invalid_topics,omitempty"`
+
+	// TraceID is an id of trace of span the event is exported as by otlp output, it's set if tracing is enabled
+	// and links exemplars of metrics to the span
+	TraceID string `json:"trace_id,omitempty"`
+
+	// ResponseTime is a time between capture of the request and its response, it's set if tracing is enabled and
+	// the response is captured
+	ResponseTime time.Duration `json:"response_time_ns,omitempty"`
+
+	// Request is the decoded request rendered as JSON with payloads redacted, it's set if rendering of requests
+	// is enabled
+	Request string `json:"request,omitempty"`
+
+	// RecordsCount and RecordsSize are set for produce requests
+	RecordsCount int `json:"records_count,omitempty"`
+	RecordsSize  int `json:"records_size,omitempty"`
+
+	// Group and GroupInstanceID are set for consumer group requests
+	Group           string `json:"group,omitempty"`
+	GroupInstanceID string `json:"group_instance_id,omitempty"`
+
+	// APIVersions are versions of apis used by client on the connection by api name
+	APIVersions map[string]int16 `json:"api_versions,omitempty"`
+}
+
+// Sink receives events, it must be safe for concurrent use
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// MultiSink writes events into every underlying sink
+type MultiSink []Sink
+
+// Write writes event into every sink and returns the first error
+func (m MultiSink) Write(e *Event) error {
+	var firstErr error
+	for _, s := range m {
+		if err := s.Write(e); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close closes every sink and returns the first error
+func (m MultiSink) Close() error {
+	var firstErr error
+	for _, s := range m {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,153 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	execMinBackoff = time.Second
+	execMaxBackoff = 30 * time.Second
+
+	// execStableTime is a run time of command after which backoff of restarts is reset
+	execStableTime = time.Minute
+
+	// execCloseTimeout limits waiting for command to exit after its stdin is closed, it's killed then
+	execCloseTimeout = 10 * time.Second
+)
+
+// ExecSink pipes events as JSON lines to stdin of external command run by shell, so events are processed by
+// scripts in any language. Stdout and stderr of the command are passed through. The command is restarted with
+// exponential backoff when it exits, events written until it's restarted are dropped. Writes block while the
+// command doesn't read its stdin.
+type ExecSink struct {
+	command string
+
+	mux   sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	enc   *json.Encoder
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewExecSink starts command and creates new ExecSink
+func NewExecSink(command string) (*ExecSink, error) {
+	s := &ExecSink{
+		command: command,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	cmd, err := s.start()
+	if err != nil {
+		return nil, err
+	}
+	go s.supervise(cmd)
+
+	return s, nil
+}
+
+// Write encodes event as JSON line to stdin of the command, event is dropped if the command isn't running
+func (s *ExecSink) Write(e *Event) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.enc == nil {
+		return nil
+	}
+	return s.enc.Encode(e)
+}
+
+// Close closes stdin of the command and waits until it exits, the command is killed if it doesn't exit in time
+func (s *ExecSink) Close() error {
+	close(s.stop)
+
+	s.mux.Lock()
+	stdin := s.stdin
+	s.mux.Unlock()
+
+	if stdin != nil {
+		stdin.Close()
+	}
+
+	select {
+	case <-s.done:
+	case <-time.After(execCloseTimeout):
+		s.mux.Lock()
+		s.cmd.Process.Kill()
+		s.mux.Unlock()
+		<-s.done
+	}
+	return nil
+}
+
+// start starts the command, its stdin receives events
+func (s *ExecSink) start() (*exec.Cmd, error) {
+	cmd := exec.Command("sh", "-c", s.command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s.mux.Lock()
+	s.cmd, s.stdin, s.enc = cmd, stdin, json.NewEncoder(stdin)
+	s.mux.Unlock()
+
+	return cmd, nil
+}
+
+// supervise waits until the command exits and restarts it until the sink is closed
+func (s *ExecSink) supervise(cmd *exec.Cmd) {
+	defer close(s.done)
+
+	backoff := execMinBackoff
+	for {
+		started := time.Now()
+		err := cmd.Wait()
+
+		s.mux.Lock()
+		s.stdin, s.enc = nil, nil
+		s.mux.Unlock()
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if time.Since(started) > execStableTime {
+			backoff = execMinBackoff
+		}
+		log.Printf("exec output command exited, events are dropped until it's restarted in %s: %v\n", backoff, err)
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > execMaxBackoff {
+				backoff = execMaxBackoff
+			}
+
+			if cmd, err = s.start(); err == nil {
+				break
+			}
+			log.Printf("could not restart exec output command, retry in %s: %s\n", backoff, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package events
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const rotatedFileTimeFormat = "20060102T150405"
+
+// RotatingFile is a file writer which rotates the file when its size exceeds maxSize or when
+// rotation interval is passed. Rotated files are renamed to <path>.<timestamp> and optionally gzipped.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	compress bool
+
+	mux      sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewRotatingFile opens file for appending, zero maxSize or interval disables the corresponding rotation
+func NewRotatingFile(path string, maxSize int64, interval time.Duration, compress bool) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		interval: interval,
+		compress: compress,
+	}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write writes p to the file, it rotates the file before writing if needed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	if f.needsRotation(int64(len(p))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close closes current file
+func (f *RotatingFile) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+
+	return nil
+}
+
+func (f *RotatingFile) needsRotation(writeLen int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+writeLen > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && time.Since(f.openedAt) >= f.interval
+}
+
+// rotate renames current file and opens a new one
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	rotated, err := f.reserveRotated(time.Now())
+	if err == nil {
+		err = os.Rename(f.path, rotated)
+		if err != nil {
+			os.Remove(rotated)
+		}
+	}
+	if err != nil {
+		// keep writing to the current file, otherwise every following write fails on the closed file
+		if oerr := f.open(); oerr != nil {
+			log.Printf("could not reopen file %s: %s\n", f.path, oerr)
+		}
+		return err
+	}
+
+	if f.compress {
+		go func() {
+			if err := gzipFile(rotated); err != nil {
+				log.Printf("could not compress rotated file %s: %s\n", rotated, err)
+			}
+		}()
+	}
+
+	return f.open()
+}
+
+// reserveRotated creates an empty file named for rotated file, the rotated file replaces it on renaming.
+// O_EXCL prevents overwriting of the file rotated during the same second.
+func (f *RotatingFile) reserveRotated(now time.Time) (string, error) {
+	path := fmt.Sprintf("%s.%s", f.path, now.Format(rotatedFileTimeFormat))
+	err := f.createRotated(path)
+	if os.IsExist(err) {
+		path = fmt.Sprintf("%s.%s.%d", f.path, now.Format(rotatedFileTimeFormat), now.UnixNano())
+		err = f.createRotated(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (f *RotatingFile) createRotated(path string) error {
+	// compressed file of the same second is removed after compression, so its name is taken by the .gz file
+	if f.compress {
+		if _, err := os.Stat(path + ".gz"); err == nil {
+			return &os.PathError{Op: "open", Path: path + ".gz", Err: os.ErrExist}
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// gzipFile compresses file into <path>.gz and removes the original
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
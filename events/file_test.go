@@ -0,0 +1,95 @@
+package events
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRotatingFileSameSecond(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	f, err := NewRotatingFile(path, 10, 0, false)
+	if err != nil {
+		t.Fatalf("could not open file: %s", err)
+	}
+	defer f.Close()
+
+	lines := []string{"first\n", "second\n", "third\n"}
+	for _, line := range lines {
+		if _, err = f.Write([]byte(line)); err != nil {
+			t.Fatalf("could not write: %s", err)
+		}
+	}
+
+	// both rotations are done during the same second, neither of rotated files is overwritten
+	names, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(lines) {
+		t.Fatalf("files %v, want %d files", names, len(lines))
+	}
+
+	var contents []string
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+	sort.Strings(contents)
+	sort.Strings(lines)
+	for i := range lines {
+		if contents[i] != lines[i] {
+			t.Errorf("contents of files %q, want %q", contents, lines)
+			break
+		}
+	}
+}
+
+func TestRotatingFileRenameFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	f, err := NewRotatingFile(path, 10, 0, false)
+	if err != nil {
+		t.Fatalf("could not open file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write([]byte("first\n")); err != nil {
+		t.Fatalf("could not write: %s", err)
+	}
+
+	// renaming of the removed file fails
+	if err = os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("second\n")); err == nil {
+		t.Fatal("rotation of removed file doesn't fail")
+	}
+
+	if _, err = f.Write([]byte("third\n")); err != nil {
+		t.Fatalf("could not write after failed rotation: %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "third\n" {
+		t.Errorf("contents %q, want %q", b, "third\n")
+	}
+}
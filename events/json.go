@@ -0,0 +1,35 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// JSONSink writes every event as one JSON object per line
+type JSONSink struct {
+	mux sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewJSONSink creates new JSONSink
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// Write encodes event as JSON line
+func (s *JSONSink) Write(e *Event) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.enc.Encode(e)
+}
+
+// Close closes underlying writer if it's closable
+func (s *JSONSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaSink produces events as JSON messages into kafka topic. Messages are keyed by client ip, so
+// events of one client are kept in order.
+type KafkaSink struct {
+	topic    string
+	producer sarama.AsyncProducer
+	done     chan struct{}
+}
+
+// NewKafkaSink creates new KafkaSink
+func NewKafkaSink(brokers []string, topic string) (*KafkaSink, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-sniffer"
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Compression = sarama.CompressionSnappy
+
+	producer, err := sarama.NewAsyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &KafkaSink{
+		topic:    topic,
+		producer: producer,
+		done:     make(chan struct{}),
+	}
+
+	go s.logErrors()
+
+	return s, nil
+}
+
+// Write sends event to kafka asynchronously, delivery errors are logged. Event is dropped if producer can't
+// keep up with events, e.g. while brokers are unavailable, so writers aren't blocked.
+func (s *KafkaSink) Write(e *Event) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.producer.Input() <- &sarama.ProducerMessage{
+		Topic: s.topic,
+		Key:   sarama.StringEncoder(e.SrcIP),
+		Value: sarama.ByteEncoder(value),
+	}:
+		return nil
+	default:
+		metrics.DroppedEvents.WithLabelValues("kafka").Inc()
+		return errBufferFull
+	}
+}
+
+// Close flushes buffered events and closes producer
+func (s *KafkaSink) Close() error {
+	s.producer.AsyncClose()
+	<-s.done
+	return nil
+}
+
+func (s *KafkaSink) logErrors() {
+	defer close(s.done)
+
+	for err := range s.producer.Errors() {
+		log.Printf("could not produce event to kafka: %s\n", err)
+	}
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+const natsSubjectPrefix = "kafka.sniffer"
+
+// NATSSink publishes events as JSON messages into kafka.sniffer.<cluster>.<api name> subjects,
+// e.g. kafka.sniffer.main.Produce, so subscribers can pick requests of interest with wildcards.
+type NATSSink struct {
+	prefix string
+	conn   *nats.Conn
+}
+
+// NewNATSSink creates new NATSSink, cluster is a name of sniffed kafka cluster used in subjects
+func NewNATSSink(url, cluster string) (*NATSSink, error) {
+	if cluster == "" || strings.ContainsAny(cluster, ".*> \t") {
+		return nil, fmt.Errorf("invalid nats subject token %q", cluster)
+	}
+
+	conn, err := nats.Connect(url,
+		nats.Name("kafka-sniffer"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("disconnected from nats: %s\n", err)
+			}
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			log.Printf("nats error: %s\n", err)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NATSSink{
+		prefix: natsSubjectPrefix + "." + cluster + ".",
+		conn:   conn,
+	}, nil
+}
+
+// Write publishes event, messages are buffered by the client while it reconnects
+func (s *NATSSink) Write(e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.Publish(s.prefix+e.APIName, data)
+}
+
+// Close flushes buffered messages and closes connection
+func (s *NATSSink) Close() error {
+	err := s.conn.Flush()
+	s.conn.Close()
+	return err
+}
@@ -0,0 +1,209 @@
+package events
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpTimeout    = 30 * time.Second
+	otlpScopeName  = "github.com/d-ulyanov/kafka-sniffer"
+	otlpTracesPath = "/v1/traces"
+
+	// span kinds, see opentelemetry-proto trace.proto
+	otlpSpanKindClient   = 3
+	otlpSpanKindProducer = 4
+	otlpSpanKindConsumer = 5
+)
+
+// OTLPSink exports every event as OpenTelemetry span with OTLP/HTTP JSON protocol, so captured requests
+// can be seen in Jaeger, Tempo or any other OTLP compatible tracing backend.
+type OTLPSink struct {
+	*batcher
+
+	url         string
+	serviceName string
+	client      *http.Client
+}
+
+// otlp JSON structures, only fields needed for spans export are defined
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+// NewOTLPSink creates new OTLPSink, endpoint is a base URL of OTLP/HTTP receiver, e.g. http://127.0.0.1:4318
+func NewOTLPSink(endpoint, serviceName string, batchSize int, flushInterval time.Duration) *OTLPSink {
+	s := &OTLPSink{
+		url:         strings.TrimRight(endpoint, "/") + otlpTracesPath,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpTimeout},
+	}
+	s.batcher = newBatcher("otlp", batchSize, flushInterval, s.export)
+
+	return s
+}
+
+func (s *OTLPSink) export(batch []*Event) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, e := range batch {
+		spans = append(spans, newOTLPSpan(e))
+	}
+
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", s.serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("otlp receiver responded with %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// NewTraceID returns random id of trace of span of event, events with trace ids are exported as spans of
+// these traces, so exemplars of metrics can refer to them
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// newOTLPSpan creates span of the event following messaging semantic conventions
+func newOTLPSpan(e *Event) otlpSpan {
+	traceID := e.TraceID
+	if traceID == "" {
+		traceID = NewTraceID()
+	}
+
+	span := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            randomHex(8),
+		Name:              e.APIName,
+		Kind:              otlpSpanKindClient,
+		StartTimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(e.Time.Add(e.ResponseTime).UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringAttribute("messaging.system", "kafka"),
+			stringAttribute("messaging.kafka.client_id", e.ClientID),
+			stringAttribute("kafka.api_name", e.APIName),
+			intAttribute("kafka.api_version", int64(e.APIVersion)),
+			intAttribute("kafka.correlation_id", int64(e.CorrelationID)),
+			intAttribute("kafka.request_size", int64(e.Size)),
+			stringAttribute("net.peer.ip", e.SrcIP),
+			stringAttribute("net.peer.port", e.SrcPort),
+			stringAttribute("net.host.ip", e.DstIP),
+			stringAttribute("net.host.port", e.DstPort),
+		},
+	}
+
+	switch e.APIKey {
+	case 0:
+		span.Kind = otlpSpanKindProducer
+		span.Attributes = append(span.Attributes,
+			stringAttribute("messaging.operation", "publish"),
+			intAttribute("messaging.batch.message_count", int64(e.RecordsCount)),
+			intAttribute("messaging.message.payload_size_bytes", int64(e.RecordsSize)),
+		)
+	case 1:
+		span.Kind = otlpSpanKindConsumer
+		span.Attributes = append(span.Attributes, stringAttribute("messaging.operation", "receive"))
+	}
+
+	if len(e.Topics) > 0 {
+		span.Name = strings.Join(e.Topics, ",") + " " + e.APIName
+		span.Attributes = append(span.Attributes, stringAttribute("messaging.destination.name", strings.Join(e.Topics, ",")))
+	}
+	if e.Group != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("messaging.kafka.consumer.group", e.Group))
+	}
+	if e.Principal != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("enduser.id", e.Principal))
+	}
+	if e.Owner != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("peer.service", e.Owner))
+	}
+	if len(e.Schemas) > 0 {
+		span.Attributes = append(span.Attributes, stringAttribute("kafka.schemas", strings.Join(e.Schemas, ",")))
+	}
+	if len(e.InvalidTopics) > 0 {
+		span.Attributes = append(span.Attributes, stringAttribute("kafka.invalid_topics", strings.Join(e.InvalidTopics, ",")))
+	}
+	if e.Country != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("client.geo.country.iso_code", e.Country))
+	}
+	if e.ASN != 0 {
+		span.Attributes = append(span.Attributes, intAttribute("client.as.number", int64(e.ASN)))
+	}
+	if e.Request != "" {
+		span.Attributes = append(span.Attributes, stringAttribute("kafka.request", e.Request))
+	}
+
+	return span
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+// intAttribute encodes int64 as string as required by OTLP JSON mapping
+func intAttribute(key string, value int64) otlpAttribute {
+	v := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &v}}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
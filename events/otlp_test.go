@@ -0,0 +1,19 @@
+package events
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestOTLPSpanDuration(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	span := newOTLPSpan(&Event{Time: start, APIKey: 0, APIName: "produce", ResponseTime: 15 * time.Millisecond})
+
+	if want := strconv.FormatInt(start.UnixNano(), 10); span.StartTimeUnixNano != want {
+		t.Errorf("span starts at %s, want %s", span.StartTimeUnixNano, want)
+	}
+	if want := strconv.FormatInt(start.Add(15*time.Millisecond).UnixNano(), 10); span.EndTimeUnixNano != want {
+		t.Errorf("span ends at %s, want %s", span.EndTimeUnixNano, want)
+	}
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	parquetPartitionFormat = "date=2006-01-02/hour=15"
+	parquetTmpSuffix       = ".tmp"
+)
+
+// parquetEvent is a row of parquet file
+type parquetEvent struct {
+	Timestamp       int64    `parquet:"name=timestamp, type=TIMESTAMP_MILLIS"`
+	SrcIP           string   `parquet:"name=src_ip, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	SrcPort         string   `parquet:"name=src_port, type=UTF8"`
+	DstIP           string   `parquet:"name=dst_ip, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	DstPort         string   `parquet:"name=dst_port, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	APIKey          int32    `parquet:"name=api_key, type=INT_16"`
+	APIName         string   `parquet:"name=api_name, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	APIVersion      int32    `parquet:"name=api_version, type=INT_16"`
+	CorrelationID   int32    `parquet:"name=correlation_id, type=INT32"`
+	ClientID        string   `parquet:"name=client_id, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Size            int64    `parquet:"name=size, type=INT64"`
+	Topics          []string `parquet:"name=topics, type=LIST, valuetype=UTF8"`
+	RecordsCount    int64    `parquet:"name=records_count, type=INT64"`
+	RecordsSize     int64    `parquet:"name=records_size, type=INT64"`
+	Group           string   `parquet:"name=group, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	GroupInstanceID string   `parquet:"name=group_instance_id, type=UTF8"`
+	Principal       string   `parquet:"name=principal, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Connection      string   `parquet:"name=connection, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Owner           string   `parquet:"name=owner, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Country         string   `parquet:"name=country, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	ASN             int64    `parquet:"name=asn, type=INT64"`
+	ASOrg           string   `parquet:"name=as_org, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	ACLUnexpected   bool     `parquet:"name=acl_unexpected, type=BOOLEAN"`
+	Schemas         []string `parquet:"name=schemas, type=LIST, valuetype=UTF8"`
+	InvalidTopics   []string `parquet:"name=invalid_topics, type=LIST, valuetype=UTF8"`
+	TraceID         string   `parquet:"name=trace_id, type=UTF8"`
+	Request         string   `parquet:"name=request, type=UTF8"`
+}
+
+// ParquetSink writes events into hourly partitioned parquet files <dir>/date=YYYY-MM-DD/hour=HH/events-<ts>.parquet,
+// which can be queried with DuckDB, Spark etc. The file is written as <name>.tmp and is renamed when the hour is over,
+// since parquet file isn't readable until its footer is written.
+type ParquetSink struct {
+	*batcher
+
+	dir string
+
+	hour time.Time
+	path string
+	file *os.File
+	pw   *writer.ParquetWriter
+}
+
+// NewParquetSink creates new ParquetSink
+func NewParquetSink(dir string, batchSize int, flushInterval time.Duration) (*ParquetSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &ParquetSink{dir: dir}
+	s.batcher = newBatcher("parquet", batchSize, flushInterval, s.write)
+
+	return s, nil
+}
+
+// Close flushes the rest of events and finalizes the current file
+func (s *ParquetSink) Close() error {
+	if err := s.batcher.Close(); err != nil {
+		return err
+	}
+	return s.closeFile()
+}
+
+func (s *ParquetSink) write(batch []*Event) error {
+	for _, e := range batch {
+		hour := e.Time.UTC().Truncate(time.Hour)
+		if s.pw == nil || !hour.Equal(s.hour) {
+			if err := s.closeFile(); err != nil {
+				return err
+			}
+			if err := s.openFile(hour); err != nil {
+				return err
+			}
+		}
+
+		if err := s.pw.Write(newParquetEvent(e)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ParquetSink) openFile(hour time.Time) (err error) {
+	partition := filepath.Join(s.dir, filepath.FromSlash(hour.Format(parquetPartitionFormat)))
+	if err = os.MkdirAll(partition, 0755); err != nil {
+		return err
+	}
+
+	s.hour = hour
+	s.path = filepath.Join(partition, fmt.Sprintf("events-%d.parquet", time.Now().UnixNano()))
+	if s.file, err = os.Create(s.path + parquetTmpSuffix); err != nil {
+		return err
+	}
+
+	if s.pw, err = writer.NewParquetWriterFromWriter(s.file, new(parquetEvent), 1); err != nil {
+		s.file.Close()
+		s.pw = nil
+		return err
+	}
+
+	return nil
+}
+
+// closeFile writes parquet footer and renames the file
+func (s *ParquetSink) closeFile() error {
+	if s.pw == nil {
+		return nil
+	}
+
+	err := s.pw.WriteStop()
+	s.pw = nil
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(s.path+parquetTmpSuffix, s.path)
+}
+
+func newParquetEvent(e *Event) *parquetEvent {
+	return &parquetEvent{
+		Timestamp:       e.Time.UnixNano() / int64(time.Millisecond),
+		SrcIP:           e.SrcIP,
+		SrcPort:         e.SrcPort,
+		DstIP:           e.DstIP,
+		DstPort:         e.DstPort,
+		APIKey:          int32(e.APIKey),
+		APIName:         e.APIName,
+		APIVersion:      int32(e.APIVersion),
+		CorrelationID:   e.CorrelationID,
+		ClientID:        e.ClientID,
+		Size:            int64(e.Size),
+		Topics:          e.Topics,
+		RecordsCount:    int64(e.RecordsCount),
+		RecordsSize:     int64(e.RecordsSize),
+		Group:           e.Group,
+		GroupInstanceID: e.GroupInstanceID,
+		Principal:       e.Principal,
+		Connection:      e.Connection,
+		Owner:           e.Owner,
+		Country:         e.Country,
+		ASN:             int64(e.ASN),
+		ASOrg:           e.ASOrg,
+		ACLUnexpected:   e.ACLUnexpected,
+		Schemas:         e.Schemas,
+		InvalidTopics:   e.InvalidTopics,
+		TraceID:         e.TraceID,
+		Request:         e.Request,
+	}
+}
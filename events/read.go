@@ -0,0 +1,123 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// parquetReadBatch is a count of rows read from parquet file at once
+const parquetReadBatch = 1000
+
+// ReadJSON reads JSON lines of events written by json and file outputs and calls fn for every event until
+// reader is over or fn returns error
+func ReadJSON(r io.Reader, fn func(*Event) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		e := &Event{}
+		if err := dec.Decode(e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadParquet reads events of parquet file written by parquet output and calls fn for every event until file
+// is over or fn returns error
+func ReadParquet(path string, fn func(*Event) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pr, err := reader.NewParquetReader(&parquetFile{File: f}, new(parquetEvent), 1)
+	if err != nil {
+		return err
+	}
+	defer pr.ReadStop()
+
+	for left := int(pr.GetNumRows()); left > 0; left -= parquetReadBatch {
+		n := parquetReadBatch
+		if left < n {
+			n = left
+		}
+
+		rows := make([]parquetEvent, n)
+		if err = pr.Read(&rows); err != nil {
+			return err
+		}
+		for i := range rows {
+			if err = fn(rows[i].event()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// event converts row back into event
+func (p *parquetEvent) event() *Event {
+	return &Event{
+		Time:            time.Unix(0, p.Timestamp*int64(time.Millisecond)),
+		SrcIP:           p.SrcIP,
+		SrcPort:         p.SrcPort,
+		DstIP:           p.DstIP,
+		DstPort:         p.DstPort,
+		APIKey:          int16(p.APIKey),
+		APIName:         p.APIName,
+		APIVersion:      int16(p.APIVersion),
+		CorrelationID:   p.CorrelationID,
+		ClientID:        p.ClientID,
+		Size:            int(p.Size),
+		Topics:          p.Topics,
+		RecordsCount:    int(p.RecordsCount),
+		RecordsSize:     int(p.RecordsSize),
+		Group:           p.Group,
+		GroupInstanceID: p.GroupInstanceID,
+		Principal:       p.Principal,
+		Connection:      p.Connection,
+		Owner:           p.Owner,
+		Country:         p.Country,
+		ASN:             uint(p.ASN),
+		ASOrg:           p.ASOrg,
+		ACLUnexpected:   p.ACLUnexpected,
+		Schemas:         p.Schemas,
+		InvalidTopics:   p.InvalidTopics,
+		TraceID:         p.TraceID,
+		Request:         p.Request,
+	}
+}
+
+// parquetFile is a read-only parquet source of local file
+type parquetFile struct {
+	*os.File
+}
+
+// Open opens the file once more, parquet reader reads columns by separate handles
+func (f *parquetFile) Open(name string) (source.ParquetFile, error) {
+	if name == "" {
+		name = f.Name()
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &parquetFile{File: file}, nil
+}
+
+// Create isn't supported by read-only source
+func (f *parquetFile) Create(string) (source.ParquetFile, error) {
+	return nil, os.ErrPermission
+}
@@ -0,0 +1,60 @@
+package events
+
+import "sync"
+
+// Ring is a sink keeping the last events in memory, so concrete recent traffic can be inspected without verbose
+// logging. The oldest event is overwritten by a new one when the ring is full.
+type Ring struct {
+	mux    sync.Mutex
+	events []*Event
+
+	// next is an index of slot of the next event, slots before it are the newest events
+	next int
+	full bool
+}
+
+// NewRing creates new Ring keeping size last events
+func NewRing(size int) *Ring {
+	return &Ring{events: make([]*Event, size)}
+}
+
+// Write puts event into the ring in place of the oldest one
+func (r *Ring) Write(e *Event) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next, r.full = 0, true
+	}
+
+	return nil
+}
+
+// Close does nothing, events are kept until the ring is released
+func (r *Ring) Close() error {
+	return nil
+}
+
+// Recent returns up to limit of the newest events matched by match, the newest event is the first one. All
+// kept events are matched if match is nil, limit isn't set if it's zero.
+func (r *Ring) Recent(match func(e *Event) bool, limit int) []*Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.events)
+	}
+
+	out := make([]*Event, 0)
+	for i := 0; i < count && (limit == 0 || len(out) < limit); i++ {
+		e := r.events[(r.next-1-i+len(r.events))%len(r.events)]
+		if match == nil || match(e) {
+			out = append(out, e)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,124 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	syslogAppName         = "kafka-sniffer"
+	syslogSeverityInfo    = 6
+	syslogDialTimeout     = 5 * time.Second
+	syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogFacilities maps facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogSink sends events as RFC5424 messages with JSON body. Supported addresses are udp://host:port,
+// tcp://host:port (with octet-counting framing from RFC6587) and unix:///path/to/socket.
+type SyslogSink struct {
+	network  string
+	addr     string
+	priority int
+	hostname string
+	procID   string
+
+	mux  sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink creates new SyslogSink
+func NewSyslogSink(rawURL, facility string) (*SyslogSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+
+	s := &SyslogSink{
+		priority: code*8 + syslogSeverityInfo,
+		procID:   strconv.Itoa(os.Getpid()),
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network, s.addr = u.Scheme, u.Host
+	case "unix":
+		s.network, s.addr = "unixgram", u.Path
+	default:
+		return nil, fmt.Errorf("unsupported syslog address %q, expected udp://, tcp:// or unix://", rawURL)
+	}
+
+	if s.hostname, err = os.Hostname(); err != nil {
+		s.hostname = "-"
+	}
+
+	if err = s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write sends event to syslog, stream connections are re-established once on failure
+func (s *SyslogSink) Write(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %s %s - %s",
+		s.priority, e.Time.Format(syslogTimestampFormat), s.hostname, syslogAppName, s.procID, e.APIName, body)
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn != nil {
+		if _, err = s.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	if err = s.connect(); err != nil {
+		return err
+	}
+	_, err = s.conn.Write([]byte(msg))
+
+	return err
+}
+
+// Close closes connection to syslog
+func (s *SyslogSink) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *SyslogSink) connect() (err error) {
+	s.conn, err = net.DialTimeout(s.network, s.addr, syslogDialTimeout)
+	return err
+}
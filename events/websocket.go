@@ -0,0 +1,43 @@
+package events
+
+import (
+	"log"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketHandler returns handler which pushes events of the broadcaster as JSON messages
+// to websocket clients
+func (b *Broadcaster) WebSocketHandler() http.Handler {
+	return websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+
+		ch := b.Subscribe()
+		defer b.Unsubscribe(ch)
+
+		// clients don't send anything, reading is needed to detect closed connection
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var msg []byte
+			for websocket.Message.Receive(conn, &msg) == nil {
+			}
+		}()
+
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := websocket.JSON.Send(conn, e); err != nil {
+					log.Printf("could not send event to websocket client %s: %s\n", conn.Request().RemoteAddr, err)
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+}
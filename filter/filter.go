@@ -0,0 +1,233 @@
+// Package filter implements kafka-aware expressions selecting decoded requests, e.g.
+// topic =~ "payments.*" && apikey == produce && clientid != "mirror-maker"
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+)
+
+// Request is a decoded request matched by expression
+type Request struct {
+	APIKey     int16
+	APIVersion int16
+	ClientID   string
+	ClientIP   string
+	Principal  string
+	Connection string
+	Group      string
+	Topics     []string
+	Size       int
+}
+
+// fields of requests, topic is a multi-valued field
+const (
+	fieldTopic      = "topic"
+	fieldAPIKey     = "apikey"
+	fieldVersion    = "version"
+	fieldClientID   = "clientid"
+	fieldClient     = "client"
+	fieldPrincipal  = "principal"
+	fieldConnection = "connection"
+	fieldGroup      = "group"
+	fieldSize       = "size"
+)
+
+// numericFields are compared as numbers by <, <=, > and >=
+var numericFields = map[string]bool{fieldAPIKey: true, fieldVersion: true, fieldSize: true}
+
+// Expr is a parsed expression, nil Expr matches all requests
+type Expr struct {
+	source string
+	root   node
+}
+
+// Match returns true if request matches the expression or e is nil
+func (e *Expr) Match(r *Request) bool {
+	if e == nil {
+		return true
+	}
+	return e.root.match(r)
+}
+
+// String returns source of the expression
+func (e *Expr) String() string {
+	if e == nil {
+		return ""
+	}
+	return e.source
+}
+
+// node is a node of expression tree
+type node interface {
+	match(r *Request) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) match(r *Request) bool { return n.left.match(r) && n.right.match(r) }
+
+type orNode struct{ left, right node }
+
+func (n *orNode) match(r *Request) bool { return n.left.match(r) || n.right.match(r) }
+
+type notNode struct{ operand node }
+
+func (n *notNode) match(r *Request) bool { return !n.operand.match(r) }
+
+// comparison compares field of request with value. Negative operators (!= and !~) of topic are true if none of
+// topics equals or matches the value.
+type comparison struct {
+	field string
+	op    string
+	value string
+
+	// number is the value of numeric comparison, apikey is compared with key of api name too
+	number int64
+	re     *regexp.Regexp
+}
+
+func (c *comparison) match(r *Request) bool {
+	switch c.op {
+	case "!=":
+		return !c.equals(r)
+	case "!~":
+		return !c.matchesRegexp(r)
+	case "==":
+		return c.equals(r)
+	case "=~":
+		return c.matchesRegexp(r)
+	default:
+		n, ok := c.numeric(r)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case "<":
+			return n < c.number
+		case "<=":
+			return n <= c.number
+		case ">":
+			return n > c.number
+		default:
+			return n >= c.number
+		}
+	}
+}
+
+func (c *comparison) equals(r *Request) bool {
+	switch c.field {
+	case fieldTopic:
+		for _, topic := range r.Topics {
+			if topic == c.value {
+				return true
+			}
+		}
+		return false
+	case fieldAPIKey, fieldVersion, fieldSize:
+		n, _ := c.numeric(r)
+		return n == c.number
+	default:
+		return c.text(r) == c.value
+	}
+}
+
+func (c *comparison) matchesRegexp(r *Request) bool {
+	if c.field == fieldTopic {
+		for _, topic := range r.Topics {
+			if c.re.MatchString(topic) {
+				return true
+			}
+		}
+		return false
+	}
+	if c.field == fieldAPIKey {
+		return c.re.MatchString(kafka.APIKeyName(r.APIKey))
+	}
+	return c.re.MatchString(c.text(r))
+}
+
+// numeric returns value of numeric field
+func (c *comparison) numeric(r *Request) (int64, bool) {
+	switch c.field {
+	case fieldAPIKey:
+		return int64(r.APIKey), true
+	case fieldVersion:
+		return int64(r.APIVersion), true
+	case fieldSize:
+		return int64(r.Size), true
+	default:
+		return 0, false
+	}
+}
+
+// text returns value of string field
+func (c *comparison) text(r *Request) string {
+	switch c.field {
+	case fieldClientID:
+		return r.ClientID
+	case fieldClient:
+		return r.ClientIP
+	case fieldPrincipal:
+		return r.Principal
+	case fieldConnection:
+		return r.Connection
+	case fieldGroup:
+		return r.Group
+	case fieldVersion, fieldSize:
+		n, _ := c.numeric(r)
+		return strconv.FormatInt(n, 10)
+	default:
+		return ""
+	}
+}
+
+// newComparison validates operator and value of field, pos is a position of the value in expression
+func newComparison(field, op, value string, pos int) (*comparison, error) {
+	c := &comparison{field: field, op: op, value: value}
+
+	switch op {
+	case "=~", "!~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, &Error{Pos: pos, Message: "invalid regular expression " + strconv.Quote(value) + ": " + err.Error()}
+		}
+		c.re = re
+		return c, nil
+	case "<", "<=", ">", ">=":
+		if !numericFields[field] {
+			return nil, &Error{Pos: pos, Message: "operator " + op + " isn't supported by field " + field}
+		}
+	}
+
+	if numericFields[field] {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil && field == fieldAPIKey {
+			// api keys are set by names, e.g. apikey == produce
+			key, ok := kafka.APIKeyByName(value)
+			if !ok {
+				return nil, &Error{Pos: pos, Message: "unknown api key " + strconv.Quote(value)}
+			}
+			n, err = int64(key), nil
+		}
+		if err != nil {
+			return nil, &Error{Pos: pos, Message: "field " + field + " must be compared with number, got " + strconv.Quote(value)}
+		}
+		c.number = n
+	}
+
+	return c, nil
+}
+
+// isField returns true if name is a field of requests
+func isField(name string) bool {
+	switch strings.ToLower(name) {
+	case fieldTopic, fieldAPIKey, fieldVersion, fieldClientID, fieldClient, fieldPrincipal, fieldConnection, fieldGroup, fieldSize:
+		return true
+	default:
+		return false
+	}
+}
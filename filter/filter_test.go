@@ -0,0 +1,75 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sampleRequests are requests of a producer, a consumer, a member of consumer group and a follower replica
+var sampleRequests = []struct {
+	name string
+	req  *Request
+}{
+	{"produce", &Request{APIKey: 0, APIVersion: 9, ClientID: "payments-service", ClientIP: "10.0.0.1", Principal: "alice",
+		Connection: "client", Topics: []string{"payments", "payments.v2"}, Size: 1200}},
+	{"fetch", &Request{APIKey: 1, APIVersion: 11, ClientID: "mirror-maker", ClientIP: "10.0.0.2",
+		Connection: "client", Topics: []string{"orders"}, Size: 100}},
+	{"join", &Request{APIKey: 11, APIVersion: 5, ClientID: "billing", ClientIP: "10.0.0.3",
+		Connection: "client", Group: "billing-consumers", Size: 300}},
+	{"replication", &Request{APIKey: 1, APIVersion: 12, ClientID: "broker-1", ClientIP: "10.0.0.100",
+		Connection: "replication", Topics: []string{"orders", "payments"}, Size: 5000}},
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		want []string
+	}{
+		// any topic of request equals or matches the value, none of them for negative operators
+		{`topic == payments`, []string{"produce", "replication"}},
+		{`topic != payments`, []string{"fetch", "join"}},
+		{`topic =~ "^pay"`, []string{"produce", "replication"}},
+		{`topic !~ "^pay"`, []string{"fetch", "join"}},
+		{`topic =~ "v2"`, []string{"produce"}},
+
+		// api keys are compared by names and numbers, regular expressions match names
+		{`apikey == produce`, []string{"produce"}},
+		{`apikey == FETCH && connection != replication`, []string{"fetch"}},
+		{`apikey == 1`, []string{"fetch", "replication"}},
+		{`apikey != fetch`, []string{"produce", "join"}},
+		{`apikey =~ "^Join"`, []string{"join"}},
+		{`apikey < 2`, []string{"produce", "fetch", "replication"}},
+
+		{`version >= 11`, []string{"fetch", "replication"}},
+		{`version =~ "^1"`, []string{"fetch", "replication"}},
+		{`size > 1000 && size <= 5000`, []string{"produce", "replication"}},
+		{`size == 300`, []string{"join"}},
+		{`clientid == "mirror-maker" || group == "billing-consumers"`, []string{"fetch", "join"}},
+		{`client =~ "^10\\.0\\.0\\.[12]$"`, []string{"produce", "fetch"}},
+		{`principal == alice`, []string{"produce"}},
+		{`principal == ""`, []string{"fetch", "join", "replication"}},
+		{`group != ""`, []string{"join"}},
+
+		{`!(topic == orders) && !(apikey == joingroup)`, []string{"produce"}},
+		{`topic == orders || topic == payments && apikey == produce`, []string{"produce", "fetch", "replication"}},
+		{`(topic == orders || topic == payments) && apikey == produce`, []string{"produce"}},
+		{`!topic == orders`, []string{"produce", "join"}},
+		{`topic == missing`, nil},
+	} {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%q: %s", c.expr, err)
+			continue
+		}
+
+		var got []string
+		for _, s := range sampleRequests {
+			if e.Match(s.req) {
+				got = append(got, s.name)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q matches %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
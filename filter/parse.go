@@ -0,0 +1,240 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Error is an error of parsing of expression
+type Error struct {
+	Pos     int
+	Message string
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return fmt.Sprintf("filter: %s at position %d", e.Message, e.Pos+1)
+}
+
+// token kinds
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind  int
+	text  string
+	pos   int
+	value string
+}
+
+// comparisonOps are operators of comparisons, two-char operators go first
+var comparisonOps = []string{"==", "!=", "=~", "!~", "<=", ">=", "<", ">"}
+
+// lex splits expression into tokens
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, text: "&&", pos: i})
+			i += 2
+		case strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, token{kind: tokenOr, text: "||", pos: i})
+			i += 2
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, &Error{Pos: i, Message: "unterminated string"}
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, &Error{Pos: i, Message: "invalid string " + s[i:end+1]}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s[i : end+1], pos: i, value: value})
+			i = end + 1
+		case c == '!' && !strings.HasPrefix(s[i:], "!=") && !strings.HasPrefix(s[i:], "!~"):
+			tokens = append(tokens, token{kind: tokenNot, text: "!", pos: i})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			op := ""
+			for _, candidate := range comparisonOps {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &Error{Pos: i, Message: "unknown operator " + string(c)}
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		case isWordChar(c):
+			end := i
+			for end < len(s) && isWordChar(rune(s[end])) {
+				end++
+			}
+			word := s[i:end]
+			kind := tokenIdent
+			if _, err := strconv.ParseInt(word, 10, 64); err == nil {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, token{kind: kind, text: word, pos: i, value: word})
+			i = end
+		default:
+			return nil, &Error{Pos: i, Message: "unexpected character " + strconv.QuoteRune(c)}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, text: "end of expression", pos: len(s)}), nil
+}
+
+// isWordChar returns true for characters of identifiers and bare values, e.g. produce, 10.0.0.1 or my-client
+func isWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_-.:/*", c)
+}
+
+// parser is a recursive descent parser of grammar:
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expr ")" | comparison
+//	comparison = field op value
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses expression, empty expression is nil Expr matching all requests
+func Parse(s string) (*Expr, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &Error{Pos: t.pos, Message: "unexpected " + t.text}
+	}
+
+	return &Expr{source: s, root: root}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	switch t := p.next(); t.kind {
+	case tokenNot:
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	case tokenLParen:
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, &Error{Pos: t.pos, Message: "expected ) instead of " + t.text}
+		}
+		return n, nil
+	case tokenIdent:
+		return p.comparison(t)
+	default:
+		return nil, &Error{Pos: t.pos, Message: "expected field instead of " + t.text}
+	}
+}
+
+func (p *parser) comparison(field token) (node, error) {
+	if !isField(field.text) {
+		return nil, &Error{Pos: field.pos, Message: "unknown field " + field.text + ", expected one of: topic, apikey, version, clientid, client, principal, connection, group, size"}
+	}
+
+	op := p.next()
+	if op.kind != tokenOp {
+		return nil, &Error{Pos: op.pos, Message: "expected operator instead of " + op.text}
+	}
+
+	value := p.next()
+	if value.kind != tokenString && value.kind != tokenIdent && value.kind != tokenNumber {
+		return nil, &Error{Pos: value.pos, Message: "expected value instead of " + value.text}
+	}
+
+	return newComparison(strings.ToLower(field.text), op.text, value.value, value.pos)
+}
@@ -0,0 +1,134 @@
+package filter
+
+import (
+	"strconv"
+	"testing"
+)
+
+// format renders expression tree with parentheses around every binary node
+func format(n node) string {
+	switch n := n.(type) {
+	case *andNode:
+		return "(" + format(n.left) + " && " + format(n.right) + ")"
+	case *orNode:
+		return "(" + format(n.left) + " || " + format(n.right) + ")"
+	case *notNode:
+		return "!" + format(n.operand)
+	case *comparison:
+		return n.field + n.op + strconv.Quote(n.value)
+	default:
+		return "?"
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		want string
+	}{
+		{`topic == a`, `topic=="a"`},
+		{`topic == a || topic == b && apikey == produce`, `(topic=="a" || (topic=="b" && apikey=="produce"))`},
+		{`topic == a && topic == b || apikey == produce`, `((topic=="a" && topic=="b") || apikey=="produce")`},
+		{`(topic == a || topic == b) && apikey == produce`, `((topic=="a" || topic=="b") && apikey=="produce")`},
+		{`topic == a || topic == b || topic == c`, `((topic=="a" || topic=="b") || topic=="c")`},
+		{`topic == a && topic == b && topic == c`, `((topic=="a" && topic=="b") && topic=="c")`},
+		{`!topic == a && topic == b`, `(!topic=="a" && topic=="b")`},
+		{`!(topic == a && topic == b)`, `!(topic=="a" && topic=="b")`},
+		{`!!topic == a`, `!!topic=="a"`},
+		{`((topic == a))`, `topic=="a"`},
+		{`apikey==produce&&version>=3||size<10`, `((apikey=="produce" && version>="3") || size<"10")`},
+		{`Topic == a && ClientID != b`, `(topic=="a" && clientid!="b")`},
+		{"\ttopic  ==\na ", `topic=="a"`},
+	} {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%q: %s", c.expr, err)
+			continue
+		}
+		if got := format(e.root); got != c.want {
+			t.Errorf("%q is parsed as %s, want %s", c.expr, got, c.want)
+		}
+		if e.String() != c.expr {
+			t.Errorf("%q is rendered as %q", c.expr, e.String())
+		}
+	}
+}
+
+func TestParseQuoting(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		want string
+	}{
+		{`clientid == "mirror-maker"`, `mirror-maker`},
+		{`clientid == "say \"hi\""`, `say "hi"`},
+		{`clientid == "tab\tand\\backslash"`, "tab\tand\\backslash"},
+		{`topic == "a && b || (c)"`, `a && b || (c)`},
+		{`topic == "with space"`, `with space`},
+		{`principal == ""`, ``},
+		{`clientid == "ünïcode"`, `ünïcode`},
+		{`client == 10.0.0.1`, `10.0.0.1`},
+		{`client == 2001:db8::/64`, `2001:db8::/64`},
+		{`topic == payments.v2-eu_1`, `payments.v2-eu_1`},
+		{`client =~ "^10\\.0\\."`, `^10\.0\.`},
+		{`version == -1`, `-1`},
+	} {
+		e, err := Parse(c.expr)
+		if err != nil {
+			t.Errorf("%q: %s", c.expr, err)
+			continue
+		}
+		if got := e.root.(*comparison).value; got != c.want {
+			t.Errorf("value of %q is %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		err  string
+	}{
+		{`topic == "abc`, `filter: unterminated string at position 10`},
+		{`topic == "abc\"`, `filter: unterminated string at position 10`},
+		{`topic == "\q"`, `filter: invalid string "\q" at position 10`},
+		{`topic = a`, `filter: unknown operator = at position 7`},
+		{`topic == a # b`, `filter: unexpected character '#' at position 12`},
+		{`topic == a &&`, `filter: expected field instead of end of expression at position 14`},
+		{`&& topic == a`, `filter: expected field instead of && at position 1`},
+		{`!`, `filter: expected field instead of end of expression at position 2`},
+		{`(topic == a`, `filter: expected ) instead of end of expression at position 12`},
+		{`topic == a)`, `filter: unexpected ) at position 11`},
+		{`topic == a b`, `filter: unexpected b at position 12`},
+		{`()`, `filter: expected field instead of ) at position 2`},
+		{`foo == a`, `filter: unknown field foo, expected one of: topic, apikey, version, clientid, client, principal, connection, group, size at position 1`},
+		{`topic a`, `filter: expected operator instead of a at position 7`},
+		{`topic ==`, `filter: expected value instead of end of expression at position 9`},
+		{`topic == (`, `filter: expected value instead of ( at position 10`},
+		{`topic < 3`, `filter: operator < isn't supported by field topic at position 9`},
+		{`size == big`, `filter: field size must be compared with number, got "big" at position 9`},
+		{`version >= "3a"`, `filter: field version must be compared with number, got "3a" at position 12`},
+		{`apikey == nope`, `filter: unknown api key "nope" at position 11`},
+		{`topic =~ "("`, "filter: invalid regular expression \"(\": error parsing regexp: missing closing ): `(` at position 10"},
+	} {
+		e, err := Parse(c.expr)
+		if err == nil {
+			t.Errorf("%q is parsed as %s", c.expr, format(e.root))
+			continue
+		}
+		if _, ok := err.(*Error); !ok || err.Error() != c.err {
+			t.Errorf("%q: error is %v, want %s", c.expr, err, c.err)
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, expr := range []string{"", " \t\n"} {
+		e, err := Parse(expr)
+		if err != nil || e != nil {
+			t.Errorf("%q is parsed as %v, %v, want nil expression", expr, e, err)
+		}
+		if !e.Match(&Request{}) || e.String() != "" {
+			t.Errorf("nil expression doesn't match all requests")
+		}
+	}
+}
@@ -4,14 +4,22 @@ go 1.13
 
 require (
 	github.com/Shopify/sarama v1.26.3
+	github.com/cilium/ebpf v0.5.0
 	github.com/eapache/go-xerial-snappy v0.0.0-20180814174437-776d5712da21
 	github.com/golang/protobuf v1.4.2 // indirect
+	github.com/golang/snappy v0.0.1
 	github.com/google/gopacket v1.1.17
-	github.com/klauspost/compress v1.9.8
+	github.com/klauspost/compress v1.10.5
+	github.com/nats-io/nats.go v1.11.0
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/pierrec/lz4 v2.4.1+incompatible
-	github.com/pkg/errors v0.8.1
-	github.com/prometheus/client_golang v1.6.0
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/rcrowley/go-metrics v0.0.0-20190826022208-cac0b30c2563
-	golang.org/x/net v0.0.0-20200513185701-a91f0712d120 // indirect
+	github.com/xitongsys/parquet-go v1.5.4
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c
 	google.golang.org/protobuf v1.23.0
 )
@@ -0,0 +1,143 @@
+package kafka
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APIKey is an api key known by sniffer. Bodies of requests of decoded keys are decoded, requests of other keys
+// are read by header only.
+type APIKey struct {
+	Key     int16  `json:"key"`
+	Name    string `json:"name"`
+	Decoded bool   `json:"decoded"`
+
+	// Versions is a range of versions decoder of requests is written for, it's nil for keys which requests aren't
+	// decoded. Requests of other versions are decoded too, they usually fail with decoding errors.
+	Versions *VersionRange `json:"versions,omitempty"`
+
+	// newBody allocates body of request of version, it's nil for keys which requests aren't decoded
+	newBody func(version int16) ProtocolBody
+}
+
+// VersionRange is a range of api versions, both versions are included
+type VersionRange struct {
+	Min int16 `json:"min"`
+	Max int16 `json:"max"`
+}
+
+// Contains returns true if version is in the range
+func (r *VersionRange) Contains(version int16) bool {
+	return version >= r.Min && version <= r.Max
+}
+
+// APIKeys are kafka api keys known by sniffer sorted by key, see https://kafka.apache.org/protocol#protocol_api_keys.
+// Requests of unknown keys aren't recognized by request header detection.
+var APIKeys = []APIKey{
+	{Key: 0, Name: "Produce", Decoded: true, Versions: &VersionRange{Min: 0, Max: 8}, newBody: func(int16) ProtocolBody { return &ProduceRequest{} }},
+	{Key: 1, Name: "Fetch", Decoded: true, Versions: &VersionRange{Min: 0, Max: 11}, newBody: func(v int16) ProtocolBody { return &FetchRequest{Version: v} }},
+	{Key: 2, Name: "ListOffsets"},
+	{Key: 3, Name: "Metadata"},
+	{Key: 4, Name: "LeaderAndIsr"},
+	{Key: 5, Name: "StopReplica"},
+	{Key: 6, Name: "UpdateMetadata"},
+	{Key: 7, Name: "ControlledShutdown"},
+	{Key: 8, Name: "OffsetCommit"},
+	{Key: 9, Name: "OffsetFetch"},
+	{Key: 10, Name: "FindCoordinator"},
+	{Key: 11, Name: "JoinGroup", Decoded: true, Versions: &VersionRange{Min: 0, Max: 9}, newBody: func(v int16) ProtocolBody { return &JoinGroupRequest{Version: v} }},
+	{Key: 12, Name: "Heartbeat"},
+	{Key: 13, Name: "LeaveGroup"},
+	{Key: 14, Name: "SyncGroup", Decoded: true, Versions: &VersionRange{Min: 0, Max: 5}, newBody: func(v int16) ProtocolBody { return &SyncGroupRequest{Version: v} }},
+	{Key: 15, Name: "DescribeGroups"},
+	{Key: 16, Name: "ListGroups"},
+	{Key: 17, Name: "SaslHandshake", Decoded: true, Versions: &VersionRange{Min: 0, Max: 1}, newBody: func(v int16) ProtocolBody { return &SaslHandshakeRequest{Version: v} }},
+	{Key: 18, Name: "ApiVersions"},
+	{Key: 19, Name: "CreateTopics"},
+	{Key: 20, Name: "DeleteTopics"},
+	{Key: 21, Name: "DeleteRecords"},
+	{Key: 22, Name: "InitProducerId"},
+	{Key: 23, Name: "OffsetForLeaderEpoch"},
+	{Key: 24, Name: "AddPartitionsToTxn"},
+	{Key: 25, Name: "AddOffsetsToTxn"},
+	{Key: 26, Name: "EndTxn"},
+	{Key: 27, Name: "WriteTxnMarkers", Decoded: true, Versions: &VersionRange{Min: 0, Max: 1}, newBody: func(v int16) ProtocolBody { return &WriteTxnMarkersRequest{Version: v} }},
+	{Key: 28, Name: "TxnOffsetCommit"},
+	{Key: 29, Name: "DescribeAcls"},
+	{Key: 30, Name: "CreateAcls"},
+	{Key: 31, Name: "DeleteAcls"},
+	{Key: 32, Name: "DescribeConfigs"},
+	{Key: 33, Name: "AlterConfigs"},
+	{Key: 34, Name: "AlterReplicaLogDirs"},
+	{Key: 35, Name: "DescribeLogDirs"},
+	{Key: 36, Name: "SaslAuthenticate", Decoded: true, Versions: &VersionRange{Min: 0, Max: 2}, newBody: func(v int16) ProtocolBody { return &SaslAuthenticateRequest{Version: v} }},
+	{Key: 37, Name: "CreatePartitions"},
+	{Key: 38, Name: "CreateDelegationToken"},
+	{Key: 39, Name: "RenewDelegationToken"},
+	{Key: 40, Name: "ExpireDelegationToken"},
+	{Key: 41, Name: "DescribeDelegationToken"},
+	{Key: 42, Name: "DeleteGroups"},
+	{Key: 43, Name: "ElectLeaders"},
+	{Key: 44, Name: "IncrementalAlterConfigs"},
+	{Key: 45, Name: "AlterPartitionReassignments"},
+	{Key: 46, Name: "ListPartitionReassignments"},
+	{Key: 47, Name: "OffsetDelete"},
+	{Key: 48, Name: "DescribeClientQuotas"},
+	{Key: 49, Name: "AlterClientQuotas"},
+	{Key: 50, Name: "DescribeUserScramCredentials"},
+	{Key: 51, Name: "AlterUserScramCredentials"},
+	{Key: 52, Name: "Vote"},
+	{Key: 53, Name: "BeginQuorumEpoch"},
+	{Key: 54, Name: "EndQuorumEpoch"},
+	{Key: 55, Name: "DescribeQuorum"},
+	{Key: 56, Name: "AlterPartition"},
+	{Key: 57, Name: "UpdateFeatures"},
+	{Key: 58, Name: "Envelope"},
+	{Key: 59, Name: "FetchSnapshot"},
+	{Key: 60, Name: "DescribeCluster"},
+	{Key: 61, Name: "DescribeProducers"},
+	{Key: 62, Name: "BrokerRegistration"},
+	{Key: 63, Name: "BrokerHeartbeat"},
+	{Key: 64, Name: "UnregisterBroker"},
+	{Key: 65, Name: "DescribeTransactions"},
+	{Key: 66, Name: "ListTransactions"},
+	{Key: 67, Name: "AllocateProducerIds"},
+	{Key: 68, Name: "ConsumerGroupHeartbeat"},
+}
+
+// apiKeysByKey indexes APIKeys by key
+var apiKeysByKey = indexAPIKeys(APIKeys)
+
+func indexAPIKeys(keys []APIKey) map[int16]*APIKey {
+	index := make(map[int16]*APIKey, len(keys))
+	for i := range keys {
+		index[keys[i].Key] = &keys[i]
+	}
+	return index
+}
+
+// LookupAPIKey returns known kafka api key by its number
+func LookupAPIKey(key int16) (APIKey, bool) {
+	if k, ok := apiKeysByKey[key]; ok {
+		return *k, true
+	}
+	return APIKey{}, false
+}
+
+// APIKeyName returns name of kafka api key
+func APIKeyName(key int16) string {
+	if k, ok := apiKeysByKey[key]; ok {
+		return k.Name
+	}
+	return fmt.Sprintf("Unknown(%d)", key)
+}
+
+// APIKeyByName returns kafka api key by its name, names are case insensitive
+func APIKeyByName(name string) (int16, bool) {
+	for _, k := range APIKeys {
+		if strings.EqualFold(k.Name, name) {
+			return k.Key, true
+		}
+	}
+	return 0, false
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"unsafe"
 )
 
 // PacketDecodingError is returned when there was an error (other than truncated data) decoding the Kafka broker's response.
@@ -26,6 +27,7 @@ var errInvalidArrayLength = PacketDecodingError{"invalid array length"}
 var errInvalidByteSliceLength = PacketDecodingError{"invalid byteslice length"}
 var errInvalidStringLength = PacketDecodingError{"invalid string length"}
 var errVarintOverflow = PacketDecodingError{"varint overflow"}
+var errUVarintOverflow = PacketDecodingError{"uvarint overflow"}
 var errInvalidBool = PacketDecodingError{"invalid bool"}
 
 // PacketDecoder is the interface providing helpers for reading with Kafka's encoding rules.
@@ -38,15 +40,22 @@ type PacketDecoder interface {
 	getInt32() (int32, error)
 	getInt64() (int64, error)
 	getVarint() (int64, error)
+	getUVarint() (uint64, error)
 	getArrayLength() (int, error)
+	getCompactArrayLength() (int, error)
 	getBool() (bool, error)
+	getTaggedFieldArray() error
 
 	// Collections
 	getBytes() ([]byte, error)
 	getVarintBytes() ([]byte, error)
 	getRawBytes(length int) ([]byte, error)
+	getCompactBytes() ([]byte, error)
 	getString() (string, error)
+	getInternedString() (string, error)
 	getNullableString() (*string, error)
+	getCompactString() (string, error)
+	getCompactNullableString() (*string, error)
 	getInt32Array() ([]int32, error)
 	getInt64Array() ([]int64, error)
 	getStringArray() ([]string, error)
@@ -104,11 +113,16 @@ type versionedDecoder interface {
 // Decode takes bytes and a Decoder and fills the fields of the decoder from the bytes,
 // interpreted using Kafka's encoding rules.
 func Decode(buf []byte, in decoder) error {
+	return decode(buf, in, false)
+}
+
+// decode decodes buf, strings of zero copy decoder refer to buf instead of being copied
+func decode(buf []byte, in decoder, zeroCopy bool) error {
 	if buf == nil {
 		return nil
 	}
 
-	helper := RealDecoder{raw: buf}
+	helper := RealDecoder{raw: buf, zeroCopy: zeroCopy}
 	err := in.Decode(&helper)
 	if err != nil {
 		return err
@@ -126,6 +140,10 @@ type RealDecoder struct {
 	raw   []byte
 	off   int
 	stack []PushDecoder
+
+	// zeroCopy makes strings refer to raw instead of being copied, they are valid while raw isn't reused.
+	// Bytes always refer to raw.
+	zeroCopy bool
 }
 
 // primitives
@@ -184,6 +202,20 @@ func (rd *RealDecoder) getVarint() (int64, error) {
 	return tmp, nil
 }
 
+func (rd *RealDecoder) getUVarint() (uint64, error) {
+	tmp, n := binary.Uvarint(rd.raw[rd.off:])
+	if n == 0 {
+		rd.off = len(rd.raw)
+		return 0, ErrInsufficientData
+	}
+	if n < 0 {
+		rd.off -= n
+		return 0, errUVarintOverflow
+	}
+	rd.off += n
+	return tmp, nil
+}
+
 func (rd *RealDecoder) getArrayLength() (int, error) {
 	if rd.remaining() < 4 {
 		rd.off = len(rd.raw)
@@ -194,12 +226,33 @@ func (rd *RealDecoder) getArrayLength() (int, error) {
 	if tmp > rd.remaining() {
 		rd.off = len(rd.raw)
 		return -1, ErrInsufficientData
-	} else if tmp > 2*math.MaxUint16 {
+	} else if tmp > 2*math.MaxUint16 || tmp < -1 {
 		return -1, errInvalidArrayLength
 	}
 	return tmp, nil
 }
 
+// getCompactArrayLength decodes the length of a compact array used by flexible versions,
+// the length is encoded as unsigned varint N+1 and zero stands for a null array
+func (rd *RealDecoder) getCompactArrayLength() (int, error) {
+	n, err := rd.getUVarint()
+	if err != nil {
+		return -1, err
+	}
+	if n == 0 {
+		return -1, nil
+	}
+
+	// length is compared before conversion, huge lengths would overflow int
+	if n-1 > uint64(rd.remaining()) {
+		rd.off = len(rd.raw)
+		return -1, ErrInsufficientData
+	} else if n-1 > 2*math.MaxUint16 {
+		return -1, errInvalidArrayLength
+	}
+	return int(n - 1), nil
+}
+
 func (rd *RealDecoder) getBool() (bool, error) {
 	b, err := rd.getInt8()
 	if err != nil || b == 0 {
@@ -211,6 +264,25 @@ func (rd *RealDecoder) getBool() (bool, error) {
 	return true, nil
 }
 
+// getTaggedFieldArray skips the tagged fields of flexible versions. We don't need any
+// of the currently defined tagged fields, so their values are discarded.
+func (rd *RealDecoder) getTaggedFieldArray() error {
+	tagCount, err := rd.getUVarint()
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < tagCount; i++ {
+		if _, err := rd.getUVarint(); err != nil { // tag
+			return err
+		}
+		if _, err := rd.getUVarintBytes(); err != nil { // size + data
+			return err
+		}
+	}
+	return nil
+}
+
 // collections
 
 func (rd *RealDecoder) getBytes() ([]byte, error) {
@@ -237,6 +309,33 @@ func (rd *RealDecoder) getVarintBytes() ([]byte, error) {
 	return rd.getRawBytes(int(tmp))
 }
 
+func (rd *RealDecoder) getCompactBytes() ([]byte, error) {
+	n, err := rd.getUVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	return rd.getRawBytes(int(n - 1))
+}
+
+// getUVarintBytes reads unsigned varint length and the following bytes,
+// unlike compact bytes the length isn't shifted by one
+func (rd *RealDecoder) getUVarintBytes() ([]byte, error) {
+	n, err := rd.getUVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(rd.remaining()) {
+		rd.off = len(rd.raw)
+		return nil, ErrInsufficientData
+	}
+
+	return rd.getRawBytes(int(n))
+}
+
 func (rd *RealDecoder) getStringLength() (int, error) {
 	length, err := rd.getInt16()
 	if err != nil {
@@ -256,13 +355,34 @@ func (rd *RealDecoder) getStringLength() (int, error) {
 	return n, nil
 }
 
+// str returns string of bytes, it refers to the bytes in zero copy mode
+func (rd *RealDecoder) str(b []byte) string {
+	if rd.zeroCopy && len(b) > 0 {
+		return *(*string)(unsafe.Pointer(&b))
+	}
+	return string(b)
+}
+
 func (rd *RealDecoder) getString() (string, error) {
 	n, err := rd.getStringLength()
 	if err != nil || n == -1 {
 		return "", err
 	}
 
-	tmpStr := string(rd.raw[rd.off : rd.off+n])
+	tmpStr := rd.str(rd.raw[rd.off : rd.off+n])
+	rd.off += n
+	return tmpStr, nil
+}
+
+// getInternedString decodes string of repeated values like topics and client ids, equal strings of different
+// requests share memory
+func (rd *RealDecoder) getInternedString() (string, error) {
+	n, err := rd.getStringLength()
+	if err != nil || n == -1 {
+		return "", err
+	}
+
+	tmpStr := intern(rd.raw[rd.off : rd.off+n])
 	rd.off += n
 	return tmpStr, nil
 }
@@ -273,11 +393,47 @@ func (rd *RealDecoder) getNullableString() (*string, error) {
 		return nil, err
 	}
 
-	tmpStr := string(rd.raw[rd.off : rd.off+n])
+	tmpStr := rd.str(rd.raw[rd.off : rd.off+n])
 	rd.off += n
 	return &tmpStr, err
 }
 
+func (rd *RealDecoder) getCompactStringLength() (int, error) {
+	length, err := rd.getUVarint()
+	if err != nil {
+		return 0, err
+	}
+
+	if length > uint64(rd.remaining())+1 {
+		rd.off = len(rd.raw)
+		return 0, ErrInsufficientData
+	}
+
+	return int(length) - 1, nil
+}
+
+func (rd *RealDecoder) getCompactString() (string, error) {
+	n, err := rd.getCompactStringLength()
+	if err != nil || n == -1 {
+		return "", err
+	}
+
+	tmpStr := rd.str(rd.raw[rd.off : rd.off+n])
+	rd.off += n
+	return tmpStr, nil
+}
+
+func (rd *RealDecoder) getCompactNullableString() (*string, error) {
+	n, err := rd.getCompactStringLength()
+	if err != nil || n == -1 {
+		return nil, err
+	}
+
+	tmpStr := rd.str(rd.raw[rd.off : rd.off+n])
+	rd.off += n
+	return &tmpStr, nil
+}
+
 func (rd *RealDecoder) getInt32Array() ([]int32, error) {
 	if rd.remaining() < 4 {
 		rd.off = len(rd.raw)
@@ -344,6 +500,12 @@ func (rd *RealDecoder) getStringArray() ([]string, error) {
 	n := int(binary.BigEndian.Uint32(rd.raw[rd.off:]))
 	rd.off += 4
 
+	// every string has at least 2 bytes of length, so huge counts aren't allocated
+	if rd.remaining() < 2*n {
+		rd.off = len(rd.raw)
+		return nil, ErrInsufficientData
+	}
+
 	if n == 0 {
 		return nil, nil
 	}
@@ -375,7 +537,7 @@ func (rd *RealDecoder) getSubset(length int) (PacketDecoder, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &RealDecoder{raw: buf}, nil
+	return &RealDecoder{raw: buf, zeroCopy: rd.zeroCopy}, nil
 }
 
 func (rd *RealDecoder) getRawBytes(length int) ([]byte, error) {
@@ -396,7 +558,7 @@ func (rd *RealDecoder) peek(offset, length int) (PacketDecoder, error) {
 		return nil, ErrInsufficientData
 	}
 	off := rd.off + offset
-	return &RealDecoder{raw: rd.raw[off : off+length]}, nil
+	return &RealDecoder{raw: rd.raw[off : off+length], zeroCopy: rd.zeroCopy}, nil
 }
 
 func (rd *RealDecoder) peekInt8(offset int) (int8, error) {
@@ -443,3 +605,27 @@ func (rd *RealDecoder) pop() error {
 func (rd *RealDecoder) discard(length int) {
 	rd.off += length
 }
+
+// getFlexibleString decodes compact string for flexible versions and regular string otherwise
+func getFlexibleString(pd PacketDecoder, flexible bool) (string, error) {
+	if flexible {
+		return pd.getCompactString()
+	}
+	return pd.getString()
+}
+
+// getFlexibleNullableString decodes compact nullable string for flexible versions and regular one otherwise
+func getFlexibleNullableString(pd PacketDecoder, flexible bool) (*string, error) {
+	if flexible {
+		return pd.getCompactNullableString()
+	}
+	return pd.getNullableString()
+}
+
+// getFlexibleArrayLength decodes compact array length for flexible versions and regular one otherwise
+func getFlexibleArrayLength(pd PacketDecoder, flexible bool) (int, error) {
+	if flexible {
+		return pd.getCompactArrayLength()
+	}
+	return pd.getArrayLength()
+}
@@ -3,14 +3,29 @@ package kafka
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"sync"
 
 	snappy "github.com/eapache/go-xerial-snappy"
+	master "github.com/golang/snappy"
 	"github.com/pierrec/lz4"
 )
 
+// MaxDecompressedSize is the maximum size (in bytes) of decompressed records of a record batch or a legacy
+// message set, larger records are considered garbage, e.g. a decompression bomb
+var MaxDecompressedSize = 100 * 1024 * 1024
+
+var errDecompressedSize = PacketDecodingError{"decompressed records exceed max size"}
+
+var (
+	// xerialHeader starts snappy data of xerial framing format, its blocks start at xerialBlocksOffset
+	xerialHeader       = []byte{130, 83, 78, 65, 80, 80, 89, 0}
+	xerialBlocksOffset = 16
+)
+
 var (
 	lz4ReaderPool = sync.Pool{
 		New: func() interface{} {
@@ -22,6 +37,10 @@ var (
 )
 
 func decompress(cc CompressionCodec, data []byte) ([]byte, error) {
+	if StrictPrivacy() {
+		return nil, errStrictPrivacy
+	}
+
 	switch cc {
 	case CompressionNone:
 		return data, nil
@@ -46,18 +65,69 @@ func decompress(cc CompressionCodec, data []byte) ([]byte, error) {
 			return nil, err
 		}
 
-		return ioutil.ReadAll(reader)
+		return readDecompressed(reader)
 	case CompressionSnappy:
+		n, err := snappyDecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > MaxDecompressedSize {
+			return nil, errDecompressedSize
+		}
 		return snappy.Decode(data)
 	case CompressionLZ4:
 		reader := lz4ReaderPool.Get().(*lz4.Reader)
 		defer lz4ReaderPool.Put(reader)
 
 		reader.Reset(bytes.NewReader(data))
-		return ioutil.ReadAll(reader)
+		return readDecompressed(reader)
 	case CompressionZSTD:
-		return zstdDecompress(nil, data)
+		decompressed, err := zstdDecompress(nil, data)
+		if err != nil {
+			return nil, err
+		}
+		if len(decompressed) > MaxDecompressedSize {
+			return nil, errDecompressedSize
+		}
+		return decompressed, nil
 	default:
 		return nil, PacketDecodingError{fmt.Sprintf("invalid compression specified (%d)", cc)}
 	}
 }
+
+// readDecompressed reads decompressed data up to MaxDecompressedSize
+func readDecompressed(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, errDecompressedSize
+	}
+	return data, nil
+}
+
+// snappyDecodedLen returns decoded length of snappy data, unframed or of xerial framing format, from headers of
+// its blocks, so memory for decompression bombs isn't allocated. Snappy decoder allocates up to 4 GB by a header.
+func snappyDecodedLen(data []byte) (int, error) {
+	if !bytes.HasPrefix(data, xerialHeader) {
+		return master.DecodedLen(data)
+	}
+
+	total := 0
+	for pos := xerialBlocksOffset; pos+4 <= len(data) && total <= MaxDecompressedSize; {
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		if size < 0 || size > len(data)-pos {
+			return 0, snappy.ErrMalformed
+		}
+
+		n, err := master.DecodedLen(data[pos : pos+size])
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		pos += size
+	}
+	return total, nil
+}
@@ -0,0 +1,124 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+)
+
+const (
+	// requestHeaderMinSize is a size of length, api key, api version, correlation id and client id length
+	requestHeaderMinSize = 14
+
+	// maxDetectedAPIVersion is a max api version considered valid by request header detection
+	maxDetectedAPIVersion = 20
+)
+
+// ParseRequestHeader checks heuristically if payload starts with kafka request header: known api key,
+// sane length, version and correlation id and printable client id. It returns correlation id of the request.
+func ParseRequestHeader(payload []byte) (correlationID int32, ok bool) {
+	if len(payload) < requestHeaderMinSize {
+		return 0, false
+	}
+
+	var (
+		length        = int32(binary.BigEndian.Uint32(payload[0:4]))
+		key           = int16(binary.BigEndian.Uint16(payload[4:6]))
+		version       = int16(binary.BigEndian.Uint16(payload[6:8]))
+		clientIDLen   = int16(binary.BigEndian.Uint16(payload[12:14]))
+		clientIDBytes = payload[requestHeaderMinSize:]
+	)
+	correlationID = int32(binary.BigEndian.Uint32(payload[8:12]))
+
+	if length < requestHeaderMinSize-4 || length > MaxRequestSize {
+		return 0, false
+	}
+	if _, known := apiKeysByKey[key]; !known {
+		return 0, false
+	}
+	if version < 0 || version > maxDetectedAPIVersion || correlationID < 0 {
+		return 0, false
+	}
+
+	// client id is nullable string
+	if clientIDLen < -1 || int32(clientIDLen) > length-(requestHeaderMinSize-4) {
+		return 0, false
+	}
+	if clientIDLen < 0 {
+		clientIDBytes = nil
+	} else if int(clientIDLen) < len(clientIDBytes) {
+		clientIDBytes = clientIDBytes[:clientIDLen]
+	}
+	for _, b := range clientIDBytes {
+		if b < 0x20 || b > 0x7e {
+			return 0, false
+		}
+	}
+
+	return correlationID, true
+}
+
+// ResponseCorrelationID returns correlation id of kafka response starting the payload
+func ResponseCorrelationID(payload []byte) (int32, bool) {
+	if len(payload) < 8 {
+		return 0, false
+	}
+	return int32(binary.BigEndian.Uint32(payload[4:8])), true
+}
+
+// SkipToRequest discards bytes of reader until it starts with plausible request header, e.g. after decoding
+// error or lost bytes. It returns count of discarded bytes.
+func SkipToRequest(r *bufio.Reader) (int, error) {
+	skipped := 0
+	for {
+		// buffered bytes are checked without waiting for more data
+		size := r.Buffered()
+		if size < requestHeaderMinSize {
+			size = requestHeaderMinSize
+		}
+
+		payload, err := r.Peek(size)
+		if len(payload) < requestHeaderMinSize {
+			return skipped, err
+		}
+
+		if _, ok := ParseRequestHeader(payload); ok {
+			return skipped, nil
+		}
+
+		if _, err = r.Discard(1); err != nil {
+			return skipped, err
+		}
+		skipped++
+	}
+}
+
+// SyncToRequest discards bytes of reader until it starts with request header confirmed by the header of the
+// next request, it's used for streams joined in the middle, where a single plausible header is often found in
+// data of a request. Request is accepted unconfirmed if it doesn't fit into buffer of reader or stream ends
+// after it. It returns count of discarded bytes.
+func SyncToRequest(r *bufio.Reader) (int, error) {
+	skipped := 0
+	for {
+		n, err := SkipToRequest(r)
+		skipped += n
+		if err != nil {
+			return skipped, err
+		}
+
+		header, _ := r.Peek(requestHeaderMinSize)
+		next := 4 + int(DecodeLength(header))
+
+		payload, err := r.Peek(next + requestHeaderMinSize)
+		if err == bufio.ErrBufferFull || len(payload) <= next {
+			return skipped, nil
+		}
+		if _, ok := ParseRequestHeader(payload[next:]); ok || err != nil {
+			return skipped, nil
+		}
+
+		if _, err = r.Discard(1); err != nil {
+			return skipped, err
+		}
+		skipped++
+	}
+}
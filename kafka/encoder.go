@@ -0,0 +1,504 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// PacketEncodingError is returned from a failure while encoding a Kafka packet. This can happen, for example,
+// if you try to encode a string over 2^15 characters in length, since Kafka's encoding rules do not permit that.
+type PacketEncodingError struct {
+	Info string
+}
+
+func (err PacketEncodingError) Error() string {
+	return fmt.Sprintf("kafka: error encoding packet: %s", err.Info)
+}
+
+// PacketEncoder is the interface providing helpers for writing with Kafka's encoding rules, it's a counterpart
+// of PacketDecoder. Types implementing Encoder only need to worry about calling methods like PutString,
+// not about how a string is represented in Kafka.
+type PacketEncoder interface {
+	// Primitives
+	putInt8(in int8)
+	putInt16(in int16)
+	putInt32(in int32)
+	putInt64(in int64)
+	putVarint(in int64)
+	putUVarint(in uint64)
+	putArrayLength(in int) error
+	putCompactArrayLength(in int)
+	putBool(in bool)
+	putEmptyTaggedFieldArray()
+
+	// Collections
+	putBytes(in []byte) error
+	putVarintBytes(in []byte) error
+	putRawBytes(in []byte) error
+	putCompactBytes(in []byte) error
+	putString(in string) error
+	putNullableString(in *string) error
+	putCompactString(in string) error
+	putCompactNullableString(in *string) error
+	putInt32Array(in []int32) error
+	putInt64Array(in []int64) error
+	putStringArray(in []string) error
+
+	// offset returns count of bytes encoded so far
+	offset() int
+
+	// Stacks, see PushEncoder
+	push(in PushEncoder)
+	pop() error
+}
+
+// PushEncoder is the interface for encoding fields like CRCs and lengths where the value of the field depends
+// on what is encoded after it in the packet. Start them with PacketEncoder.Push() where the actual value is
+// located in the packet, then PacketEncoder.Pop() them when all the bytes they depend upon have been encoded.
+type PushEncoder interface {
+	// Saves the offset into the input buffer as the location to actually write the calculated value when able.
+	saveOffset(in int)
+
+	// Returns the length of data to reserve for the output of this encoder (eg 4 bytes for a CRC32).
+	reserveLength() int
+
+	// Indicates that all required data is now available to calculate and write the field.
+	// SaveOffset is guaranteed to have been called first. The implementation should write ReserveLength() bytes
+	// of data to the saved offset, based on the data between the saved offset and curOffset.
+	run(curOffset int, buf []byte) error
+}
+
+// DynamicPushEncoder extends the interface of PushEncoder for uses cases where the length of the
+// fields itself is unknown until its value was computed (for instance varint encoded length
+// fields).
+type DynamicPushEncoder interface {
+	PushEncoder
+
+	// Called during pop() to adjust the length of the field.
+	// It should return the difference in bytes between the last computed length and current length.
+	adjustLength(currOffset int) int
+}
+
+// encoder is the interface that wraps the basic Encode method.
+// Anything implementing encoder can be turned into bytes using Kafka's encoding rules.
+type encoder interface {
+	Encode(pe PacketEncoder) error
+}
+
+type versionedEncoder interface {
+	Encode(pe PacketEncoder, version int16) error
+}
+
+// Encode takes an encoder and turns it into bytes using Kafka's encoding rules. The encoder is encoded twice:
+// the first pass computes the length of the packet, the second one writes it.
+func Encode(e encoder) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+
+	var prepEnc prepEncoder
+	if err := e.Encode(&prepEnc); err != nil {
+		return nil, err
+	}
+
+	if prepEnc.length < 0 || prepEnc.length > int(MaxRequestSize) {
+		return nil, PacketEncodingError{fmt.Sprintf("invalid request size (%d)", prepEnc.length)}
+	}
+
+	realEnc := RealEncoder{raw: make([]byte, prepEnc.length)}
+	if err := e.Encode(&realEnc); err != nil {
+		return nil, err
+	}
+
+	return realEnc.raw, nil
+}
+
+// prepEncoder implements PacketEncoder, it only computes the length of the packet and validates its values
+type prepEncoder struct {
+	stack  []PushEncoder
+	length int
+}
+
+// primitives
+
+func (pe *prepEncoder) putInt8(in int8) {
+	pe.length++
+}
+
+func (pe *prepEncoder) putInt16(in int16) {
+	pe.length += 2
+}
+
+func (pe *prepEncoder) putInt32(in int32) {
+	pe.length += 4
+}
+
+func (pe *prepEncoder) putInt64(in int64) {
+	pe.length += 8
+}
+
+func (pe *prepEncoder) putVarint(in int64) {
+	var buf [binary.MaxVarintLen64]byte
+	pe.length += binary.PutVarint(buf[:], in)
+}
+
+func (pe *prepEncoder) putUVarint(in uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	pe.length += binary.PutUvarint(buf[:], in)
+}
+
+func (pe *prepEncoder) putArrayLength(in int) error {
+	if in > math.MaxInt32 {
+		return PacketEncodingError{fmt.Sprintf("array too long (%d)", in)}
+	}
+	pe.length += 4
+	return nil
+}
+
+func (pe *prepEncoder) putCompactArrayLength(in int) {
+	pe.putUVarint(uint64(in + 1))
+}
+
+func (pe *prepEncoder) putBool(in bool) {
+	pe.length++
+}
+
+func (pe *prepEncoder) putEmptyTaggedFieldArray() {
+	pe.putUVarint(0)
+}
+
+// arrays
+
+func (pe *prepEncoder) putBytes(in []byte) error {
+	pe.length += 4
+	if in == nil {
+		return nil
+	}
+	return pe.putRawBytes(in)
+}
+
+func (pe *prepEncoder) putVarintBytes(in []byte) error {
+	if in == nil {
+		pe.putVarint(-1)
+		return nil
+	}
+	pe.putVarint(int64(len(in)))
+	return pe.putRawBytes(in)
+}
+
+func (pe *prepEncoder) putRawBytes(in []byte) error {
+	if len(in) > math.MaxInt32 {
+		return PacketEncodingError{fmt.Sprintf("byteslice too long (%d)", len(in))}
+	}
+	pe.length += len(in)
+	return nil
+}
+
+func (pe *prepEncoder) putCompactBytes(in []byte) error {
+	if in == nil {
+		pe.putUVarint(0)
+		return nil
+	}
+	pe.putUVarint(uint64(len(in)) + 1)
+	return pe.putRawBytes(in)
+}
+
+func (pe *prepEncoder) putString(in string) error {
+	pe.length += 2
+	if len(in) > math.MaxInt16 {
+		return PacketEncodingError{fmt.Sprintf("string too long (%d)", len(in))}
+	}
+	pe.length += len(in)
+	return nil
+}
+
+func (pe *prepEncoder) putNullableString(in *string) error {
+	if in == nil {
+		pe.length += 2
+		return nil
+	}
+	return pe.putString(*in)
+}
+
+func (pe *prepEncoder) putCompactString(in string) error {
+	pe.putUVarint(uint64(len(in)) + 1)
+	pe.length += len(in)
+	return nil
+}
+
+func (pe *prepEncoder) putCompactNullableString(in *string) error {
+	if in == nil {
+		pe.putUVarint(0)
+		return nil
+	}
+	return pe.putCompactString(*in)
+}
+
+func (pe *prepEncoder) putInt32Array(in []int32) error {
+	if err := pe.putArrayLength(len(in)); err != nil {
+		return err
+	}
+	pe.length += 4 * len(in)
+	return nil
+}
+
+func (pe *prepEncoder) putInt64Array(in []int64) error {
+	if err := pe.putArrayLength(len(in)); err != nil {
+		return err
+	}
+	pe.length += 8 * len(in)
+	return nil
+}
+
+func (pe *prepEncoder) putStringArray(in []string) error {
+	if err := pe.putArrayLength(len(in)); err != nil {
+		return err
+	}
+
+	for _, str := range in {
+		if err := pe.putString(str); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pe *prepEncoder) offset() int {
+	return pe.length
+}
+
+// stackable
+
+func (pe *prepEncoder) push(in PushEncoder) {
+	in.saveOffset(pe.length)
+	pe.length += in.reserveLength()
+	pe.stack = append(pe.stack, in)
+}
+
+func (pe *prepEncoder) pop() error {
+	in := pe.stack[len(pe.stack)-1]
+	pe.stack = pe.stack[:len(pe.stack)-1]
+	if dpe, ok := in.(DynamicPushEncoder); ok {
+		pe.length += dpe.adjustLength(pe.length)
+	}
+
+	return nil
+}
+
+// RealEncoder implements PacketEncoder, it writes the packet into buffer of the length computed by prepEncoder
+type RealEncoder struct {
+	raw   []byte
+	off   int
+	stack []PushEncoder
+}
+
+// primitives
+
+func (re *RealEncoder) putInt8(in int8) {
+	re.raw[re.off] = byte(in)
+	re.off++
+}
+
+func (re *RealEncoder) putInt16(in int16) {
+	binary.BigEndian.PutUint16(re.raw[re.off:], uint16(in))
+	re.off += 2
+}
+
+func (re *RealEncoder) putInt32(in int32) {
+	binary.BigEndian.PutUint32(re.raw[re.off:], uint32(in))
+	re.off += 4
+}
+
+func (re *RealEncoder) putInt64(in int64) {
+	binary.BigEndian.PutUint64(re.raw[re.off:], uint64(in))
+	re.off += 8
+}
+
+func (re *RealEncoder) putVarint(in int64) {
+	re.off += binary.PutVarint(re.raw[re.off:], in)
+}
+
+func (re *RealEncoder) putUVarint(in uint64) {
+	re.off += binary.PutUvarint(re.raw[re.off:], in)
+}
+
+func (re *RealEncoder) putArrayLength(in int) error {
+	re.putInt32(int32(in))
+	return nil
+}
+
+// putCompactArrayLength encodes the length of a compact array used by flexible versions,
+// the length is encoded as unsigned varint N+1 and -1 stands for a null array
+func (re *RealEncoder) putCompactArrayLength(in int) {
+	re.putUVarint(uint64(in + 1))
+}
+
+func (re *RealEncoder) putBool(in bool) {
+	if in {
+		re.putInt8(1)
+		return
+	}
+	re.putInt8(0)
+}
+
+// putEmptyTaggedFieldArray encodes tagged fields of flexible versions, no tagged fields are sent
+func (re *RealEncoder) putEmptyTaggedFieldArray() {
+	re.putUVarint(0)
+}
+
+// collection
+
+func (re *RealEncoder) putRawBytes(in []byte) error {
+	copy(re.raw[re.off:], in)
+	re.off += len(in)
+	return nil
+}
+
+func (re *RealEncoder) putBytes(in []byte) error {
+	if in == nil {
+		re.putInt32(-1)
+		return nil
+	}
+	re.putInt32(int32(len(in)))
+	return re.putRawBytes(in)
+}
+
+func (re *RealEncoder) putVarintBytes(in []byte) error {
+	if in == nil {
+		re.putVarint(-1)
+		return nil
+	}
+	re.putVarint(int64(len(in)))
+	return re.putRawBytes(in)
+}
+
+func (re *RealEncoder) putCompactBytes(in []byte) error {
+	if in == nil {
+		re.putUVarint(0)
+		return nil
+	}
+	re.putUVarint(uint64(len(in)) + 1)
+	return re.putRawBytes(in)
+}
+
+func (re *RealEncoder) putString(in string) error {
+	re.putInt16(int16(len(in)))
+	copy(re.raw[re.off:], in)
+	re.off += len(in)
+	return nil
+}
+
+func (re *RealEncoder) putNullableString(in *string) error {
+	if in == nil {
+		re.putInt16(-1)
+		return nil
+	}
+	return re.putString(*in)
+}
+
+func (re *RealEncoder) putCompactString(in string) error {
+	re.putUVarint(uint64(len(in)) + 1)
+	copy(re.raw[re.off:], in)
+	re.off += len(in)
+	return nil
+}
+
+func (re *RealEncoder) putCompactNullableString(in *string) error {
+	if in == nil {
+		re.putUVarint(0)
+		return nil
+	}
+	return re.putCompactString(*in)
+}
+
+func (re *RealEncoder) putInt32Array(in []int32) error {
+	err := re.putArrayLength(len(in))
+	if err != nil {
+		return err
+	}
+	for _, val := range in {
+		re.putInt32(val)
+	}
+	return nil
+}
+
+func (re *RealEncoder) putInt64Array(in []int64) error {
+	err := re.putArrayLength(len(in))
+	if err != nil {
+		return err
+	}
+	for _, val := range in {
+		re.putInt64(val)
+	}
+	return nil
+}
+
+func (re *RealEncoder) putStringArray(in []string) error {
+	err := re.putArrayLength(len(in))
+	if err != nil {
+		return err
+	}
+
+	for _, val := range in {
+		if err := re.putString(val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (re *RealEncoder) offset() int {
+	return re.off
+}
+
+// stacks
+
+func (re *RealEncoder) push(in PushEncoder) {
+	in.saveOffset(re.off)
+	re.off += in.reserveLength()
+	re.stack = append(re.stack, in)
+}
+
+func (re *RealEncoder) pop() error {
+	// this is go's ugly pop pattern (the inverse of append)
+	in := re.stack[len(re.stack)-1]
+	re.stack = re.stack[:len(re.stack)-1]
+
+	return in.run(re.off, re.raw)
+}
+
+// putFlexibleString encodes compact string for flexible versions and regular string otherwise
+func putFlexibleString(pe PacketEncoder, in string, flexible bool) error {
+	if flexible {
+		return pe.putCompactString(in)
+	}
+	return pe.putString(in)
+}
+
+// putFlexibleNullableString encodes compact nullable string for flexible versions and regular one otherwise
+func putFlexibleNullableString(pe PacketEncoder, in *string, flexible bool) error {
+	if flexible {
+		return pe.putCompactNullableString(in)
+	}
+	return pe.putNullableString(in)
+}
+
+// putFlexibleArrayLength encodes compact array length for flexible versions and regular one otherwise
+func putFlexibleArrayLength(pe PacketEncoder, in int, flexible bool) error {
+	if flexible {
+		pe.putCompactArrayLength(in)
+		return nil
+	}
+	return pe.putArrayLength(in)
+}
+
+// putFlexibleBytes encodes compact bytes for flexible versions and regular ones otherwise
+func putFlexibleBytes(pe PacketEncoder, in []byte, flexible bool) error {
+	if flexible {
+		return pe.putCompactBytes(in)
+	}
+	return pe.putBytes(in)
+}
@@ -0,0 +1,57 @@
+package kafka
+
+import "strconv"
+
+// errorNames are names of error codes of kafka protocol reported in responses to produce and fetch requests,
+// see https://kafka.apache.org/protocol#protocol_error_codes
+var errorNames = map[int16]string{
+	-1: "UNKNOWN_SERVER_ERROR",
+	0:  "NONE",
+	1:  "OFFSET_OUT_OF_RANGE",
+	2:  "CORRUPT_MESSAGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	4:  "INVALID_FETCH_SIZE",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	8:  "BROKER_NOT_AVAILABLE",
+	9:  "REPLICA_NOT_AVAILABLE",
+	10: "MESSAGE_TOO_LARGE",
+	13: "NETWORK_EXCEPTION",
+	17: "INVALID_TOPIC_EXCEPTION",
+	18: "RECORD_LIST_TOO_LARGE",
+	19: "NOT_ENOUGH_REPLICAS",
+	20: "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	21: "INVALID_REQUIRED_ACKS",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	31: "CLUSTER_AUTHORIZATION_FAILED",
+	32: "INVALID_TIMESTAMP",
+	35: "UNSUPPORTED_VERSION",
+	43: "UNSUPPORTED_FOR_MESSAGE_FORMAT",
+	44: "POLICY_VIOLATION",
+	45: "OUT_OF_ORDER_SEQUENCE_NUMBER",
+	46: "DUPLICATE_SEQUENCE_NUMBER",
+	47: "INVALID_PRODUCER_EPOCH",
+	48: "INVALID_TXN_STATE",
+	53: "TRANSACTIONAL_ID_AUTHORIZATION_FAILED",
+	59: "UNKNOWN_PRODUCER_ID",
+	67: "KAFKA_STORAGE_ERROR",
+	70: "FETCH_SESSION_ID_NOT_FOUND",
+	71: "INVALID_FETCH_SESSION_EPOCH",
+	74: "FENCED_LEADER_EPOCH",
+	75: "UNKNOWN_LEADER_EPOCH",
+	76: "UNSUPPORTED_COMPRESSION_TYPE",
+	78: "OFFSET_NOT_AVAILABLE",
+	87: "INVALID_RECORD",
+	89: "THROTTLING_QUOTA_EXCEEDED",
+	90: "PRODUCER_FENCED",
+}
+
+// ErrorName returns name of error code, e.g. NOT_LEADER_OR_FOLLOWER, codes without names are formatted as
+// ERROR_<code>
+func ErrorName(code int16) string {
+	if name, ok := errorNames[code]; ok {
+		return name
+	}
+	return "ERROR_" + strconv.Itoa(int(code))
+}
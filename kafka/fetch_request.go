@@ -1,6 +1,9 @@
 package kafka
 
 import (
+	"encoding/json"
+	"sort"
+
 	"github.com/d-ulyanov/kafka-sniffer/metrics"
 )
 
@@ -33,10 +36,24 @@ func (b *fetchRequestBlock) decode(pd PacketDecoder, version int16) (err error)
 	return nil
 }
 
+func (b *fetchRequestBlock) encode(pe PacketEncoder, version int16) error {
+	if version >= 9 {
+		pe.putInt32(b.currentLeaderEpoch)
+	}
+	pe.putInt64(b.fetchOffset)
+	if version >= 5 {
+		pe.putInt64(b.logStartOffset)
+	}
+	pe.putInt32(b.maxBytes)
+	return nil
+}
+
 // FetchRequest (API key 1) will fetch Kafka messages. Version 3 introduced the MaxBytes field. See
 // https://issues.apache.org/jira/browse/KAFKA-2063 for a discussion of the issues leading up to that.  The KIP is at
 // https://cwiki.apache.org/confluence/display/KAFKA/KIP-74%3A+Add+Fetch+Response+Size+Limit+in+Bytes
 type FetchRequest struct {
+	// ReplicaID is a broker id of follower replicating partitions, it's -1 for consumers
+	ReplicaID    int32
 	MaxWaitTime  int32
 	MinBytes     int32
 	MaxBytes     int32
@@ -62,6 +79,23 @@ func (r *FetchRequest) ExtractTopics() []string {
 	return topics
 }
 
+// IsFollower returns true if the request is sent by follower broker replicating partitions, not by consumer
+func (r *FetchRequest) IsFollower() bool {
+	return r.ReplicaID >= 0
+}
+
+// FetchOffsets returns offsets partitions of topics are fetched from
+func (r *FetchRequest) FetchOffsets() map[string]map[int32]int64 {
+	offsets := make(map[string]map[int32]int64, len(r.blocks))
+	for topic, blocks := range r.blocks {
+		offsets[topic] = make(map[int32]int64, len(blocks))
+		for partition, block := range blocks {
+			offsets[topic][partition] = block.fetchOffset
+		}
+	}
+	return offsets
+}
+
 // GetRequestedBlocksCount returns a total amount of blocks from fetch request
 func (r *FetchRequest) GetRequestedBlocksCount() (blocksCount int) {
 	for _, partition := range r.blocks {
@@ -74,7 +108,7 @@ func (r *FetchRequest) GetRequestedBlocksCount() (blocksCount int) {
 func (r *FetchRequest) Decode(pd PacketDecoder, version int16) (err error) {
 	r.Version = version
 
-	if _, err = pd.getInt32(); err != nil {
+	if r.ReplicaID, err = pd.getInt32(); err != nil {
 		return err
 	}
 	if r.MaxWaitTime, err = pd.getInt32(); err != nil {
@@ -116,7 +150,7 @@ func (r *FetchRequest) Decode(pd PacketDecoder, version int16) (err error) {
 	r.blocks = make(map[string]map[int32]*fetchRequestBlock)
 	for i := 0; i < topicCount; i++ {
 		var topic string
-		topic, err = pd.getString()
+		topic, err = pd.getInternedString()
 		if err != nil {
 			return err
 		}
@@ -149,7 +183,7 @@ func (r *FetchRequest) Decode(pd PacketDecoder, version int16) (err error) {
 		r.forgotten = make(map[string][]int32)
 		for i := 0; i < forgottenCount; i++ {
 			var topic string
-			topic, err = pd.getString()
+			topic, err = pd.getInternedString()
 			if err != nil {
 				return err
 			}
@@ -158,6 +192,9 @@ func (r *FetchRequest) Decode(pd PacketDecoder, version int16) (err error) {
 			if err != nil {
 				return err
 			}
+			if partitionCount < 0 {
+				return errInvalidArrayLength
+			}
 			r.forgotten[topic] = make([]int32, partitionCount)
 
 			for j := 0; j < partitionCount; j++ {
@@ -181,6 +218,63 @@ func (r *FetchRequest) Decode(pd PacketDecoder, version int16) (err error) {
 	return nil
 }
 
+// Encode encodes kafka fetch request into packet
+func (r *FetchRequest) Encode(pe PacketEncoder, version int16) (err error) {
+	pe.putInt32(r.ReplicaID)
+	pe.putInt32(r.MaxWaitTime)
+	pe.putInt32(r.MinBytes)
+	if version >= 3 {
+		pe.putInt32(r.MaxBytes)
+	}
+	if version >= 4 {
+		pe.putInt8(int8(r.Isolation))
+	}
+	if version >= 7 {
+		pe.putInt32(r.SessionID)
+		pe.putInt32(r.SessionEpoch)
+	}
+
+	if err = pe.putArrayLength(len(r.blocks)); err != nil {
+		return err
+	}
+	for topic, blocks := range r.blocks {
+		if err = pe.putString(topic); err != nil {
+			return err
+		}
+		if err = pe.putArrayLength(len(blocks)); err != nil {
+			return err
+		}
+		for partition, block := range blocks {
+			pe.putInt32(partition)
+			if err = block.encode(pe, version); err != nil {
+				return err
+			}
+		}
+	}
+
+	if version >= 7 {
+		if err = pe.putArrayLength(len(r.forgotten)); err != nil {
+			return err
+		}
+		for topic, partitions := range r.forgotten {
+			if err = pe.putString(topic); err != nil {
+				return err
+			}
+			if err = pe.putInt32Array(partitions); err != nil {
+				return err
+			}
+		}
+	}
+
+	if version >= 11 {
+		if err = pe.putString(r.RackID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // CollectClientMetrics collects metrics associated with client
 func (r *FetchRequest) CollectClientMetrics(srcHost string) {
 	metrics.RequestsCount.WithLabelValues(srcHost, "fetch").Inc()
@@ -197,6 +291,10 @@ func (r *FetchRequest) version() int16 {
 	return r.Version
 }
 
+func (r *FetchRequest) headerVersion() int16 {
+	return 1
+}
+
 func (r *FetchRequest) requiredVersion() Version {
 	switch r.Version {
 	case 0:
@@ -248,3 +346,75 @@ func (r *FetchRequest) AddBlock(topic string, partitionID int32, fetchOffset int
 
 	r.blocks[topic][partitionID] = tmp
 }
+
+type fetchPartitionJSON struct {
+	Partition          int32 `json:"partition"`
+	CurrentLeaderEpoch int32 `json:"current_leader_epoch"`
+	FetchOffset        int64 `json:"fetch_offset"`
+	LogStartOffset     int64 `json:"log_start_offset"`
+	MaxBytes           int32 `json:"max_bytes"`
+}
+
+type fetchTopicJSON struct {
+	Topic      string               `json:"topic"`
+	Partitions []fetchPartitionJSON `json:"partitions"`
+}
+
+type fetchForgottenJSON struct {
+	Topic      string  `json:"topic"`
+	Partitions []int32 `json:"partitions"`
+}
+
+// MarshalJSON renders fetch request with its topic partitions in order
+func (r *FetchRequest) MarshalJSON() ([]byte, error) {
+	topics := make([]fetchTopicJSON, 0, len(r.blocks))
+	for topic, blocks := range r.blocks {
+		t := fetchTopicJSON{Topic: topic, Partitions: make([]fetchPartitionJSON, 0, len(blocks))}
+		for partition, block := range blocks {
+			t.Partitions = append(t.Partitions, fetchPartitionJSON{
+				Partition:          partition,
+				CurrentLeaderEpoch: block.currentLeaderEpoch,
+				FetchOffset:        block.fetchOffset,
+				LogStartOffset:     block.logStartOffset,
+				MaxBytes:           block.maxBytes,
+			})
+		}
+		sort.Slice(t.Partitions, func(i, j int) bool { return t.Partitions[i].Partition < t.Partitions[j].Partition })
+		topics = append(topics, t)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Topic < topics[j].Topic })
+
+	forgotten := make([]fetchForgottenJSON, 0, len(r.forgotten))
+	for topic, partitions := range r.forgotten {
+		forgotten = append(forgotten, fetchForgottenJSON{Topic: topic, Partitions: partitions})
+	}
+	sort.Slice(forgotten, func(i, j int) bool { return forgotten[i].Topic < forgotten[j].Topic })
+
+	return json.Marshal(struct {
+		ReplicaID    int32                `json:"replica_id"`
+		MaxWaitTime  int32                `json:"max_wait_time"`
+		MinBytes     int32                `json:"min_bytes"`
+		MaxBytes     int32                `json:"max_bytes"`
+		Isolation    IsolationLevel       `json:"isolation"`
+		SessionID    int32                `json:"session_id"`
+		SessionEpoch int32                `json:"session_epoch"`
+		Topics       []fetchTopicJSON     `json:"topics"`
+		Forgotten    []fetchForgottenJSON `json:"forgotten,omitempty"`
+		RackID       string               `json:"rack_id,omitempty"`
+	}{
+		ReplicaID:    r.ReplicaID,
+		MaxWaitTime:  r.MaxWaitTime,
+		MinBytes:     r.MinBytes,
+		MaxBytes:     r.MaxBytes,
+		Isolation:    r.Isolation,
+		SessionID:    r.SessionID,
+		SessionEpoch: r.SessionEpoch,
+		Topics:       topics,
+		Forgotten:    forgotten,
+		RackID:       r.RackID,
+	})
+}
+
+func (r *FetchRequest) String() string {
+	return jsonString(r)
+}
@@ -0,0 +1,151 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// FetchKey is an api key of Fetch requests and responses
+const FetchKey int16 = 1
+
+// FetchResponse (API key 1) contains records of partitions, only error codes and high watermarks of partitions
+// are decoded
+type FetchResponse struct {
+	Version      int16
+	ThrottleTime int32
+
+	// ErrorCode is an error of the whole fetch session since version 7
+	ErrorCode  int16
+	Partitions []PartitionResult
+}
+
+// ReadFetchResponse decodes error codes and high watermarks of partitions of fetch response body of the version
+// read from reader. Records are discarded as they are read, so responses of any size are decoded without
+// buffering them.
+func ReadFetchResponse(r io.Reader, version int16) (*FetchResponse, error) {
+	d := &streamDecoder{r: r}
+	resp := &FetchResponse{Version: version}
+
+	if version >= 1 {
+		resp.ThrottleTime = d.int32()
+	}
+	if version >= 7 {
+		resp.ErrorCode = d.int16()
+
+		// session id
+		d.int32()
+	}
+
+	for i, topicCount := 0, d.int32(); i < int(topicCount) && d.err == nil; i++ {
+		topic := d.string()
+
+		for j, partitionCount := 0, d.int32(); j < int(partitionCount) && d.err == nil; j++ {
+			result := PartitionResult{Topic: topic}
+			result.Partition = d.int32()
+			result.ErrorCode = d.int16()
+			result.HighWatermark = d.int64()
+
+			// last stable offset, log start offset and aborted transactions of producer ids and first offsets
+			if version >= 4 {
+				d.int64()
+			}
+			if version >= 5 {
+				d.int64()
+			}
+			if version >= 4 {
+				if abortedCount := d.int32(); abortedCount > 0 {
+					d.skip(int64(abortedCount) * 16)
+				}
+			}
+
+			// preferred read replica
+			if version >= 11 {
+				d.int32()
+			}
+
+			// records aren't decoded, their size is -1 if they are null
+			if size := d.int32(); size > 0 {
+				d.skip(int64(size))
+			}
+
+			if d.err == nil {
+				resp.Partitions = append(resp.Partitions, result)
+			}
+		}
+	}
+
+	return resp, d.err
+}
+
+// streamDecoder decodes fields of non-flexible versions from reader, the first error is kept and the next fields
+// are zero after it
+type streamDecoder struct {
+	r   io.Reader
+	buf [8]byte
+	err error
+}
+
+func (d *streamDecoder) read(n int) []byte {
+	if d.err != nil {
+		return d.buf[:0]
+	}
+	if _, d.err = io.ReadFull(d.r, d.buf[:n]); d.err != nil {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return d.buf[:0]
+	}
+	return d.buf[:n]
+}
+
+func (d *streamDecoder) int16() int16 {
+	if b := d.read(2); len(b) == 2 {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *streamDecoder) int32() int32 {
+	if b := d.read(4); len(b) == 4 {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *streamDecoder) int64() int64 {
+	if b := d.read(8); len(b) == 8 {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads string, null string is empty
+func (d *streamDecoder) string() string {
+	n := d.int16()
+	if n <= 0 || d.err != nil {
+		return ""
+	}
+
+	data := make([]byte, n)
+	if _, d.err = io.ReadFull(d.r, data); d.err != nil {
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+		return ""
+	}
+	return string(data)
+}
+
+// skip discards n bytes
+func (d *streamDecoder) skip(n int64) {
+	if d.err != nil {
+		return
+	}
+	if skipped, err := io.CopyN(ioutil.Discard, d.r, n); skipped < n {
+		d.err = err
+		if d.err == io.EOF {
+			d.err = io.ErrUnexpectedEOF
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Fuzz targets of decoders, e.g.:
+//
+//	go test -run '^$' -fuzz FuzzRecordBatch ./kafka
+//
+// Seeds of each target are kept in kafka/testdata/fuzz/<target>/corpus as raw inputs, crash reproducers among
+// them are checked by every go test run. Inputs found failing by the fuzzer are written into
+// kafka/testdata/fuzz/<target> and are replayed by go test too. Decoders must return errors for any input, they
+// must never panic.
+
+// FuzzDecodeRequest decodes request with length prefix, decoded request is rendered and encoded back
+func FuzzDecodeRequest(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req, _, err := DecodeRequest(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		_ = req.String()
+		_, _ = EncodeRequest(req)
+	})
+}
+
+// FuzzRecordBatch decodes record batch or legacy message set, records are decompressed by codec of the batch.
+// Checksum of record batch is fixed up, so mutations reach records instead of failing the check.
+func FuzzRecordBatch(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var records Records
+		if err := records.decode(&RealDecoder{raw: fixBatchCRC(data)}); err != nil {
+			return
+		}
+
+		_ = records.String()
+	})
+}
+
+// FuzzDecompress decompresses data by codec taken from the first byte
+func FuzzDecompress(f *testing.F) {
+	addCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+
+		_, _ = decompress(CompressionCodec(data[0]%5), data[1:])
+	})
+}
+
+// TestFuzzCrashers checks inputs of corpora which crashed decoders are rejected: snappy block declaring huge
+// decoded length isn't decompressed and record declaring more headers than its data holds is a partial trailing
+// record of the batch
+func TestFuzzCrashers(t *testing.T) {
+	if StrictPrivacy() {
+		t.Skip("records aren't decompressed and decoded in strict privacy mode")
+	}
+
+	for _, c := range []struct {
+		name     string
+		rejected func(data []byte) bool
+	}{
+		{"FuzzDecompress/corpus/snappy-decoded-length-overflow", func(data []byte) bool {
+			_, err := decompress(CompressionCodec(data[0]%5), data[1:])
+			return err != nil
+		}},
+		{"FuzzRecordBatch/corpus/record-headers-count-overflow", func(data []byte) bool {
+			var records Records
+			err := records.decode(&RealDecoder{raw: fixBatchCRC(data)})
+			return err != nil || records.RecordBatch.PartialTrailingRecord && len(records.RecordBatch.Records) == 0
+		}},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "fuzz", c.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !c.rejected(data) {
+			t.Errorf("%s isn't rejected", c.name)
+		}
+	}
+}
+
+// addCorpus adds inputs of corpus of the target as seeds
+func addCorpus(f *testing.F) {
+	dir := filepath.Join("testdata", "fuzz", f.Name(), "corpus")
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(files) == 0 {
+		f.Fatalf("corpus %s is empty", dir)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+// fixBatchCRC returns copy of data with checksum of record batch computed from the data, other data isn't changed
+func fixBatchCRC(data []byte) []byte {
+	const crcOffset = magicOffset + 1
+	if len(data) < recordBatchOverhead+12 || data[magicOffset] != 2 {
+		return data
+	}
+	end := 12 + int(int32(binary.BigEndian.Uint32(data[8:])))
+	if end < crcOffset+4 || end > len(data) {
+		return data
+	}
+
+	fixed := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(fixed[crcOffset:], crc32.Checksum(fixed[crcOffset+4:end], castagnoliTable))
+	return fixed
+}
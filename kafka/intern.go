@@ -0,0 +1,37 @@
+package kafka
+
+import (
+	"sync"
+)
+
+// maxInterned limits count of interned strings, the cache is cleared when it's exceeded, so unique strings
+// sent by broken or malicious clients don't grow it forever
+const maxInterned = 100000
+
+// interned is a cache of repeated strings like topics and client ids, it shares memory of the same strings
+// decoded from different requests and makes map lookups by them faster
+var interned = struct {
+	sync.RWMutex
+	strings map[string]string
+}{strings: make(map[string]string)}
+
+// intern returns string of bytes shared with previously decoded equal strings
+func intern(b []byte) string {
+	interned.RLock()
+	s, ok := interned.strings[string(b)]
+	interned.RUnlock()
+	if ok {
+		return s
+	}
+
+	s = string(b)
+
+	interned.Lock()
+	if len(interned.strings) >= maxInterned {
+		interned.strings = make(map[string]string)
+	}
+	interned.strings[s] = s
+	interned.Unlock()
+
+	return s
+}
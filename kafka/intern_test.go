@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+// encodeStrings returns strings encoded as kafka strings with int16 length
+func encodeStrings(strs ...string) []byte {
+	var buf []byte
+	for _, s := range strs {
+		buf = append(buf, byte(len(s)>>8), byte(len(s)))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+func TestIntern(t *testing.T) {
+	a := intern([]byte("orders"))
+	b := intern([]byte("orders"))
+	if a != "orders" || b != "orders" {
+		t.Fatalf("interned strings are %q and %q, want orders", a, b)
+	}
+	if (*reflect.StringHeader)(unsafe.Pointer(&a)).Data != (*reflect.StringHeader)(unsafe.Pointer(&b)).Data {
+		t.Error("equal interned strings don't share memory")
+	}
+}
+
+func TestInternLimit(t *testing.T) {
+	for i := 0; i <= maxInterned; i++ {
+		intern([]byte("topic-" + strconv.Itoa(i)))
+	}
+
+	interned.RLock()
+	n := len(interned.strings)
+	interned.RUnlock()
+	if n > maxInterned {
+		t.Errorf("%d strings are interned, limit is %d", n, maxInterned)
+	}
+}
+
+// BenchmarkDecodeString decodes topics and client ids repeated by requests with interning and with copies,
+// interned strings don't allocate once they are seen
+func BenchmarkDecodeString(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		strs []string
+	}{
+		{"topic", []string{"orders", "payments.v2", "customer-events-eu-west-1"}},
+		{"client-id", []string{"consumer-billing-service-1", "producer-7f0c4a2e-6b1d-4d3c-9f0a"}},
+	} {
+		data := encodeStrings(bm.strs...)
+
+		for _, interning := range []bool{true, false} {
+			name := bm.name + "/copied"
+			if interning {
+				name = bm.name + "/interned"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					rd := RealDecoder{raw: data}
+					for range bm.strs {
+						var err error
+						if interning {
+							_, err = rd.getInternedString()
+						} else {
+							_, err = rd.getString()
+						}
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"encoding/json"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// GroupProtocol is a protocol (e.g. partition assignor) supported by a group member
+type GroupProtocol struct {
+	Name     string
+	Metadata []byte
+}
+
+func (p *GroupProtocol) decode(pd PacketDecoder, flexible bool) (err error) {
+	if flexible {
+		if p.Name, err = pd.getCompactString(); err != nil {
+			return err
+		}
+		if p.Metadata, err = pd.getCompactBytes(); err != nil {
+			return err
+		}
+		return pd.getTaggedFieldArray()
+	}
+
+	if p.Name, err = pd.getString(); err != nil {
+		return err
+	}
+	p.Metadata, err = pd.getBytes()
+	return err
+}
+
+func (p *GroupProtocol) encode(pe PacketEncoder, flexible bool) error {
+	if err := putFlexibleString(pe, p.Name, flexible); err != nil {
+		return err
+	}
+	if err := putFlexibleBytes(pe, p.Metadata, flexible); err != nil {
+		return err
+	}
+	if flexible {
+		pe.putEmptyTaggedFieldArray()
+	}
+	return nil
+}
+
+// JoinGroupRequest (API key 11) is sent by every member of a consumer group when the group rebalances.
+// Version 5 introduced static membership (KIP-345), version 6 is the first flexible version (KIP-482).
+type JoinGroupRequest struct {
+	Version          int16
+	GroupID          string
+	SessionTimeout   int32
+	RebalanceTimeout int32
+	MemberID         string
+	GroupInstanceID  *string
+	ProtocolType     string
+	GroupProtocols   []*GroupProtocol
+	Reason           *string
+}
+
+// Decode decodes kafka join group request from packet
+func (r *JoinGroupRequest) Decode(pd PacketDecoder, version int16) (err error) {
+	r.Version = version
+	flexible := r.Version >= 6
+
+	if r.GroupID, err = getFlexibleString(pd, flexible); err != nil {
+		return err
+	}
+	if r.SessionTimeout, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if r.Version >= 1 {
+		if r.RebalanceTimeout, err = pd.getInt32(); err != nil {
+			return err
+		}
+	}
+	if r.MemberID, err = getFlexibleString(pd, flexible); err != nil {
+		return err
+	}
+	if r.Version >= 5 {
+		if r.GroupInstanceID, err = getFlexibleNullableString(pd, flexible); err != nil {
+			return err
+		}
+	}
+	if r.ProtocolType, err = getFlexibleString(pd, flexible); err != nil {
+		return err
+	}
+
+	protocolCount, err := getFlexibleArrayLength(pd, flexible)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < protocolCount; i++ {
+		protocol := &GroupProtocol{}
+		if err = protocol.decode(pd, flexible); err != nil {
+			return err
+		}
+		r.GroupProtocols = append(r.GroupProtocols, protocol)
+	}
+
+	if r.Version >= 8 {
+		if r.Reason, err = pd.getCompactNullableString(); err != nil {
+			return err
+		}
+	}
+
+	if flexible {
+		return pd.getTaggedFieldArray()
+	}
+
+	return nil
+}
+
+// Encode encodes kafka join group request into packet
+func (r *JoinGroupRequest) Encode(pe PacketEncoder, version int16) error {
+	flexible := version >= 6
+
+	if err := putFlexibleString(pe, r.GroupID, flexible); err != nil {
+		return err
+	}
+	pe.putInt32(r.SessionTimeout)
+	if version >= 1 {
+		pe.putInt32(r.RebalanceTimeout)
+	}
+	if err := putFlexibleString(pe, r.MemberID, flexible); err != nil {
+		return err
+	}
+	if version >= 5 {
+		if err := putFlexibleNullableString(pe, r.GroupInstanceID, flexible); err != nil {
+			return err
+		}
+	}
+	if err := putFlexibleString(pe, r.ProtocolType, flexible); err != nil {
+		return err
+	}
+
+	if err := putFlexibleArrayLength(pe, len(r.GroupProtocols), flexible); err != nil {
+		return err
+	}
+	for _, protocol := range r.GroupProtocols {
+		if err := protocol.encode(pe, flexible); err != nil {
+			return err
+		}
+	}
+
+	if version >= 8 {
+		if err := pe.putCompactNullableString(r.Reason); err != nil {
+			return err
+		}
+	}
+
+	if flexible {
+		pe.putEmptyTaggedFieldArray()
+	}
+
+	return nil
+}
+
+// IsStaticMember returns true if the member has group.instance.id configured (static membership, KIP-345)
+func (r *JoinGroupRequest) IsStaticMember() bool {
+	return r.GroupInstanceID != nil && *r.GroupInstanceID != ""
+}
+
+// InstanceID returns group.instance.id of the static member or empty string for dynamic members
+func (r *JoinGroupRequest) InstanceID() string {
+	if r.GroupInstanceID == nil {
+		return ""
+	}
+	return *r.GroupInstanceID
+}
+
+// CollectClientMetrics collects metrics associated with client
+func (r *JoinGroupRequest) CollectClientMetrics(srcHost string) {
+	metrics.RequestsCount.WithLabelValues(srcHost, "join_group").Inc()
+	metrics.GroupJoinRequests.WithLabelValues(r.GroupID).Inc()
+}
+
+func (r *JoinGroupRequest) key() int16 {
+	return 11
+}
+
+func (r *JoinGroupRequest) version() int16 {
+	return r.Version
+}
+
+func (r *JoinGroupRequest) headerVersion() int16 {
+	if r.Version >= 6 {
+		return 2
+	}
+	return 1
+}
+
+func (r *JoinGroupRequest) requiredVersion() Version {
+	switch r.Version {
+	case 0:
+		return V0_9_0_0
+	case 1:
+		return V0_10_1_0
+	case 2:
+		return V0_11_0_0
+	case 3:
+		return V2_0_0_0
+	case 4:
+		return V2_2_0_0
+	case 5:
+		return V2_3_0_0
+	case 6:
+		return V2_4_0_0
+	default:
+		return MaxVersion
+	}
+}
+
+// MarshalJSON renders protocol, metadata is rendered as is since it's a subscription of the member and not
+// a payload
+func (p *GroupProtocol) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name     string `json:"name"`
+		Metadata []byte `json:"metadata"`
+	}{
+		Name:     p.Name,
+		Metadata: p.Metadata,
+	})
+}
+
+// MarshalJSON renders join group request
+func (r *JoinGroupRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		GroupID          string           `json:"group_id"`
+		SessionTimeout   int32            `json:"session_timeout"`
+		RebalanceTimeout int32            `json:"rebalance_timeout"`
+		MemberID         string           `json:"member_id"`
+		GroupInstanceID  *string          `json:"group_instance_id"`
+		ProtocolType     string           `json:"protocol_type"`
+		GroupProtocols   []*GroupProtocol `json:"group_protocols"`
+		Reason           *string          `json:"reason,omitempty"`
+	}{
+		GroupID:          r.GroupID,
+		SessionTimeout:   r.SessionTimeout,
+		RebalanceTimeout: r.RebalanceTimeout,
+		MemberID:         r.MemberID,
+		GroupInstanceID:  r.GroupInstanceID,
+		ProtocolType:     r.ProtocolType,
+		GroupProtocols:   r.GroupProtocols,
+		Reason:           r.Reason,
+	})
+}
+
+func (r *JoinGroupRequest) String() string {
+	return jsonString(r)
+}
@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RenderPayloads enables rendering of payloads, keys and values of records and values of their headers, by
+// MarshalJSON and String methods of decoded types. Payloads are redacted by default and in strict privacy mode,
+// only their sizes are rendered. Secrets, e.g. SASL authentication bytes, are always redacted.
+var RenderPayloads bool
+
+// renderPayload returns payload if payloads are rendered and nil otherwise, so it's omitted from JSON
+func renderPayload(b []byte) []byte {
+	if !RenderPayloads || StrictPrivacy() {
+		return nil
+	}
+	return b
+}
+
+// payloadSize returns size of payload, it's -1 for null payload, e.g. value of tombstone record
+func payloadSize(b []byte) int {
+	if b == nil {
+		return -1
+	}
+	return len(b)
+}
+
+// jsonString returns JSON rendering of decoded value for String methods
+func jsonString(v json.Marshaler) string {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("!(%s)", err)
+	}
+	return string(b)
+}
@@ -53,6 +53,11 @@ func (l *lengthField) check(curOffset int, buf []byte) error {
 	return nil
 }
 
+func (l *lengthField) run(curOffset int, buf []byte) error {
+	binary.BigEndian.PutUint32(buf[l.startOffset:], uint32(curOffset-l.startOffset-4))
+	return nil
+}
+
 type varintLengthField struct {
 	startOffset int
 	length      int64
@@ -73,6 +78,18 @@ func (l *varintLengthField) reserveLength() int {
 	return binary.PutVarint(tmp[:], l.length)
 }
 
+func (l *varintLengthField) adjustLength(currOffset int) int {
+	oldFieldSize := l.reserveLength()
+	l.length = int64(currOffset - l.startOffset - oldFieldSize)
+
+	return l.reserveLength() - oldFieldSize
+}
+
+func (l *varintLengthField) run(curOffset int, buf []byte) error {
+	binary.PutVarint(buf[l.startOffset:], l.length)
+	return nil
+}
+
 func (l *varintLengthField) check(curOffset int, _ []byte) error {
 	if int64(curOffset-l.startOffset-l.reserveLength()) != l.length {
 		return PacketDecodingError{"length field invalid"}
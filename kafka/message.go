@@ -1,6 +1,7 @@
 package kafka
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -34,13 +35,17 @@ type CompressionCodec int8
 
 // String returns string representation of CompressionCodec
 func (cc CompressionCodec) String() string {
-	return []string{
+	names := []string{
 		"none",
 		"gzip",
 		"snappy",
 		"lz4",
 		"zstd",
-	}[int(cc)]
+	}
+	if cc < 0 || int(cc) >= len(names) {
+		return fmt.Sprintf("unknown(%d)", cc)
+	}
+	return names[cc]
 }
 
 // Message is a kafka message type
@@ -54,7 +59,8 @@ type Message struct {
 	Version          int8             // v1 requires Kafka 0.10
 	Timestamp        time.Time        // the timestamp of the message (version 1+ only)
 
-	compressedSize int // used for computing the compression ratio metrics
+	compressedSize int  // used for computing the compression ratio metrics
+	wrapped        bool // the message is wrapped by compressed message, it can't be compressed itself
 }
 
 // Decode decodes message from packet
@@ -81,6 +87,7 @@ func (m *Message) Decode(pd PacketDecoder) (err error) {
 		return err
 	}
 	m.Codec = CompressionCodec(attribute & compressionCodecMask)
+	m.CompressionLevel = CompressionLevelDefault // level isn't known from the message, it's used by encoding
 	m.LogAppendTime = attribute&timestampTypeMask == timestampTypeMask
 
 	if m.Version == 1 {
@@ -89,6 +96,11 @@ func (m *Message) Decode(pd PacketDecoder) (err error) {
 		}
 	}
 
+	// keys and values are skipped in strict privacy mode, a compressed set is counted as one message
+	if StrictPrivacy() {
+		return m.skipPayload(pd)
+	}
+
 	m.Key, err = pd.getBytes()
 	if err != nil {
 		return err
@@ -111,6 +123,11 @@ func (m *Message) Decode(pd PacketDecoder) (err error) {
 			break
 		}
 
+		// kafka doesn't nest compressed messages, nested ones would multiply decompressed size
+		if m.wrapped {
+			return PacketDecodingError{"compressed message is wrapped by compressed message"}
+		}
+
 		m.Value, err = decompress(m.Codec, m.Value)
 		if err != nil {
 			return err
@@ -123,9 +140,107 @@ func (m *Message) Decode(pd PacketDecoder) (err error) {
 	return pd.pop()
 }
 
+// skipPayload skips key and value of the message keeping only size of the value
+func (m *Message) skipPayload(pd PacketDecoder) error {
+	if _, err := pd.getBytes(); err != nil {
+		return err
+	}
+
+	value, err := pd.getBytes()
+	if err != nil {
+		return err
+	}
+	m.compressedSize = len(value)
+
+	return pd.pop()
+}
+
 // decodes a message set from a previously encoded bulk-message
 func (m *Message) decodeSet() (err error) {
 	pd := RealDecoder{raw: m.Value}
-	m.Set = &MessageSet{}
+	m.Set = &MessageSet{wrapped: true}
 	return m.Set.Decode(&pd)
 }
+
+// Encode encodes message into packet, value of message wrapping message set is the compressed set
+func (m *Message) Encode(pe PacketEncoder) error {
+	crc32Encoder := acquireCrc32Field(crcIEEE)
+	defer releaseCrc32Field(crc32Encoder)
+	pe.push(crc32Encoder)
+
+	pe.putInt8(m.Version)
+
+	attributes := int8(m.Codec) & compressionCodecMask
+	if m.LogAppendTime {
+		attributes |= timestampTypeMask
+	}
+	pe.putInt8(attributes)
+
+	if m.Version >= 1 {
+		if err := (Timestamp{&m.Timestamp}).Encode(pe); err != nil {
+			return err
+		}
+	}
+
+	if err := pe.putBytes(m.Key); err != nil {
+		return err
+	}
+
+	value := m.Value
+	if m.Set != nil && m.Codec != CompressionNone {
+		set, err := Encode(m.Set)
+		if err != nil {
+			return err
+		}
+		value = set
+	}
+
+	var payload []byte
+	if value != nil {
+		var err error
+		if payload, err = compress(m.Codec, m.CompressionLevel, value); err != nil {
+			return err
+		}
+	}
+	if err := pe.putBytes(payload); err != nil {
+		return err
+	}
+
+	return pe.pop()
+}
+
+// MarshalJSON renders message, its key and value are redacted unless payloads are rendered. Message wrapping
+// compressed message set is rendered with the set instead of value.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	msg := struct {
+		Version       int8        `json:"version"`
+		Codec         string      `json:"codec"`
+		LogAppendTime bool        `json:"log_append_time"`
+		Timestamp     *time.Time  `json:"timestamp,omitempty"`
+		KeySize       int         `json:"key_size"`
+		Key           []byte      `json:"key,omitempty"`
+		ValueSize     int         `json:"value_size"`
+		Value         []byte      `json:"value,omitempty"`
+		Set           *MessageSet `json:"set,omitempty"`
+	}{
+		Version:       m.Version,
+		Codec:         m.Codec.String(),
+		LogAppendTime: m.LogAppendTime,
+		KeySize:       payloadSize(m.Key),
+		Key:           renderPayload(m.Key),
+		ValueSize:     payloadSize(m.Value),
+		Set:           m.Set,
+	}
+	if m.Version >= 1 {
+		msg.Timestamp = &m.Timestamp
+	}
+	if m.Set == nil {
+		msg.Value = renderPayload(m.Value)
+	}
+
+	return json.Marshal(msg)
+}
+
+func (m *Message) String() string {
+	return jsonString(m)
+}
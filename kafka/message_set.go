@@ -1,5 +1,7 @@
 package kafka
 
+import "encoding/json"
+
 // MessageBlock represents a part of request with message
 type MessageBlock struct {
 	Offset int64
@@ -16,7 +18,12 @@ func (msb *MessageBlock) Messages() []*MessageBlock {
 }
 
 // Decode decodes message block from packet
-func (msb *MessageBlock) Decode(pd PacketDecoder) (err error) {
+func (msb *MessageBlock) Decode(pd PacketDecoder) error {
+	return msb.decode(pd, false)
+}
+
+// decode decodes message block, wrapped is true for blocks of message set wrapped by compressed message
+func (msb *MessageBlock) decode(pd PacketDecoder, wrapped bool) (err error) {
 	if msb.Offset, err = pd.getInt64(); err != nil {
 		return err
 	}
@@ -28,7 +35,7 @@ func (msb *MessageBlock) Decode(pd PacketDecoder) (err error) {
 		return err
 	}
 
-	msb.Msg = new(Message)
+	msb.Msg = &Message{wrapped: wrapped}
 	if err = msb.Msg.Decode(pd); err != nil {
 		return err
 	}
@@ -40,11 +47,28 @@ func (msb *MessageBlock) Decode(pd PacketDecoder) (err error) {
 	return nil
 }
 
+// Encode encodes message block into packet
+func (msb *MessageBlock) Encode(pe PacketEncoder) error {
+	pe.putInt64(msb.Offset)
+
+	lengthEncoder := acquireLengthField()
+	defer releaseLengthField(lengthEncoder)
+	pe.push(lengthEncoder)
+
+	if err := msb.Msg.Encode(pe); err != nil {
+		return err
+	}
+
+	return pe.pop()
+}
+
 // MessageSet is a replacement for RecordBatch in older versions
 type MessageSet struct {
 	PartialTrailingMessage bool // whether the set on the wire contained an incomplete trailing MessageBlock
 	OverflowMessage        bool // whether the set on the wire contained an overflow message
 	Messages               []*MessageBlock
+
+	wrapped bool // the set is the value of compressed message
 }
 
 // Decode retrieves message set from packet
@@ -66,7 +90,7 @@ func (ms *MessageSet) Decode(pd PacketDecoder) (err error) {
 		}
 
 		msb := new(MessageBlock)
-		err = msb.Decode(pd)
+		err = msb.decode(pd, ms.wrapped)
 		switch err {
 		case nil:
 			ms.Messages = append(ms.Messages, msb)
@@ -87,3 +111,46 @@ func (ms *MessageSet) Decode(pd PacketDecoder) (err error) {
 
 	return nil
 }
+
+// Encode encodes message set into packet
+func (ms *MessageSet) Encode(pe PacketEncoder) error {
+	for _, msb := range ms.Messages {
+		if err := msb.Encode(pe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddMessage adds message to the set at the next offset
+func (ms *MessageSet) AddMessage(msg *Message) {
+	ms.Messages = append(ms.Messages, &MessageBlock{Offset: int64(len(ms.Messages)), Msg: msg})
+}
+
+// MarshalJSON renders message block
+func (msb *MessageBlock) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Offset  int64    `json:"offset"`
+		Message *Message `json:"message"`
+	}{
+		Offset:  msb.Offset,
+		Message: msb.Msg,
+	})
+}
+
+// MarshalJSON renders message set with its messages
+func (ms *MessageSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PartialTrailingMessage bool            `json:"partial_trailing_message,omitempty"`
+		OverflowMessage        bool            `json:"overflow_message,omitempty"`
+		Messages               []*MessageBlock `json:"messages"`
+	}{
+		PartialTrailingMessage: ms.PartialTrailingMessage,
+		OverflowMessage:        ms.OverflowMessage,
+		Messages:               ms.Messages,
+	})
+}
+
+func (ms *MessageSet) String() string {
+	return jsonString(ms)
+}
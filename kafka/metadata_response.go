@@ -0,0 +1,76 @@
+package kafka
+
+// MetadataKey is an api key of Metadata requests and responses
+const MetadataKey int16 = 3
+
+// MetadataBroker is a broker of the cluster described by Metadata response
+type MetadataBroker struct {
+	NodeID int32
+	Host   string
+	Port   int32
+	Rack   *string
+}
+
+// MetadataResponse (API key 3) describes brokers and topics of the cluster, only brokers are decoded
+type MetadataResponse struct {
+	Version      int16
+	ThrottleTime int32
+	Brokers      []*MetadataBroker
+}
+
+// Decode decodes brokers of kafka metadata response from packet, topics are left undecoded
+func (r *MetadataResponse) Decode(pd PacketDecoder, version int16) (err error) {
+	r.Version = version
+	flexible := r.Version >= 9
+
+	// response header v1 of flexible versions has tagged fields after correlation id
+	if flexible {
+		if err = pd.getTaggedFieldArray(); err != nil {
+			return err
+		}
+	}
+
+	if r.Version >= 3 {
+		if r.ThrottleTime, err = pd.getInt32(); err != nil {
+			return err
+		}
+	}
+
+	brokerCount, err := getFlexibleArrayLength(pd, flexible)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < brokerCount; i++ {
+		broker := &MetadataBroker{}
+		if broker.NodeID, err = pd.getInt32(); err != nil {
+			return err
+		}
+		if broker.Host, err = getFlexibleString(pd, flexible); err != nil {
+			return err
+		}
+		if broker.Port, err = pd.getInt32(); err != nil {
+			return err
+		}
+		if r.Version >= 1 {
+			if broker.Rack, err = getFlexibleNullableString(pd, flexible); err != nil {
+				return err
+			}
+		}
+		if flexible {
+			if err = pd.getTaggedFieldArray(); err != nil {
+				return err
+			}
+		}
+		r.Brokers = append(r.Brokers, broker)
+	}
+
+	pd.discard(pd.remaining())
+
+	return nil
+}
+
+// DecodeMetadataResponse decodes brokers of metadata response body of the version
+func DecodeMetadataResponse(body []byte, version int16) (*MetadataResponse, error) {
+	resp := &MetadataResponse{}
+	return resp, resp.Decode(&RealDecoder{raw: body}, version)
+}
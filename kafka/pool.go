@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"sync"
+)
+
+const (
+	// minPooledBuffer is a size of the smallest class of pooled buffers, each next class is 4 times larger
+	minPooledBuffer = 1 << 10
+
+	// bufferClasses is a count of classes of pooled buffers, the largest class is 4MB. Larger requests
+	// aren't pooled, their buffers grow with read bytes.
+	bufferClasses = 7
+)
+
+// bufferPools are pools of request buffers by size class
+var bufferPools [bufferClasses]sync.Pool
+
+// bufferClass returns class of buffer fitting length, it's -1 if buffer isn't pooled
+func bufferClass(length int) int {
+	size := minPooledBuffer
+	for class := 0; class < bufferClasses; class++ {
+		if length <= size {
+			return class
+		}
+		size <<= 2
+	}
+	return -1
+}
+
+// getBuffer returns buffer of length from pool, it's nil if buffer of the length isn't pooled
+func getBuffer(length int) *[]byte {
+	class := bufferClass(length)
+	if class < 0 {
+		return nil
+	}
+
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		*buf = (*buf)[:length]
+		return buf
+	}
+
+	buf := make([]byte, length, minPooledBuffer<<(2*uint(class)))
+	return &buf
+}
+
+// putBuffer returns buffer taken by getBuffer to pool
+func putBuffer(buf *[]byte) {
+	if buf == nil {
+		return
+	}
+	bufferPools[bufferClass(cap(*buf))].Put(buf)
+}
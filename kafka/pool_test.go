@@ -0,0 +1,154 @@
+package kafka
+
+import (
+	"bytes"
+	"testing"
+)
+
+// testProduceRequest returns encoded produce request of client with records of topic
+func testProduceRequest(tb testing.TB, clientID, transactionalID, topic string, records int) []byte {
+	tb.Helper()
+
+	p := &ProduceRequest{TransactionalID: &transactionalID, Version: 7, RequiredAcks: -1, Timeout: 1000}
+	p.AddBatch(topic, 0, testBatch(CompressionNone, records))
+	data, err := EncodeRequest(NewRequest(1, clientID, p))
+	if err != nil {
+		tb.Fatalf("could not encode request: %s", err)
+	}
+	return data
+}
+
+func TestBufferClass(t *testing.T) {
+	for _, tt := range []struct {
+		length, class int
+	}{
+		{1, 0},
+		{minPooledBuffer, 0},
+		{minPooledBuffer + 1, 1},
+		{4 << 20, bufferClasses - 1},
+		{4<<20 + 1, -1},
+	} {
+		if class := bufferClass(tt.length); class != tt.class {
+			t.Errorf("class of buffer of %d bytes is %d, want %d", tt.length, class, tt.class)
+		}
+	}
+}
+
+// TestReleasedBufferReuse decodes a request into buffer released by the previous request, fields of the previous
+// request which are safe to keep mustn't be changed by it
+func TestReleasedBufferReuse(t *testing.T) {
+	for _, zeroCopy := range []bool{false, true} {
+		name := "copy"
+		if zeroCopy {
+			name = "zero-copy"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			ZeroCopyDecode = zeroCopy
+			defer func() { ZeroCopyDecode = false }()
+
+			// requests have the same length, so the second one takes the buffer of the first one
+			first, _, err := DecodeRequest(bytes.NewReader(testProduceRequest(t, "producer-a", "tx-a", "orders", 3)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientID := first.ClientID
+			topics := first.Body.(*ProduceRequest).ExtractTopics()
+			transactionalID := *first.Body.(*ProduceRequest).TransactionalID
+			if !zeroCopy {
+				// strings decoded with copies are safe to keep after release
+				defer func() {
+					if transactionalID != "tx-a" {
+						t.Errorf("transactional id of released request is changed to %q", transactionalID)
+					}
+				}()
+			}
+
+			buf := first.buf
+			first.Release()
+
+			second, _, err := DecodeRequest(bytes.NewReader(testProduceRequest(t, "producer-b", "tx-b", "events", 3)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer second.Release()
+			if second.buf != buf {
+				t.Skip("released buffer isn't reused by pool")
+			}
+
+			// topics and client ids are interned, so they are safe to keep in zero copy mode too
+			if clientID != "producer-a" {
+				t.Errorf("client id of released request is changed to %q", clientID)
+			}
+			if len(topics) != 1 || topics[0] != "orders" {
+				t.Errorf("topics of released request are changed to %v", topics)
+			}
+			if second.ClientID != "producer-b" || second.Body.(*ProduceRequest).ExtractTopics()[0] != "events" {
+				t.Errorf("request decoded into reused buffer is corrupted: %s", second)
+			}
+		})
+	}
+}
+
+// TestUnreleasedRequestIsKept decodes a request while the previous request isn't released, the previous request
+// mustn't share its buffer
+func TestUnreleasedRequestIsKept(t *testing.T) {
+	ZeroCopyDecode = true
+	defer func() { ZeroCopyDecode = false }()
+
+	first, _, err := DecodeRequest(bytes.NewReader(testProduceRequest(t, "producer-a", "tx-a", "orders", 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Release()
+
+	second, _, err := DecodeRequest(bytes.NewReader(testProduceRequest(t, "producer-b", "tx-b", "events", 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Release()
+
+	if first.buf == second.buf {
+		t.Fatal("buffer of request which isn't released is reused")
+	}
+	if id := *first.Body.(*ProduceRequest).TransactionalID; id != "tx-a" {
+		t.Errorf("transactional id of kept request is changed to %q", id)
+	}
+}
+
+// BenchmarkDecodeRequest compares decoding of requests with buffers returned to pool by Release and without
+// releases, when every request allocates its buffer
+func BenchmarkDecodeRequest(b *testing.B) {
+	for _, bm := range []struct {
+		name    string
+		records int
+	}{
+		{"small", 1},
+		{"large", 200},
+	} {
+		data := testProduceRequest(b, "producer", "tx", "orders", bm.records)
+		for _, pooled := range []bool{true, false} {
+			name := bm.name + "/unpooled"
+			if pooled {
+				name = bm.name + "/pooled"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(data)))
+
+				r := bytes.NewReader(data)
+				for i := 0; i < b.N; i++ {
+					r.Reset(data)
+					req, _, err := DecodeRequest(r)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if pooled {
+						req.Release()
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// errStrictPrivacy is returned by decompression of records in strict privacy mode, it guards against code
+// paths which would read payloads
+var errStrictPrivacy = errors.New("records mustn't be decompressed in strict privacy mode")
+
+// strictPrivacy is 1 if keys and values of records are never decompressed or decoded, it's accessed atomically
+var strictPrivacy int32
+
+// SetStrictPrivacy enables or disables strict no-payload mode for regulated environments: record batches of
+// produce requests are decoded by headers only, counts and sizes of records are taken from the headers, keys,
+// values and headers of records are skipped without decompression. The mode is always enabled and can't be
+// disabled in builds with nopayload tag.
+func SetStrictPrivacy(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&strictPrivacy, v)
+}
+
+// StrictPrivacy returns true if strict no-payload mode is enabled by SetStrictPrivacy or nopayload build tag
+func StrictPrivacy() bool {
+	return strictPrivacyBuild || atomic.LoadInt32(&strictPrivacy) == 1
+}
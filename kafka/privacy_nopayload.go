@@ -0,0 +1,7 @@
+//go:build nopayload
+// +build nopayload
+
+package kafka
+
+// strictPrivacyBuild enforces strict no-payload mode in builds with nopayload tag
+const strictPrivacyBuild = true
@@ -0,0 +1,7 @@
+//go:build !nopayload
+// +build !nopayload
+
+package kafka
+
+// strictPrivacyBuild is false in regular builds, strict no-payload mode is enabled by SetStrictPrivacy
+const strictPrivacyBuild = false
@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var privacyCodecs = []CompressionCodec{CompressionNone, CompressionGZIP, CompressionSnappy, CompressionLZ4, CompressionZSTD}
+
+// decodeStrict encodes produce request and decodes it back in strict privacy mode
+func decodeStrict(t *testing.T, p *ProduceRequest) *ProduceRequest {
+	t.Helper()
+
+	data, err := EncodeRequest(NewRequest(1, "privacy", p))
+	if err != nil {
+		t.Fatalf("could not encode request: %s", err)
+	}
+
+	SetStrictPrivacy(true)
+	defer SetStrictPrivacy(false)
+
+	req, _, err := DecodeRequest(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not decode request: %s", err)
+	}
+	return req.Body.(*ProduceRequest)
+}
+
+func testBatch(codec CompressionCodec, n int) *RecordBatch {
+	ts := time.Unix(1600000000, 0)
+	b := &RecordBatch{
+		Version:          2,
+		Codec:            codec,
+		CompressionLevel: CompressionLevelDefault,
+		FirstTimestamp:   ts,
+		MaxTimestamp:     ts,
+		ProducerID:       -1,
+		ProducerEpoch:    -1,
+		FirstSequence:    -1,
+		LastOffsetDelta:  int32(n - 1),
+	}
+	for i := 0; i < n; i++ {
+		b.AddRecord(&Record{
+			OffsetDelta: int64(i),
+			Key:         []byte("secret-key"),
+			Value:       bytes.Repeat([]byte("secret-value"), 20),
+			Headers:     []*RecordHeader{{Key: []byte("h"), Value: []byte("secret-header")}},
+		})
+	}
+	return b
+}
+
+func testMessageSet(codec CompressionCodec, n int) *MessageSet {
+	ts := time.Unix(1600000000, 0)
+	set := &MessageSet{}
+	for i := 0; i < n; i++ {
+		set.AddMessage(&Message{Version: 1, Timestamp: ts, Key: []byte("secret-key"), Value: []byte("secret-value")})
+	}
+	if codec == CompressionNone {
+		return set
+	}
+
+	wrapper := &MessageSet{}
+	wrapper.AddMessage(&Message{Version: 1, Timestamp: ts, Codec: codec, CompressionLevel: CompressionLevelDefault, Set: set})
+	return wrapper
+}
+
+func TestStrictPrivacyRecordBatch(t *testing.T) {
+	for _, codec := range privacyCodecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			p := &ProduceRequest{Version: 7, RequiredAcks: -1, Timeout: 1000}
+			p.AddBatch("orders", 0, testBatch(codec, 3))
+			got := decodeStrict(t, p)
+
+			records := got.records["orders"][0]
+			batch := records.RecordBatch
+			if batch == nil {
+				t.Fatal("record batch isn't decoded")
+			}
+			if batch.Records != nil {
+				t.Errorf("records are decoded: %d records", len(batch.Records))
+			}
+			if !batch.headerOnly {
+				t.Error("batch isn't decoded by header only")
+			}
+
+			// count of records is taken from the header, size is a size of compressed records: size of records
+			// of partition without offset, length and the rest of the header
+			if n := got.RecordsLen(); n != 3 {
+				t.Errorf("records count %d, want 3", n)
+			}
+			if size, want := got.RecordsSize(), records.size-12-recordBatchOverhead; size != want || size <= 0 {
+				t.Errorf("records size %d, want %d", size, want)
+			}
+
+			got.ProducedRecords(func(r ProducedRecord) {
+				t.Errorf("record of topic %s is produced in strict privacy mode", r.Topic)
+			})
+			if _, err := EncodeRequest(NewRequest(1, "privacy", got)); err == nil {
+				t.Error("batch decoded by header is encoded")
+			}
+		})
+	}
+}
+
+func TestStrictPrivacyMessageSet(t *testing.T) {
+	for _, codec := range privacyCodecs[:3] {
+		t.Run(codec.String(), func(t *testing.T) {
+			p := &ProduceRequest{Version: 2, RequiredAcks: 1, Timeout: 1000}
+			p.AddSet("clicks", 0, testMessageSet(codec, 2))
+			got := decodeStrict(t, p)
+
+			set := got.records["clicks"][0].MsgSet
+			if set == nil {
+				t.Fatal("message set isn't decoded")
+			}
+
+			// compressed set is counted as one message, its size is a size of compressed value
+			want := 2
+			if codec != CompressionNone {
+				want = 1
+			}
+			if len(set.Messages) != want {
+				t.Fatalf("messages count %d, want %d", len(set.Messages), want)
+			}
+			if n := got.RecordsLen(); n != want {
+				t.Errorf("records count %d, want %d", n, want)
+			}
+
+			for _, block := range set.Messages {
+				msg := block.Msg
+				if msg.Key != nil || msg.Value != nil || msg.Set != nil {
+					t.Errorf("payload of message is decoded: key %q, value %q, set %v", msg.Key, msg.Value, msg.Set != nil)
+				}
+				if msg.compressedSize <= 0 {
+					t.Errorf("size of message isn't taken from the message: %d", msg.compressedSize)
+				}
+				if codec == CompressionNone && msg.compressedSize != len("secret-value") {
+					t.Errorf("size of value %d, want %d", msg.compressedSize, len("secret-value"))
+				}
+			}
+
+			got.ProducedRecords(func(r ProducedRecord) {
+				if r.Key != nil || r.Value != nil {
+					t.Errorf("payload of record of topic %s is produced in strict privacy mode", r.Topic)
+				}
+			})
+		})
+	}
+}
+
+func TestStrictPrivacyDecompress(t *testing.T) {
+	SetStrictPrivacy(true)
+	defer SetStrictPrivacy(false)
+
+	for _, codec := range privacyCodecs {
+		if _, err := decompress(codec, []byte("payload")); err != errStrictPrivacy {
+			t.Errorf("decompression of %s returns %v, want %v", codec, err, errStrictPrivacy)
+		}
+	}
+}
+
+func TestStrictPrivacyRendering(t *testing.T) {
+	RenderPayloads = true
+	defer func() { RenderPayloads = false }()
+
+	SetStrictPrivacy(true)
+	defer SetStrictPrivacy(false)
+
+	if b := renderPayload([]byte("secret")); b != nil {
+		t.Errorf("payload %q is rendered in strict privacy mode", b)
+	}
+}
@@ -0,0 +1,91 @@
+package kafka
+
+// ProduceKey is an api key of Produce requests and responses
+const ProduceKey int16 = 0
+
+// ProduceResponse (API key 0) contains results of writes to partitions, only error codes are decoded
+type ProduceResponse struct {
+	Version      int16
+	Partitions   []PartitionResult
+	ThrottleTime int32
+}
+
+// Decode decodes results of partitions of kafka produce response from packet
+func (r *ProduceResponse) Decode(pd PacketDecoder, version int16) (err error) {
+	r.Version = version
+
+	topicCount, err := pd.getArrayLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < topicCount; i++ {
+		var topic string
+		if topic, err = pd.getString(); err != nil {
+			return err
+		}
+
+		var partitionCount int
+		if partitionCount, err = pd.getArrayLength(); err != nil {
+			return err
+		}
+		for j := 0; j < partitionCount; j++ {
+			result := PartitionResult{Topic: topic}
+			if result.Partition, err = pd.getInt32(); err != nil {
+				return err
+			}
+			if result.ErrorCode, err = pd.getInt16(); err != nil {
+				return err
+			}
+
+			// base offset, log append time and log start offset
+			if _, err = pd.getInt64(); err != nil {
+				return err
+			}
+			if r.Version >= 2 {
+				if _, err = pd.getInt64(); err != nil {
+					return err
+				}
+			}
+			if r.Version >= 5 {
+				if _, err = pd.getInt64(); err != nil {
+					return err
+				}
+			}
+
+			// errors of records rejected by broker and error message
+			if r.Version >= 8 {
+				var recordErrorCount int
+				if recordErrorCount, err = pd.getArrayLength(); err != nil {
+					return err
+				}
+				for k := 0; k < recordErrorCount; k++ {
+					if _, err = pd.getInt32(); err != nil {
+						return err
+					}
+					if _, err = pd.getNullableString(); err != nil {
+						return err
+					}
+				}
+				if _, err = pd.getNullableString(); err != nil {
+					return err
+				}
+			}
+
+			r.Partitions = append(r.Partitions, result)
+		}
+	}
+
+	if r.Version >= 1 {
+		if r.ThrottleTime, err = pd.getInt32(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeProduceResponse decodes results of partitions of produce response body of the version
+func DecodeProduceResponse(body []byte, version int16) (*ProduceResponse, error) {
+	resp := &ProduceResponse{}
+	return resp, resp.Decode(&RealDecoder{raw: body}, version)
+}
@@ -1,6 +1,7 @@
 package kafka
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -27,6 +28,27 @@ func (h *RecordHeader) Decode(pd PacketDecoder) (err error) {
 	return nil
 }
 
+// Encode encodes record header into packet
+func (h *RecordHeader) Encode(pe PacketEncoder) error {
+	if err := pe.putVarintBytes(h.Key); err != nil {
+		return err
+	}
+	return pe.putVarintBytes(h.Value)
+}
+
+// MarshalJSON renders header, its value is redacted unless payloads are rendered
+func (h *RecordHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Key       string `json:"key"`
+		ValueSize int    `json:"value_size"`
+		Value     []byte `json:"value,omitempty"`
+	}{
+		Key:       string(h.Key),
+		ValueSize: payloadSize(h.Value),
+		Value:     renderPayload(h.Value),
+	})
+}
+
 // Record is kafka record type
 type Record struct {
 	Headers []*RecordHeader
@@ -72,6 +94,10 @@ func (r *Record) Decode(pd PacketDecoder) (err error) {
 		return err
 	}
 
+	// every header has at least 2 bytes of lengths of key and value, so huge counts aren't allocated
+	if numHeaders > int64(pd.remaining()/2) {
+		return ErrInsufficientData
+	}
 	if numHeaders >= 0 {
 		r.Headers = make([]*RecordHeader, numHeaders)
 	}
@@ -85,3 +111,54 @@ func (r *Record) Decode(pd PacketDecoder) (err error) {
 
 	return pd.pop()
 }
+
+// Encode encodes record into packet, its length is computed
+func (r *Record) Encode(pe PacketEncoder) error {
+	pe.push(&r.length)
+	pe.putInt8(r.Attributes)
+	pe.putVarint(int64(r.TimestampDelta / time.Millisecond))
+	pe.putVarint(r.OffsetDelta)
+	if err := pe.putVarintBytes(r.Key); err != nil {
+		return err
+	}
+	if err := pe.putVarintBytes(r.Value); err != nil {
+		return err
+	}
+	pe.putVarint(int64(len(r.Headers)))
+
+	for _, h := range r.Headers {
+		if err := h.Encode(pe); err != nil {
+			return err
+		}
+	}
+
+	return pe.pop()
+}
+
+// MarshalJSON renders record, its key and value are redacted unless payloads are rendered. Sizes of null key
+// and value are -1.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Attributes     int8            `json:"attributes"`
+		TimestampDelta int64           `json:"timestamp_delta_ms"`
+		OffsetDelta    int64           `json:"offset_delta"`
+		KeySize        int             `json:"key_size"`
+		Key            []byte          `json:"key,omitempty"`
+		ValueSize      int             `json:"value_size"`
+		Value          []byte          `json:"value,omitempty"`
+		Headers        []*RecordHeader `json:"headers,omitempty"`
+	}{
+		Attributes:     r.Attributes,
+		TimestampDelta: int64(r.TimestampDelta / time.Millisecond),
+		OffsetDelta:    r.OffsetDelta,
+		KeySize:        payloadSize(r.Key),
+		Key:            renderPayload(r.Key),
+		ValueSize:      payloadSize(r.Value),
+		Value:          renderPayload(r.Value),
+		Headers:        r.Headers,
+	})
+}
+
+func (r *Record) String() string {
+	return jsonString(r)
+}
@@ -1,6 +1,8 @@
 package kafka
 
 import (
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -19,6 +21,15 @@ func (e recordsArray) Decode(pd PacketDecoder) error {
 	return nil
 }
 
+func (e recordsArray) Encode(pe PacketEncoder) error {
+	for _, r := range e {
+		if err := r.Encode(pe); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RecordBatch are records from one kafka request
 type RecordBatch struct {
 	FirstOffset           int64
@@ -39,6 +50,25 @@ type RecordBatch struct {
 	IsTransactional       bool
 
 	recordsLen int // uncompressed records size
+
+	// headerOnly is true if records are skipped in strict privacy mode, count of records is taken from
+	// the header then and size of records is a compressed one
+	headerOnly   bool
+	recordsCount int
+}
+
+// count returns count of records of the batch
+func (b *RecordBatch) count() int {
+	if b.headerOnly {
+		return b.recordsCount
+	}
+	return len(b.Records)
+}
+
+// AddRecord adds record to the batch, offset delta of the record and last offset delta of the batch aren't
+// changed
+func (b *RecordBatch) AddRecord(r *Record) {
+	b.Records = append(b.Records, r)
 }
 
 func (b *RecordBatch) decode(pd PacketDecoder) (err error) {
@@ -71,6 +101,7 @@ func (b *RecordBatch) decode(pd PacketDecoder) (err error) {
 		return err
 	}
 	b.Codec = CompressionCodec(int8(attributes) & compressionCodecMask)
+	b.CompressionLevel = CompressionLevelDefault // level isn't known from the batch, it's used by encoding
 	b.Control = attributes&controlMask == controlMask
 	b.LogAppendTime = attributes&timestampTypeMask == timestampTypeMask
 	b.IsTransactional = attributes&isTransactionalMask == isTransactionalMask
@@ -103,7 +134,8 @@ func (b *RecordBatch) decode(pd PacketDecoder) (err error) {
 	if err != nil {
 		return err
 	}
-	if numRecs >= 0 {
+	b.headerOnly = StrictPrivacy()
+	if numRecs >= 0 && !b.headerOnly {
 		b.Records = make([]*Record, numRecs)
 	}
 
@@ -122,6 +154,15 @@ func (b *RecordBatch) decode(pd PacketDecoder) (err error) {
 		return err
 	}
 
+	// keys and values of records aren't touched in strict privacy mode
+	if b.headerOnly {
+		if numRecs > 0 {
+			b.recordsCount = numRecs
+		}
+		b.recordsLen = len(recBuffer)
+		return nil
+	}
+
 	recBuffer, err = decompress(b.Codec, recBuffer)
 	if err != nil {
 		return err
@@ -136,3 +177,123 @@ func (b *RecordBatch) decode(pd PacketDecoder) (err error) {
 	}
 	return err
 }
+
+func (b *RecordBatch) encode(pe PacketEncoder) error {
+	if b.Version != 2 {
+		return PacketEncodingError{fmt.Sprintf("unsupported record batch version (%d)", b.Version)}
+	}
+	if b.headerOnly {
+		return PacketEncodingError{"records of batch aren't decoded in strict privacy mode"}
+	}
+
+	pe.putInt64(b.FirstOffset)
+
+	length := acquireLengthField()
+	defer releaseLengthField(length)
+	pe.push(length)
+
+	pe.putInt32(b.PartitionLeaderEpoch)
+	pe.putInt8(b.Version)
+
+	crc32Encoder := acquireCrc32Field(crcCastagnoli)
+	defer releaseCrc32Field(crc32Encoder)
+	pe.push(crc32Encoder)
+
+	pe.putInt16(b.computeAttributes())
+	pe.putInt32(b.LastOffsetDelta)
+
+	if err := (Timestamp{&b.FirstTimestamp}).Encode(pe); err != nil {
+		return err
+	}
+
+	if err := (Timestamp{&b.MaxTimestamp}).Encode(pe); err != nil {
+		return err
+	}
+
+	pe.putInt64(b.ProducerID)
+	pe.putInt16(b.ProducerEpoch)
+	pe.putInt32(b.FirstSequence)
+
+	if err := pe.putArrayLength(len(b.Records)); err != nil {
+		return err
+	}
+
+	// records are encoded and compressed by both passes of encoding, so changes of records between
+	// encodings aren't missed
+	raw, err := Encode(recordsArray(b.Records))
+	if err != nil {
+		return err
+	}
+	compressed, err := compress(b.Codec, b.CompressionLevel, raw)
+	if err != nil {
+		return err
+	}
+	if err := pe.putRawBytes(compressed); err != nil {
+		return err
+	}
+
+	if err := pe.pop(); err != nil {
+		return err
+	}
+	return pe.pop()
+}
+
+func (b *RecordBatch) computeAttributes() int16 {
+	attr := int16(b.Codec) & int16(compressionCodecMask)
+	if b.Control {
+		attr |= controlMask
+	}
+	if b.LogAppendTime {
+		attr |= timestampTypeMask
+	}
+	if b.IsTransactional {
+		attr |= isTransactionalMask
+	}
+	return attr
+}
+
+// MarshalJSON renders batch with its records, records aren't rendered in strict privacy mode. Size of records
+// is the uncompressed one of decoded batches.
+func (b *RecordBatch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FirstOffset           int64     `json:"first_offset"`
+		PartitionLeaderEpoch  int32     `json:"partition_leader_epoch"`
+		Version               int8      `json:"version"`
+		Codec                 string    `json:"codec"`
+		Control               bool      `json:"control"`
+		Transactional         bool      `json:"transactional"`
+		LogAppendTime         bool      `json:"log_append_time"`
+		LastOffsetDelta       int32     `json:"last_offset_delta"`
+		FirstTimestamp        time.Time `json:"first_timestamp"`
+		MaxTimestamp          time.Time `json:"max_timestamp"`
+		ProducerID            int64     `json:"producer_id"`
+		ProducerEpoch         int16     `json:"producer_epoch"`
+		FirstSequence         int32     `json:"first_sequence"`
+		RecordsCount          int       `json:"records_count"`
+		RecordsSize           int       `json:"records_size"`
+		PartialTrailingRecord bool      `json:"partial_trailing_record,omitempty"`
+		Records               []*Record `json:"records,omitempty"`
+	}{
+		FirstOffset:           b.FirstOffset,
+		PartitionLeaderEpoch:  b.PartitionLeaderEpoch,
+		Version:               b.Version,
+		Codec:                 b.Codec.String(),
+		Control:               b.Control,
+		Transactional:         b.IsTransactional,
+		LogAppendTime:         b.LogAppendTime,
+		LastOffsetDelta:       b.LastOffsetDelta,
+		FirstTimestamp:        b.FirstTimestamp,
+		MaxTimestamp:          b.MaxTimestamp,
+		ProducerID:            b.ProducerID,
+		ProducerEpoch:         b.ProducerEpoch,
+		FirstSequence:         b.FirstSequence,
+		RecordsCount:          b.count(),
+		RecordsSize:           b.recordsLen,
+		PartialTrailingRecord: b.PartialTrailingRecord,
+		Records:               b.Records,
+	})
+}
+
+func (b *RecordBatch) String() string {
+	return jsonString(b)
+}
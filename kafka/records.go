@@ -1,6 +1,9 @@
 package kafka
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 const (
 	unknownRecords = iota
@@ -15,6 +18,17 @@ type Records struct {
 	recordsType int
 	MsgSet      *MessageSet
 	RecordBatch *RecordBatch
+
+	// size is a size of encoded records in decoded request, it's known in shallow decoded requests too
+	size int
+}
+
+func newLegacyRecords(msgSet *MessageSet) Records {
+	return Records{recordsType: legacyRecords, MsgSet: msgSet}
+}
+
+func newDefaultRecords(batch *RecordBatch) Records {
+	return Records{recordsType: defaultRecords, RecordBatch: batch}
 }
 
 func (r *Records) setTypeFromMagic(pd PacketDecoder) error {
@@ -52,3 +66,35 @@ func (r *Records) decode(pd PacketDecoder) error {
 	}
 	return fmt.Errorf("unknown records type: %v", r.recordsType)
 }
+
+func (r *Records) encode(pe PacketEncoder) error {
+	switch r.recordsType {
+	case legacyRecords:
+		if r.MsgSet == nil {
+			return nil
+		}
+		return r.MsgSet.Encode(pe)
+	case defaultRecords:
+		if r.RecordBatch == nil {
+			return nil
+		}
+		return r.RecordBatch.encode(pe)
+	}
+	return PacketEncodingError{fmt.Sprintf("unknown records type: %v", r.recordsType)}
+}
+
+// MarshalJSON renders records as the record batch or the legacy message set, records of shallow decoded
+// requests are null
+func (r Records) MarshalJSON() ([]byte, error) {
+	switch r.recordsType {
+	case legacyRecords:
+		return json.Marshal(r.MsgSet)
+	case defaultRecords:
+		return json.Marshal(r.RecordBatch)
+	}
+	return []byte("null"), nil
+}
+
+func (r Records) String() string {
+	return jsonString(r)
+}
@@ -1,10 +1,13 @@
 package kafka
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/d-ulyanov/kafka-sniffer/metrics"
 )
@@ -14,12 +17,23 @@ var (
 	MaxRequestSize int32 = 100 * 1024 * 1024
 )
 
+// ZeroCopyDecode enables zero copy decoding of produce and fetch requests: their strings refer to buffer of
+// the request instead of being copied. Such strings and all bytes of the request are valid until the request
+// is released with Release, they must be copied to be kept longer. Topics and client ids are interned and
+// are always safe to keep.
+var ZeroCopyDecode bool
+
+// initialRequestBuffer is a size of buffer allocated for request before its bytes are read
+const initialRequestBuffer = 64 << 10
+
 // ProtocolBody represents body of kafka request
 type ProtocolBody interface {
 	versionedDecoder
+	versionedEncoder
 	metrics.ClientMetricsCollector
 	key() int16
 	version() int16
+	headerVersion() int16
 	requiredVersion() Version
 }
 
@@ -39,9 +53,37 @@ type Request struct {
 
 	ClientID string
 
+	// Body is nil for requests of apis which aren't decoded, only their header is decoded
 	Body ProtocolBody
 
 	UsePreparedKeyVersion bool
+
+	// headerOnly disables decoding of body, e.g. for requests skipped by sampling
+	headerOnly bool
+
+	// buf is a pooled buffer of encoded request, decoded body may refer to it
+	buf *[]byte
+}
+
+// NewRequest creates request of client with body, api key and version of the request are the ones of body
+func NewRequest(correlationID int32, clientID string, body ProtocolBody) *Request {
+	return &Request{
+		Key:           body.key(),
+		Version:       body.version(),
+		CorrelationID: correlationID,
+		ClientID:      clientID,
+		Body:          body,
+	}
+}
+
+// Release returns buffer of the request to pool, the request and its body mustn't be used after the call.
+// Requests which aren't released are collected as usual.
+func (r *Request) Release() {
+	if r == nil {
+		return
+	}
+	putBuffer(r.buf)
+	r.buf = nil
 }
 
 // Decode decodes request from packet
@@ -65,17 +107,21 @@ func (r *Request) Decode(pd PacketDecoder) (err error) {
 		return err
 	}
 
-	r.ClientID, err = pd.getString() // +2 + len(r.ClientID) bytes
+	r.ClientID, err = pd.getInternedString() // +2 + len(r.ClientID) bytes
 	if err != nil {
 		return err
 	}
 
+	if r.headerOnly {
+		pd.discard(pd.remaining())
+		return nil
+	}
+
 	body := allocateBody(r.Key, r.Version)
 
 	// If  we can't (don't want) to unmarshal request structure - we need to discard the rest bytes
 	if body == nil {
-		// discard 10 bytes + clientID length
-		pd.discard(int(r.BodyLength) - 10 - len(r.ClientID))
+		pd.discard(pd.remaining())
 
 		// Skip Body decoding for now
 		return nil
@@ -86,9 +132,83 @@ func (r *Request) Decode(pd PacketDecoder) (err error) {
 		return PacketDecodingError{fmt.Sprintf("unknown Request key (%d)", r.Key)}
 	}
 
+	// request header v2 is used by flexible versions and has tagged fields after clientID
+	if r.Body.headerVersion() >= 2 {
+		if err = pd.getTaggedFieldArray(); err != nil {
+			return err
+		}
+	}
+
 	return r.Body.Decode(pd, r.Version)
 }
 
+// Encode encodes request into packet, its length isn't encoded (see EncodeRequest). Requests without body,
+// e.g. of apis which aren't decoded, are encoded with header only.
+func (r *Request) Encode(pe PacketEncoder) error {
+	pe.putInt16(r.Key)
+	pe.putInt16(r.Version)
+	pe.putInt32(r.CorrelationID)
+	if err := pe.putString(r.ClientID); err != nil {
+		return err
+	}
+
+	if r.Body == nil {
+		return nil
+	}
+
+	// request header v2 is used by flexible versions and has tagged fields after clientID
+	if r.Body.headerVersion() >= 2 {
+		pe.putEmptyTaggedFieldArray()
+	}
+
+	return r.Body.Encode(pe, r.Version)
+}
+
+// MarshalJSON renders request header and body, body of requests of apis which aren't decoded is null. Payloads
+// are redacted unless they are rendered by RenderPayloads.
+func (r *Request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		APIKey        int16        `json:"api_key"`
+		APIName       string       `json:"api_name"`
+		APIVersion    int16        `json:"api_version"`
+		CorrelationID int32        `json:"correlation_id"`
+		ClientID      string       `json:"client_id"`
+		Body          ProtocolBody `json:"body"`
+	}{
+		APIKey:        r.Key,
+		APIName:       APIKeyName(r.Key),
+		APIVersion:    r.Version,
+		CorrelationID: r.CorrelationID,
+		ClientID:      r.ClientID,
+		Body:          r.Body,
+	})
+}
+
+func (r *Request) String() string {
+	return jsonString(r)
+}
+
+// lengthPrefixedRequest is a request encoded with its length, as it's sent by clients
+type lengthPrefixedRequest struct {
+	*Request
+}
+
+func (r lengthPrefixedRequest) Encode(pe PacketEncoder) error {
+	length := acquireLengthField()
+	defer releaseLengthField(length)
+
+	pe.push(length)
+	if err := r.Request.Encode(pe); err != nil {
+		return err
+	}
+	return pe.pop()
+}
+
+// EncodeRequest encodes request with its length in wire format, as it's read by DecodeRequest
+func EncodeRequest(r *Request) ([]byte, error) {
+	return Encode(lengthPrefixedRequest{r})
+}
+
 // DecodeLength decodes length from packet
 func DecodeLength(encoded []byte) int32 {
 	return int32(binary.BigEndian.Uint32(encoded[:4]))
@@ -104,8 +224,18 @@ func DecodeVersion(encoded []byte) int16 {
 	return int16(binary.BigEndian.Uint16(encoded[6:]))
 }
 
-// DecodeRequest decodes request from packets delivered by reader
+// DecodeRequest decodes request from packets delivered by reader. It returns count of read bytes, the whole
+// request is read unless its length is invalid. Only header is decoded for requests of unsupported apis.
 func DecodeRequest(r io.Reader) (*Request, int, error) {
+	return decodeRequest(r, false)
+}
+
+// DecodeRequestHeader reads request like DecodeRequest, but decodes only its header, body of the request is nil
+func DecodeRequestHeader(r io.Reader) (*Request, int, error) {
+	return decodeRequest(r, true)
+}
+
+func decodeRequest(r io.Reader, headerOnly bool) (*Request, int, error) {
 	var (
 		needReadBytes = 8
 		readBytes     = make([]byte, needReadBytes)
@@ -123,20 +253,33 @@ func DecodeRequest(r io.Reader) (*Request, int, error) {
 	key := DecodeKey(readBytes)
 	version := DecodeVersion(readBytes)
 
-	// check request type
-	if protocol := allocateBody(key, version); protocol == nil {
-		return nil, int(length), PacketDecodingError{fmt.Sprintf("unsupported protocol with key: %d", key)}
-	}
-
 	// check request size
 	if length <= 4 || length > MaxRequestSize {
-		return nil, int(length), PacketDecodingError{fmt.Sprintf("message of length %d too large or too small", length)}
+		return nil, needReadBytes, PacketDecodingError{fmt.Sprintf("message of length %d too large or too small", length)}
 	}
 
-	// read full request
-	encodedReq := make([]byte, length)
-	if _, err := io.ReadFull(r, encodedReq); err != nil {
-		return nil, int(length), err
+	// read full request into pooled buffer, buffer of large request grows with read bytes, so length of
+	// incomplete request doesn't allocate memory
+	var encodedReq []byte
+	buf := getBuffer(int(length))
+	if buf != nil {
+		if _, err := io.ReadFull(r, *buf); err != nil {
+			putBuffer(buf)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, int(length), err
+		}
+		encodedReq = *buf
+	} else {
+		body := bytes.NewBuffer(make([]byte, 0, minInt(int(length), initialRequestBuffer)))
+		if _, err := io.CopyN(body, r, int64(length)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, int(length), err
+		}
+		encodedReq = body.Bytes()
 	}
 
 	bytesRead := needReadBytes + len(encodedReq)
@@ -145,22 +288,47 @@ func DecodeRequest(r io.Reader) (*Request, int, error) {
 		Key:                   key,
 		Version:               version,
 		UsePreparedKeyVersion: true,
+		headerOnly:            headerOnly,
+		buf:                   buf,
 	}
 
-	// decode request
-	if err := Decode(encodedReq, req); err != nil {
+	// decode request, time of reading isn't counted since it's spent on waiting for packets
+	start := time.Now()
+	err := decode(encodedReq, req, ZeroCopyDecode && isZeroCopyKey(key))
+	metrics.InternalStageDuration.WithLabelValues("decode").Observe(time.Since(start).Seconds())
+	if err != nil {
+		putBuffer(buf)
 		return nil, bytesRead, err
 	}
 
 	return req, bytesRead, nil
 }
 
+// isZeroCopyKey returns true if requests of api key are decoded without copies in zero copy mode, they are
+// requests of the hot path: produce and fetch
+func isZeroCopyKey(key int16) bool {
+	return key == 0 || key == 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// CountRequest collects metrics of request which body isn't decoded, e.g. skipped by sampling, metrics of
+// its body are collected as for empty body
+func CountRequest(srcHost string, key, version int16) {
+	if body := allocateBody(key, version); body != nil {
+		body.CollectClientMetrics(srcHost)
+	}
+}
+
+// allocateBody allocates body of request by registry of api keys, it's nil for requests which aren't decoded
 func allocateBody(key, version int16) ProtocolBody {
-	switch key {
-	case 0:
-		return &ProduceRequest{}
-	case 1:
-		return &FetchRequest{Version: version}
+	if k, ok := apiKeysByKey[key]; ok && k.newBody != nil {
+		return k.newBody(version)
 	}
 	return nil
 }
@@ -1,6 +1,11 @@
 package kafka
 
 import (
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
 	"github.com/d-ulyanov/kafka-sniffer/metrics"
 )
 
@@ -11,6 +16,23 @@ import (
 // by setting the `min.isr` value in the brokers configuration).
 type RequiredAcks int16
 
+// shallowDecode is 1 if records of produce requests aren't decoded, it's accessed atomically
+var shallowDecode int32
+
+// SetShallowDecode enables or disables decoding of records of produce requests, only topics are decoded in
+// shallow mode and record counts and sizes aren't known. It may be changed at any time, e.g. by watchdog.
+func SetShallowDecode(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&shallowDecode, v)
+}
+
+func isShallowDecode() bool {
+	return atomic.LoadInt32(&shallowDecode) == 1
+}
+
 // ProduceRequest is a type of request in kafka
 type ProduceRequest struct {
 	TransactionalID *string
@@ -18,11 +40,15 @@ type ProduceRequest struct {
 	Timeout         int32
 	Version         int16 // v1 requires Kafka 0.9, v2 requires Kafka 0.10, v3 requires Kafka 0.11
 	records         map[string]map[int32]Records
+
+	// shallow is true if records aren't decoded
+	shallow bool
 }
 
 // Decode decodes kafka produce request from packet
 func (r *ProduceRequest) Decode(pd PacketDecoder, version int16) error {
 	r.Version = version
+	r.shallow = isShallowDecode()
 
 	if version >= 3 {
 		id, err := pd.getNullableString()
@@ -49,7 +75,7 @@ func (r *ProduceRequest) Decode(pd PacketDecoder, version int16) error {
 
 	r.records = make(map[string]map[int32]Records)
 	for i := 0; i < topicCount; i++ {
-		topic, err := pd.getString()
+		topic, err := pd.getInternedString()
 		if err != nil {
 			return err
 		}
@@ -69,6 +95,14 @@ func (r *ProduceRequest) Decode(pd PacketDecoder, version int16) error {
 				return err
 			}
 
+			if r.shallow {
+				if _, err := pd.getRawBytes(int(size)); err != nil {
+					return err
+				}
+				r.records[topic][partition] = Records{size: int(size)}
+				continue
+			}
+
 			// rewind decoder to size
 			recordsDecoder, err := pd.getSubset(int(size))
 			if err != nil {
@@ -78,6 +112,7 @@ func (r *ProduceRequest) Decode(pd PacketDecoder, version int16) error {
 			if err := records.decode(recordsDecoder); err != nil {
 				return err
 			}
+			records.size = int(size)
 			r.records[topic][partition] = records
 		}
 	}
@@ -85,6 +120,72 @@ func (r *ProduceRequest) Decode(pd PacketDecoder, version int16) error {
 	return nil
 }
 
+// Encode encodes kafka produce request into packet, records of shallow decoded requests can't be encoded
+func (r *ProduceRequest) Encode(pe PacketEncoder, version int16) error {
+	if r.shallow {
+		return PacketEncodingError{"records of shallow decoded produce request can't be encoded"}
+	}
+
+	if version >= 3 {
+		if err := pe.putNullableString(r.TransactionalID); err != nil {
+			return err
+		}
+	}
+	pe.putInt16(int16(r.RequiredAcks))
+	pe.putInt32(r.Timeout)
+	if err := pe.putArrayLength(len(r.records)); err != nil {
+		return err
+	}
+
+	for topic, partitions := range r.records {
+		if err := pe.putString(topic); err != nil {
+			return err
+		}
+		if err := pe.putArrayLength(len(partitions)); err != nil {
+			return err
+		}
+
+		for partition, records := range partitions {
+			pe.putInt32(partition)
+
+			length := acquireLengthField()
+			pe.push(length)
+			err := records.encode(pe)
+			if err == nil {
+				err = pe.pop()
+			}
+			releaseLengthField(length)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AddBatch sets records of topic partition to the batch of records, it's used by requests of version 3 and
+// newer
+func (r *ProduceRequest) AddBatch(topic string, partition int32, batch *RecordBatch) {
+	r.ensureRecords(topic)
+	r.records[topic][partition] = newDefaultRecords(batch)
+}
+
+// AddSet sets records of topic partition to the legacy message set, it's used by requests of versions before 3
+func (r *ProduceRequest) AddSet(topic string, partition int32, set *MessageSet) {
+	r.ensureRecords(topic)
+	r.records[topic][partition] = newLegacyRecords(set)
+}
+
+func (r *ProduceRequest) ensureRecords(topic string) {
+	if r.records == nil {
+		r.records = make(map[string]map[int32]Records)
+	}
+	if r.records[topic] == nil {
+		r.records[topic] = make(map[int32]Records)
+	}
+}
+
 func (r *ProduceRequest) key() int16 {
 	return 0
 }
@@ -93,6 +194,10 @@ func (r *ProduceRequest) version() int16 {
 	return r.Version
 }
 
+func (r *ProduceRequest) headerVersion() int16 {
+	return 1
+}
+
 // ExtractTopics returns topics list
 func (r *ProduceRequest) ExtractTopics() []string {
 	out := make([]string, 0, len(r.records))
@@ -112,7 +217,7 @@ func (r *ProduceRequest) RecordsLen() (recordsLen int) {
 			case legacyRecords:
 				recordsLen += len(record.MsgSet.Messages)
 			case defaultRecords:
-				recordsLen += len(record.RecordBatch.Records)
+				recordsLen += record.RecordBatch.count()
 			}
 		}
 	}
@@ -136,10 +241,108 @@ func (r *ProduceRequest) RecordsSize() (recordsSize int) {
 	return
 }
 
+// TopicRecordsSize returns size in bytes of records of topic, it's zero for shallow decoded requests
+func (r *ProduceRequest) TopicRecordsSize(topic string) (recordsSize int) {
+	for _, record := range r.records[topic] {
+		switch record.recordsType {
+		case legacyRecords:
+			for _, msg := range record.MsgSet.Messages {
+				recordsSize += msg.Msg.compressedSize
+			}
+		case defaultRecords:
+			recordsSize += record.RecordBatch.recordsLen
+		}
+	}
+	return
+}
+
+// TopicBatchSizes returns sizes in bytes of encoded records of partitions of topic, records of partition are
+// a record batch or a message set. Brokers reject records larger than max.message.bytes of topic. Sizes are
+// known in shallow decoded requests too, they are zeros in requests built by AddBatch and AddSet.
+func (r *ProduceRequest) TopicBatchSizes(topic string) []int {
+	sizes := make([]int, 0, len(r.records[topic]))
+	for _, records := range r.records[topic] {
+		sizes = append(sizes, records.size)
+	}
+	return sizes
+}
+
+// ProducerIDs returns ids of idempotent and transactional producers of record batches of the request with
+// their epochs, batches of other producers have no id. Epoch of producer is the latest one if batches of the
+// request differ by epochs. Ids aren't known in shallow decoded requests and legacy message sets.
+func (r *ProduceRequest) ProducerIDs() map[int64]int16 {
+	var ids map[int64]int16
+	for _, partition := range r.records {
+		for _, records := range partition {
+			batch := records.RecordBatch
+			if records.recordsType != defaultRecords || batch == nil || batch.ProducerID < 0 {
+				continue
+			}
+
+			if ids == nil {
+				ids = make(map[int64]int16)
+			}
+			if epoch, ok := ids[batch.ProducerID]; !ok || batch.ProducerEpoch > epoch {
+				ids[batch.ProducerID] = batch.ProducerEpoch
+			}
+		}
+	}
+	return ids
+}
+
+// ProducedRecord is a record of produce request, key, value and headers refer to buffers of the request
+type ProducedRecord struct {
+	Topic     string
+	Partition int32
+	Key       []byte
+	Value     []byte
+	Headers   []*RecordHeader
+	Timestamp time.Time
+}
+
+// ProducedRecords calls fn for every record of the request, records of compressed legacy message sets are
+// unwrapped. There are no records in shallow decoded requests and in strict privacy mode.
+func (r *ProduceRequest) ProducedRecords(fn func(ProducedRecord)) {
+	for topic, partitions := range r.records {
+		for partition, records := range partitions {
+			switch records.recordsType {
+			case legacyRecords:
+				for _, block := range records.MsgSet.Messages {
+					for _, msg := range block.Messages() {
+						fn(ProducedRecord{
+							Topic:     topic,
+							Partition: partition,
+							Key:       msg.Msg.Key,
+							Value:     msg.Msg.Value,
+							Timestamp: msg.Msg.Timestamp,
+						})
+					}
+				}
+			case defaultRecords:
+				batch := records.RecordBatch
+				for _, record := range batch.Records {
+					fn(ProducedRecord{
+						Topic:     topic,
+						Partition: partition,
+						Key:       record.Key,
+						Value:     record.Value,
+						Headers:   record.Headers,
+						Timestamp: batch.FirstTimestamp.Add(record.TimestampDelta),
+					})
+				}
+			}
+		}
+	}
+}
+
 // CollectClientMetrics collects metrics associated with client
 func (r *ProduceRequest) CollectClientMetrics(srcHost string) {
 	metrics.RequestsCount.WithLabelValues(srcHost, "produce").Inc()
 
+	if r.shallow {
+		return
+	}
+
 	batchSize := r.RecordsSize()
 	metrics.ProducerBatchSize.WithLabelValues(srcHost).Add(float64(batchSize))
 
@@ -161,3 +364,51 @@ func (r *ProduceRequest) requiredVersion() Version {
 		return MinVersion
 	}
 }
+
+type producePartitionJSON struct {
+	Partition int32    `json:"partition"`
+	Records   *Records `json:"records"`
+}
+
+type produceTopicJSON struct {
+	Topic      string                 `json:"topic"`
+	Partitions []producePartitionJSON `json:"partitions"`
+}
+
+// MarshalJSON renders produce request with records of its topic partitions in order, records of shallow
+// decoded requests are null
+func (r *ProduceRequest) MarshalJSON() ([]byte, error) {
+	topics := make([]produceTopicJSON, 0, len(r.records))
+	for topic, partitions := range r.records {
+		t := produceTopicJSON{Topic: topic, Partitions: make([]producePartitionJSON, 0, len(partitions))}
+		for partition, records := range partitions {
+			p := producePartitionJSON{Partition: partition}
+			if !r.shallow {
+				records := records
+				p.Records = &records
+			}
+			t.Partitions = append(t.Partitions, p)
+		}
+		sort.Slice(t.Partitions, func(i, j int) bool { return t.Partitions[i].Partition < t.Partitions[j].Partition })
+		topics = append(topics, t)
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Topic < topics[j].Topic })
+
+	return json.Marshal(struct {
+		TransactionalID *string            `json:"transactional_id"`
+		RequiredAcks    RequiredAcks       `json:"required_acks"`
+		Timeout         int32              `json:"timeout"`
+		Shallow         bool               `json:"shallow,omitempty"`
+		Topics          []produceTopicJSON `json:"topics"`
+	}{
+		TransactionalID: r.TransactionalID,
+		RequiredAcks:    r.RequiredAcks,
+		Timeout:         r.Timeout,
+		Shallow:         r.shallow,
+		Topics:          topics,
+	})
+}
+
+func (r *ProduceRequest) String() string {
+	return jsonString(r)
+}
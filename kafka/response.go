@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ResponseHeader is a header of kafka response. Body isn't decoded, its format is defined by api key
+// and version of the request with the same correlation id.
+type ResponseHeader struct {
+	// Length is a length of correlation id and body
+	Length int32
+
+	CorrelationID int32
+}
+
+// PartitionResult is a result of request to partition of topic decoded from response
+type PartitionResult struct {
+	Topic     string
+	Partition int32
+	ErrorCode int16
+
+	// HighWatermark is an offset of the next record after the last committed one, it's set by fetch responses
+	HighWatermark int64
+}
+
+// ReadResponse reads header of response from reader and passes reader of its body to decode with correlation id
+// of the response, decode may be nil. Body returns io.ErrUnexpectedEOF if it's truncated, the rest of body which
+// isn't read by decode is discarded. It returns count of read bytes.
+func ReadResponse(r io.Reader, decode func(correlationID int32, body io.Reader)) (*ResponseHeader, int, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+
+	resp := &ResponseHeader{
+		Length:        DecodeLength(header[:]),
+		CorrelationID: DecodeLength(header[4:]),
+	}
+
+	if resp.Length < 4 || resp.Length > MaxRequestSize {
+		return nil, len(header), PacketDecodingError{fmt.Sprintf("response of length %d too large or too small", resp.Length)}
+	}
+
+	body := &responseBody{io.LimitedReader{R: r, N: int64(resp.Length - 4)}}
+	if decode != nil {
+		decode(resp.CorrelationID, body)
+	}
+
+	_, err := io.Copy(ioutil.Discard, body)
+	return resp, len(header) + int(int64(resp.Length-4)-body.N), err
+}
+
+// responseBody reads body of response limited by its length
+type responseBody struct {
+	io.LimitedReader
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.LimitedReader.Read(p)
+	if err == io.EOF && b.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
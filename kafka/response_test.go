@@ -0,0 +1,190 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// responseWriter encodes fields of response bodies of non-flexible versions
+type responseWriter struct {
+	bytes.Buffer
+}
+
+func (w *responseWriter) write(values ...interface{}) *responseWriter {
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			_ = binary.Write(w, binary.BigEndian, int16(len(s)))
+			w.WriteString(s)
+			continue
+		}
+		_ = binary.Write(w, binary.BigEndian, v)
+	}
+	return w
+}
+
+// response returns response of correlation id with the body prefixed by its length
+func response(correlationID int32, body []byte) []byte {
+	w := &responseWriter{}
+	w.write(int32(len(body)+4), correlationID)
+	w.Write(body)
+	return w.Bytes()
+}
+
+func TestDecodeProduceResponse(t *testing.T) {
+	// v0: topics, partitions with partition, error code and base offset
+	v0 := &responseWriter{}
+	v0.write(int32(1), "orders", int32(2))
+	v0.write(int32(0), int16(0), int64(100))
+	v0.write(int32(1), int16(6), int64(-1))
+
+	// v8 adds log append time, log start offset, record errors and error message to partitions
+	v8 := &responseWriter{}
+	v8.write(int32(2), "orders", int32(1))
+	v8.write(int32(0), int16(87), int64(-1), int64(-1), int64(0))
+	v8.write(int32(1), int32(3), "invalid record", "one or more records have been rejected")
+	v8.write("payments", int32(1))
+	v8.write(int32(4), int16(0), int64(42), int64(-1), int64(0), int32(0), int16(-1))
+	v8.write(int32(100))
+
+	for _, c := range []struct {
+		name     string
+		version  int16
+		body     []byte
+		want     []PartitionResult
+		throttle int32
+	}{
+		{"v0", 0, v0.Bytes(), []PartitionResult{
+			{Topic: "orders", Partition: 0},
+			{Topic: "orders", Partition: 1, ErrorCode: 6},
+		}, 0},
+		{"v8", 8, v8.Bytes(), []PartitionResult{
+			{Topic: "orders", Partition: 0, ErrorCode: 87},
+			{Topic: "payments", Partition: 4},
+		}, 100},
+	} {
+		resp, err := DecodeProduceResponse(c.body, c.version)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp.Partitions, c.want) {
+			t.Errorf("%s: partitions are %+v, want %+v", c.name, resp.Partitions, c.want)
+		}
+		if resp.ThrottleTime != c.throttle {
+			t.Errorf("%s: throttle time is %d, want %d", c.name, resp.ThrottleTime, c.throttle)
+		}
+	}
+
+	if _, err := DecodeProduceResponse(v8.Bytes()[:20], 8); err == nil {
+		t.Error("truncated response is decoded")
+	}
+}
+
+func TestReadFetchResponse(t *testing.T) {
+	records := make([]byte, 1000)
+
+	// v4: throttle time, topics, partitions with partition, error code, high watermark, last stable offset,
+	// aborted transactions and records
+	v4 := &responseWriter{}
+	v4.write(int32(0), int32(1), "orders", int32(2))
+	v4.write(int32(0), int16(0), int64(500), int64(500), int32(1), int64(7), int64(480), int32(len(records)), records)
+	v4.write(int32(1), int16(1), int64(-1), int64(-1), int32(-1), int32(-1))
+
+	// v11 adds error code and session id of the fetch session, log start offset and preferred read replica
+	v11 := &responseWriter{}
+	v11.write(int32(0), int16(0), int32(12345), int32(1), "payments", int32(1))
+	v11.write(int32(3), int16(0), int64(1000), int64(1000), int64(0), int32(0), int32(-1), int32(len(records)), records)
+
+	// sessions are rejected with error and empty responses
+	session := &responseWriter{}
+	session.write(int32(0), int16(70), int32(0), int32(0))
+
+	for _, c := range []struct {
+		name      string
+		version   int16
+		body      []byte
+		want      []PartitionResult
+		errorCode int16
+	}{
+		{"v4", 4, v4.Bytes(), []PartitionResult{
+			{Topic: "orders", Partition: 0, HighWatermark: 500},
+			{Topic: "orders", Partition: 1, ErrorCode: 1, HighWatermark: -1},
+		}, 0},
+		{"v11", 11, v11.Bytes(), []PartitionResult{
+			{Topic: "payments", Partition: 3, HighWatermark: 1000},
+		}, 0},
+		{"session error", 11, session.Bytes(), nil, 70},
+	} {
+		resp, err := ReadFetchResponse(bytes.NewReader(c.body), c.version)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp.Partitions, c.want) {
+			t.Errorf("%s: partitions are %+v, want %+v", c.name, resp.Partitions, c.want)
+		}
+		if resp.ErrorCode != c.errorCode {
+			t.Errorf("%s: error code is %d, want %d", c.name, resp.ErrorCode, c.errorCode)
+		}
+	}
+
+	// partitions read before truncated records are decoded
+	resp, err := ReadFetchResponse(bytes.NewReader(v4.Bytes()[:100]), 4)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated response is read with error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if len(resp.Partitions) != 0 {
+		t.Errorf("partitions of truncated response are %+v, want none", resp.Partitions)
+	}
+}
+
+func TestReadResponse(t *testing.T) {
+	first := response(1, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	second := response(2, []byte{9})
+	r := bytes.NewReader(append(append([]byte{}, first...), second...))
+
+	// the rest of body which isn't read is discarded
+	resp, n, err := ReadResponse(r, func(correlationID int32, body io.Reader) {
+		data := make([]byte, 3)
+		if _, err := io.ReadFull(body, data); err != nil {
+			t.Fatal(err)
+		}
+		if correlationID != 1 || !bytes.Equal(data, []byte{1, 2, 3}) {
+			t.Errorf("body of response %d starts with %v, want [1 2 3] of response 1", correlationID, data)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CorrelationID != 1 || n != len(first) {
+		t.Errorf("read %d bytes of response %d, want %d bytes of response 1", n, resp.CorrelationID, len(first))
+	}
+
+	// body is limited by length of response
+	resp, n, err = ReadResponse(r, func(correlationID int32, body io.Reader) {
+		if data, err := ioutil.ReadAll(body); err != nil || !bytes.Equal(data, []byte{9}) {
+			t.Errorf("body of response %d is %v, %v, want [9]", correlationID, data, err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CorrelationID != 2 || n != len(second) {
+		t.Errorf("read %d bytes of response %d, want %d bytes of response 2", n, resp.CorrelationID, len(second))
+	}
+
+	// truncated body is reported to decoder and by reader
+	truncated := bytes.NewReader(first[:len(first)-2])
+	_, n, err = ReadResponse(truncated, func(correlationID int32, body io.Reader) {
+		if _, err := ioutil.ReadAll(body); err != io.ErrUnexpectedEOF {
+			t.Errorf("truncated body is read with error %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+	})
+	if err != io.ErrUnexpectedEOF || n != len(first)-2 {
+		t.Errorf("truncated response is read with %d bytes and error %v, want %d bytes and %v", n, err, len(first)-2, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// SASLPrincipal returns name of user authenticated by the first SASL message of the mechanism, it's empty
+// for unsupported mechanisms (GSSAPI) and for the following messages. Passwords and tokens aren't kept.
+func SASLPrincipal(mechanism string, authBytes []byte) string {
+	switch mechanism {
+	case "PLAIN":
+		// authzid NUL authcid NUL passwd
+		parts := bytes.Split(authBytes, []byte{0})
+		if len(parts) != 3 {
+			return ""
+		}
+		if len(parts[0]) > 0 {
+			return string(parts[0])
+		}
+		return string(parts[1])
+	case "SCRAM-SHA-256", "SCRAM-SHA-512":
+		// client-first-message: gs2 header, then n=user,r=nonce
+		fields := strings.Split(string(authBytes), ",")
+		if len(fields) < 4 {
+			return ""
+		}
+		for _, field := range fields[2:] {
+			if strings.HasPrefix(field, "n=") {
+				return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(field[2:])
+			}
+		}
+	case "OAUTHBEARER":
+		return oauthBearerPrincipal(string(authBytes))
+	}
+
+	return ""
+}
+
+// oauthBearerPrincipal returns authorization id of OAUTHBEARER client message (RFC 7628) or subject of its
+// unsecured JWT, signature of the token isn't verified
+func oauthBearerPrincipal(msg string) string {
+	fields := strings.Split(msg, "\x01")
+
+	// gs2 header: n,a=authzid,
+	for _, field := range strings.Split(fields[0], ",") {
+		if strings.HasPrefix(field, "a=") && len(field) > 2 {
+			return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(field[2:])
+		}
+	}
+
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "auth=Bearer ") {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(field, "auth=Bearer "), ".")
+		if len(parts) < 2 {
+			return ""
+		}
+
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return ""
+		}
+
+		var claims struct {
+			Subject string `json:"sub"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return ""
+		}
+		return claims.Subject
+	}
+
+	return ""
+}
+
+// IsSASLRequest returns true if api key is a key of SaslHandshake or SaslAuthenticate request
+func IsSASLRequest(key int16) bool {
+	return key == 17 || key == 36
+}
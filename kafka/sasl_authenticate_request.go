@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"encoding/json"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// SaslAuthenticateRequest (API key 36) carries SASL messages of the mechanism chosen by SaslHandshake
+type SaslAuthenticateRequest struct {
+	Version   int16
+	AuthBytes []byte
+}
+
+// Decode decodes kafka sasl authenticate request from packet
+func (r *SaslAuthenticateRequest) Decode(pd PacketDecoder, version int16) (err error) {
+	r.Version = version
+
+	if r.Version >= 2 {
+		if r.AuthBytes, err = pd.getCompactBytes(); err != nil {
+			return err
+		}
+		return pd.getTaggedFieldArray()
+	}
+
+	r.AuthBytes, err = pd.getBytes()
+	return err
+}
+
+// Encode encodes kafka sasl authenticate request into packet
+func (r *SaslAuthenticateRequest) Encode(pe PacketEncoder, version int16) error {
+	if version >= 2 {
+		if err := pe.putCompactBytes(r.AuthBytes); err != nil {
+			return err
+		}
+		pe.putEmptyTaggedFieldArray()
+		return nil
+	}
+
+	return pe.putBytes(r.AuthBytes)
+}
+
+// CollectClientMetrics collects metrics associated with client
+func (r *SaslAuthenticateRequest) CollectClientMetrics(srcHost string) {
+	metrics.RequestsCount.WithLabelValues(srcHost, "sasl_authenticate").Inc()
+}
+
+func (r *SaslAuthenticateRequest) key() int16 {
+	return 36
+}
+
+func (r *SaslAuthenticateRequest) version() int16 {
+	return r.Version
+}
+
+func (r *SaslAuthenticateRequest) headerVersion() int16 {
+	if r.Version >= 2 {
+		return 2
+	}
+	return 1
+}
+
+func (r *SaslAuthenticateRequest) requiredVersion() Version {
+	switch r.Version {
+	case 0:
+		return V1_0_0_0
+	case 1:
+		return V2_2_0_0
+	case 2:
+		return V2_4_0_0
+	default:
+		return MaxVersion
+	}
+}
+
+// MarshalJSON renders sasl authenticate request, auth bytes carry passwords and tokens, so only their size is
+// rendered even if payloads are rendered
+func (r *SaslAuthenticateRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		AuthBytesSize int `json:"auth_bytes_size"`
+	}{
+		AuthBytesSize: payloadSize(r.AuthBytes),
+	})
+}
+
+func (r *SaslAuthenticateRequest) String() string {
+	return jsonString(r)
+}
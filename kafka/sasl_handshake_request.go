@@ -0,0 +1,66 @@
+package kafka
+
+import (
+	"encoding/json"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// SaslHandshakeRequest (API key 17) starts SASL authentication of the connection with the mechanism
+type SaslHandshakeRequest struct {
+	Version   int16
+	Mechanism string
+}
+
+// Decode decodes kafka sasl handshake request from packet
+func (r *SaslHandshakeRequest) Decode(pd PacketDecoder, version int16) (err error) {
+	r.Version = version
+	r.Mechanism, err = pd.getString()
+	return err
+}
+
+// Encode encodes kafka sasl handshake request into packet
+func (r *SaslHandshakeRequest) Encode(pe PacketEncoder, version int16) error {
+	return pe.putString(r.Mechanism)
+}
+
+// CollectClientMetrics collects metrics associated with client
+func (r *SaslHandshakeRequest) CollectClientMetrics(srcHost string) {
+	metrics.RequestsCount.WithLabelValues(srcHost, "sasl_handshake").Inc()
+}
+
+func (r *SaslHandshakeRequest) key() int16 {
+	return 17
+}
+
+func (r *SaslHandshakeRequest) version() int16 {
+	return r.Version
+}
+
+func (r *SaslHandshakeRequest) headerVersion() int16 {
+	return 1
+}
+
+func (r *SaslHandshakeRequest) requiredVersion() Version {
+	switch r.Version {
+	case 0:
+		return V0_10_0_0
+	case 1:
+		return V1_0_0_0
+	default:
+		return MaxVersion
+	}
+}
+
+// MarshalJSON renders sasl handshake request
+func (r *SaslHandshakeRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Mechanism string `json:"mechanism"`
+	}{
+		Mechanism: r.Mechanism,
+	})
+}
+
+func (r *SaslHandshakeRequest) String() string {
+	return jsonString(r)
+}
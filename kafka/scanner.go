@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"bufio"
+	"io"
+)
+
+// RequestScanner reads requests one by one from a stream of bytes, e.g. reassembled client side of connection
+// to broker or a file of dumped requests. It's used like bufio.Scanner:
+//
+//	scanner := kafka.NewRequestScanner(r)
+//	for scanner.Scan() {
+//		req := scanner.Request()
+//		...
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+//
+// Scanning stops at the end of stream or at the first error. End of stream between requests isn't an error,
+// end of stream in the middle of request is io.ErrUnexpectedEOF.
+type RequestScanner struct {
+	r *bufio.Reader
+
+	req    *Request
+	offset int64
+	read   int64
+	err    error
+}
+
+// NewRequestScanner creates scanner reading requests from r
+func NewRequestScanner(r io.Reader) *RequestScanner {
+	return &RequestScanner{r: bufio.NewReader(r)}
+}
+
+// Scan reads and decodes the next request, which is available by Request then. It returns false when scanning
+// stops by the end of stream or by error, Err returns the error then.
+func (s *RequestScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	// end of stream is checked before decoding, so it isn't confused with truncated header of request
+	if _, err := s.r.Peek(1); err != nil {
+		s.stop(err)
+		return false
+	}
+
+	req, readBytes, err := DecodeRequest(s.r)
+	s.offset = s.read
+	s.read += int64(readBytes)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.stop(err)
+		return false
+	}
+
+	s.req = req
+	return true
+}
+
+// Request returns the request decoded by the last call of Scan. Requests aren't released by scanner, they may
+// be kept or released by Release when they aren't used anymore.
+func (s *RequestScanner) Request() *Request {
+	return s.req
+}
+
+// Offset returns offset of the last request read by Scan in the stream, it's the offset of request failed to
+// be decoded after Scan returns false
+func (s *RequestScanner) Offset() int64 {
+	return s.offset
+}
+
+// Err returns the error stopped scanning, it's nil if scanning stopped by the end of stream
+func (s *RequestScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+func (s *RequestScanner) stop(err error) {
+	s.req = nil
+	s.err = err
+}
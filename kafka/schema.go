@@ -0,0 +1,77 @@
+package kafka
+
+import "encoding/binary"
+
+// schemaWireHeaderSize is a size of header of Confluent Schema Registry wire format: magic byte 0 and
+// 4-byte schema id
+const schemaWireHeaderSize = 5
+
+// SchemaRefs are ids of schemas of keys and values of records of topic in Schema Registry wire format
+type SchemaRefs struct {
+	Keys   []int32
+	Values []int32
+}
+
+// SchemaID returns id of schema of key or value serialized in Confluent Schema Registry wire format: magic
+// byte 0 followed by big endian schema id. Detection is heuristic, any data starting with zero byte and
+// positive id looks like it.
+func SchemaID(data []byte) (int32, bool) {
+	if len(data) < schemaWireHeaderSize || data[0] != 0 {
+		return 0, false
+	}
+
+	id := int32(binary.BigEndian.Uint32(data[1:schemaWireHeaderSize]))
+	return id, id > 0
+}
+
+// SchemaRefs returns distinct ids of schemas of keys and values of records by topic, topics without them are
+// skipped. It's empty for shallow decoded requests and in strict privacy mode.
+func (r *ProduceRequest) SchemaRefs() map[string]*SchemaRefs {
+	out := make(map[string]*SchemaRefs)
+	if r.shallow || StrictPrivacy() {
+		return out
+	}
+
+	for topic, partitions := range r.records {
+		refs := &SchemaRefs{}
+		add := func(key, value []byte) {
+			if id, ok := SchemaID(key); ok {
+				refs.Keys = appendSchemaID(refs.Keys, id)
+			}
+			if id, ok := SchemaID(value); ok {
+				refs.Values = appendSchemaID(refs.Values, id)
+			}
+		}
+
+		for _, records := range partitions {
+			switch records.recordsType {
+			case legacyRecords:
+				for _, block := range records.MsgSet.Messages {
+					for _, msg := range block.Messages() {
+						add(msg.Msg.Key, msg.Msg.Value)
+					}
+				}
+			case defaultRecords:
+				for _, record := range records.RecordBatch.Records {
+					add(record.Key, record.Value)
+				}
+			}
+		}
+
+		if len(refs.Keys) > 0 || len(refs.Values) > 0 {
+			out[topic] = refs
+		}
+	}
+
+	return out
+}
+
+// appendSchemaID appends id if it isn't in ids yet, records of a batch usually share the same schema
+func appendSchemaID(ids []int32, id int32) []int32 {
+	for _, i := range ids {
+		if i == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
@@ -0,0 +1,204 @@
+package kafka
+
+import (
+	"encoding/json"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// SyncGroupAssignment is a partition assignment of a group member sent by the group leader
+type SyncGroupAssignment struct {
+	MemberID   string
+	Assignment []byte
+}
+
+// SyncGroupRequest (API key 14) is sent by every member of a consumer group after JoinGroup,
+// it completes the rebalance and starts a new generation of the group.
+type SyncGroupRequest struct {
+	Version          int16
+	GroupID          string
+	GenerationID     int32
+	MemberID         string
+	GroupInstanceID  *string
+	ProtocolType     *string
+	ProtocolName     *string
+	GroupAssignments []*SyncGroupAssignment
+}
+
+// Decode decodes kafka sync group request from packet
+func (r *SyncGroupRequest) Decode(pd PacketDecoder, version int16) (err error) {
+	r.Version = version
+	flexible := r.Version >= 4
+
+	if r.GroupID, err = getFlexibleString(pd, flexible); err != nil {
+		return err
+	}
+	if r.GenerationID, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if r.MemberID, err = getFlexibleString(pd, flexible); err != nil {
+		return err
+	}
+	if r.Version >= 3 {
+		if r.GroupInstanceID, err = getFlexibleNullableString(pd, flexible); err != nil {
+			return err
+		}
+	}
+	if r.Version >= 5 {
+		if r.ProtocolType, err = pd.getCompactNullableString(); err != nil {
+			return err
+		}
+		if r.ProtocolName, err = pd.getCompactNullableString(); err != nil {
+			return err
+		}
+	}
+
+	assignmentCount, err := getFlexibleArrayLength(pd, flexible)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < assignmentCount; i++ {
+		assignment := &SyncGroupAssignment{}
+		if assignment.MemberID, err = getFlexibleString(pd, flexible); err != nil {
+			return err
+		}
+		if flexible {
+			if assignment.Assignment, err = pd.getCompactBytes(); err != nil {
+				return err
+			}
+			if err = pd.getTaggedFieldArray(); err != nil {
+				return err
+			}
+		} else if assignment.Assignment, err = pd.getBytes(); err != nil {
+			return err
+		}
+		r.GroupAssignments = append(r.GroupAssignments, assignment)
+	}
+
+	if flexible {
+		return pd.getTaggedFieldArray()
+	}
+
+	return nil
+}
+
+// Encode encodes kafka sync group request into packet
+func (r *SyncGroupRequest) Encode(pe PacketEncoder, version int16) error {
+	flexible := version >= 4
+
+	if err := putFlexibleString(pe, r.GroupID, flexible); err != nil {
+		return err
+	}
+	pe.putInt32(r.GenerationID)
+	if err := putFlexibleString(pe, r.MemberID, flexible); err != nil {
+		return err
+	}
+	if version >= 3 {
+		if err := putFlexibleNullableString(pe, r.GroupInstanceID, flexible); err != nil {
+			return err
+		}
+	}
+	if version >= 5 {
+		if err := pe.putCompactNullableString(r.ProtocolType); err != nil {
+			return err
+		}
+		if err := pe.putCompactNullableString(r.ProtocolName); err != nil {
+			return err
+		}
+	}
+
+	if err := putFlexibleArrayLength(pe, len(r.GroupAssignments), flexible); err != nil {
+		return err
+	}
+	for _, assignment := range r.GroupAssignments {
+		if err := putFlexibleString(pe, assignment.MemberID, flexible); err != nil {
+			return err
+		}
+		if err := putFlexibleBytes(pe, assignment.Assignment, flexible); err != nil {
+			return err
+		}
+		if flexible {
+			pe.putEmptyTaggedFieldArray()
+		}
+	}
+
+	if flexible {
+		pe.putEmptyTaggedFieldArray()
+	}
+
+	return nil
+}
+
+// CollectClientMetrics collects metrics associated with client
+func (r *SyncGroupRequest) CollectClientMetrics(srcHost string) {
+	metrics.RequestsCount.WithLabelValues(srcHost, "sync_group").Inc()
+	metrics.GroupSyncRequests.WithLabelValues(r.GroupID).Inc()
+}
+
+func (r *SyncGroupRequest) key() int16 {
+	return 14
+}
+
+func (r *SyncGroupRequest) version() int16 {
+	return r.Version
+}
+
+func (r *SyncGroupRequest) headerVersion() int16 {
+	if r.Version >= 4 {
+		return 2
+	}
+	return 1
+}
+
+func (r *SyncGroupRequest) requiredVersion() Version {
+	switch r.Version {
+	case 0:
+		return V0_9_0_0
+	case 1:
+		return V0_11_0_0
+	case 2:
+		return V2_0_0_0
+	case 3:
+		return V2_3_0_0
+	case 4:
+		return V2_4_0_0
+	default:
+		return MaxVersion
+	}
+}
+
+// MarshalJSON renders assignment, it's rendered as is since it's an assignment of partitions and not a payload
+func (a *SyncGroupAssignment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		MemberID   string `json:"member_id"`
+		Assignment []byte `json:"assignment"`
+	}{
+		MemberID:   a.MemberID,
+		Assignment: a.Assignment,
+	})
+}
+
+// MarshalJSON renders sync group request
+func (r *SyncGroupRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		GroupID          string                 `json:"group_id"`
+		GenerationID     int32                  `json:"generation_id"`
+		MemberID         string                 `json:"member_id"`
+		GroupInstanceID  *string                `json:"group_instance_id"`
+		ProtocolType     *string                `json:"protocol_type"`
+		ProtocolName     *string                `json:"protocol_name"`
+		GroupAssignments []*SyncGroupAssignment `json:"group_assignments"`
+	}{
+		GroupID:          r.GroupID,
+		GenerationID:     r.GenerationID,
+		MemberID:         r.MemberID,
+		GroupInstanceID:  r.GroupInstanceID,
+		ProtocolType:     r.ProtocolType,
+		ProtocolName:     r.ProtocolName,
+		GroupAssignments: r.GroupAssignments,
+	})
+}
+
+func (r *SyncGroupRequest) String() string {
+	return jsonString(r)
+}
@@ -1,6 +1,9 @@
 package kafka
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Timestamp is a structure representing UNIX timestamp
 type Timestamp struct {
@@ -24,3 +27,17 @@ func (t Timestamp) Decode(pd PacketDecoder) error {
 	*t.Time = timestamp
 	return nil
 }
+
+// Encode encodes timestamp into packet as milliseconds, zero time is encoded as -1
+func (t Timestamp) Encode(pe PacketEncoder) error {
+	timestamp := int64(-1)
+
+	if !t.Before(time.Unix(0, 0)) {
+		timestamp = t.UnixNano() / int64(time.Millisecond)
+	} else if !t.IsZero() {
+		return PacketEncodingError{fmt.Sprintf("invalid timestamp (%v)", t)}
+	}
+
+	pe.putInt64(timestamp)
+	return nil
+}
@@ -2,8 +2,17 @@ package kafka
 
 import (
 	"fmt"
+	"strings"
 )
 
+// internalTopicPrefix is a prefix of kafka internal topics like __consumer_offsets and __transaction_state
+const internalTopicPrefix = "__"
+
+// IsInternalTopic returns true if the topic is kafka internal topic
+func IsInternalTopic(topic string) bool {
+	return strings.HasPrefix(topic, internalTopicPrefix)
+}
+
 // Encoder is a simple interface for any type that can be encoded as an array of bytes
 // in order to be sent as the key or value of a Kafka message. Length() is provided as an
 // optimization, and must return the same as len() on the result of Encode().
@@ -82,6 +91,7 @@ var (
 	V1_1_0_0  = newKafkaVersion(1, 1, 0, 0)
 	V2_0_0_0  = newKafkaVersion(2, 0, 0, 0)
 	V2_1_0_0  = newKafkaVersion(2, 1, 0, 0)
+	V2_2_0_0  = newKafkaVersion(2, 2, 0, 0)
 	V2_3_0_0  = newKafkaVersion(2, 3, 0, 0)
 	V2_4_0_0  = newKafkaVersion(2, 4, 0, 0)
 
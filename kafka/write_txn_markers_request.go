@@ -0,0 +1,223 @@
+package kafka
+
+import (
+	"encoding/json"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// TxnMarkerTopic is a topic with partitions a transaction marker is written to
+type TxnMarkerTopic struct {
+	Topic      string  `json:"topic"`
+	Partitions []int32 `json:"partitions"`
+}
+
+// TxnMarker is a result of transaction of producer, partition leaders append it to partitions of the
+// transaction as control batches
+type TxnMarker struct {
+	ProducerID       int64            `json:"producer_id"`
+	ProducerEpoch    int16            `json:"producer_epoch"`
+	Commit           bool             `json:"commit"`
+	Topics           []TxnMarkerTopic `json:"topics"`
+	CoordinatorEpoch int32            `json:"coordinator_epoch"`
+}
+
+// Result returns commit or abort
+func (m *TxnMarker) Result() string {
+	if m.Commit {
+		return "commit"
+	}
+	return "abort"
+}
+
+func (m *TxnMarker) decode(pd PacketDecoder, flexible bool) (err error) {
+	if m.ProducerID, err = pd.getInt64(); err != nil {
+		return err
+	}
+	if m.ProducerEpoch, err = pd.getInt16(); err != nil {
+		return err
+	}
+	if m.Commit, err = pd.getBool(); err != nil {
+		return err
+	}
+
+	topicCount, err := getFlexibleArrayLength(pd, flexible)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < topicCount; i++ {
+		var topic TxnMarkerTopic
+		if topic.Topic, err = getFlexibleString(pd, flexible); err != nil {
+			return err
+		}
+
+		partitionCount, err := getFlexibleArrayLength(pd, flexible)
+		if err != nil {
+			return err
+		}
+		if partitionCount > pd.remaining()/4 {
+			return ErrInsufficientData
+		}
+		for j := 0; j < partitionCount; j++ {
+			partition, err := pd.getInt32()
+			if err != nil {
+				return err
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+
+		if flexible {
+			if err = pd.getTaggedFieldArray(); err != nil {
+				return err
+			}
+		}
+		m.Topics = append(m.Topics, topic)
+	}
+
+	if m.CoordinatorEpoch, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if flexible {
+		return pd.getTaggedFieldArray()
+	}
+	return nil
+}
+
+func (m *TxnMarker) encode(pe PacketEncoder, flexible bool) error {
+	pe.putInt64(m.ProducerID)
+	pe.putInt16(m.ProducerEpoch)
+	pe.putBool(m.Commit)
+
+	if err := putFlexibleArrayLength(pe, len(m.Topics), flexible); err != nil {
+		return err
+	}
+	for _, topic := range m.Topics {
+		if err := putFlexibleString(pe, topic.Topic, flexible); err != nil {
+			return err
+		}
+		if err := putFlexibleArrayLength(pe, len(topic.Partitions), flexible); err != nil {
+			return err
+		}
+		for _, partition := range topic.Partitions {
+			pe.putInt32(partition)
+		}
+		if flexible {
+			pe.putEmptyTaggedFieldArray()
+		}
+	}
+
+	pe.putInt32(m.CoordinatorEpoch)
+	if flexible {
+		pe.putEmptyTaggedFieldArray()
+	}
+	return nil
+}
+
+// WriteTxnMarkersRequest (API key 27) is sent by transaction coordinator to leaders of partitions of committed
+// or aborted transactions, leaders append markers to the partitions as control batches. Markers never come in
+// produce requests of clients, so they are observed by requests of inter-broker connections.
+// Version 1 is the first flexible version.
+type WriteTxnMarkersRequest struct {
+	Version int16
+	Markers []*TxnMarker
+}
+
+// Decode decodes kafka write txn markers request from packet
+func (r *WriteTxnMarkersRequest) Decode(pd PacketDecoder, version int16) error {
+	r.Version = version
+	flexible := r.Version >= 1
+
+	markerCount, err := getFlexibleArrayLength(pd, flexible)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < markerCount; i++ {
+		marker := &TxnMarker{}
+		if err = marker.decode(pd, flexible); err != nil {
+			return err
+		}
+		r.Markers = append(r.Markers, marker)
+	}
+
+	if flexible {
+		return pd.getTaggedFieldArray()
+	}
+	return nil
+}
+
+// Encode encodes kafka write txn markers request into packet
+func (r *WriteTxnMarkersRequest) Encode(pe PacketEncoder, version int16) error {
+	flexible := version >= 1
+
+	if err := putFlexibleArrayLength(pe, len(r.Markers), flexible); err != nil {
+		return err
+	}
+	for _, marker := range r.Markers {
+		if err := marker.encode(pe, flexible); err != nil {
+			return err
+		}
+	}
+
+	if flexible {
+		pe.putEmptyTaggedFieldArray()
+	}
+	return nil
+}
+
+// ExtractTopics returns topics markers are written to
+func (r *WriteTxnMarkersRequest) ExtractTopics() []string {
+	seen := make(map[string]bool)
+	var topics []string
+	for _, marker := range r.Markers {
+		for _, topic := range marker.Topics {
+			if !seen[topic.Topic] {
+				seen[topic.Topic] = true
+				topics = append(topics, topic.Topic)
+			}
+		}
+	}
+	return topics
+}
+
+// CollectClientMetrics collects metrics associated with client, markers are counted by topics after topic
+// filters
+func (r *WriteTxnMarkersRequest) CollectClientMetrics(srcHost string) {
+	metrics.RequestsCount.WithLabelValues(srcHost, "write_txn_markers").Inc()
+}
+
+func (r *WriteTxnMarkersRequest) key() int16 {
+	return 27
+}
+
+func (r *WriteTxnMarkersRequest) version() int16 {
+	return r.Version
+}
+
+func (r *WriteTxnMarkersRequest) headerVersion() int16 {
+	if r.Version >= 1 {
+		return 2
+	}
+	return 1
+}
+
+func (r *WriteTxnMarkersRequest) requiredVersion() Version {
+	switch r.Version {
+	case 0:
+		return V0_11_0_0
+	default:
+		return MaxVersion
+	}
+}
+
+// MarshalJSON renders write txn markers request
+func (r *WriteTxnMarkersRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Markers []*TxnMarker `json:"markers"`
+	}{
+		Markers: r.Markers,
+	})
+}
+
+func (r *WriteTxnMarkersRequest) String() string {
+	return jsonString(r)
+}
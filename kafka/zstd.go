@@ -8,13 +8,21 @@ import (
 
 var (
 	zstdDec *zstd.Decoder
+	zstdEnc *zstd.Encoder
 
-	zstdDecOnce sync.Once
+	zstdDecOnce, zstdEncOnce sync.Once
 )
 
 func zstdDecompress(dst, src []byte) ([]byte, error) {
 	zstdDecOnce.Do(func() {
-		zstdDec, _ = zstd.NewReader(nil)
+		zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(MaxDecompressedSize)))
 	})
 	return zstdDec.DecodeAll(src, dst)
 }
+
+func zstdCompress(dst, src []byte) ([]byte, error) {
+	zstdEncOnce.Do(func() {
+		zstdEnc, _ = zstd.NewWriter(nil, zstd.WithZeroFrames(true))
+	})
+	return zstdEnc.EncodeAll(src, dst), nil
+}
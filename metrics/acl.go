@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resource types of ACLs which access is observed
+const (
+	ACLTopic = "topic"
+	ACLGroup = "group"
+)
+
+// operations of ACLs which are observed, produce requests write to topics, fetch requests read from topics and
+// consumers joining groups read from groups
+const (
+	ACLRead  = "read"
+	ACLWrite = "write"
+	ACLAll   = "all"
+)
+
+// ACL is an access control entry declared in the cluster
+type ACL struct {
+	// Principal is e.g. User:alice or User:* for all users
+	Principal string
+
+	// ResourceType is topic, group or another resource type, ResourceName is a name, a prefix of names for
+	// prefixed pattern type, or * for all resources
+	ResourceType string
+	ResourceName string
+	PatternType  string
+
+	// Host is an address of client or * for all hosts
+	Host string
+
+	// Operation is read, write, all or another operation, Allow is false for deny entries
+	Operation string
+	Allow     bool
+}
+
+// observable returns true if usage of ACL is observed, e.g. describe and create operations aren't observed
+func (a ACL) observable() bool {
+	if a.ResourceType != ACLTopic && a.ResourceType != ACLGroup {
+		return false
+	}
+	return a.Operation == ACLRead || a.Operation == ACLWrite || a.Operation == ACLAll
+}
+
+// matches returns true if ACL covers access of principal from host to the resource by operation
+func (a ACL) matches(principal, host, resourceType, resource, operation string) bool {
+	if a.ResourceType != resourceType {
+		return false
+	}
+	if a.Principal != "User:*" && a.Principal != "User:"+principal {
+		return false
+	}
+	if a.Host != "*" && a.Host != host {
+		return false
+	}
+	if a.Operation != ACLAll && a.Operation != operation {
+		return false
+	}
+
+	if a.PatternType == "prefixed" {
+		return strings.HasPrefix(resource, a.ResourceName)
+	}
+	return a.ResourceName == "*" || a.ResourceName == resource
+}
+
+// ACLAudit compares declared ACLs with observed access of principals authenticated by SASL, a passive audit of
+// least privilege: allow entries which are never used are reported by acl_unused_info metric, access which isn't
+// allowed by any entry or is denied is counted by acl_unexpected_access_total metric.
+type ACLAudit struct {
+	unexpectedAccess *prometheus.CounterVec
+	unusedDesc       *prometheus.Desc
+
+	mux  sync.Mutex
+	acls []ACL
+	used map[ACL]bool
+
+	// reported contains unexpected accesses which are already logged
+	reported map[string]bool
+
+	// anonymizer hashes principals, resources and hosts in labels of metrics, may be nil
+	anonymizer *Anonymizer
+}
+
+// NewACLAudit creates new ACLAudit, access isn't audited until ACLs are set. ACLs are matched by real names,
+// labels of metrics are anonymized by anonymizer if it isn't nil.
+func NewACLAudit(registerer prometheus.Registerer, anonymizer *Anonymizer) *ACLAudit {
+	a := &ACLAudit{
+		unexpectedAccess: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "acl_unexpected_access_total",
+			Help:      "Total requests of principal to resource not allowed by any declared ACL or denied by one, resource_type is topic or group, operation is read or write",
+		}, []string{"principal", "resource_type", "resource", "operation"}),
+		unusedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "acl_unused_info"),
+			"Declared allow ACL which hasn't been used by observed requests since sniffer start",
+			[]string{"principal", "resource_type", "pattern_type", "resource", "operation", "host"}, nil,
+		),
+		used:       make(map[ACL]bool),
+		reported:   make(map[string]bool),
+		anonymizer: anonymizer,
+	}
+
+	registerer.MustRegister(a)
+
+	return a
+}
+
+// SetACLs replaces declared ACLs, usage of entries which are still declared is kept
+func (a *ACLAudit) SetACLs(acls []ACL) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	used := make(map[ACL]bool, len(a.used))
+	for _, acl := range acls {
+		if a.used[acl] {
+			used[acl] = true
+		}
+	}
+	a.acls, a.used = acls, used
+}
+
+// Observe registers access of principal from host to the resource by operation, it returns true if the access
+// is unexpected. Access of clients not authenticated by SASL isn't audited, it's never unexpected if a is nil
+// or ACLs aren't set.
+func (a *ACLAudit) Observe(principal, host, resourceType, resource, operation string) bool {
+	if a == nil || principal == "" {
+		return false
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	if a.acls == nil {
+		return false
+	}
+
+	var allowed, denied bool
+	for _, acl := range a.acls {
+		if !acl.matches(principal, host, resourceType, resource, operation) {
+			continue
+		}
+		if !acl.Allow {
+			denied = true
+			continue
+		}
+		allowed = true
+		a.used[acl] = true
+	}
+
+	if allowed && !denied {
+		return false
+	}
+
+	a.unexpectedAccess.WithLabelValues(a.label(principal), resourceType, a.label(resource), operation).Inc()
+
+	key := genLabelKey(principal, resourceType, resource, operation)
+	if !a.reported[key] {
+		a.reported[key] = true
+		log.Printf("acl audit: unexpected access: %s %s %s %s from %s", a.label(principal), operation, resourceType, a.label(resource), a.label(host))
+	}
+
+	return true
+}
+
+// Describe implements prometheus.Collector
+func (a *ACLAudit) Describe(ch chan<- *prometheus.Desc) {
+	a.unexpectedAccess.Describe(ch)
+	ch <- a.unusedDesc
+}
+
+// Collect implements prometheus.Collector
+func (a *ACLAudit) Collect(ch chan<- prometheus.Metric) {
+	a.unexpectedAccess.Collect(ch)
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, acl := range a.acls {
+		if !acl.Allow || !acl.observable() || a.used[acl] {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(a.unusedDesc, prometheus.GaugeValue, 1,
+			a.principalLabel(acl.Principal), acl.ResourceType, acl.PatternType, a.label(acl.ResourceName), acl.Operation, a.label(acl.Host))
+	}
+}
+
+// label returns anonymized value of label, wildcards of declared ACLs are kept
+func (a *ACLAudit) label(value string) string {
+	if value == "*" {
+		return value
+	}
+	return a.anonymizer.Hash(value)
+}
+
+// principalLabel returns anonymized principal of declared ACL, e.g. User:anon-3f2a9c1d0b7e4a65, its name is
+// hashed like names of observed principals
+func (a *ACLAudit) principalLabel(principal string) string {
+	if name := strings.TrimPrefix(principal, "User:"); name != principal {
+		return "User:" + a.label(name)
+	}
+	return a.label(principal)
+}
@@ -0,0 +1,291 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DashboardOptions are features of sniffer changing its metrics and their labels, dashboard is generated to
+// match them
+type DashboardOptions struct {
+	// Owners, Country and ASN are true if metrics of clients are labeled by owner, country and asn
+	Owners  bool
+	Country bool
+	ASN     bool
+
+	// Labels are names of labels added to all metrics, e.g. node and zone in kubernetes mode
+	Labels []string
+
+	// Batches is false if batch metrics of producers aren't collected, e.g. with shallow decoding
+	Batches bool
+
+	// Responses is true if responses are captured, so response time, response errors and lag are measured
+	Responses bool
+
+	Rebalances  bool
+	Schemas     bool
+	TopicNaming bool
+	ACLAudit    bool
+	DataFlow    bool
+}
+
+// Dashboard is a Grafana dashboard definition
+type Dashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Tags          []string          `json:"tags"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Refresh       string            `json:"refresh"`
+	Time          map[string]string `json:"time"`
+	Templating    struct {
+		List []DashboardVariable `json:"list"`
+	} `json:"templating"`
+	Panels []DashboardPanel `json:"panels"`
+}
+
+// DashboardVariable is a template variable of dashboard, e.g. data source or values of label
+type DashboardVariable struct {
+	Name       string `json:"name"`
+	Label      string `json:"label,omitempty"`
+	Type       string `json:"type"`
+	Datasource string `json:"datasource,omitempty"`
+	Query      string `json:"query"`
+	Refresh    int    `json:"refresh,omitempty"`
+	IncludeAll bool   `json:"includeAll"`
+	Multi      bool   `json:"multi"`
+	AllValue   string `json:"allValue,omitempty"`
+}
+
+// DashboardPanel is a time series or table panel of dashboard
+type DashboardPanel struct {
+	ID              int                      `json:"id"`
+	Type            string                   `json:"type"`
+	Title           string                   `json:"title"`
+	Datasource      string                   `json:"datasource"`
+	GridPos         map[string]int           `json:"gridPos"`
+	Targets         []DashboardTarget        `json:"targets"`
+	FieldConfig     map[string]interface{}   `json:"fieldConfig"`
+	Transformations []map[string]interface{} `json:"transformations,omitempty"`
+}
+
+// DashboardTarget is a prometheus query of panel
+type DashboardTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	Format       string `json:"format,omitempty"`
+	Instant      bool   `json:"instant,omitempty"`
+}
+
+// dashboard layout: graphs are two per row, tables take the whole row
+const (
+	dashboardWidth  = 24
+	dashboardHeight = 8
+	graphWidth      = 12
+)
+
+// rateWindow is a window of rates of counters in dashboard
+const rateWindow = "5m"
+
+// NewDashboard generates Grafana dashboard of sniffer metrics enabled by options: panels of disabled features
+// are skipped, metrics of clients are filtered and grouped by their optional labels, e.g. owner, and all
+// metrics are filtered by labels added to all of them, e.g. node
+func NewDashboard(opts DashboardOptions) *Dashboard {
+	b := newDashboardBuilder(opts)
+
+	b.graph("Requests by type", "reqps", fmt.Sprintf("sum by (request_type) (%s)", b.clientRate("typed_requests_total")), "{{request_type}}")
+	b.graph("Requests by client", "reqps", b.byClient(b.clientRate("typed_requests_total")), b.clientLegend())
+	b.graph("Requests by topic", "reqps", fmt.Sprintf("sum by (topic, request_type) (rate(%s[%s]))", b.metric("topic_typed_requests_total", `topic=~"$topic"`), rateWindow), "{{topic}} {{request_type}}")
+	if opts.Batches {
+		b.graph("Produced bytes by client", "Bps", b.byClient(b.clientRate("producer_batch_size")), b.clientLegend())
+		b.graph("Produced batch length by client", "short", b.byClient(b.clientRate("producer_batch_length")), b.clientLegend())
+	}
+	b.graph("Fetched blocks by client", "short", b.byClient(b.clientRate("blocks_requested")), b.clientLegend())
+	b.graph("Active connections by client", "short", b.byClient(b.client("active_connections_total")), b.clientLegend())
+	b.graph("Producer ids by client", "short", b.byClient(b.client("client_producer_ids")), b.clientLegend())
+	b.graph("Producer epoch bumps by client", "short", b.byClient(b.clientRate("producer_epoch_bumps_total")), b.clientLegend())
+
+	if opts.Responses {
+		b.graph("Response time p99 by api", "s", fmt.Sprintf("histogram_quantile(0.99, sum by (le, api) (rate(%s[%s])))", b.metric("response_time_seconds_bucket", ""), rateWindow), "{{api}}")
+		b.graph("Response errors by topic", "short", fmt.Sprintf("sum by (topic, request_type, error) (rate(%s[%s]))", b.client("response_errors_total", `topic=~"$topic"`), rateWindow), "{{topic}} {{request_type}} {{error}}")
+		b.graph("Consumer lag estimate by client", "short", b.byClient(b.client("consumer_lag_estimate", `topic=~"$topic"`)), b.clientLegend())
+	}
+	b.graph("Consumer group joins", "short", fmt.Sprintf("sum by (group) (rate(%s[%s]))", b.metric("group_join_requests_total", ""), rateWindow), "{{group}}")
+	b.graph("Transactions by topic", "short", fmt.Sprintf("sum by (topic, result) (rate(%s[%s]))", b.metric("transaction_markers_total", `topic=~"$topic"`), rateWindow), "{{topic}} {{result}}")
+	if opts.Rebalances {
+		b.graph("Consumer group rebalance storms", "short", fmt.Sprintf("sum by (group) (increase(%s[%s]))", b.metric("group_rebalance_storms_total", ""), rateWindow), "{{group}}")
+	}
+
+	b.table("Producers of topics", b.client("producer_topic_relation_info", `topic=~"$topic"`))
+	b.table("Consumers of topics", b.client("consumer_topic_relation_info", `topic=~"$topic"`))
+	if opts.DataFlow {
+		b.table("Data flows from producers to consumers", b.metric("data_flow_info", `topic=~"$topic"`))
+	}
+	b.table("Consumer group members", b.client("group_member_relation_info"))
+	b.table("Deprecated api versions of clients", b.client("client_api_version_info", `deprecated="true"`))
+	if opts.Schemas {
+		b.table("Schemas of produced records", b.client("producer_schema_relation_info", `topic=~"$topic"`))
+	}
+	if opts.TopicNaming {
+		b.table("Topic names violating naming rules", b.client("topic_name_violation_info", `topic=~"$topic"`))
+	}
+	if opts.ACLAudit {
+		b.graph("Unexpected access by ACLs", "short", fmt.Sprintf("sum by (principal, resource_type, resource, operation) (increase(%s[%s]))", b.metric("acl_unexpected_access_total", ""), rateWindow), "{{principal}} {{operation}} {{resource_type}} {{resource}}")
+	}
+
+	b.graph("Dropped packets", "pps", fmt.Sprintf("sum by (reason) (rate(%s[%s]))", b.metric("dropped_packets_total", ""), rateWindow), "{{reason}}")
+	b.graph("Skipped and retransmitted bytes", "Bps",
+		fmt.Sprintf("sum(rate(%s[%s]))", b.metric("skipped_bytes_total", ""), rateWindow), "skipped",
+		fmt.Sprintf("sum(rate(%s[%s]))", b.metric("retransmitted_bytes_total", ""), rateWindow), "retransmitted")
+	b.graph("Stream evictions", "short", fmt.Sprintf("sum by (reason) (increase(%s[%s]))", b.metric("stream_evictions_total", ""), rateWindow), "{{reason}}")
+	b.graph("Pipeline queues", "short", fmt.Sprintf("sum by (queue) (%s)", b.metric("internal_queue_length", "")), "{{queue}}")
+
+	return b.d
+}
+
+type dashboardBuilder struct {
+	d *Dashboard
+
+	// clientLabels are optional labels of metrics of clients
+	clientLabels []string
+	labels       []string
+
+	// x and y are position of the next panel
+	x, y int
+}
+
+func newDashboardBuilder(opts DashboardOptions) *dashboardBuilder {
+	b := &dashboardBuilder{
+		d: &Dashboard{
+			Title:         "Kafka sniffer",
+			UID:           "kafka-sniffer",
+			Tags:          []string{"kafka", "kafka-sniffer"},
+			SchemaVersion: 27,
+			Refresh:       "1m",
+			Time:          map[string]string{"from": "now-6h", "to": "now"},
+			Panels:        []DashboardPanel{},
+		},
+		labels: opts.Labels,
+	}
+	if opts.Owners {
+		b.clientLabels = append(b.clientLabels, ownerLabel)
+	}
+	if opts.Country {
+		b.clientLabels = append(b.clientLabels, countryLabel)
+	}
+	if opts.ASN {
+		b.clientLabels = append(b.clientLabels, asnLabel)
+	}
+
+	b.d.Templating.List = []DashboardVariable{{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"}}
+	for _, label := range b.labels {
+		b.variable(label, "build_info")
+	}
+	b.variable("client_ip", "typed_requests_total")
+	for _, label := range b.clientLabels {
+		b.variable(label, "typed_requests_total")
+	}
+	b.variable("topic", "producer_topic_relation_info")
+
+	return b
+}
+
+// variable adds variable of values of label of metric, all values are selected by default
+func (b *dashboardBuilder) variable(label, metric string) {
+	b.d.Templating.List = append(b.d.Templating.List, DashboardVariable{
+		Name:       label,
+		Type:       "query",
+		Datasource: "$datasource",
+		Query:      fmt.Sprintf("label_values(%s_%s, %s)", namespace, metric, label),
+		Refresh:    2,
+		IncludeAll: true,
+		Multi:      true,
+		AllValue:   ".*",
+	})
+}
+
+// metric returns selector of metric filtered by labels added to all metrics and matchers
+func (b *dashboardBuilder) metric(name, matchers string) string {
+	var all []string
+	for _, label := range b.labels {
+		all = append(all, fmt.Sprintf(`%s=~"$%s"`, label, label))
+	}
+	if matchers != "" {
+		all = append(all, matchers)
+	}
+	if len(all) == 0 {
+		return namespace + "_" + name
+	}
+	return fmt.Sprintf("%s_%s{%s}", namespace, name, strings.Join(all, ","))
+}
+
+// client returns selector of metric of clients filtered by client_ip and optional labels of clients
+func (b *dashboardBuilder) client(name string, matchers ...string) string {
+	all := []string{`client_ip=~"$client_ip"`}
+	for _, label := range b.clientLabels {
+		all = append(all, fmt.Sprintf(`%s=~"$%s"`, label, label))
+	}
+	return b.metric(name, strings.Join(append(all, matchers...), ","))
+}
+
+func (b *dashboardBuilder) clientRate(name string) string {
+	return fmt.Sprintf("rate(%s[%s])", b.client(name), rateWindow)
+}
+
+// byClient sums expression by client_ip and optional labels of clients
+func (b *dashboardBuilder) byClient(expr string) string {
+	return fmt.Sprintf("sum by (%s) (%s)", strings.Join(append([]string{"client_ip"}, b.clientLabels...), ", "), expr)
+}
+
+func (b *dashboardBuilder) clientLegend() string {
+	legend := "{{client_ip}}"
+	for _, label := range b.clientLabels {
+		legend += " {{" + label + "}}"
+	}
+	return legend
+}
+
+// graph adds time series panel of pairs of expressions and their legends
+func (b *dashboardBuilder) graph(title, unit string, exprLegends ...string) {
+	p := b.panel("timeseries", title, graphWidth)
+	for i := 0; i+1 < len(exprLegends); i += 2 {
+		p.Targets = append(p.Targets, DashboardTarget{RefID: refID(len(p.Targets)), Expr: exprLegends[i], LegendFormat: exprLegends[i+1]})
+	}
+	p.FieldConfig = map[string]interface{}{"defaults": map[string]interface{}{"unit": unit}, "overrides": []interface{}{}}
+}
+
+// table adds table panel of current series of relation metric, a column per label
+func (b *dashboardBuilder) table(title, expr string) {
+	p := b.panel("table", title, dashboardWidth)
+	p.Targets = []DashboardTarget{{RefID: refID(0), Expr: expr, Format: "table", Instant: true}}
+	p.FieldConfig = map[string]interface{}{"defaults": map[string]interface{}{}, "overrides": []interface{}{}}
+	p.Transformations = []map[string]interface{}{{
+		"id": "organize",
+		"options": map[string]interface{}{
+			"excludeByName": map[string]bool{"Time": true, "Value": true, "__name__": true},
+		},
+	}}
+}
+
+// panel adds panel of width at the next position, it's moved to the next row if the current one is full
+func (b *dashboardBuilder) panel(kind, title string, width int) *DashboardPanel {
+	if b.x+width > dashboardWidth {
+		b.x, b.y = 0, b.y+dashboardHeight
+	}
+
+	b.d.Panels = append(b.d.Panels, DashboardPanel{
+		ID:         len(b.d.Panels) + 1,
+		Type:       kind,
+		Title:      title,
+		Datasource: "$datasource",
+		GridPos:    map[string]int{"x": b.x, "y": b.y, "w": width, "h": dashboardHeight},
+	})
+	b.x += width
+
+	return &b.d.Panels[len(b.d.Panels)-1]
+}
+
+// refID returns id of i-th query of panel: A, B, C...
+func refID(i int) string {
+	return string(rune('A' + i))
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// DataFlow exports edges of data flows from producers to consumers through topics by data_flow_info metric, it
+// answers who consumes what a client writes without joins in queries. Edges are joined from current producer
+// and consumer relations of storage on every scrape, so they expire together with relations.
+type DataFlow struct {
+	storage *Storage
+	desc    *prometheus.Desc
+}
+
+// dataFlowEdge is a flow from producer to consumer through topic
+type dataFlowEdge struct {
+	producer, topic, consumer string
+}
+
+// NewDataFlow creates DataFlow of relations of storage and registers it
+func NewDataFlow(registerer prometheus.Registerer, storage *Storage) *DataFlow {
+	f := &DataFlow{
+		storage: storage,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "data_flow_info"),
+			"Data flow from producer to consumer through topic, it's joined from current producer_topic_relation_info and consumer_topic_relation_info",
+			[]string{"producer_ip", "topic", "consumer_ip"}, nil,
+		),
+	}
+
+	registerer.MustRegister(f)
+
+	return f
+}
+
+// Describe implements prometheus.Collector
+func (f *DataFlow) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+// Collect implements prometheus.Collector
+func (f *DataFlow) Collect(ch chan<- prometheus.Metric) {
+	for edge := range f.edges() {
+		ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, 1, edge.producer, edge.topic, edge.consumer)
+	}
+}
+
+// edges joins producers and consumers by topics, relations of a client differing by principal or connection
+// make the same edge
+func (f *DataFlow) edges() map[dataFlowEdge]bool {
+	producers := make(map[string]map[string]bool)
+	for _, r := range f.storage.ProducerTopicRelations() {
+		if producers[r.Topic] == nil {
+			producers[r.Topic] = make(map[string]bool)
+		}
+		producers[r.Topic][r.ClientIP] = true
+	}
+
+	edges := make(map[dataFlowEdge]bool)
+	for _, r := range f.storage.ConsumerTopicRelations() {
+		for producer := range producers[r.Topic] {
+			edges[dataFlowEdge{producer: producer, topic: r.Topic, consumer: r.ClientIP}] = true
+		}
+	}
+
+	return edges
+}
@@ -10,6 +10,27 @@ var (
 		Help:      "Total requests to kafka by type",
 	}, []string{"client_ip", "request_type"})
 
+	// TopicRequestsCount is a prometheus metric. See info field
+	TopicRequestsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "topic_typed_requests_total",
+		Help:      "Total requests to kafka by topic and type, request of several topics is counted by each of them",
+	}, []string{"topic", "request_type"})
+
+	// TransactionMarkers is a prometheus metric. See info field
+	TransactionMarkers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transaction_markers_total",
+		Help:      "Total transaction markers written by coordinators to topics by result, commit or abort, transaction of several partitions of topic is counted once",
+	}, []string{"topic", "result"})
+
+	// OversizedBatches is a prometheus metric. See info field
+	OversizedBatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "oversized_batches_total",
+		Help:      "Total produced record batches and message sets larger than max message size, brokers reject them with MESSAGE_TOO_LARGE",
+	}, []string{"client_ip", "topic"})
+
 	// ProducerBatchLen is a prometheus metric. See info field
 	ProducerBatchLen = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: namespace,
@@ -30,10 +51,112 @@ var (
 		Name:      "blocks_requested",
 		Help:      "Total size of a batch in producer request to kafka",
 	}, []string{"client_ip"})
+
+	// GroupJoinRequests is a prometheus metric. See info field
+	GroupJoinRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "group_join_requests_total",
+		Help:      "Total JoinGroup requests by consumer group",
+	}, []string{"group"})
+
+	// GroupSyncRequests is a prometheus metric. See info field
+	GroupSyncRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "group_sync_requests_total",
+		Help:      "Total SyncGroup requests by consumer group",
+	}, []string{"group"})
+
+	// CaptureReopens is a prometheus metric. See info field
+	CaptureReopens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "capture_reopens_total",
+		Help:      "Total reopens of packet capture after capture errors",
+	}, []string{"interface"})
+
+	// Resyncs is a prometheus metric. See info field
+	Resyncs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resync_total",
+		Help:      "Total resynchronizations of request streams by the next plausible request header",
+	})
+
+	// SkippedBytes is a prometheus metric. See info field
+	SkippedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "skipped_bytes_total",
+		Help:      "Total bytes of requests skipped because their bytes are missing in capture, e.g. cut by snaplen",
+	})
+
+	// RetransmittedBytes is a prometheus metric. See info field
+	RetransmittedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retransmitted_bytes_total",
+		Help:      "Total bytes of retransmitted or overlapping TCP segments discarded by reassembly, they aren't decoded twice",
+	})
+
+	// DroppedPackets is a prometheus metric. See info field
+	DroppedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_packets_total",
+		Help:      "Total packets intentionally dropped by sniffer, e.g. because of backpressure of full queue of assembler",
+	}, []string{"reason"})
+
+	// DroppedEvents is a prometheus metric. See info field
+	DroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dropped_events_total",
+		Help:      "Total events dropped by outputs which can't keep up with them, e.g. because of full buffer",
+	}, []string{"output"})
+
+	// ResponseTime is a prometheus metric. See info field
+	ResponseTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "response_time_seconds",
+		Help:      "Time between kafka request and response by api",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api"})
+
+	// StreamBufferedBytes is a prometheus metric. See info field
+	StreamBufferedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stream_buffered_bytes",
+		Help:      "Bytes of reassembled streams buffered until they are decoded",
+	})
+
+	// StreamEvictions is a prometheus metric. See info field
+	StreamEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_evictions_total",
+		Help:      "Total streams evicted with their buffered data by exceeded memory limit",
+	}, []string{"reason"})
+
+	// ResponseErrors is a prometheus metric. See info field
+	ResponseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "response_errors_total",
+		Help:      "Total errors of partitions in produce and fetch responses by client, topic, request_type (produce or fetch) and error name, e.g. NOT_LEADER_OR_FOLLOWER, topic is empty for errors of fetch sessions, responses are decoded only if they are captured",
+	}, []string{"client_ip", "topic", "request_type", "error"})
+
+	// TLSDecryptionErrors is a prometheus metric. See info field
+	TLSDecryptionErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tls_decryption_errors_total",
+		Help:      "Total directions of TLS connections which couldn't be decrypted, e.g. because of missing keys",
+	})
 )
 
 func init() {
-	prometheus.MustRegister(RequestsCount, ProducerBatchLen, ProducerBatchSize, BlocksRequested)
+	prometheus.MustRegister(RequestsCount, TopicRequestsCount, TransactionMarkers, OversizedBatches, ProducerBatchLen, ProducerBatchSize, BlocksRequested, GroupJoinRequests, GroupSyncRequests, CaptureReopens, Resyncs, SkippedBytes, RetransmittedBytes, DroppedPackets, DroppedEvents, ResponseTime, StreamBufferedBytes, StreamEvictions, ResponseErrors, TLSDecryptionErrors)
+}
+
+// ObserveWithTrace observes value with trace id as exemplar, so a spike of metric can be followed to a trace of
+// request. Value is observed without exemplar if trace id is empty.
+func ObserveWithTrace(o prometheus.Observer, value float64, traceID string) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && traceID != "" {
+		eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	o.Observe(value)
 }
 
 // ClientMetricsCollector is an interface, which allows to collect metrics for concrete client
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// country and asn labels are added to metrics with client_ip label
+const (
+	countryLabel = "country"
+	asnLabel     = "asn"
+)
+
+// GeoIP looks up country and autonomous system of client addresses in MaxMind databases (GeoLite2 or GeoIP2
+// Country/City and ASN), e.g. to spot unexpected external producers of clusters exposed beyond the datacenter
+type GeoIP struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// GeoInfo is a location of client address, fields are empty if they aren't known
+type GeoInfo struct {
+	// Country is ISO 3166-1 code of country, e.g. DE
+	Country string
+
+	// ASN and ASOrg are number and organization of autonomous system
+	ASN   uint
+	ASOrg string
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// OpenGeoIP opens country and ASN databases, any of them may be empty to skip the lookup
+func OpenGeoIP(countryDB, asnDB string) (*GeoIP, error) {
+	g := &GeoIP{}
+
+	if countryDB != "" {
+		reader, err := maxminddb.Open(countryDB)
+		if err != nil {
+			return nil, fmt.Errorf("could not open country database: %s", err)
+		}
+		g.country = reader
+	}
+
+	if asnDB != "" {
+		reader, err := maxminddb.Open(asnDB)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("could not open ASN database: %s", err)
+		}
+		g.asn = reader
+	}
+
+	return g, nil
+}
+
+// Lookup returns location of client address as it's rendered in client_ip label, location is empty for private
+// addresses, unknown addresses or if g is nil
+func (g *GeoIP) Lookup(clientIP string) GeoInfo {
+	var info GeoInfo
+	if g == nil {
+		return info
+	}
+
+	ip := parseClientIP(clientIP)
+	if ip == nil {
+		return info
+	}
+
+	if g.country != nil {
+		var record countryRecord
+		if err := g.country.Lookup(ip, &record); err == nil {
+			info.Country = record.Country.ISOCode
+		}
+	}
+
+	if g.asn != nil {
+		var record asnRecord
+		if err := g.asn.Lookup(ip, &record); err == nil {
+			info.ASN, info.ASOrg = record.Number, record.Organization
+		}
+	}
+
+	return info
+}
+
+// Close closes databases
+func (g *GeoIP) Close() error {
+	var err error
+	if g.country != nil {
+		err = g.country.Close()
+	}
+	if g.asn != nil {
+		if asnErr := g.asn.Close(); asnErr != nil {
+			err = asnErr
+		}
+	}
+	return err
+}
+
+// NewGeoIPGatherer returns gatherer adding country and asn labels to metrics of gatherer with client_ip label,
+// labels which aren't known are skipped
+func NewGeoIPGatherer(gatherer prometheus.Gatherer, geoIP *GeoIP) prometheus.Gatherer {
+	return NewClientGatherer(gatherer, func(clientIP string) map[string]string {
+		info := geoIP.Lookup(clientIP)
+
+		labels := make(map[string]string, 2)
+		if info.Country != "" {
+			labels[countryLabel] = info.Country
+		}
+		if info.ASN != 0 {
+			labels[asnLabel] = strconv.FormatUint(uint64(info.ASN), 10)
+		}
+		return labels
+	})
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/graphite"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// NewGraphiteBridge creates bridge which pushes sniffer metrics from gatherer to graphite addr (host:port)
+// using plaintext protocol. Go runtime and process metrics aren't pushed.
+func NewGraphiteBridge(gatherer prometheus.Gatherer, addr, prefix string, interval time.Duration, useTags bool) (*graphite.Bridge, error) {
+	return graphite.NewBridge(&graphite.Config{
+		URL:           addr,
+		Prefix:        prefix,
+		Interval:      interval,
+		UseTags:       useTags,
+		Gatherer:      snifferGatherer{gatherer},
+		Logger:        log.New(os.Stderr, "graphite: ", log.LstdFlags),
+		ErrorHandling: graphite.ContinueOnError,
+	})
+}
+
+// snifferGatherer gathers only metrics of kafka_sniffer namespace
+type snifferGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g snifferGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+
+	filtered := mfs[:0]
+	for _, mf := range mfs {
+		if strings.HasPrefix(mf.GetName(), namespace+"_") {
+			filtered = append(filtered, mf)
+		}
+	}
+
+	return filtered, err
+}
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"runtime"
+
 	"github.com/d-ulyanov/kafka-sniffer/version"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,8 +14,67 @@ var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 	Help:      "Kafka sniffer build info",
 }, []string{"version", "revision", "branch"})
 
+var (
+	// InternalStageDuration is a prometheus metric. See info field
+	InternalStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "internal_stage_duration_seconds",
+		Help:      "Processing time of packets and requests by stage of sniffer pipeline: dispatch (including wait for full queue), assemble, decode and output",
+		Buckets:   prometheus.ExponentialBuckets(1e-6, 4, 10),
+	}, []string{"stage"})
+
+	// InternalStreamDecoders is a prometheus metric. See info field
+	InternalStreamDecoders = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "internal_stream_decoders",
+		Help:      "Running goroutines decoding requests and responses of TCP streams",
+	})
+
+	// InternalDegraded is a prometheus metric. See info field
+	InternalDegraded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "internal_degraded",
+		Help:      "Is 1 when decoding is degraded by watchdog because sniffer exceeds its CPU or memory limits",
+	})
+
+	internalGoroutines = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "internal_goroutines",
+		Help:      "Goroutines of sniffer",
+	}, func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	internalHeapBytes = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "internal_heap_bytes",
+		Help:      "Bytes of allocated heap objects of sniffer",
+	}, func() float64 {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return float64(stats.HeapAlloc)
+	})
+)
+
 func init() {
-	prometheus.MustRegister(buildInfo)
+	prometheus.MustRegister(buildInfo, InternalStageDuration, InternalStreamDecoders, InternalDegraded, internalGoroutines, internalHeapBytes)
 
 	buildInfo.WithLabelValues(version.Version, version.Revision, version.Branch)
 }
+
+// RegisterQueue exports length of queue of sniffer pipeline, e.g. channel of packets of assembler, as
+// internal_queue_length metric with queue label. Queue registered with the same name before is replaced, e.g.
+// by the next pipeline of embedded sniffer.
+func RegisterQueue(name string, length func() int) {
+	queue := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "internal_queue_length",
+		Help:        "Items waiting in queue of sniffer pipeline, a full queue means the next stage is a bottleneck",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 {
+		return float64(length())
+	})
+
+	prometheus.Unregister(queue)
+	prometheus.MustRegister(queue)
+}
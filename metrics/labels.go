@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// NewLabeledGatherer returns gatherer adding const labels to all metrics of gatherer, e.g. node and zone of
+// sniffer. Labels already set on a metric aren't overridden.
+func NewLabeledGatherer(gatherer prometheus.Gatherer, labels map[string]string) prometheus.Gatherer {
+	return labeledGatherer{Gatherer: gatherer, labels: labels}
+}
+
+type labeledGatherer struct {
+	prometheus.Gatherer
+	labels map[string]string
+}
+
+func (g labeledGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.Label = g.addLabels(m.Label)
+		}
+	}
+
+	return mfs, err
+}
+
+// addLabels adds labels missing in pairs, pairs are kept sorted by name
+func (g labeledGatherer) addLabels(pairs []*dto.LabelPair) []*dto.LabelPair {
+	set := make(map[string]bool, len(pairs))
+	for _, p := range pairs {
+		set[p.GetName()] = true
+	}
+
+	for name, value := range g.labels {
+		if set[name] {
+			continue
+		}
+		name, value := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].GetName() < pairs[j].GetName() })
+
+	return pairs
+}
+
+// NewClientGatherer returns gatherer adding labels of client to metrics of gatherer with client_ip label,
+// labels of client are returned by labels func by value of client_ip label
+func NewClientGatherer(gatherer prometheus.Gatherer, labels func(clientIP string) map[string]string) prometheus.Gatherer {
+	return clientGatherer{Gatherer: gatherer, labels: labels}
+}
+
+type clientGatherer struct {
+	prometheus.Gatherer
+	labels func(clientIP string) map[string]string
+}
+
+func (g clientGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+
+	// clients have many metrics, their labels are resolved once per gathering
+	cache := make(map[string]map[string]string)
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			for _, p := range m.Label {
+				if p.GetName() != "client_ip" {
+					continue
+				}
+
+				labels, ok := cache[p.GetValue()]
+				if !ok {
+					labels = g.labels(p.GetValue())
+					cache[p.GetValue()] = labels
+				}
+				if len(labels) > 0 {
+					m.Label = labeledGatherer{labels: labels}.addLabels(m.Label)
+				}
+				break
+			}
+		}
+	}
+
+	return mfs, err
+}
+
+// parseClientIP parses client address as it's rendered in client_ip label: IP, IPv6 in brackets or IPv6
+// prefix, address of prefix is returned for prefix. It's nil for invalid address.
+func parseClientIP(clientIP string) net.IP {
+	addr := strings.Trim(clientIP, "[]")
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		addr = addr[:i]
+	}
+	return net.ParseIP(addr)
+}
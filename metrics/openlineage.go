@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	openLineageProducer  = "https://github.com/d-ulyanov/kafka-sniffer"
+	openLineageSchemaURL = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/$defs/RunEvent"
+
+	openLineageTimeout = 10 * time.Second
+)
+
+// openLineageEvent is an OpenLineage run event, see https://openlineage.io/docs/spec/object-model
+type openLineageEvent struct {
+	EventType string               `json:"eventType"`
+	EventTime time.Time            `json:"eventTime"`
+	Run       openLineageRun       `json:"run"`
+	Job       openLineageJob       `json:"job"`
+	Inputs    []openLineageDataset `json:"inputs"`
+	Outputs   []openLineageDataset `json:"outputs"`
+	Producer  string               `json:"producer"`
+	SchemaURL string               `json:"schemaURL"`
+}
+
+type openLineageRun struct {
+	RunID string `json:"runId"`
+}
+
+type openLineageJob struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type openLineageDataset struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// OpenLineageExporter periodically posts observed data flows of the storage as OpenLineage run events, so
+// passively discovered lineage plugs into catalogs like Marquez or DataHub. Every client is a job reading its
+// consumed topics and writing its produced topics, the job is named by owner of the client, by principal or
+// by client ip. Every export is a completed run of every job observed during the expiration time of relations.
+type OpenLineageExporter struct {
+	url              string
+	token            string
+	jobNamespace     string
+	datasetNamespace string
+
+	storage *Storage
+	owners  *Owners
+	client  *http.Client
+}
+
+// NewOpenLineageExporter creates new OpenLineageExporter posting events to url, e.g.
+// http://marquez:5000/api/v1/lineage, with bearer token if it isn't empty. Topics are datasets of
+// datasetNamespace, e.g. kafka://broker1:9092. Owners may be nil.
+func NewOpenLineageExporter(url, token, jobNamespace, datasetNamespace string, storage *Storage, owners *Owners) *OpenLineageExporter {
+	return &OpenLineageExporter{
+		url:              url,
+		token:            token,
+		jobNamespace:     jobNamespace,
+		datasetNamespace: datasetNamespace,
+		storage:          storage,
+		owners:           owners,
+		client:           &http.Client{Timeout: openLineageTimeout},
+	}
+}
+
+// Run exports lineage every interval
+func (e *OpenLineageExporter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := e.Export(); err != nil {
+			log.Printf("could not export lineage: %s\n", err)
+		}
+	}
+}
+
+// Export posts run event of every job, the first error is returned
+func (e *OpenLineageExporter) Export() error {
+	var firstErr error
+	for _, event := range e.events(time.Now()) {
+		if err := e.post(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// events returns run events of jobs of current relations, inter-broker connections aren't jobs
+func (e *OpenLineageExporter) events(now time.Time) []*openLineageEvent {
+	jobs := make(map[string]*openLineageEvent)
+	job := func(r Relation) *openLineageEvent {
+		name := e.owners.Owner(r.ClientIP)
+		if name == "" {
+			name = r.Principal
+		}
+		if name == "" {
+			name = r.ClientIP
+		}
+
+		event, ok := jobs[name]
+		if !ok {
+			event = &openLineageEvent{
+				EventType: "COMPLETE",
+				EventTime: now,
+				Run:       openLineageRun{RunID: newUUID()},
+				Job:       openLineageJob{Namespace: e.jobNamespace, Name: name},
+				Inputs:    []openLineageDataset{},
+				Outputs:   []openLineageDataset{},
+				Producer:  openLineageProducer,
+				SchemaURL: openLineageSchemaURL,
+			}
+			jobs[name] = event
+		}
+		return event
+	}
+
+	for _, r := range e.storage.ConsumerTopicRelations() {
+		if r.Connection != "inter_broker" {
+			event := job(r)
+			event.Inputs = appendDataset(event.Inputs, openLineageDataset{Namespace: e.datasetNamespace, Name: r.Topic})
+		}
+	}
+	for _, r := range e.storage.ProducerTopicRelations() {
+		if r.Connection != "inter_broker" {
+			event := job(r)
+			event.Outputs = appendDataset(event.Outputs, openLineageDataset{Namespace: e.datasetNamespace, Name: r.Topic})
+		}
+	}
+
+	out := make([]*openLineageEvent, 0, len(jobs))
+	for _, event := range jobs {
+		sort.Slice(event.Inputs, func(i, j int) bool { return event.Inputs[i].Name < event.Inputs[j].Name })
+		sort.Slice(event.Outputs, func(i, j int) bool { return event.Outputs[i].Name < event.Outputs[j].Name })
+		out = append(out, event)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Job.Name < out[j].Job.Name })
+
+	return out
+}
+
+func (e *OpenLineageExporter) post(event *openLineageEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("lineage of job %s is rejected with %s", event.Job.Name, resp.Status)
+	}
+	return nil
+}
+
+// appendDataset appends dataset if it isn't in datasets yet, e.g. a topic written by many addresses of the job
+func appendDataset(datasets []openLineageDataset, dataset openLineageDataset) []openLineageDataset {
+	for _, d := range datasets {
+		if d == dataset {
+			return datasets
+		}
+	}
+	return append(datasets, dataset)
+}
+
+// newUUID returns random UUID (version 4) of run
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ownerLabel is a label with owner of client added to metrics with client_ip label
+const ownerLabel = "owner"
+
+// Owners maps client addresses to their owners, e.g. teams or services, by CIDRs or IPs. The most specific
+// CIDR wins.
+type Owners struct {
+	// nets are sorted from the most specific one
+	nets []ownerNet
+}
+
+type ownerNet struct {
+	net   *net.IPNet
+	owner string
+}
+
+// LoadOwners loads mapping file with "<CIDR or IP> <owner>" lines, empty lines and lines starting with # are
+// skipped, e.g.:
+//
+//	10.1.0.0/16   payments-service
+//	10.1.2.3      billing-cron
+func LoadOwners(path string) (*Owners, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := &Owners{}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected CIDR or IP and owner", path, line)
+		}
+
+		ipNet, err := parseOwnerNet(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		o.nets = append(o.nets, ownerNet{net: ipNet, owner: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(o.nets, func(i, j int) bool {
+		ones1, _ := o.nets[i].net.Mask.Size()
+		ones2, _ := o.nets[j].net.Mask.Size()
+		return ones1 > ones2
+	})
+
+	return o, nil
+}
+
+// parseOwnerNet parses CIDR or IP as network of the single address
+func parseOwnerNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Owner returns owner of client address as it's rendered in client_ip label: IP, IPv6 in brackets or IPv6
+// prefix. It's empty if the owner isn't known or owners are nil.
+func (o *Owners) Owner(clientIP string) string {
+	if o == nil {
+		return ""
+	}
+
+	ip := parseClientIP(clientIP)
+	if ip == nil {
+		return ""
+	}
+
+	for _, n := range o.nets {
+		if n.net.Contains(ip) {
+			return n.owner
+		}
+	}
+	return ""
+}
+
+// NewOwnerGatherer returns gatherer adding owner label to metrics of gatherer with client_ip label, metrics
+// of clients with unknown owners don't have the label
+func NewOwnerGatherer(gatherer prometheus.Gatherer, owners *Owners) prometheus.Gatherer {
+	return NewClientGatherer(gatherer, func(clientIP string) map[string]string {
+		if owner := owners.Owner(clientIP); owner != "" {
+			return map[string]string{ownerLabel: owner}
+		}
+		return nil
+	})
+}
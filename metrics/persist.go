@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var relationsBucket = []byte("relations")
+
+// Persister periodically saves relations of the storage into bolt database and restores them on startup,
+// so restart of the sniffer doesn't wipe relations collected before.
+type Persister struct {
+	db      *bolt.DB
+	storage *Storage
+}
+
+// OpenPersister opens (or creates) bolt database file
+func OpenPersister(path string, storage *Storage) (*Persister, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Persister{db: db, storage: storage}, nil
+}
+
+// Restore loads saved relations into the storage
+func (p *Persister) Restore() error {
+	snapshot := make(map[string][]SavedRelation)
+
+	err := p.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(relationsBucket)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var relations []SavedRelation
+			if err := json.Unmarshal(v, &relations); err != nil {
+				return err
+			}
+			snapshot[string(k)] = relations
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	p.storage.Restore(snapshot)
+
+	return nil
+}
+
+// Save saves current relations of the storage
+func (p *Persister) Save() error {
+	snapshot := p.storage.Snapshot()
+
+	return p.db.Update(func(tx *bolt.Tx) error {
+		// recreate bucket to drop expired relations
+		if tx.Bucket(relationsBucket) != nil {
+			if err := tx.DeleteBucket(relationsBucket); err != nil {
+				return err
+			}
+		}
+
+		b, err := tx.CreateBucket(relationsBucket)
+		if err != nil {
+			return err
+		}
+
+		for name, relations := range snapshot {
+			v, err := json.Marshal(relations)
+			if err != nil {
+				return err
+			}
+			if err = b.Put([]byte(name), v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Run saves relations every interval
+func (p *Persister) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := p.Save(); err != nil {
+			log.Printf("could not save relations: %s\n", err)
+		}
+	}
+}
+
+// Close saves relations and closes database
+func (p *Persister) Close() error {
+	if err := p.Save(); err != nil {
+		p.db.Close()
+		return err
+	}
+	return p.db.Close()
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProducerIDs tracks ids and epochs of idempotent and transactional producers seen in record batches. Restarted
+// idempotent producer gets a new id, so a growing count of ids of a client reveals restarts. Transactional
+// producer keeps its id between restarts and bumps its epoch on initialization, fencing the previous instance
+// with the same transactional id: batches of the stale epoch written after the bump come from a zombie.
+type ProducerIDs struct {
+	expireTime time.Duration
+
+	desc        *prometheus.Desc
+	epochBumps  *prometheus.CounterVec
+	staleEpochs *prometheus.CounterVec
+
+	mux       sync.Mutex
+	producers map[int64]*producerState
+}
+
+// producerState is the latest epoch of producer id and a client it was seen from
+type producerState struct {
+	clientIP string
+	epoch    int16
+	seenAt   time.Time
+}
+
+// NewProducerIDs creates ProducerIDs and registers its metrics, ids unseen for expireTime are forgotten
+func NewProducerIDs(registerer prometheus.Registerer, expireTime time.Duration) *ProducerIDs {
+	p := &ProducerIDs{
+		expireTime: expireTime,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "client_producer_ids"),
+			"Distinct ids of idempotent and transactional producers of client seen during metrics expiration time",
+			[]string{"client_ip"}, nil,
+		),
+		epochBumps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "producer_epoch_bumps_total",
+			Help:      "Total bumps of epochs of producer ids by client, e.g. transactional producer restarted and fenced its previous instance",
+		}, []string{"client_ip"}),
+		staleEpochs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "producer_stale_epoch_requests_total",
+			Help:      "Total produce requests by client with an epoch older than the latest epoch of producer id, they come from fenced zombie producers",
+		}, []string{"client_ip"}),
+		producers: make(map[int64]*producerState),
+	}
+
+	registerer.MustRegister(p, p.epochBumps, p.staleEpochs)
+
+	return p
+}
+
+// Observe registers producer id with epoch seen in produce request of client at time now
+func (p *ProducerIDs) Observe(clientIP string, producerID int64, epoch int16, now time.Time) {
+	if p == nil || producerID < 0 {
+		return
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	s, ok := p.producers[producerID]
+	if !ok || now.Sub(s.seenAt) > p.expireTime {
+		p.producers[producerID] = &producerState{clientIP: clientIP, epoch: epoch, seenAt: now}
+		return
+	}
+
+	switch {
+	case epoch > s.epoch:
+		p.epochBumps.WithLabelValues(clientIP).Inc()
+		s.clientIP = clientIP
+		s.epoch = epoch
+	case epoch < s.epoch:
+		// the zombie doesn't own the id anymore, so the id stays with the client of the latest epoch
+		p.staleEpochs.WithLabelValues(clientIP).Inc()
+	default:
+		s.clientIP = clientIP
+	}
+	s.seenAt = now
+}
+
+// Describe implements prometheus.Collector
+func (p *ProducerIDs) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.desc
+}
+
+// Collect implements prometheus.Collector, expired ids are forgotten on collection
+func (p *ProducerIDs) Collect(ch chan<- prometheus.Metric) {
+	for clientIP, count := range p.counts(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(count), clientIP)
+	}
+}
+
+// counts returns counts of ids by clients at time now
+func (p *ProducerIDs) counts(now time.Time) map[string]int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	counts := make(map[string]int)
+	for id, s := range p.producers {
+		if now.Sub(s.seenAt) > p.expireTime {
+			delete(p.producers, id)
+			continue
+		}
+		counts[s.clientIP]++
+	}
+	return counts
+}
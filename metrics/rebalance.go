@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const rebalanceWindow = time.Minute
+
+// RebalanceDetector counts rebalances of consumer groups and detects rebalance storms. Every rebalance
+// ends with SyncGroup requests of a new generation, so a rebalance is counted once per generation id.
+// Groups which aren't observed during the window are forgotten like expired relations.
+type RebalanceDetector struct {
+	threshold int
+	window    time.Duration
+
+	rebalancesTotal *prometheus.CounterVec
+	stormsTotal     *prometheus.CounterVec
+
+	mux    sync.Mutex
+	groups map[string]*groupGenerations
+}
+
+// groupGenerations contains generations of the group observed during the last rebalanceWindow, timer expires
+// the group when it isn't observed during the window since lastSeen
+type groupGenerations struct {
+	lastGeneration int32
+	seenAt         []time.Time
+
+	timer    *time.Timer
+	lastSeen time.Time
+}
+
+// NewRebalanceDetector creates new RebalanceDetector. Group is considered in rebalance storm when
+// it rebalances more than threshold times per minute, zero threshold disables storm detection.
+func NewRebalanceDetector(registerer prometheus.Registerer, threshold int) *RebalanceDetector {
+	var d = &RebalanceDetector{
+		threshold: threshold,
+		window:    rebalanceWindow,
+		rebalancesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "group_rebalances_total",
+			Help:      "Total rebalances (new generations) of consumer group",
+		}, []string{"group"}),
+		stormsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "group_rebalance_storms_total",
+			Help:      "Total times consumer group exceeded allowed rebalances per minute",
+		}, []string{"group"}),
+		groups: make(map[string]*groupGenerations),
+	}
+
+	registerer.MustRegister(d.rebalancesTotal, d.stormsTotal)
+
+	return d
+}
+
+// ObserveGeneration registers generation of the group seen in SyncGroup request. It returns count of
+// rebalances during the last minute and true when this generation has started a rebalance storm.
+func (d *RebalanceDetector) ObserveGeneration(group string, generation int32, now time.Time) (int, bool) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	g, ok := d.groups[group]
+	if !ok {
+		g = &groupGenerations{lastGeneration: -1}
+		g.timer = time.AfterFunc(d.window, func() { d.expire(group, g) })
+		d.groups[group] = g
+	} else {
+		g.timer.Reset(d.window)
+	}
+	g.lastSeen = time.Now()
+
+	// the whole group sends SyncGroup with the same generation, count it only once
+	if generation == g.lastGeneration {
+		return len(g.seenAt), false
+	}
+	g.lastGeneration = generation
+
+	// forget rebalances which are out of the window
+	var (
+		since = now.Add(-d.window)
+		i     int
+	)
+	for i < len(g.seenAt) && g.seenAt[i].Before(since) {
+		i++
+	}
+	g.seenAt = append(g.seenAt[i:], now)
+
+	d.rebalancesTotal.WithLabelValues(group).Inc()
+
+	// report the storm once, when the threshold is exceeded
+	if d.threshold <= 0 || len(g.seenAt) != d.threshold+1 {
+		return len(g.seenAt), false
+	}
+
+	d.stormsTotal.WithLabelValues(group).Inc()
+
+	return len(g.seenAt), true
+}
+
+// expire forgets generations of the group unless it has been observed again since its timer fired
+func (d *RebalanceDetector) expire(group string, g *groupGenerations) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.groups[group] == g && time.Since(g.lastSeen) >= d.window {
+		delete(d.groups, group)
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRebalanceDetector(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	d := NewRebalanceDetector(prometheus.NewRegistry(), 2)
+
+	for _, c := range []struct {
+		at         time.Duration
+		generation int32
+		count      int
+		storm      bool
+	}{
+		{0, 1, 1, false},
+		// the whole group sends SyncGroup with the same generation
+		{time.Second, 1, 1, false},
+		{10 * time.Second, 2, 2, false},
+		{20 * time.Second, 3, 3, true},
+		// the storm is reported once
+		{30 * time.Second, 4, 4, false},
+		// rebalances out of the window are forgotten
+		{75 * time.Second, 5, 3, true},
+	} {
+		count, storm := d.ObserveGeneration("billing", c.generation, start.Add(c.at))
+		if count != c.count || storm != c.storm {
+			t.Errorf("generation %d at %s: %d rebalances, storm %t, want %d, %t", c.generation, c.at, count, storm, c.count, c.storm)
+		}
+	}
+}
+
+func TestRebalanceDetectorExpiration(t *testing.T) {
+	d := NewRebalanceDetector(prometheus.NewRegistry(), 2)
+	d.window = 50 * time.Millisecond
+
+	groups := func() int {
+		d.mux.Lock()
+		defer d.mux.Unlock()
+		return len(d.groups)
+	}
+
+	// group observed during the window isn't expired
+	for i := 0; i < 5; i++ {
+		d.ObserveGeneration("billing", 1, time.Now())
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := groups(); n != 1 {
+		t.Fatalf("%d groups are observed, want 1", n)
+	}
+
+	// idle group is forgotten and its generation starts a new rebalance
+	deadline := time.Now().Add(time.Second)
+	for groups() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := groups(); n != 0 {
+		t.Fatalf("%d idle groups aren't expired", n)
+	}
+	if count, _ := d.ObserveGeneration("billing", 1, time.Now()); count != 1 {
+		t.Errorf("expired group has %d rebalances, want 1", count)
+	}
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	schemaRegistryTimeout = 10 * time.Second
+
+	// schemaRetryInterval is an interval of retrying lookup of schema id which isn't found or failed
+	schemaRetryInterval = 5 * time.Minute
+
+	// schemaQueueSize limits schema ids waiting for lookup, ids are looked up again by the next records
+	schemaQueueSize = 1000
+)
+
+// SchemaSubject is a subject and version of schema in Schema Registry
+type SchemaSubject struct {
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// String returns subject with version, e.g. orders-value v7
+func (s SchemaSubject) String() string {
+	return fmt.Sprintf("%s v%d", s.Subject, s.Version)
+}
+
+// schemaLookup is a result of lookup of schema id, subjects are nil if the lookup failed
+type schemaLookup struct {
+	subjects []SchemaSubject
+	at       time.Time
+}
+
+// SchemaRegistry resolves ids of schemas detected in records into subjects and versions by Confluent Schema
+// Registry API. Ids are looked up in background, so decoding isn't blocked by the registry, and cached:
+// an id is resolved by records following the first ones.
+type SchemaRegistry struct {
+	url    string
+	user   string
+	pass   string
+	client *http.Client
+
+	mux     sync.RWMutex
+	lookups map[int32]*schemaLookup
+	queue   chan int32
+}
+
+// NewSchemaRegistry creates new SchemaRegistry of registry url, user and password of basic auth are set in url
+func NewSchemaRegistry(registryURL string) (*SchemaRegistry, error) {
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &SchemaRegistry{
+		client:  &http.Client{Timeout: schemaRegistryTimeout},
+		lookups: make(map[int32]*schemaLookup),
+		queue:   make(chan int32, schemaQueueSize),
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.pass, _ = u.User.Password()
+		u.User = nil
+	}
+	r.url = strings.TrimSuffix(u.String(), "/")
+
+	go r.run()
+
+	return r, nil
+}
+
+// Resolve returns subject and version of schema id of key or value of topic. Subject of topic name strategy
+// (<topic>-key or <topic>-value) is preferred if the schema is registered under several subjects. It's false
+// until the id is looked up, if it isn't found or r is nil.
+func (r *SchemaRegistry) Resolve(id int32, topic string, key bool) (SchemaSubject, bool) {
+	if r == nil {
+		return SchemaSubject{}, false
+	}
+
+	r.mux.RLock()
+	lookup, ok := r.lookups[id]
+	r.mux.RUnlock()
+
+	if !ok || lookup.subjects == nil && time.Since(lookup.at) > schemaRetryInterval {
+		r.enqueue(id)
+	}
+	if !ok || len(lookup.subjects) == 0 {
+		return SchemaSubject{}, false
+	}
+
+	preferred := topic + "-value"
+	if key {
+		preferred = topic + "-key"
+	}
+	for _, s := range lookup.subjects {
+		if s.Subject == preferred {
+			return s, true
+		}
+	}
+	return lookup.subjects[0], true
+}
+
+// enqueue queues id for lookup, the lookup is marked as pending, so the id isn't queued again until it's done
+func (r *SchemaRegistry) enqueue(id int32) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if lookup, ok := r.lookups[id]; ok && (lookup.subjects != nil || time.Since(lookup.at) <= schemaRetryInterval) {
+		return
+	}
+	select {
+	case r.queue <- id:
+		r.lookups[id] = &schemaLookup{at: time.Now()}
+	default:
+	}
+}
+
+// run looks up queued ids
+func (r *SchemaRegistry) run() {
+	for id := range r.queue {
+		subjects, err := r.lookup(id)
+		if err != nil {
+			log.Printf("could not look up schema %d: %s\n", id, err)
+		}
+
+		r.mux.Lock()
+		r.lookups[id] = &schemaLookup{subjects: subjects, at: time.Now()}
+		r.mux.Unlock()
+	}
+}
+
+// lookup returns subjects and versions of schema id, it's empty (not nil) if the schema isn't found
+func (r *SchemaRegistry) lookup(id int32) ([]SchemaSubject, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/schemas/ids/%d/versions", r.url, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.pass)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// not a schema id, e.g. a record which only looks like wire format
+		return []SchemaSubject{}, nil
+	default:
+		return nil, fmt.Errorf("registry responded with %s", resp.Status)
+	}
+
+	var subjects []SchemaSubject
+	if err = json.NewDecoder(resp.Body).Decode(&subjects); err != nil {
+		return nil, err
+	}
+	if subjects == nil {
+		subjects = []SchemaSubject{}
+	}
+	return subjects, nil
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -10,57 +11,324 @@ import (
 
 const namespace = "kafka_sniffer"
 
+// ActivityExpireTime is an expiration time of the last activity of topics and clients, it's much longer than
+// expiration time of relations, so topics idle for days are reported with their last activity. Expiration time of
+// relations is used if it's longer.
+var ActivityExpireTime = 30 * 24 * time.Hour
+
 // Storage contains prometheus metrics that have expiration time. When expiration time is exceeded,
 // metric with specific labels is removed from storage. It is needed to keep only fresh producer,
 // topic and consumer relations.
 type Storage struct {
-	producerTopicRelationInfo *metric
-	consumerTopicRelationInfo *metric
-	activeConnectionsTotal    *metric
+	producerTopicRelationInfo    *metric
+	consumerTopicRelationInfo    *metric
+	activeConnectionsTotal       *metric
+	groupMemberRelationInfo      *metric
+	internalTopicRelationInfo    *metric
+	replicationTopicRelationInfo *metric
+	clientAPIVersionInfo         *metric
+	producerSchemaRelationInfo   *metric
+	topicNameViolationInfo       *metric
+	topicActivity                *metric
+	clientTopicActivity          *metric
+	consumerLagEstimate          *metric
 }
 
 // NewStorage creates new Storage
 func NewStorage(registerer prometheus.Registerer, expireTime time.Duration) *Storage {
+	activityExpireTime := ActivityExpireTime
+	if activityExpireTime < expireTime {
+		activityExpireTime = expireTime
+	}
+
 	var s = &Storage{
 		producerTopicRelationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "producer_topic_relation_info",
-			Help:      "Relation information between producer and topic",
-		}, []string{"client_ip", "topic"}), expireTime),
+			Help:      "Relation information between producer and topic, principal is a user authenticated by SASL, connection is client, inter_broker or unknown",
+		}, []string{"client_ip", "topic", "principal", "connection"}), expireTime),
 		consumerTopicRelationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "consumer_topic_relation_info",
-			Help:      "Relation information between consumer and topic",
-		}, []string{"client_ip", "topic"}), expireTime),
+			Help:      "Relation information between consumer and topic, principal is a user authenticated by SASL, connection is client, inter_broker or unknown",
+		}, []string{"client_ip", "topic", "principal", "connection"}), expireTime),
 		activeConnectionsTotal: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "active_connections_total",
-			Help:      "Contains total count of active connections",
-		}, []string{"client_ip"}), expireTime),
+			Help:      "Contains total count of active connections, encrypted is true for TLS connections",
+		}, []string{"client_ip", "encrypted"}), expireTime),
+		groupMemberRelationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "group_member_relation_info",
+			Help:      "Relation information between client and consumer group, group_instance_id is set for static members only",
+		}, []string{"client_ip", "group", "group_instance_id", "static", "principal"}), expireTime),
+		internalTopicRelationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "internal_topic_relation_info",
+			Help:      "Relation information between client and internal topic, role is producer or consumer",
+		}, []string{"client_ip", "topic", "role", "principal"}), expireTime),
+		replicationTopicRelationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "replication_topic_relation_info",
+			Help:      "Relation information between follower broker and topic it replicates, replica_id is a broker id of follower",
+		}, []string{"client_ip", "topic", "replica_id"}), expireTime),
+		clientAPIVersionInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "client_api_version_info",
+			Help:      "Versions of apis used by client, deprecated is true for versions older than minimal versions of the next broker upgrade",
+		}, []string{"client_ip", "client_id", "api", "version", "deprecated"}), expireTime),
+		producerSchemaRelationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "producer_schema_relation_info",
+			Help:      "Relation information between producer, topic and schema of record keys or values in Schema Registry wire format, part is key or value, subject and version are empty if schema isn't resolved by registry",
+		}, []string{"client_ip", "topic", "part", "schema_id", "subject", "version"}), expireTime),
+		topicNameViolationInfo: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "topic_name_violation_info",
+			Help:      "Topic used by client violating naming rules, role is producer or consumer, rule is invalid_chars, too_long, reserved, mixed_separators, prefix or pattern",
+		}, []string{"client_ip", "topic", "role", "rule"}), expireTime),
+		topicActivity: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "topic_last_activity_timestamp_seconds",
+			Help:      "Unix time of the last request to topic, role is producer or consumer",
+		}, []string{"topic", "role"}), activityExpireTime),
+		clientTopicActivity: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "client_topic_last_activity_timestamp_seconds",
+			Help:      "Unix time of the last request of client to topic, role is producer or consumer",
+		}, []string{"client_ip", "topic", "role"}), activityExpireTime),
+		consumerLagEstimate: newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "consumer_lag_estimate",
+			Help:      "Records of partition consumer is behind by the last fetch: high watermark of fetch response minus offset of fetch request, responses are decoded only if they are captured",
+		}, []string{"client_ip", "topic", "partition"}), expireTime),
 	}
 
 	registerer.MustRegister(
 		s.producerTopicRelationInfo.promMetric,
 		s.consumerTopicRelationInfo.promMetric,
 		s.activeConnectionsTotal.promMetric,
+		s.groupMemberRelationInfo.promMetric,
+		s.internalTopicRelationInfo.promMetric,
+		s.replicationTopicRelationInfo.promMetric,
+		s.clientAPIVersionInfo.promMetric,
+		s.producerSchemaRelationInfo.promMetric,
+		s.topicNameViolationInfo.promMetric,
+		s.topicActivity.promMetric,
+		s.clientTopicActivity.promMetric,
+		s.consumerLagEstimate.promMetric,
 	)
 
 	return s
 }
 
-// AddProducerTopicRelationInfo adds (producer, topic) pair to metrics
-func (s *Storage) AddProducerTopicRelationInfo(producer, topic string) {
-	s.producerTopicRelationInfo.set(producer, topic)
+// AddProducerTopicRelationInfo adds (producer, topic) pair to metrics, principal is empty for clients not
+// authenticated by SASL, connection is a type of connection of the producer
+func (s *Storage) AddProducerTopicRelationInfo(producer, topic, principal, connection string) {
+	s.producerTopicRelationInfo.set(producer, topic, principal, connection)
+}
+
+// AddConsumerTopicRelationInfo adds (consumer, topic) pair to metrics, principal is empty for clients not
+// authenticated by SASL, connection is a type of connection of the consumer
+func (s *Storage) AddConsumerTopicRelationInfo(consumer, topic, principal, connection string) {
+	s.consumerTopicRelationInfo.set(consumer, topic, principal, connection)
+}
+
+// AddActiveConnectionsTotal adds incoming connection, encrypted is true for TLS connections
+func (s *Storage) AddActiveConnectionsTotal(clientIP string, encrypted bool) {
+	s.activeConnectionsTotal.inc(clientIP, strconv.FormatBool(encrypted))
+}
+
+// AddGroupMemberRelationInfo adds (client, group) pair to metrics, groupInstanceID is empty for dynamic members
+func (s *Storage) AddGroupMemberRelationInfo(clientIP, group, groupInstanceID, principal string) {
+	s.groupMemberRelationInfo.set(clientIP, group, groupInstanceID, strconv.FormatBool(groupInstanceID != ""), principal)
+}
+
+// AddInternalTopicRelationInfo adds (client, internal topic, role) to metrics
+func (s *Storage) AddInternalTopicRelationInfo(clientIP, topic, role, principal string) {
+	s.internalTopicRelationInfo.set(clientIP, topic, role, principal)
+}
+
+// AddReplicationTopicRelationInfo adds (follower, topic) pair to metrics, follower fetches aren't reported
+// as consumers
+func (s *Storage) AddReplicationTopicRelationInfo(follower, topic string, replicaID int32) {
+	s.replicationTopicRelationInfo.set(follower, topic, strconv.Itoa(int(replicaID)))
+}
+
+// AddClientAPIVersionInfo adds (client, api, version) to metrics, deprecated is true if version is older than
+// minimal version of the api
+func (s *Storage) AddClientAPIVersionInfo(clientIP, clientID, api string, version int16, deprecated bool) {
+	s.clientAPIVersionInfo.set(clientIP, clientID, api, strconv.Itoa(int(version)), strconv.FormatBool(deprecated))
+}
+
+// AddProducerSchemaRelationInfo adds (producer, topic, schema) to metrics, part is key or value, subject is
+// empty if schema isn't resolved
+func (s *Storage) AddProducerSchemaRelationInfo(producer, topic, part string, schemaID int32, subject SchemaSubject) {
+	version := ""
+	if subject.Subject != "" {
+		version = strconv.Itoa(subject.Version)
+	}
+	s.producerSchemaRelationInfo.set(producer, topic, part, strconv.Itoa(int(schemaID)), subject.Subject, version)
+}
+
+// AddTopicNameViolationInfo adds (client, topic, rule) to metrics, role is producer or consumer
+func (s *Storage) AddTopicNameViolationInfo(clientIP, topic, role, rule string) {
+	s.topicNameViolationInfo.set(clientIP, topic, role, rule)
+}
+
+// AddTopicActivity updates the last activity of client and topic by request seen at capture time, role is
+// producer or consumer. Activity doesn't go back in time, e.g. by requests of streams decoded late.
+func (s *Storage) AddTopicActivity(clientIP, topic, role string, seen time.Time) {
+	ts := float64(seen.Unix())
+	s.topicActivity.setMax(ts, topic, role)
+	s.clientTopicActivity.setMax(ts, clientIP, topic, role)
+}
+
+// SetConsumerLag sets estimated lag of consumer behind high watermark of partition of topic by fetch offset and
+// high watermark of its response. It isn't saved into state file, since it's stale after restart.
+func (s *Storage) SetConsumerLag(clientIP, topic string, partition int32, lag int64) {
+	s.consumerLagEstimate.setValue(float64(lag), clientIP, topic, strconv.Itoa(int(partition)))
+}
+
+// Relation is a relation between client and topic
+type Relation struct {
+	ClientIP   string
+	Topic      string
+	Principal  string
+	Connection string
+	LastSeen   time.Time
+}
+
+// ProducerTopicRelations returns current (producer, topic) relations
+func (s *Storage) ProducerTopicRelations() []Relation {
+	return s.producerTopicRelationInfo.topicRelations()
+}
+
+// ConsumerTopicRelations returns current (consumer, topic) relations
+func (s *Storage) ConsumerTopicRelations() []Relation {
+	return s.consumerTopicRelationInfo.topicRelations()
+}
+
+// APIVersionRelation is a version of api used by client
+type APIVersionRelation struct {
+	ClientIP   string
+	ClientID   string
+	API        string
+	Version    int16
+	Deprecated bool
+	LastSeen   time.Time
+}
+
+// ClientAPIVersionRelations returns current (client, api, version) relations
+func (s *Storage) ClientAPIVersionRelations() []APIVersionRelation {
+	out := make([]APIVersionRelation, 0)
+	s.clientAPIVersionInfo.each(func(r *relation) {
+		version, _ := strconv.Atoi(r.labels[3])
+		out = append(out, APIVersionRelation{
+			ClientIP:   r.labels[0],
+			ClientID:   r.labels[1],
+			API:        r.labels[2],
+			Version:    int16(version),
+			Deprecated: r.labels[4] == "true",
+			LastSeen:   r.seenAt(),
+		})
+	})
+	return out
+}
+
+// Activity is the last time client was producing to or fetching from topic, client is empty for activity of
+// topic by all clients
+type Activity struct {
+	ClientIP string
+	Topic    string
+	Role     string
+	LastSeen time.Time
+}
+
+// TopicActivity returns the last activity of topics by roles
+func (s *Storage) TopicActivity() []Activity {
+	out := make([]Activity, 0)
+	s.topicActivity.each(func(r *relation) {
+		out = append(out, Activity{Topic: r.labels[0], Role: r.labels[1], LastSeen: r.valueTime()})
+	})
+	return out
+}
+
+// ClientTopicActivity returns the last activity of clients with topics by roles
+func (s *Storage) ClientTopicActivity() []Activity {
+	out := make([]Activity, 0)
+	s.clientTopicActivity.each(func(r *relation) {
+		out = append(out, Activity{ClientIP: r.labels[0], Topic: r.labels[1], Role: r.labels[2], LastSeen: r.valueTime()})
+	})
+	return out
+}
+
+// SavedRelation is a relation of metric with its labels and value, it's used to persist the storage
+type SavedRelation struct {
+	Labels   []string  `json:"labels"`
+	Value    float64   `json:"value"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Snapshot returns current relations of all metrics of the storage by metric name
+func (s *Storage) Snapshot() map[string][]SavedRelation {
+	out := make(map[string][]SavedRelation)
+	for name, m := range s.byName() {
+		out[name] = m.snapshot()
+	}
+	return out
+}
+
+// Restore restores relations returned by Snapshot, expired relations are skipped
+func (s *Storage) Restore(snapshot map[string][]SavedRelation) {
+	metrics := s.byName()
+	for name, relations := range snapshot {
+		m, ok := metrics[name]
+		if !ok {
+			continue
+		}
+		for _, r := range relations {
+			m.restore(r.Labels, r.Value, r.LastSeen)
+		}
+	}
+}
+
+// RelationCounts returns counts of current relations of all metrics of the storage by metric name
+func (s *Storage) RelationCounts() map[string]int {
+	out := make(map[string]int)
+	for name, m := range s.byName() {
+		count := 0
+		m.each(func(*relation) { count++ })
+		out[name] = count
+	}
+	return out
 }
 
-// AddConsumerTopicRelationInfo adds (consumer, topic) pair to metrics
-func (s *Storage) AddConsumerTopicRelationInfo(consumer, topic string) {
-	s.consumerTopicRelationInfo.set(consumer, topic)
+func (s *Storage) byName() map[string]*metric {
+	return map[string]*metric{
+		"producer_topic_relation_info":    s.producerTopicRelationInfo,
+		"consumer_topic_relation_info":    s.consumerTopicRelationInfo,
+		"active_connections_total":        s.activeConnectionsTotal,
+		"group_member_relation_info":      s.groupMemberRelationInfo,
+		"internal_topic_relation_info":    s.internalTopicRelationInfo,
+		"replication_topic_relation_info": s.replicationTopicRelationInfo,
+		"client_api_version_info":         s.clientAPIVersionInfo,
+		"producer_schema_relation_info":   s.producerSchemaRelationInfo,
+		"topic_name_violation_info":       s.topicNameViolationInfo,
+
+		"topic_last_activity_timestamp_seconds":        s.topicActivity,
+		"client_topic_last_activity_timestamp_seconds": s.clientTopicActivity,
+	}
 }
 
-// AddActiveConnectionsTotal adds incoming connection
-func (s *Storage) AddActiveConnectionsTotal(clientIP string) {
-	s.activeConnectionsTotal.inc(clientIP)
+// relationShards is a count of shards of relations of metric, relations are sharded by hash of labels, so
+// streams updating different relations don't contend for the same mutex
+const relationShards = 64
+
+// relationShard is a part of relations of metric
+type relationShard struct {
+	mux       sync.Mutex
+	relations map[string]*relation
 }
 
 // metric contains expiration functionality
@@ -70,8 +338,9 @@ type metric struct {
 
 	expCh chan []string
 
-	mux       sync.Mutex
-	relations map[string]*relation
+	// shardCount is a count of used shards, it's relationShards, benchmarks lower it to compare with a single shard
+	shardCount uint32
+	shards     [relationShards]relationShard
 }
 
 func newMetric(promMetric *prometheus.GaugeVec, expireTime time.Duration) *metric {
@@ -79,8 +348,12 @@ func newMetric(promMetric *prometheus.GaugeVec, expireTime time.Duration) *metri
 		promMetric: promMetric,
 		expireTime: expireTime,
 
-		relations: make(map[string]*relation),
-		expCh:     make(chan []string),
+		expCh: make(chan []string),
+
+		shardCount: relationShards,
+	}
+	for i := range m.shards {
+		m.shards[i].relations = make(map[string]*relation)
 	}
 
 	go m.runExpiration()
@@ -88,27 +361,127 @@ func newMetric(promMetric *prometheus.GaugeVec, expireTime time.Duration) *metri
 	return m
 }
 
+// shard returns shard of relation by its key, the key is hashed with FNV-1a
+func (m *metric) shard(key string) *relationShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &m.shards[h%m.shardCount]
+}
+
 func (m *metric) set(labels ...string) {
 	m.promMetric.WithLabelValues(labels...).Set(float64(1))
 
-	m.update(labels...)
+	m.update(labels...).setValue(1)
 }
 
 func (m *metric) inc(labels ...string) {
 	m.promMetric.WithLabelValues(labels...).Inc()
 
-	m.update(labels...)
+	m.update(labels...).addValue(1)
+}
+
+// setValue sets value of relation
+func (m *metric) setValue(value float64, labels ...string) {
+	m.promMetric.WithLabelValues(labels...).Set(value)
+
+	m.update(labels...).setValue(value)
+}
+
+// setMax sets value of relation to value if it's greater than the current one
+func (m *metric) setMax(value float64, labels ...string) {
+	r := m.update(labels...)
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if value > r.value {
+		r.value = value
+		m.promMetric.WithLabelValues(labels...).Set(value)
+	}
 }
 
 // update updates relations or creates new one
-func (m *metric) update(labels ...string) {
-	m.mux.Lock()
-	defer m.mux.Unlock()
-	if r, ok := m.relations[genLabelKey(labels...)]; ok {
+func (m *metric) update(labels ...string) *relation {
+	key := genLabelKey(labels...)
+	shard := m.shard(key)
+
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	if r, ok := shard.relations[key]; ok {
 		r.refresh()
-	} else {
-		m.relations[genLabelKey(labels...)] = newRelation(m.expireTime, labels, m.expCh)
+		return r
 	}
+
+	r := newRelation(m.expireTime, labels, m.expCh, time.Now())
+	shard.relations[key] = r
+	return r
+}
+
+// restore restores relation seen at lastSeen with metric value, relation expires in expireTime since lastSeen
+func (m *metric) restore(labels []string, value float64, lastSeen time.Time) {
+	if time.Since(lastSeen) >= m.expireTime {
+		return
+	}
+
+	key := genLabelKey(labels...)
+	shard := m.shard(key)
+
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	if _, ok := shard.relations[key]; ok {
+		return
+	}
+
+	// labels of the metric might have been changed since the relation was saved
+	gauge, err := m.promMetric.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		return
+	}
+	gauge.Set(value)
+
+	r := newRelation(m.expireTime, labels, m.expCh, lastSeen)
+	r.setValue(value)
+	shard.relations[key] = r
+}
+
+// snapshot returns all current relations of the metric
+func (m *metric) snapshot() []SavedRelation {
+	out := make([]SavedRelation, 0)
+	m.each(func(r *relation) {
+		r.mux.Lock()
+		out = append(out, SavedRelation{Labels: r.labels, Value: r.value, LastSeen: r.lastSeen})
+		r.mux.Unlock()
+	})
+	return out
+}
+
+// each calls f for every relation of the metric, shards are locked one by one
+func (m *metric) each(f func(r *relation)) {
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mux.Lock()
+		for _, r := range shard.relations {
+			f(r)
+		}
+		shard.mux.Unlock()
+	}
+}
+
+// topicRelations returns relations of metric with (client_ip, topic, principal, connection) labels
+func (m *metric) topicRelations() []Relation {
+	out := make([]Relation, 0)
+	m.each(func(r *relation) {
+		out = append(out, Relation{
+			ClientIP:   r.labels[0],
+			Topic:      r.labels[1],
+			Principal:  r.labels[2],
+			Connection: r.labels[3],
+			LastSeen:   r.seenAt(),
+		})
+	})
+	return out
 }
 
 // runExpiration removes metric by specific label values and removes relation
@@ -117,9 +490,11 @@ func (m *metric) runExpiration() {
 		m.promMetric.DeleteLabelValues(labels...)
 
 		// remove relation
-		m.mux.Lock()
-		delete(m.relations, genLabelKey(labels...))
-		m.mux.Unlock()
+		key := genLabelKey(labels...)
+		shard := m.shard(key)
+		shard.mux.Lock()
+		delete(shard.relations, key)
+		shard.mux.Unlock()
 	}
 }
 
@@ -130,15 +505,20 @@ type relation struct {
 	labels []string
 	expCh  chan []string
 
-	mux   sync.Mutex
-	timer *time.Timer
+	mux      sync.Mutex
+	timer    *time.Timer
+	lastSeen time.Time
+	value    float64
 }
 
-func newRelation(expireTime time.Duration, labels []string, expCh chan []string) *relation {
+// newRelation creates relation which expires in expireTime since lastSeen
+func newRelation(expireTime time.Duration, labels []string, expCh chan []string, lastSeen time.Time) *relation {
 	var rel = relation{
 		expireTime: expireTime,
 		labels:     labels,
 		expCh:      expCh,
+		lastSeen:   lastSeen,
+		timer:      time.NewTimer(expireTime - time.Since(lastSeen)),
 	}
 
 	go rel.run()
@@ -148,21 +528,42 @@ func newRelation(expireTime time.Duration, labels []string, expCh chan []string)
 
 // run runs expiration with specific timer
 func (c *relation) run() {
-	c.refresh()
-
 	<-c.timer.C
 	c.expCh <- c.labels
 }
 
-// refresh resets timer or create new one
+// refresh resets timer
 func (c *relation) refresh() {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	if c.timer == nil {
-		c.timer = time.NewTimer(c.expireTime)
-	} else {
-		c.timer.Reset(c.expireTime)
-	}
+	c.lastSeen = time.Now()
+	c.timer.Reset(c.expireTime)
+}
+
+func (c *relation) setValue(value float64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.value = value
+}
+
+func (c *relation) addValue(delta float64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.value += delta
+}
+
+// valueTime returns value of relation as Unix time
+func (c *relation) valueTime() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return time.Unix(int64(c.value), 0)
+}
+
+// seenAt returns last time the relation was refreshed
+func (c *relation) seenAt() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.lastSeen
 }
 
 func genLabelKey(labels ...string) string {
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BenchmarkMetricUpdate updates relations of 10k connections of distinct clients from parallel streams with
+// relations in a single shard, as they were kept before sharding, and in all shards
+func BenchmarkMetricUpdate(b *testing.B) {
+	const connections = 10000
+
+	labels := make([][]string, connections)
+	for i := range labels {
+		labels[i] = []string{"10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256), "topic-" + strconv.Itoa(i%100)}
+	}
+
+	for _, shards := range []uint32{1, relationShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			m := newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bench_relation_info"}, []string{"client_ip", "topic"}), time.Hour)
+			m.shardCount = shards
+			for _, l := range labels {
+				m.update(l...)
+			}
+
+			var next uint32
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// streams start at different connections, so they don't update the same relations in lockstep
+				i := int(atomic.AddUint32(&next, 7919))
+				for pb.Next() {
+					m.update(labels[i%connections]...)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestMetricShards(t *testing.T) {
+	m := newMetric(prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_relation_info"}, []string{"client_ip"}), time.Hour)
+	for i := 0; i < 1000; i++ {
+		m.set("10.0.0." + strconv.Itoa(i))
+	}
+
+	var total, used int
+	for i := range m.shards {
+		if n := len(m.shards[i].relations); n > 0 {
+			total += n
+			used++
+		}
+	}
+	if total != 1000 {
+		t.Errorf("%d relations are kept, want 1000", total)
+	}
+	if used < relationShards/2 {
+		t.Errorf("relations are kept in %d of %d shards", used, relationShards)
+	}
+	if r := m.update("10.0.0.1"); r.labels[0] != "10.0.0.1" {
+		t.Errorf("relation of other labels %v is updated", r.labels)
+	}
+}
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TopNEntry is a topic or client with its traffic during the period of report
+type TopNEntry struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// TopNReport is a digest of traffic during the period: topics by produced bytes, producers by produced bytes,
+// consumers by fetch requests and error sources by errors of responses
+type TopNReport struct {
+	From      time.Time   `json:"from"`
+	To        time.Time   `json:"to"`
+	Topics    []TopNEntry `json:"topics"`
+	Producers []TopNEntry `json:"producers"`
+	Consumers []TopNEntry `json:"consumers"`
+
+	// ErrorSources are clients with topics and names of errors of partitions in responses to them, e.g.
+	// 10.1.2.3 orders NOT_LEADER_OR_FOLLOWER, they are counted only if responses are captured
+	ErrorSources []TopNEntry `json:"error_sources"`
+}
+
+// TopN accumulates traffic of topics and clients and periodically reports the top of them, so operators get
+// a digest without building dashboards
+type TopN struct {
+	n int
+
+	mux       sync.Mutex
+	since     time.Time
+	topics    map[string]float64
+	producers map[string]float64
+	consumers map[string]float64
+	errors    map[string]float64
+	last      *TopNReport
+}
+
+// NewTopN creates new TopN reporting n top topics and clients
+func NewTopN(n int) *TopN {
+	t := &TopN{n: n}
+	t.reset(time.Now())
+	return t
+}
+
+// AddProduced adds bytes of records produced by client to topic
+func (t *TopN) AddProduced(clientIP, topic string, bytes int) {
+	if t == nil {
+		return
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.topics[topic] += float64(bytes)
+	t.producers[clientIP] += float64(bytes)
+}
+
+// AddFetch adds fetch request of client
+func (t *TopN) AddFetch(clientIP string) {
+	if t == nil {
+		return
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.consumers[clientIP]++
+}
+
+// AddError adds error of response to client, topic is empty for errors which aren't errors of partitions
+func (t *TopN) AddError(clientIP, topic, errorName string) {
+	if t == nil {
+		return
+	}
+
+	source := clientIP
+	if topic != "" {
+		source += " " + topic
+	}
+	source += " " + errorName
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.errors[source]++
+}
+
+// Last returns report of the last completed period, it's report of the current period until the first one is
+// completed
+func (t *TopN) Last() *TopNReport {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.last != nil {
+		return t.last
+	}
+	return t.report(time.Now())
+}
+
+// Rotate completes the current period and returns its report
+func (t *TopN) Rotate(now time.Time) *TopNReport {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.last = t.report(now)
+	t.reset(now)
+
+	return t.last
+}
+
+// Run logs report every interval
+func (t *TopN) Run(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		log.Print(t.Rotate(now))
+	}
+}
+
+func (t *TopN) report(now time.Time) *TopNReport {
+	return &TopNReport{
+		From:      t.since,
+		To:        now,
+		Topics:    top(t.topics, t.n),
+		Producers: top(t.producers, t.n),
+		Consumers: top(t.consumers, t.n),
+
+		ErrorSources: top(t.errors, t.n),
+	}
+}
+
+func (t *TopN) reset(now time.Time) {
+	t.since = now
+	t.topics = make(map[string]float64)
+	t.producers = make(map[string]float64)
+	t.consumers = make(map[string]float64)
+	t.errors = make(map[string]float64)
+}
+
+// String formats report for log
+func (r *TopNReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "top traffic since %s:", r.From.Format(time.RFC3339))
+	write := func(title, unit string, entries []TopNEntry) {
+		fmt.Fprintf(&b, "\n  %s:", title)
+		if len(entries) == 0 {
+			b.WriteString(" none")
+		}
+		for i, e := range entries {
+			fmt.Fprintf(&b, "\n    %d. %s %.0f %s", i+1, e.Name, e.Value, unit)
+		}
+	}
+	write("topics by produced bytes", "bytes", r.Topics)
+	write("producers by produced bytes", "bytes", r.Producers)
+	write("consumers by fetch requests", "requests", r.Consumers)
+	write("error sources by errors of responses", "errors", r.ErrorSources)
+	return b.String()
+}
+
+// top returns n entries with the greatest values sorted by value, ties are sorted by name
+func top(values map[string]float64, n int) []TopNEntry {
+	entries := make([]TopNEntry, 0, len(values))
+	for name, value := range values {
+		entries = append(entries, TopNEntry{Name: name, Value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopN(t *testing.T) {
+	start := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	topN := NewTopN(2)
+
+	topN.AddProduced("10.1.2.3", "orders", 1000)
+	topN.AddProduced("10.1.2.4", "payments", 100)
+	topN.AddProduced("10.1.2.3", "audit", 100)
+	topN.AddFetch("10.1.2.5")
+	topN.AddError("10.1.2.3", "orders", "NOT_LEADER_OR_FOLLOWER")
+	topN.AddError("10.1.2.3", "orders", "NOT_LEADER_OR_FOLLOWER")
+	topN.AddError("10.1.2.5", "", "FETCH_SESSION_ID_NOT_FOUND")
+	topN.AddError("10.1.2.5", "orders", "OFFSET_OUT_OF_RANGE")
+
+	report := topN.Rotate(start.Add(10 * time.Minute))
+
+	// ties are sorted by name
+	want := &TopNReport{
+		From:      report.From,
+		To:        start.Add(10 * time.Minute),
+		Topics:    []TopNEntry{{"orders", 1000}, {"audit", 100}},
+		Producers: []TopNEntry{{"10.1.2.3", 1100}, {"10.1.2.4", 100}},
+		Consumers: []TopNEntry{{"10.1.2.5", 1}},
+		ErrorSources: []TopNEntry{
+			{"10.1.2.3 orders NOT_LEADER_OR_FOLLOWER", 2},
+			{"10.1.2.5 FETCH_SESSION_ID_NOT_FOUND", 1},
+		},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report is %+v, want %+v", report, want)
+	}
+	if topN.Last() != report {
+		t.Error("the last report isn't the rotated one")
+	}
+
+	if s := report.String(); !strings.Contains(s, "error sources by errors of responses:\n    1. 10.1.2.3 orders NOT_LEADER_OR_FOLLOWER 2 errors") {
+		t.Errorf("report doesn't list error sources:\n%s", s)
+	}
+
+	// the next period starts empty
+	next := topN.Rotate(start.Add(20 * time.Minute))
+	if !next.From.Equal(start.Add(10*time.Minute)) || len(next.Topics) != 0 || len(next.ErrorSources) != 0 {
+		t.Errorf("the next report isn't empty: %+v", next)
+	}
+	if s := next.String(); !strings.Contains(s, "error sources by errors of responses: none") {
+		t.Errorf("empty report doesn't say there are no error sources:\n%s", s)
+	}
+}
@@ -0,0 +1,252 @@
+package sniffer
+
+import (
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/dump"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// segment is a TCP segment passed to shard
+type segment struct {
+	flow gopacket.Flow
+	tcp  *layers.TCP
+	ci   gopacket.CaptureInfo
+}
+
+// assemblerContext implements reassembly.AssemblerContext
+type assemblerContext gopacket.CaptureInfo
+
+// GetCaptureInfo returns capture info of the segment
+func (c *assemblerContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// PipelineConfig contains settings of dispatching packets to workers and of their assemblers
+type PipelineConfig struct {
+	// LinkType is a link type of packets of capture sources
+	LinkType uint32
+
+	// Workers is a count of TCP assembly workers, there is one worker if it's zero
+	Workers int
+
+	// QueueSize is a count of segments waiting for assembler of every worker
+	QueueSize int
+
+	// MaxPages and MaxPagesPerConnection limit pages of out of order segments buffered by assembler of every
+	// worker, zero means no limit
+	MaxPages, MaxPagesPerConnection int
+
+	// DropWhenFull drops segments when queue of worker is full instead of waiting for assembler, so capture
+	// doesn't fall behind
+	DropWhenFull bool
+
+	// BrokerPorts are ports of brokers, segments which aren't sent to them (or from them with Responses) are
+	// skipped if FilterPorts is set, e.g. for overlay traffic and pcap streams which aren't filtered by capture
+	BrokerPorts map[uint16]bool
+	FilterPorts bool
+	Responses   bool
+
+	// Verbose enables logging of every packet, packets aren't logged if it's nil
+	Verbose *stream.Switch
+
+	// PcapDump dumps packets of flows collected by KafkaFlows, packets aren't dumped if it's nil
+	PcapDump   *dump.RotatingPcap
+	KafkaFlows *stream.KafkaFlows
+}
+
+// WorkerStats are stats of worker reported by diagnostics
+type WorkerStats struct {
+	Queued    int
+	QueueSize int
+	Assembled uint64
+}
+
+// shard assembles its part of TCP flows in its own goroutine, so assembly of multi-gigabit traffic
+// is spread between CPUs. Requests of every stream are decoded in the goroutine of the stream.
+type shard struct {
+	// assembled is a count of assembled segments, lastAssembled is its value at the previous stuck check.
+	// They are the first fields for 64-bit alignment of atomic operations on 32-bit platforms.
+	assembled     uint64
+	lastAssembled uint64
+
+	assembler *reassembly.Assembler
+	segments  chan segment
+	done      chan struct{}
+}
+
+// newShard creates i-th shard, length of its queue of segments is exported as internal metric
+func newShard(factory reassembly.StreamFactory, i int, cfg PipelineConfig) *shard {
+	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+
+	// Out of order segments are buffered until the gap is filled or flushed
+	assembler.MaxBufferedPagesTotal = cfg.MaxPages
+	assembler.MaxBufferedPagesPerConnection = cfg.MaxPagesPerConnection
+
+	s := &shard{
+		assembler: assembler,
+		segments:  make(chan segment, cfg.QueueSize),
+		done:      make(chan struct{}),
+	}
+
+	metrics.RegisterQueue("shard_"+strconv.Itoa(i), func() int { return len(s.segments) })
+
+	go s.run()
+
+	return s
+}
+
+func (s *shard) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case seg, ok := <-s.segments:
+			if !ok {
+				s.assembler.FlushAll()
+				return
+			}
+			start := time.Now()
+			ctx := assemblerContext(seg.ci)
+			s.assembler.AssembleWithContext(seg.flow, seg.tcp, &ctx)
+			atomic.AddUint64(&s.assembled, 1)
+			metrics.InternalStageDuration.WithLabelValues("assemble").Observe(time.Since(start).Seconds())
+		case <-ticker.C:
+			// Every minute, skip gaps older than a minute and close connections that haven't seen
+			// activity in the past 2 minutes.
+			now := time.Now()
+			s.assembler.FlushWithOptions(reassembly.FlushOptions{T: now.Add(-time.Minute), TC: now.Add(time.Minute * -2)})
+			log.Println("---- FLUSHING ----")
+		}
+	}
+}
+
+// stuck returns true if queue of the shard is full and no segments were assembled since the previous call,
+// it isn't safe for concurrent use
+func (s *shard) stuck() bool {
+	assembled := atomic.LoadUint64(&s.assembled)
+	stuck := cap(s.segments) > 0 && len(s.segments) == cap(s.segments) && assembled == s.lastAssembled
+	s.lastAssembled = assembled
+	return stuck
+}
+
+// close flushes all streams and stops the shard
+func (s *shard) close() {
+	close(s.segments)
+	<-s.done
+}
+
+// Pipeline reads packets from capture sources and dispatches TCP segments to workers by flow hash, every worker
+// assembles streams of its flows with the stream factory
+type Pipeline struct {
+	cfg    PipelineConfig
+	shards []*shard
+}
+
+// NewPipeline creates new Pipeline and starts its workers
+func NewPipeline(factory reassembly.StreamFactory, cfg PipelineConfig) *Pipeline {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+
+	p := &Pipeline{cfg: cfg, shards: make([]*shard, cfg.Workers)}
+	for i := range p.shards {
+		p.shards[i] = newShard(factory, i, cfg)
+	}
+	return p
+}
+
+// Run reads packets from source until it's over, it may be called concurrently for several sources
+func (p *Pipeline) Run(source gopacket.PacketDataSource) {
+	for packet := range gopacket.NewPacketSource(source, capture.Decoder(p.cfg.LinkType)).Packets() {
+		start := time.Now()
+
+		if p.cfg.Verbose.On() {
+			log.Println(packet)
+		}
+
+		// the innermost TCP segment, tunneled segments are found only with decapsulation
+		network, tcp := capture.Decapsulate(packet)
+		if tcp == nil {
+			if p.cfg.Verbose.On() {
+				log.Println("Unusable packet")
+			}
+			continue
+		}
+
+		// overlay traffic and pcap streams aren't filtered by broker ports in capture
+		if p.cfg.FilterPorts && !p.cfg.BrokerPorts[uint16(tcp.DstPort)] && !(p.cfg.Responses && p.cfg.BrokerPorts[uint16(tcp.SrcPort)]) {
+			continue
+		}
+
+		flow := network.NetworkFlow()
+
+		// packets of flows are buffered until they are identified as kafka, so handshakes are dumped too
+		if p.cfg.PcapDump != nil {
+			for _, pkt := range p.cfg.KafkaFlows.Packets(flow, tcp.TransportFlow(), packet.Metadata().CaptureInfo, packet.Data()) {
+				if err := p.cfg.PcapDump.WritePacket(pkt.CaptureInfo, pkt.Data); err != nil {
+					log.Println("could not dump packet:", err)
+				}
+			}
+		}
+
+		// FastHash is symmetric, so both directions of a connection go to the same shard
+		i := (flow.FastHash() ^ tcp.TransportFlow().FastHash()) % uint64(len(p.shards))
+		seg := segment{flow: flow, tcp: tcp, ci: packet.Metadata().CaptureInfo}
+		if p.cfg.DropWhenFull {
+			select {
+			case p.shards[i].segments <- seg:
+			default:
+				metrics.DroppedPackets.WithLabelValues("backpressure").Inc()
+			}
+		} else {
+			p.shards[i].segments <- seg
+		}
+
+		metrics.InternalStageDuration.WithLabelValues("dispatch").Observe(time.Since(start).Seconds())
+	}
+}
+
+// Stuck returns true if queue of any worker is full and it assembled no segments since the previous call,
+// it isn't safe for concurrent use
+func (p *Pipeline) Stuck() bool {
+	stuck := false
+	for _, s := range p.shards {
+		if s.stuck() {
+			stuck = true
+		}
+	}
+	return stuck
+}
+
+// Workers returns stats of workers
+func (p *Pipeline) Workers() []WorkerStats {
+	out := make([]WorkerStats, len(p.shards))
+	for i, s := range p.shards {
+		out[i] = WorkerStats{
+			Queued:    len(s.segments),
+			QueueSize: cap(s.segments),
+			Assembled: atomic.LoadUint64(&s.assembled),
+		}
+	}
+	return out
+}
+
+// Close flushes all streams and stops workers, it must be called after all sources are over
+func (p *Pipeline) Close() {
+	for _, s := range p.shards {
+		s.close()
+	}
+}
@@ -0,0 +1,210 @@
+// Package sniffer embeds capture and decoding of kafka traffic into Go programs: packets of interface or pcap
+// file are reassembled into connections to brokers and every decoded request is passed to a callback
+package sniffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/capture"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+	"github.com/d-ulyanov/kafka-sniffer/stream"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultPort       = 9092
+	defaultSnaplen    = 16 << 10
+	defaultQueueSize  = 1000
+	defaultExpireTime = 5 * time.Minute
+
+	// readTimeout is a libpcap poll timeout of live capture, Run returns within it after context is done
+	readTimeout = 500 * time.Millisecond
+)
+
+// ConnInfo describes connection of decoded request
+type ConnInfo = stream.ConnInfo
+
+// Config contains settings of Sniffer
+type Config struct {
+	// Interface to capture packets from, e.g. eth0, it's ignored if File is set
+	Interface string
+
+	// File is a pcap file to read packets from instead of interface, Run returns when it's over
+	File string
+
+	// Ports are ports of brokers, 9092 is used if it's empty
+	Ports []uint16
+
+	// Snaplen of live capture, 16 KiB is used if it's zero
+	Snaplen int
+
+	// Workers is a count of TCP assembly workers, there is one worker if it's zero
+	Workers int
+
+	// Responses captures responses of brokers besides requests to pair them by correlation id
+	Responses bool
+
+	// Registerer registers metrics of relations of clients and topics, they aren't exported if it's nil.
+	// Internal metrics of the pipeline are registered in the default registerer.
+	Registerer prometheus.Registerer
+
+	// ExpireTime is an expiration time of metrics of relations, 5 minutes is used if it's zero
+	ExpireTime time.Duration
+
+	// Stream contains settings of decoding, e.g. topic filters, TLS keys or handlers of requests. Its
+	// BrokerPorts and Responses are set by Sniffer, OnRequest is called after its handlers.
+	Stream stream.Config
+
+	// OnRequest is called for every decoded request, it's called from goroutines of connections concurrently.
+	// Request is valid only until the call returns, its buffers are reused then.
+	OnRequest func(ctx context.Context, conn ConnInfo, req *kafka.Request)
+}
+
+// Sniffer captures kafka traffic and passes decoded requests to callback
+type Sniffer struct {
+	cfg               Config
+	storage           *metrics.Storage
+	rebalanceDetector *metrics.RebalanceDetector
+}
+
+// New creates new Sniffer, capture is opened by Run
+func New(cfg Config) (*Sniffer, error) {
+	if cfg.Interface == "" && cfg.File == "" {
+		return nil, errors.New("interface or pcap file must be set")
+	}
+	if len(cfg.Ports) == 0 {
+		cfg.Ports = []uint16{defaultPort}
+	}
+	if cfg.Snaplen == 0 {
+		cfg.Snaplen = defaultSnaplen
+	}
+	if cfg.ExpireTime == 0 {
+		cfg.ExpireTime = defaultExpireTime
+	}
+
+	registerer := cfg.Registerer
+	if registerer == nil {
+		registerer = prometheus.NewRegistry()
+	}
+
+	return &Sniffer{
+		cfg:               cfg,
+		storage:           metrics.NewStorage(registerer, cfg.ExpireTime),
+		rebalanceDetector: metrics.NewRebalanceDetector(registerer, 0),
+	}, nil
+}
+
+// Storage returns storage of relations of clients, topics and groups observed by the sniffer
+func (s *Sniffer) Storage() *metrics.Storage {
+	return s.storage
+}
+
+// Run captures packets until ctx is done or pcap file is over, requests of connections are decoded until
+// it returns. It returns error of ctx if it's done.
+func (s *Sniffer) Run(ctx context.Context) error {
+	handle, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+
+	// link types unknown to libpcap, e.g. LINUX_SLL2, are read from header of the file
+	linkType := uint32(handle.LinkType())
+	if s.cfg.File != "" {
+		if linkType, err = capture.FileLinkType(s.cfg.File); err != nil {
+			return err
+		}
+	}
+
+	brokerPorts := make(map[uint16]bool, len(s.cfg.Ports))
+	for _, port := range s.cfg.Ports {
+		brokerPorts[port] = true
+	}
+
+	streamCfg := s.cfg.Stream
+	streamCfg.BrokerPorts = brokerPorts
+	streamCfg.Responses = s.cfg.Responses
+	if s.cfg.OnRequest != nil {
+		onRequest := stream.RequestHandlerFunc(func(conn ConnInfo, req *kafka.Request, topics []string) {
+			s.cfg.OnRequest(ctx, conn, req)
+		})
+		streamCfg.Handlers = append(append([]stream.RequestHandler(nil), streamCfg.Handlers...), onRequest)
+	}
+
+	factory := stream.NewKafkaStreamFactory(s.storage, s.rebalanceDetector, nil, streamCfg)
+	pipeline := NewPipeline(factory, PipelineConfig{
+		LinkType:    linkType,
+		Workers:     s.cfg.Workers,
+		QueueSize:   defaultQueueSize,
+		BrokerPorts: brokerPorts,
+		Responses:   s.cfg.Responses,
+	})
+
+	pipeline.Run(&contextSource{ctx: ctx, source: handle})
+	pipeline.Close()
+	factory.Wait()
+
+	return ctx.Err()
+}
+
+// open opens pcap file or live capture of the interface filtered by broker ports
+func (s *Sniffer) open() (*pcap.Handle, error) {
+	var (
+		handle *pcap.Handle
+		err    error
+	)
+	if s.cfg.File != "" {
+		log.Printf("reading packets from file %q", s.cfg.File)
+		handle, err = pcap.OpenOffline(s.cfg.File)
+	} else {
+		log.Printf("starting capture on interface %q", s.cfg.Interface)
+		handle, err = pcap.OpenLive(s.cfg.Interface, int32(s.cfg.Snaplen), true, readTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = handle.SetBPFFilter(BPFFilter(s.cfg.Ports, s.cfg.Responses)); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	return handle, nil
+}
+
+// BPFFilter returns capture filter of requests to broker ports, e.g. "tcp and (dst port 9092 or dst port 9093)",
+// responses from broker ports are captured too if responses is true
+func BPFFilter(ports []uint16, responses bool) string {
+	conditions := make([]string, 0, len(ports))
+	for _, port := range ports {
+		if responses {
+			conditions = append(conditions, fmt.Sprintf("port %d", port))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("dst port %d", port))
+		}
+	}
+	return fmt.Sprintf("tcp and (%s)", strings.Join(conditions, " or "))
+}
+
+// contextSource is a source of packets which is over when context is done
+type contextSource struct {
+	ctx    context.Context
+	source gopacket.PacketDataSource
+}
+
+// ReadPacketData implements gopacket.PacketDataSource
+func (s *contextSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.ctx.Err() != nil {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	return s.source.ReadPacketData()
+}
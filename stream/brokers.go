@@ -0,0 +1,128 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ConnectionType is a kind of connection classified by addresses of brokers
+type ConnectionType string
+
+const (
+	// ConnectionClient is a connection of client to broker
+	ConnectionClient ConnectionType = "client"
+	// ConnectionInterBroker is a connection between brokers, e.g. replication or controller one
+	ConnectionInterBroker ConnectionType = "inter_broker"
+	// ConnectionReplication is a connection of follower broker fetching partitions from leader
+	ConnectionReplication ConnectionType = "replication"
+	// ConnectionUnknown is a connection between hosts which aren't known brokers
+	ConnectionUnknown ConnectionType = "unknown"
+)
+
+// Brokers is a set of IP addresses of brokers of the cluster, connections are classified by it. Brokers are
+// configured or learned from Metadata responses.
+type Brokers struct {
+	mux sync.RWMutex
+	ips map[string]bool
+
+	// hosts are broker hosts from Metadata responses which are already resolved or being resolved
+	hosts map[string]bool
+}
+
+// NewBrokers creates set of brokers from list of their addresses: IPs or host names, optionally with ports,
+// host names are resolved
+func NewBrokers(addrs []string) (*Brokers, error) {
+	b := &Brokers{
+		ips:   make(map[string]bool),
+		hosts: make(map[string]bool),
+	}
+
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+
+		ips, err := resolve(addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve broker %s: %s", addr, err)
+		}
+		for _, ip := range ips {
+			b.ips[ip] = true
+		}
+		b.hosts[addr] = true
+	}
+
+	return b, nil
+}
+
+// resolve returns IPs of host, it may be an IP itself
+func resolve(host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	return net.LookupHost(host)
+}
+
+// learn adds broker host from Metadata response, host names are resolved in background once
+func (b *Brokers) learn(host string) {
+	if b == nil {
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.hosts[host] {
+		return
+	}
+	b.hosts[host] = true
+
+	if ip := net.ParseIP(host); ip != nil {
+		b.ips[ip.String()] = true
+		return
+	}
+
+	go func() {
+		ips, err := net.LookupHost(host)
+		if err != nil {
+			log.Printf("could not resolve broker %s: %s\n", host, err)
+			return
+		}
+
+		b.mux.Lock()
+		for _, ip := range ips {
+			b.ips[ip] = true
+		}
+		b.mux.Unlock()
+	}()
+}
+
+// contains returns true if ip is an address of broker
+func (b *Brokers) contains(ip string) bool {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.ips[ip]
+}
+
+// classify returns type of connection from src to dst, all connections are unknown for nil set
+func (b *Brokers) classify(src, dst string) ConnectionType {
+	if b == nil {
+		return ConnectionUnknown
+	}
+
+	switch {
+	case b.contains(src) && b.contains(dst):
+		return ConnectionInterBroker
+	case b.contains(dst):
+		return ConnectionClient
+	default:
+		return ConnectionUnknown
+	}
+}
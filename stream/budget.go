@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// memoryBudget limits memory of data buffered by stream readers. Reader exceeding its own limit is evicted,
+// least recently active readers are evicted when total limit is exceeded. Zero limit means no limit.
+type memoryBudget struct {
+	streamLimit int64
+	limit       int64
+
+	// total is a count of bytes buffered by all readers, it's accessed atomically
+	total int64
+
+	mux     sync.Mutex
+	readers map[*streamReader]struct{}
+}
+
+func newMemoryBudget(streamLimit, limit int64) *memoryBudget {
+	return &memoryBudget{
+		streamLimit: streamLimit,
+		limit:       limit,
+		readers:     make(map[*streamReader]struct{}),
+	}
+}
+
+func (b *memoryBudget) register(r *streamReader) {
+	b.mux.Lock()
+	b.readers[r] = struct{}{}
+	b.mux.Unlock()
+}
+
+func (b *memoryBudget) unregister(r *streamReader) {
+	b.mux.Lock()
+	delete(b.readers, r)
+	b.mux.Unlock()
+}
+
+// add accounts n bytes buffered by the reader, n is negative for released bytes. Readers are evicted if
+// added bytes exceed limits.
+func (b *memoryBudget) add(r *streamReader, n int64) {
+	buffered := atomic.AddInt64(&r.buffered, n)
+	total := atomic.AddInt64(&b.total, n)
+	metrics.StreamBufferedBytes.Add(float64(n))
+
+	if n <= 0 {
+		return
+	}
+
+	if b.streamLimit > 0 && buffered > b.streamLimit && r.evict() {
+		metrics.StreamEvictions.WithLabelValues("stream_limit").Inc()
+	}
+
+	if b.limit > 0 && total > b.limit {
+		b.evictIdle()
+	}
+}
+
+// evictIdle evicts least recently active readers until total buffered bytes fit the limit
+func (b *memoryBudget) evictIdle() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	readers := make([]*streamReader, 0, len(b.readers))
+	for r := range b.readers {
+		if atomic.LoadInt64(&r.buffered) > 0 {
+			readers = append(readers, r)
+		}
+	}
+	sort.Slice(readers, func(i, j int) bool {
+		return atomic.LoadInt64(&readers[i].lastActive) < atomic.LoadInt64(&readers[j].lastActive)
+	})
+
+	for _, r := range readers {
+		if atomic.LoadInt64(&b.total) <= b.limit {
+			return
+		}
+		if r.evict() {
+			metrics.StreamEvictions.WithLabelValues("memory_limit").Inc()
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package stream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/d-ulyanov/kafka-sniffer/filter"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// DefaultBufferSize is a default size of buffer of decoded stream
+const DefaultBufferSize = 64 << 10
+
+// InternalTopics defines how internal topics (__consumer_offsets, __transaction_state etc.) are reported
+type InternalTopics string
+
+const (
+	// InternalTopicsInclude reports internal topics as any other topic
+	InternalTopicsInclude InternalTopics = "include"
+	// InternalTopicsExclude skips internal topics
+	InternalTopicsExclude InternalTopics = "exclude"
+	// InternalTopicsSeparate reports internal topics with separate metric
+	InternalTopicsSeparate InternalTopics = "separate"
+)
+
+// ParseInternalTopics parses InternalTopics mode from string
+func ParseInternalTopics(s string) (InternalTopics, error) {
+	switch mode := InternalTopics(s); mode {
+	case InternalTopicsInclude, InternalTopicsExclude, InternalTopicsSeparate:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown internal topics mode %q, expected one of: include, exclude, separate", s)
+	}
+}
+
+// apiKeyGroups are names of groups of api keys accepted by ParseAPIKeys besides names and numbers of api keys
+var apiKeyGroups = map[string][]int16{
+	// group coordination: OffsetCommit, OffsetFetch, FindCoordinator, JoinGroup, Heartbeat, LeaveGroup, SyncGroup
+	"group": {8, 9, 10, 11, 12, 13, 14},
+	// transactions: InitProducerId, AddPartitionsToTxn, AddOffsetsToTxn, EndTxn, WriteTxnMarkers, TxnOffsetCommit
+	"transactions": {22, 24, 25, 26, 27, 28},
+}
+
+// ParseAPIKeys parses comma separated list of api keys by their names (e.g. Produce), numbers or groups (group,
+// transactions), names are case insensitive. Empty list means all api keys.
+func ParseAPIKeys(s string) (map[int16]bool, error) {
+	keys := make(map[int16]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if group, ok := apiKeyGroups[strings.ToLower(item)]; ok {
+			for _, key := range group {
+				keys[key] = true
+			}
+			continue
+		}
+		if key, ok := kafka.APIKeyByName(item); ok {
+			keys[key] = true
+			continue
+		}
+		key, err := strconv.ParseInt(item, 10, 16)
+		if err != nil || key < 0 {
+			return nil, fmt.Errorf("unknown api key %q, expected name, number or one of groups: group, transactions", item)
+		}
+		keys[int16(key)] = true
+	}
+
+	return keys, nil
+}
+
+// Config contains settings of kafka streams processing
+type Config struct {
+	// Verbose enables logging of every request, it may be switched at runtime. Requests aren't logged if it's nil.
+	Verbose *Switch
+
+	// InternalTopics defines how internal topics are reported
+	InternalTopics InternalTopics
+
+	// Topics selects topics reported in metrics and events, all topics are reported if it's nil
+	Topics *TopicFilter
+
+	// TopicNaming validates names of reported topics, names aren't validated if it's nil
+	TopicNaming *TopicNaming
+
+	// Filter selects decoded requests reported in metrics, events and pcap dump, all requests are reported if
+	// it's nil
+	Filter *filter.Expr
+
+	// APIKeys are api keys of processed requests, other requests are skipped by header and responses to them
+	// aren't paired. All requests are processed if it's empty.
+	APIKeys map[int16]bool
+
+	// MinVersions are minimal versions of apis by api key, requests of older versions are reported as deprecated
+	MinVersions map[int16]int16
+
+	// ClientIP defines how client addresses are rendered in metrics labels
+	ClientIP ClientIPFormat
+
+	// Owners map client addresses to owners of events, owners aren't set if it's nil
+	Owners *metrics.Owners
+
+	// GeoIP looks up country and ASN of clients of events, they aren't set if it's nil
+	GeoIP *metrics.GeoIP
+
+	// DetectSchemas enables detection of ids of schemas of records in Schema Registry wire format, they are
+	// resolved into subjects and versions by SchemaRegistry if it isn't nil
+	DetectSchemas  bool
+	SchemaRegistry *metrics.SchemaRegistry
+
+	// TopN accumulates traffic of topics and clients for periodic report, may be nil
+	TopN *metrics.TopN
+
+	// MaxMessageSize is a max size of records of partition of produce request in bytes, e.g. message.max.bytes
+	// of brokers, larger record batches and message sets are counted as oversized. Zero disables the check.
+	MaxMessageSize int
+
+	// ProducerIDs tracks ids and epochs of idempotent and transactional producers, may be nil
+	ProducerIDs *metrics.ProducerIDs
+
+	// ACLAudit audits access of principals to topics and groups against declared ACLs, may be nil
+	ACLAudit *metrics.ACLAudit
+
+	// Anonymizer hashes topics, client addresses, client ids, principals and groups before they are reported
+	// in metrics, events, top and handlers, they are reported as is if it's nil. Topic filters, filter
+	// expression and ACL audit match real names.
+	Anonymizer *metrics.Anonymizer
+
+	// Flows collects flows identified as kafka, may be nil
+	Flows *KafkaFlows
+
+	// BrokerPorts are ports of brokers, packets sent from them are responses
+	BrokerPorts map[uint16]bool
+
+	// Responses enables pairing of requests with responses, responses must be captured
+	Responses bool
+
+	// Tracing sets trace ids of events exported as spans, response times of their requests are observed with
+	// trace ids as exemplars. If responses are paired, events are written when responses are decoded, so their
+	// spans end at responses.
+	Tracing bool
+
+	// RenderRequests adds decoded requests rendered as JSON to events, payloads are rendered by
+	// kafka.RenderPayloads
+	RenderRequests bool
+
+	// BufferSize is a size of buffer of decoded stream, DefaultBufferSize is used if it's zero
+	BufferSize int
+
+	// StreamMemoryLimit limits bytes buffered by one direction of stream, it's evicted when the limit is
+	// exceeded. Zero means no limit.
+	StreamMemoryLimit int64
+
+	// MemoryLimit limits bytes buffered by all streams, least recently active streams are evicted when
+	// the limit is exceeded. Zero means no limit.
+	MemoryLimit int64
+
+	// Brokers classify connections as client or inter-broker ones, brokers of Metadata responses are added
+	// to them. All connections are unknown if it's nil.
+	Brokers *Brokers
+
+	// SampleRate is N of sample rate 1/N: every Nth request of connection is decoded, other requests are
+	// counted by header only. All requests are decoded if it's nil.
+	SampleRate *SampleRate
+
+	// TLSKeys decrypt TLS connections, both directions must be captured. Data of TLS connections isn't
+	// decoded if it's nil.
+	TLSKeys *TLSKeys
+
+	// Handlers are called for every decoded request reported in metrics and events after the built-in
+	// handler of metrics and registered handlers
+	Handlers []RequestHandler
+}
+
+func (c Config) bufferSize() int {
+	if c.BufferSize > 0 {
+		return c.BufferSize
+	}
+	return DefaultBufferSize
+}
+
+// processes returns true if requests with api key are processed, SASL requests are always processed since
+// principal of connection is taken from them
+func (c Config) processes(key int16) bool {
+	return len(c.APIKeys) == 0 || c.APIKeys[key] || kafka.IsSASLRequest(key)
+}
+
+// deprecated returns true if version of api key is older than its minimal version
+func (c Config) deprecated(key, version int16) bool {
+	min, ok := c.MinVersions[key]
+	return ok && version < min
+}
@@ -0,0 +1,64 @@
+package stream
+
+import (
+	"github.com/google/gopacket/layers"
+)
+
+// connState is a state of TCP connection
+type connState int
+
+const (
+	// connOpening means SYN is seen
+	connOpening connState = iota
+	// connEstablished means data is seen, connections joined in the middle start in this state
+	connEstablished
+	// connClosing means FIN is seen in one of directions
+	connClosing
+	// connClosed means FIN is seen in both directions or connection is reset
+	connClosed
+)
+
+// connFSM is a state machine of TCP connection. Usually only packets of requests to brokers are captured,
+// so unlike reassembly.TCPSimpleFSM it doesn't wait for packets of the other direction, e.g. SYN+ACK.
+type connFSM struct {
+	state connState
+
+	// FIN is seen from client or from server
+	clientFIN, serverFIN bool
+}
+
+// newConnFSM creates state machine of connection started with the packet
+func newConnFSM(tcp *layers.TCP) *connFSM {
+	if tcp.SYN {
+		return &connFSM{state: connOpening}
+	}
+	return &connFSM{state: connEstablished}
+}
+
+// update moves state machine by the packet from client or from server, it returns false if the packet
+// belongs to already closed connection, e.g. retransmission after RST
+func (f *connFSM) update(tcp *layers.TCP, fromClient bool) bool {
+	if f.state == connClosed {
+		return false
+	}
+
+	switch {
+	case tcp.RST:
+		f.state = connClosed
+	case tcp.FIN:
+		if fromClient {
+			f.clientFIN = true
+		} else {
+			f.serverFIN = true
+		}
+
+		f.state = connClosing
+		if f.clientFIN && f.serverFIN {
+			f.state = connClosed
+		}
+	case f.state == connOpening && !tcp.SYN:
+		f.state = connEstablished
+	}
+
+	return true
+}
@@ -0,0 +1,233 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+)
+
+// maxConnectionPending limits requests waiting for response, they are forgotten if responses are lost
+const maxConnectionPending = 1000
+
+// pendingRequest is a request waiting for response, traceID is an id of trace of its event if tracing is enabled.
+// Traced event is held until the response, so its span ends at the response. fetchOffsets are offsets of
+// partitions of consumer fetch requests to estimate lag by high watermarks of the response.
+type pendingRequest struct {
+	key, version int16
+	seen         time.Time
+	traceID      string
+	event        *events.Event
+	fetchOffsets map[string]map[int32]int64
+}
+
+// connection is a state of TCP connection shared by decoders of its requests and responses
+type connection struct {
+	mux sync.Mutex
+
+	// clientID is the first non-empty client id of requests
+	clientID string
+
+	// requests is a count of requests of the connection
+	requests int64
+
+	// apiVersions are versions of apis used by client, i.e. negotiated with broker
+	apiVersions map[int16]int16
+
+	// mechanism is a SASL mechanism of SaslHandshake, principal is a user authenticated by SaslAuthenticate
+	mechanism string
+	principal string
+
+	// pending are requests waiting for responses by correlation id, they are tracked only if responses
+	// are captured
+	pending map[int32]pendingRequest
+
+	// responded are response times of traced requests by correlation id, which responses are decoded before their
+	// events are built, they are set if tracing is enabled
+	responded map[int32]time.Duration
+
+	// encrypted is true if the connection is TLS, its data is decoded only if it's decrypted
+	encrypted bool
+
+	// tls is a state of TLS handshake shared by decryptors of both directions
+	tls *tlsSession
+}
+
+func newConnection(trackPending, tracing bool) *connection {
+	c := &connection{apiVersions: make(map[int16]int16)}
+	if trackPending {
+		c.pending = make(map[int32]pendingRequest)
+	}
+	if trackPending && tracing {
+		c.responded = make(map[int32]time.Duration)
+	}
+	return c
+}
+
+// request remembers request seen at the time, it returns held events of requests forgotten since there are too
+// many of them waiting for responses
+func (c *connection) request(req *kafka.Request, seen time.Time) []*events.Event {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.clientID == "" {
+		c.clientID = req.ClientID
+	}
+	c.requests++
+	c.apiVersions[req.Key] = req.Version
+
+	switch body := req.Body.(type) {
+	case *kafka.SaslHandshakeRequest:
+		c.mechanism = body.Mechanism
+	case *kafka.SaslAuthenticateRequest:
+		if c.principal == "" {
+			c.principal = kafka.SASLPrincipal(c.mechanism, body.AuthBytes)
+		}
+	}
+
+	if c.pending == nil {
+		return nil
+	}
+
+	var forgotten []*events.Event
+	if len(c.pending) >= maxConnectionPending {
+		forgotten = c.heldLocked()
+		c.pending = make(map[int32]pendingRequest)
+	}
+	pending := pendingRequest{key: req.Key, version: req.Version, seen: seen}
+	if fetch, ok := req.Body.(*kafka.FetchRequest); ok && !fetch.IsFollower() {
+		pending.fetchOffsets = fetch.FetchOffsets()
+	}
+	c.pending[req.CorrelationID] = pending
+	return forgotten
+}
+
+// trace holds traced event of request waiting for response with correlation id until the response. It returns
+// false if the request isn't waiting for response, e.g. responses aren't captured or the response is already
+// decoded, response time of the decoded response is set to the event.
+func (c *connection) trace(correlationID int32, e *events.Event) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	req, ok := c.pending[correlationID]
+	if !ok {
+		if responseTime, ok := c.responded[correlationID]; ok {
+			e.ResponseTime = responseTime
+			delete(c.responded, correlationID)
+		}
+		return false
+	}
+	req.traceID = e.TraceID
+	req.event = e
+	c.pending[correlationID] = req
+	return true
+}
+
+// held returns events of requests which are still waiting for responses and forgets them
+func (c *connection) held() []*events.Event {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.heldLocked()
+}
+
+func (c *connection) heldLocked() []*events.Event {
+	var held []*events.Event
+	for correlationID, req := range c.pending {
+		if req.event != nil {
+			held = append(held, req.event)
+			req.event = nil
+			c.pending[correlationID] = req
+		}
+	}
+	return held
+}
+
+// sessionState is a state of the connection attached to its events
+type sessionState struct {
+	clientID    string
+	principal   string
+	apiVersions map[int16]int16
+}
+
+// session returns client id, principal and copy of api versions of the connection
+func (c *connection) session() sessionState {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	versions := make(map[int16]int16, len(c.apiVersions))
+	for key, version := range c.apiVersions {
+		versions[key] = version
+	}
+	return sessionState{clientID: c.clientID, principal: c.principal, apiVersions: versions}
+}
+
+// requestsCount returns count of requests of the connection
+func (c *connection) requestsCount() int64 {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.requests
+}
+
+// authenticated returns SASL principal of the connection, it's empty until client is authenticated
+func (c *connection) authenticated() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.principal
+}
+
+// pendingAPI returns api key and version of request waiting for response with correlation id
+func (c *connection) pendingAPI(correlationID int32) (int16, int16, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	req, ok := c.pending[correlationID]
+	return req.key, req.version, ok
+}
+
+// response returns request of the response with correlation id seen at the time and forgets it
+func (c *connection) response(correlationID int32, seen time.Time) (pendingRequest, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	req, ok := c.pending[correlationID]
+	if !ok {
+		return req, false
+	}
+	delete(c.pending, correlationID)
+
+	// event of the request may be built after the response is decoded
+	if c.responded != nil && req.event == nil {
+		if len(c.responded) >= maxConnectionPending {
+			c.responded = make(map[int32]time.Duration)
+		}
+		c.responded[correlationID] = seen.Sub(req.seen)
+	}
+	return req, true
+}
+
+// setEncrypted marks the connection as TLS
+func (c *connection) setEncrypted() {
+	c.mux.Lock()
+	c.encrypted = true
+	c.mux.Unlock()
+}
+
+// isEncrypted returns true if the connection is TLS
+func (c *connection) isEncrypted() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.encrypted
+}
+
+// tlsSession returns TLS session of the connection decrypted with the keys
+func (c *connection) tlsSession(keys *TLSKeys) *tlsSession {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.tls == nil {
+		c.tls = newTLSSession(keys)
+	}
+	return c.tls
+}
@@ -0,0 +1,31 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+)
+
+// TestConnectionResponseBeforeTrace checks response time is set to the event built after the response is decoded
+func TestConnectionResponseBeforeTrace(t *testing.T) {
+	c := newConnection(true, true)
+	seen := time.Unix(1600000000, 0)
+
+	c.request(&kafka.Request{CorrelationID: 7, Key: kafka.MetadataKey}, seen)
+	if _, ok := c.response(7, seen.Add(20*time.Millisecond)); !ok {
+		t.Fatal("response isn't paired with the request")
+	}
+
+	e := &events.Event{TraceID: "trace"}
+	if c.trace(7, e) {
+		t.Fatal("event of responded request is held")
+	}
+	if e.ResponseTime != 20*time.Millisecond {
+		t.Errorf("response time %s, want %s", e.ResponseTime, 20*time.Millisecond)
+	}
+	if len(c.responded) != 0 {
+		t.Errorf("%d response times are kept after tracing", len(c.responded))
+	}
+}
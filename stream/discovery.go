@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"sort"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// maxPendingRequests limits count of correlation ids waiting for response per flow
+const maxPendingRequests = 16
+
+// PortDetector detects ports of kafka brokers in TCP traffic. A port is detected when a payload sent to it
+// looks like kafka request header and the payload sent back starts with response of the same correlation id,
+// so responses sent to clients aren't mistaken for requests.
+type PortDetector struct {
+	// pending contains correlation ids of requests by flow of expected response
+	pending   map[flowKey]map[int32]struct{}
+	responses map[uint16]int
+}
+
+// NewPortDetector creates new PortDetector
+func NewPortDetector() *PortDetector {
+	return &PortDetector{
+		pending:   make(map[flowKey]map[int32]struct{}),
+		responses: make(map[uint16]int),
+	}
+}
+
+// Observe checks TCP segment for kafka request or response
+func (d *PortDetector) Observe(net gopacket.Flow, tcp *layers.TCP) {
+	if len(tcp.Payload) == 0 {
+		return
+	}
+
+	key := flowKey{net, tcp.TransportFlow()}
+
+	// response of the broker to the pending request
+	if correlationIDs, ok := d.pending[key]; ok {
+		if correlationID, ok := kafka.ResponseCorrelationID(tcp.Payload); ok {
+			if _, ok = correlationIDs[correlationID]; ok {
+				d.responses[uint16(tcp.SrcPort)]++
+				delete(correlationIDs, correlationID)
+			}
+		}
+	}
+
+	correlationID, ok := kafka.ParseRequestHeader(tcp.Payload)
+	if !ok {
+		return
+	}
+
+	reverse := flowKey{net.Reverse(), key.transport.Reverse()}
+	correlationIDs, ok := d.pending[reverse]
+	if !ok {
+		correlationIDs = make(map[int32]struct{})
+		d.pending[reverse] = correlationIDs
+	}
+	if len(correlationIDs) < maxPendingRequests {
+		correlationIDs[correlationID] = struct{}{}
+	}
+}
+
+// Ports returns sorted ports which responded to at least minResponses kafka requests
+func (d *PortDetector) Ports(minResponses int) []uint16 {
+	var ports []uint16
+	for port, responses := range d.responses {
+		if responses >= minResponses {
+			ports = append(ports, port)
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	return ports
+}
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/google/gopacket"
+)
+
+const (
+	// maxBufferedFlows limits flows which packets are buffered until they are identified, buffers of all flows
+	// are forgotten when it's exceeded, e.g. by packets of flows which are never decoded
+	maxBufferedFlows = 4096
+
+	// maxBufferedPackets limits buffered packets of a flow, the rest of packets sent before its first decoded
+	// request aren't dumped
+	maxBufferedPackets = 256
+)
+
+type flowKey struct {
+	net, transport gopacket.Flow
+}
+
+// reverse returns key of the opposite direction of the flow
+func (k flowKey) reverse() flowKey {
+	return flowKey{k.net.Reverse(), k.transport.Reverse()}
+}
+
+// FlowPacket is a captured packet of a flow
+type FlowPacket struct {
+	CaptureInfo gopacket.CaptureInfo
+	Data        []byte
+}
+
+// KafkaFlows is a set of TCP flows where kafka requests were successfully decoded. Packets of flows which
+// aren't identified yet are buffered, so handshakes and the first requests of identified flows are dumped too.
+type KafkaFlows struct {
+	mux      sync.Mutex
+	flows    map[flowKey]struct{}
+	buffered map[flowKey][]FlowPacket
+}
+
+// NewKafkaFlows creates new KafkaFlows
+func NewKafkaFlows() *KafkaFlows {
+	return &KafkaFlows{
+		flows:    make(map[flowKey]struct{}),
+		buffered: make(map[flowKey][]FlowPacket),
+	}
+}
+
+// Contains returns true if kafka requests were decoded in the flow of either direction
+func (f *KafkaFlows) Contains(net, transport gopacket.Flow) bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return f.containsLocked(flowKey{net, transport})
+}
+
+// Packets returns packets of the flow of either direction to dump with the packet: buffered packets followed by
+// the packet if the flow is identified. Otherwise the packet is buffered until the flow is identified or
+// forgotten, data of the packet mustn't be modified after the call.
+func (f *KafkaFlows) Packets(net, transport gopacket.Flow, ci gopacket.CaptureInfo, data []byte) []FlowPacket {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	key := flowKey{net, transport}
+	if _, ok := f.buffered[key]; !ok {
+		if _, ok = f.buffered[key.reverse()]; ok {
+			key = key.reverse()
+		}
+	}
+
+	packets := append(f.buffered[key], FlowPacket{CaptureInfo: ci, Data: data})
+	if f.containsLocked(key) {
+		delete(f.buffered, key)
+		return packets
+	}
+
+	if len(packets) > maxBufferedPackets {
+		return nil
+	}
+	if _, ok := f.buffered[key]; !ok && len(f.buffered) >= maxBufferedFlows {
+		f.buffered = make(map[flowKey][]FlowPacket)
+	}
+	f.buffered[key] = packets
+	return nil
+}
+
+func (f *KafkaFlows) containsLocked(key flowKey) bool {
+	if _, ok := f.flows[key]; ok {
+		return true
+	}
+	_, ok := f.flows[key.reverse()]
+	return ok
+}
+
+func (f *KafkaFlows) add(net, transport gopacket.Flow) {
+	f.mux.Lock()
+	f.flows[flowKey{net, transport}] = struct{}{}
+	f.mux.Unlock()
+}
+
+// remove forgets the flow and its buffered packets
+func (f *KafkaFlows) remove(net, transport gopacket.Flow) {
+	key := flowKey{net, transport}
+
+	f.mux.Lock()
+	delete(f.flows, key)
+	delete(f.buffered, key)
+	delete(f.buffered, key.reverse())
+	f.mux.Unlock()
+}
@@ -0,0 +1,75 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func testFlow() (gopacket.Flow, gopacket.Flow) {
+	net := gopacket.NewFlow(layers.EndpointIPv4, testClient.To4(), testBroker.To4())
+	transport := gopacket.NewFlow(layers.EndpointTCPPort, []byte{0xc3, 0x51}, []byte{0x23, 0x84})
+	return net, transport
+}
+
+// TestKafkaFlowsBuffered checks packets of both directions sent before the flow is identified are dumped in
+// order with the next packet
+func TestKafkaFlowsBuffered(t *testing.T) {
+	f := NewKafkaFlows()
+	net, transport := testFlow()
+	ts := time.Unix(1600000000, 0)
+
+	packet := func(i int) gopacket.CaptureInfo {
+		return gopacket.CaptureInfo{Timestamp: ts.Add(time.Duration(i) * time.Millisecond)}
+	}
+
+	// handshake and the first request
+	for i := 0; i < 4; i++ {
+		n, tr := net, transport
+		if i == 1 {
+			n, tr = net.Reverse(), transport.Reverse()
+		}
+		if packets := f.Packets(n, tr, packet(i), []byte{byte(i)}); packets != nil {
+			t.Fatalf("%d packets of unidentified flow are dumped", len(packets))
+		}
+	}
+
+	f.add(net, transport)
+	if !f.Contains(net.Reverse(), transport.Reverse()) {
+		t.Error("reverse direction of identified flow isn't contained")
+	}
+
+	packets := f.Packets(net.Reverse(), transport.Reverse(), packet(4), []byte{4})
+	if len(packets) != 5 {
+		t.Fatalf("%d packets are dumped, want 5", len(packets))
+	}
+	for i, p := range packets {
+		if p.Data[0] != byte(i) || !p.CaptureInfo.Timestamp.Equal(packet(i).Timestamp) {
+			t.Errorf("packet %d is dumped as packet %d", i, p.Data[0])
+		}
+	}
+
+	if packets = f.Packets(net, transport, packet(5), []byte{5}); len(packets) != 1 {
+		t.Errorf("%d packets are dumped after buffered ones, want 1", len(packets))
+	}
+}
+
+func TestKafkaFlowsRemove(t *testing.T) {
+	f := NewKafkaFlows()
+	net, transport := testFlow()
+
+	for i := 0; i < maxBufferedPackets+10; i++ {
+		f.Packets(net, transport, gopacket.CaptureInfo{}, []byte{byte(i)})
+	}
+	if n := len(f.buffered[flowKey{net, transport}]); n != maxBufferedPackets {
+		t.Errorf("%d packets are buffered, want %d", n, maxBufferedPackets)
+	}
+
+	// packets of flow which isn't decoded are forgotten
+	f.remove(net, transport)
+	if len(f.buffered) != 0 {
+		t.Errorf("%d flows are buffered after removal", len(f.buffered))
+	}
+}
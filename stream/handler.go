@@ -0,0 +1,139 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// ConnInfo describes connection of decoded request
+type ConnInfo struct {
+	// Client and Broker are addresses of the connection, e.g. 10.0.0.1:52314 and 10.0.0.2:9092
+	Client string
+	Broker string
+
+	// ClientIP is an address of client as it's rendered in metrics labels
+	ClientIP string
+
+	// Principal is authenticated by SASL handshake of the connection, it's empty for anonymous connections
+	Principal string
+
+	// Connection classifies connection as client, inter-broker or replication one
+	Connection ConnectionType
+
+	// Encrypted is true for decrypted TLS connections
+	Encrypted bool
+
+	// Seen is a capture time of the first byte of the request
+	Seen time.Time
+}
+
+// RequestHandler processes decoded requests, e.g. for billing or quota accounting. Topics are topics of
+// the request reported in metrics and events: topics excluded by filters and skipped internal topics aren't in
+// them, requests with all topics filtered out aren't handled. Handlers are called from goroutines of streams
+// concurrently, request is valid only until the call returns, its buffers are reused then.
+type RequestHandler interface {
+	HandleRequest(conn ConnInfo, req *kafka.Request, topics []string)
+}
+
+// RequestHandlerFunc is a function implementing RequestHandler
+type RequestHandlerFunc func(conn ConnInfo, req *kafka.Request, topics []string)
+
+// HandleRequest implements RequestHandler
+func (f RequestHandlerFunc) HandleRequest(conn ConnInfo, req *kafka.Request, topics []string) {
+	f(conn, req, topics)
+}
+
+var (
+	handlersMux sync.RWMutex
+	handlers    = make(map[string]RequestHandler)
+)
+
+// RegisterHandler registers handler called for requests of every stream factory created after registration,
+// e.g. from init function of package imported by custom build of sniffer. It panics if name is registered twice.
+func RegisterHandler(name string, h RequestHandler) {
+	handlersMux.Lock()
+	defer handlersMux.Unlock()
+
+	if h == nil {
+		panic("stream: handler " + name + " is nil")
+	}
+	if _, ok := handlers[name]; ok {
+		panic(fmt.Sprintf("stream: handler %s is registered twice", name))
+	}
+	handlers[name] = h
+}
+
+// Handlers returns sorted names of registered handlers
+func Handlers() []string {
+	handlersMux.RLock()
+	defer handlersMux.RUnlock()
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registeredHandlers returns registered handlers sorted by names
+func registeredHandlers() []RequestHandler {
+	names := Handlers()
+
+	handlersMux.RLock()
+	defer handlersMux.RUnlock()
+
+	out := make([]RequestHandler, 0, len(names))
+	for _, name := range names {
+		if h, ok := handlers[name]; ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// metricsHandler is a built-in handler reporting relations of clients with topics and groups into storage
+// and detecting rebalance storms
+type metricsHandler struct {
+	storage           *metrics.Storage
+	rebalanceDetector *metrics.RebalanceDetector
+}
+
+// HandleRequest implements RequestHandler
+func (m *metricsHandler) HandleRequest(conn ConnInfo, req *kafka.Request, topics []string) {
+	// activity is reported by capture time, so replays of pcap files report activity of the capture
+	seen := conn.Seen
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+
+	switch body := req.Body.(type) {
+	case *kafka.ProduceRequest:
+		for _, topic := range topics {
+			m.storage.AddProducerTopicRelationInfo(conn.ClientIP, topic, conn.Principal, string(conn.Connection))
+			m.storage.AddTopicActivity(conn.ClientIP, topic, "producer", seen)
+		}
+	case *kafka.FetchRequest:
+		for _, topic := range topics {
+			if body.IsFollower() {
+				m.storage.AddReplicationTopicRelationInfo(conn.ClientIP, topic, body.ReplicaID)
+			} else {
+				m.storage.AddConsumerTopicRelationInfo(conn.ClientIP, topic, conn.Principal, string(conn.Connection))
+				m.storage.AddTopicActivity(conn.ClientIP, topic, "consumer", seen)
+			}
+		}
+	case *kafka.JoinGroupRequest:
+		m.storage.AddGroupMemberRelationInfo(conn.ClientIP, body.GroupID, body.InstanceID(), conn.Principal)
+	case *kafka.SyncGroupRequest:
+		rebalances, storm := m.rebalanceDetector.ObserveGeneration(body.GroupID, body.GenerationID, time.Now())
+		if storm {
+			log.Printf("rebalance storm detected: group %s rebalanced %d times during the last minute", body.GroupID, rebalances)
+		}
+	}
+}
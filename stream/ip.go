@@ -0,0 +1,37 @@
+package stream
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/google/gopacket"
+)
+
+// ClientIPFormat defines how client addresses are rendered in metrics labels
+type ClientIPFormat struct {
+	// IPv6Brackets renders IPv6 addresses in brackets, e.g. [2001:db8::1]
+	IPv6Brackets bool
+
+	// IPv6PrefixLen aggregates IPv6 clients by prefix of this length, e.g. 2001:db8::/64 for 64.
+	// Zero or 128 disables aggregation.
+	IPv6PrefixLen int
+}
+
+// Format renders client address of network endpoint
+func (f ClientIPFormat) Format(endpoint gopacket.Endpoint) string {
+	ip := net.IP(endpoint.Raw())
+	if len(ip) != net.IPv6len || ip.To4() != nil {
+		return endpoint.String()
+	}
+
+	var prefix string
+	if f.IPv6PrefixLen > 0 && f.IPv6PrefixLen < 8*net.IPv6len {
+		ip = ip.Mask(net.CIDRMask(f.IPv6PrefixLen, 8*net.IPv6len))
+		prefix = "/" + strconv.Itoa(f.IPv6PrefixLen)
+	}
+
+	if f.IPv6Brackets {
+		return "[" + ip.String() + "]" + prefix
+	}
+	return ip.String() + prefix
+}
@@ -2,110 +2,1001 @@ package stream
 
 import (
 	"bufio"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	"github.com/d-ulyanov/kafka-sniffer/filter"
 	"github.com/d-ulyanov/kafka-sniffer/kafka"
 	"github.com/d-ulyanov/kafka-sniffer/metrics"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/tcpassembly"
-	"github.com/google/gopacket/tcpassembly/tcpreader"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
 )
 
-// KafkaStreamFactory implements tcpassembly.StreamFactory
+// KafkaStreamFactory implements reassembly.StreamFactory
 type KafkaStreamFactory struct {
 	metricsStorage *metrics.Storage
-	verbose        bool
+	sink           events.Sink
+	cfg            Config
+
+	// handlers are called for every decoded request: built-in handler of metrics, registered and configured
+	// handlers
+	handlers []RequestHandler
+
+	// budget limits memory of data buffered by streams
+	budget *memoryBudget
+
+	// running decoders of streams
+	running sync.WaitGroup
+
+	// streams are active streams reported by diagnostics
+	streamsMux sync.Mutex
+	streams    map[*KafkaStream]struct{}
 }
 
-// NewKafkaStreamFactory assembles streams
-func NewKafkaStreamFactory(metricsStorage *metrics.Storage, verbose bool) *KafkaStreamFactory {
-	return &KafkaStreamFactory{metricsStorage: metricsStorage, verbose: verbose}
+// NewKafkaStreamFactory assembles streams, sink may be nil if events aren't needed
+func NewKafkaStreamFactory(metricsStorage *metrics.Storage, rebalanceDetector *metrics.RebalanceDetector, sink events.Sink, cfg Config) *KafkaStreamFactory {
+	handlers := []RequestHandler{&metricsHandler{storage: metricsStorage, rebalanceDetector: rebalanceDetector}}
+	handlers = append(handlers, registeredHandlers()...)
+	handlers = append(handlers, cfg.Handlers...)
+
+	return &KafkaStreamFactory{
+		metricsStorage: metricsStorage,
+		sink:           sink,
+		cfg:            cfg,
+		handlers:       handlers,
+		budget:         newMemoryBudget(cfg.StreamMemoryLimit, cfg.MemoryLimit),
+		streams:        make(map[*KafkaStream]struct{}),
+	}
 }
 
-// New assembles new stream
-func (h *KafkaStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
+// New assembles new stream of TCP connection started with the packet
+func (h *KafkaStreamFactory) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
 	s := &KafkaStream{
 		net:            net,
 		transport:      transport,
-		r:              tcpreader.NewReaderStream(),
+		requestDir:     reassembly.TCPDirClientToServer,
+		fsm:            newConnFSM(tcp),
+		joined:         !tcp.SYN,
+		conn:           newConnection(h.cfg.Responses, h.cfg.Tracing),
+		requests:       newStreamReader(h.budget),
+		responses:      newStreamReader(h.budget),
 		metricsStorage: h.metricsStorage,
-		verbose:        h.verbose,
+		sink:           h.sink,
+		cfg:            h.cfg,
+		handlers:       h.handlers,
+		started:        ac.GetCaptureInfo().Timestamp,
+	}
+
+	// assembler treats sender of the first packet as client, it's broker if SYN+ACK or response is the first
+	// captured packet
+	if tcp.SYN && tcp.ACK || h.cfg.BrokerPorts[uint16(tcp.SrcPort)] && !h.cfg.BrokerPorts[uint16(tcp.DstPort)] {
+		s.net, s.transport = net.Reverse(), transport.Reverse()
+		s.requestDir = reassembly.TCPDirServerToClient
 	}
 
-	go s.run() // Important... we must guarantee that data from the reader stream is read.
+	h.streamsMux.Lock()
+	h.streams[s] = struct{}{}
+	h.streamsMux.Unlock()
 
-	return &s.r
+	// stream is active until both of its decoders stop
+	decoders := int32(2)
+	stop := func() {
+		if atomic.AddInt32(&decoders, -1) == 0 {
+			// events of requests without captured responses are written when the connection is over
+			s.writeEvents(s.conn.held())
+
+			h.streamsMux.Lock()
+			delete(h.streams, s)
+			h.streamsMux.Unlock()
+		}
+		h.running.Done()
+		metrics.InternalStreamDecoders.Dec()
+	}
+
+	h.running.Add(2)
+	metrics.InternalStreamDecoders.Add(2)
+	go func() {
+		defer stop()
+		s.run() // Important... we must guarantee that data from the reader stream is read.
+	}()
+	go func() {
+		defer stop()
+		s.runResponses()
+	}()
+
+	return s
+}
+
+// Wait waits until requests and responses of closed streams are decoded
+func (h *KafkaStreamFactory) Wait() {
+	h.running.Wait()
 }
 
-// KafkaStream will handle the actual decoding of http requests.
+// ConnectionState is a state of active connection reported by diagnostics
+type ConnectionState struct {
+	Client    string
+	Broker    string
+	Started   time.Time
+	ClientID  string
+	Principal string
+	Encrypted bool
+	Requests  int64
+
+	// Buffered is a count of bytes of requests and responses buffered until they are decoded
+	Buffered int64
+}
+
+// Connections returns states of active connections sorted by client address
+func (h *KafkaStreamFactory) Connections() []ConnectionState {
+	h.streamsMux.Lock()
+	streams := make([]*KafkaStream, 0, len(h.streams))
+	for s := range h.streams {
+		streams = append(streams, s)
+	}
+	h.streamsMux.Unlock()
+
+	// clients are anonymized like in metrics, ports are kept to tell connections apart
+	anonymizer := h.cfg.Anonymizer
+
+	out := make([]ConnectionState, 0, len(streams))
+	for _, s := range streams {
+		session := s.conn.session()
+		out = append(out, ConnectionState{
+			Client:    net.JoinHostPort(anonymizer.Hash(s.net.Src().String()), s.transport.Src().String()),
+			Broker:    net.JoinHostPort(s.net.Dst().String(), s.transport.Dst().String()),
+			Started:   s.started,
+			ClientID:  anonymizer.Hash(session.clientID),
+			Principal: anonymizer.Hash(session.principal),
+			Encrypted: s.conn.isEncrypted(),
+			Requests:  s.conn.requestsCount(),
+			Buffered:  atomic.LoadInt64(&s.requests.buffered) + atomic.LoadInt64(&s.responses.buffered),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Client < out[j].Client })
+
+	return out
+}
+
+// KafkaStream is a TCP connection to broker keyed by both directions of the connection, requests are decoded
+// from data sent by client, responses from data sent by broker
 type KafkaStream struct {
 	net, transport gopacket.Flow
-	r              tcpreader.ReaderStream
+	started        time.Time
+	requestDir     reassembly.TCPFlowDirection
+	fsm            *connFSM
+	joined         bool
+	conn           *connection
+	requests       *streamReader
+	responses      *streamReader
 	metricsStorage *metrics.Storage
-	verbose        bool
+	sink           events.Sink
+	cfg            Config
+	handlers       []RequestHandler
+}
+
+// Accept implements reassembly.Stream, data of connections joined in the middle is accepted without waiting SYN
+func (h *KafkaStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	if !h.fsm.update(tcp, dir == h.requestDir) {
+		return false
+	}
+
+	h.reader(dir).accept(tcp, ci)
+	*start = true
+
+	return true
+}
+
+// ReassembledSG implements reassembly.Stream, it passes data of requests and responses to decoders
+func (h *KafkaStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, end, skip := sg.Info()
+	r := h.reader(dir)
+
+	if skip > 0 && h.cfg.Verbose.On() {
+		src, dst := h.addresses()
+		log.Printf("%s -> %s: %d bytes are lost", src, dst, skip)
+	}
+
+	// assembler passes every byte once, bytes of retransmitted segments which are already reassembled or
+	// queued are discarded and reported in stats only
+	if overlap := sg.Stats().OverlapBytes; overlap > 0 {
+		metrics.RetransmittedBytes.Add(float64(overlap))
+	}
+
+	length, _ := sg.Lengths()
+	r.write(sg.Fetch(length), sg.CaptureInfo(0).Timestamp, skip)
+
+	// reset closes both directions
+	if end && h.fsm.state == connClosed {
+		h.requests.close()
+		h.responses.close()
+	} else if end {
+		r.close()
+	}
+}
+
+// reader returns reader of requests or responses direction
+func (h *KafkaStream) reader(dir reassembly.TCPFlowDirection) *streamReader {
+	if dir == h.requestDir {
+		return h.requests
+	}
+	return h.responses
+}
+
+// ReassemblyComplete implements reassembly.Stream, it's called when connection is closed or flushed by timeout
+func (h *KafkaStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	h.requests.close()
+	h.responses.close()
+
+	// remove the connection from pool
+	return true
 }
 
 func (h *KafkaStream) run() {
-	srcHost := fmt.Sprint(h.net.Src())
-	srcPort := fmt.Sprint(h.transport.Src())
-	dstHost := fmt.Sprint(h.net.Dst())
-	dstPort := fmt.Sprint(h.transport.Dst())
+	src, dst := h.addresses()
+
+	// client address used in filter expression and metrics labels, labels are hashed in anonymization mode
+	clientAddr := h.cfg.ClientIP.Format(h.net.Src())
+	clientIP := h.cfg.Anonymizer.Hash(clientAddr)
 
-	log.Printf("%s:%s -> %s:%s", srcHost, srcPort, dstHost, dstPort)
-	log.Printf("%s:%s -> %s:%s", dstHost, dstPort, srcHost, srcPort)
+	log.Printf("%s -> %s", src, dst)
+	log.Printf("%s -> %s", dst, src)
 
-	buf := bufio.NewReaderSize(&h.r, 2<<15) // 65k
+	buf := bufio.NewReaderSize(h.requests, h.cfg.bufferSize())
+
+	// data written after decoder stops is dropped
+	defer h.requests.done()
+
+	// buffered packets of flows which aren't decoded are forgotten too
+	if h.cfg.Flows != nil {
+		defer h.cfg.Flows.remove(h.net, h.transport)
+	}
+
+	// connections to SSL listeners start with TLS ClientHello, their data can't be decoded
+	header, _ := buf.Peek(tlsRecordHeaderSize)
+	encrypted := isTLSHandshake(header, tlsClientHello)
+
+	// TLS connection joined in the middle starts with records of the missed session, they can't be decrypted
+	// without its handshake
+	joinedTLS := h.joined && !encrypted && isTLSRecord(header)
 
 	// add new client ip to metric
-	h.metricsStorage.AddActiveConnectionsTotal(h.net.Src().String())
+	h.metricsStorage.AddActiveConnectionsTotal(clientIP, encrypted || joinedTLS)
+
+	if joinedTLS {
+		h.conn.setEncrypted()
+		if h.cfg.Verbose.On() {
+			log.Printf("%s -> %s: TLS connection joined in the middle, skipping decoding", src, dst)
+		}
+		return
+	}
+
+	// position returns offset in captured stream of byte at offset of decoded stream, they differ for
+	// decrypted TLS connections
+	position := func(offset int64) int64 { return offset }
+
+	if encrypted {
+		h.conn.setEncrypted()
+		if h.cfg.TLSKeys == nil {
+			if h.cfg.Verbose.On() {
+				log.Printf("%s -> %s: TLS connection, skipping decoding", src, dst)
+			}
+			return
+		}
+
+		tls := h.decrypt(buf, true)
+		defer tls.close()
+
+		buf = bufio.NewReaderSize(tls, h.cfg.bufferSize())
+		position = tls.position
+	}
+
+	var (
+		identified bool
+
+		// offset of the request in the stream
+		offset int64
+
+		// req is the last decoded request, its buffer is reused after it's handled
+		req *kafka.Request
+
+		sample = sampler{rate: h.cfg.SampleRate}
+	)
+
+	// connection joined in the middle usually starts in the middle of request, its first request is confirmed
+	// by the next one
+	if h.joined {
+		skipped, err := kafka.SyncToRequest(buf)
+		if skipped > 0 {
+			if h.cfg.Verbose.On() {
+				log.Printf("%s -> %s: joined in the middle, skipped %d bytes to the first request\n", src, dst, skipped)
+			}
+			metrics.Resyncs.Inc()
+			offset += int64(skipped)
+		}
+		if err != nil {
+			return
+		}
+	}
 
 	for {
-		req, readBytes, err := kafka.DecodeRequest(buf)
+		req.Release()
+
+		// bytes of decoded and skipped requests don't take memory anymore
+		h.requests.release(position(offset))
+
+		// stream is resynchronized by the next plausible request header after decoding errors
+		skipped, err := kafka.SkipToRequest(buf)
+		if skipped > 0 {
+			if h.cfg.Verbose.On() {
+				log.Printf("%s -> %s: skipped %d bytes to the next request\n", src, dst, skipped)
+			}
+			metrics.Resyncs.Inc()
+			offset += int64(skipped)
+		}
+		if err != nil {
+			return
+		}
+
+		// header of request is buffered after resynchronization
+		header, _ := buf.Peek(8)
+		key := kafka.DecodeKey(header)
+		decode := h.cfg.processes(key) && sample.decode(key)
+
+		var readBytes int
+		if decode {
+			req, readBytes, err = kafka.DecodeRequest(buf)
+		} else {
+			req, readBytes, err = kafka.DecodeRequestHeader(buf)
+		}
+		offset += int64(readBytes)
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			return
 		}
 
+		// request with bytes missing in capture is skipped instead of decoding garbage, the next request
+		// starts after its length
+		start, end := position(offset-int64(readBytes)), position(offset)
+		if h.requests.isDamaged(start, end) {
+			if h.cfg.Verbose.On() {
+				log.Printf("%s -> %s: skipped %d bytes of request with bytes missing in capture\n", src, dst, readBytes)
+			}
+			metrics.SkippedBytes.Add(float64(readBytes))
+			continue
+		}
+
 		if err != nil {
 			log.Printf("unable to read request to Broker - skipping packet: %s\n", err)
+			continue
+		}
 
-			if _, ok := err.(kafka.PacketDecodingError); ok {
-				_, err := buf.Discard(readBytes)
-				if err != nil {
-					log.Printf("could not discard: %s\n", err)
-				}
-			}
+		// flows are identified by the first request matching filter, packets buffered before it are dumped too
+		if !identified && h.cfg.Flows != nil && h.cfg.Filter == nil {
+			h.cfg.Flows.add(h.net, h.transport)
+			identified = true
+		}
+
+		// requests of api keys which aren't processed are skipped by header
+		if !h.cfg.processes(req.Key) {
+			continue
+		}
+
+		seen := h.requests.seenAt(start)
+		h.writeEvents(h.conn.request(req, seen))
+
+		// versions of apis are reported for every request including ones skipped by sampling
+		h.metricsStorage.AddClientAPIVersionInfo(clientIP, h.cfg.Anonymizer.Hash(req.ClientID), kafka.APIKeyName(req.Key), req.Version, h.cfg.deprecated(req.Key, req.Version))
+
+		// rendered request contains topics and client id as is
+		if h.cfg.Verbose.On() && h.cfg.Anonymizer == nil {
+			log.Printf("got request %s\n", req)
+		} else if h.cfg.Verbose.On() {
+			log.Printf("got request, key: %d, version: %d, correlationID: %d\n", req.Key, req.Version, req.CorrelationID)
+		}
+
+		// requests skipped by sampling are counted without their bodies
+		if !decode {
+			kafka.CountRequest(clientIP, req.Key, req.Version)
+			continue
+		}
 
+		// only session state is taken from requests of unsupported apis
+		if req.Body == nil {
 			continue
 		}
 
-		if h.verbose {
-			log.Printf("got request, key: %d, version: %d, correlationID: %d, clientID: %s\n", req.Key, req.Version, req.CorrelationID, req.ClientID)
+		// principal authenticated by SASL is attached to relations of the connection
+		principal := h.conn.authenticated()
+
+		// brokers can be learned after the connection is started, so it's classified by every request
+		connType := h.cfg.Brokers.classify(h.net.Src().String(), h.net.Dst().String())
+
+		// fetches of followers have replica id, their connections are replication ones and followers are brokers
+		if fetch, ok := req.Body.(*kafka.FetchRequest); ok && fetch.IsFollower() {
+			h.cfg.Brokers.learn(h.net.Src().String())
+			connType = ConnectionReplication
 		}
 
-		req.Body.CollectClientMetrics(srcHost)
+		// requests not matching filter expression are skipped in metrics, events and pcap dump
+		if h.cfg.Filter != nil {
+			if !h.cfg.Filter.Match(filterRequest(req, readBytes, clientAddr, principal, connType)) {
+				continue
+			}
+			if !identified && h.cfg.Flows != nil {
+				h.cfg.Flows.add(h.net, h.transport)
+				identified = true
+			}
+		}
+
+		// topics reported in the event, internal topics are skipped unless they are included. Requests with
+		// all topics filtered out aren't reported in events.
+		var (
+			topics   []string
+			filtered bool
+		)
+
+		// access of the principal not allowed by declared ACLs is marked in the event
+		var (
+			aclUnexpected bool
+			schemas       []string
+			invalidTopics []string
+			requestType   string
+		)
+		audit := func(resourceType, resource, operation string) {
+			if h.cfg.ACLAudit.Observe(principal, h.net.Src().String(), resourceType, resource, operation) {
+				aclUnexpected = true
+			}
+		}
 
 		switch body := req.Body.(type) {
 		case *kafka.ProduceRequest:
+			requestType = "produce"
+
+			// producer ids belong to clients rather than topics, so they are tracked by batches of all topics
+			for id, epoch := range body.ProducerIDs() {
+				h.cfg.ProducerIDs.Observe(clientIP, id, epoch, time.Now())
+			}
+
+			var schemaRefs map[string]*kafka.SchemaRefs
+			if h.cfg.DetectSchemas {
+				schemaRefs = body.SchemaRefs()
+			}
+
 			for _, topic := range body.ExtractTopics() {
-				if h.verbose {
-					log.Printf("client %s:%s wrote to topic %s", srcHost, srcPort, topic)
+				if !h.cfg.Topics.allows(topic) {
+					filtered = true
+					continue
+				}
+				if h.skipInternalTopic(clientIP, principal, topic, "producer") {
+					continue
+				}
+				topics = append(topics, h.cfg.Anonymizer.Hash(topic))
+
+				if h.cfg.Verbose.On() {
+					log.Printf("client %s wrote to topic %s", src, h.cfg.Anonymizer.Hash(topic))
 				}
 
-				// add producer and topic relation info into metric
-				h.metricsStorage.AddProducerTopicRelationInfo(h.net.Src().String(), topic)
+				audit(metrics.ACLTopic, topic, metrics.ACLWrite)
+				invalidTopics = h.checkTopicName(clientIP, topic, "producer", invalidTopics)
+				h.cfg.TopN.AddProduced(clientIP, h.cfg.Anonymizer.Hash(topic), body.TopicRecordsSize(topic))
+				h.checkBatchSizes(clientIP, src, topic, body.TopicBatchSizes(topic))
+
+				if refs, ok := schemaRefs[topic]; ok {
+					schemas = h.reportSchemas(clientIP, topic, refs, schemas)
+				}
 			}
 		case *kafka.FetchRequest:
+			requestType = "fetch"
+
+			if !body.IsFollower() {
+				h.cfg.TopN.AddFetch(clientIP)
+			}
+
+			for _, topic := range body.ExtractTopics() {
+				if !h.cfg.Topics.allows(topic) {
+					filtered = true
+					continue
+				}
+
+				// followers replicate every topic including internal ones, they aren't consumers
+				if body.IsFollower() {
+					topics = append(topics, h.cfg.Anonymizer.Hash(topic))
+					continue
+				}
+
+				if h.skipInternalTopic(clientIP, principal, topic, "consumer") {
+					continue
+				}
+				topics = append(topics, h.cfg.Anonymizer.Hash(topic))
+
+				if h.cfg.Verbose.On() {
+					log.Printf("client %s read from topic %s", src, h.cfg.Anonymizer.Hash(topic))
+				}
+
+				audit(metrics.ACLTopic, topic, metrics.ACLRead)
+				invalidTopics = h.checkTopicName(clientIP, topic, "consumer", invalidTopics)
+			}
+		case *kafka.WriteTxnMarkersRequest:
+			requestType = "write_txn_markers"
+
+			allowed := make(map[string]bool)
 			for _, topic := range body.ExtractTopics() {
-				if h.verbose {
-					log.Printf("client %s:%s read from topic %s", h.net.Src(), h.transport.Src(), topic)
+				if !h.cfg.Topics.allows(topic) {
+					filtered = true
+					continue
+				}
+
+				// markers of transactional offset commits are written to the internal topic of offsets
+				if h.cfg.InternalTopics != InternalTopicsInclude && kafka.IsInternalTopic(topic) {
+					continue
 				}
+				allowed[topic] = true
+				topics = append(topics, h.cfg.Anonymizer.Hash(topic))
+			}
+
+			for _, marker := range body.Markers {
+				for _, topic := range marker.Topics {
+					if allowed[topic.Topic] {
+						metrics.TransactionMarkers.WithLabelValues(h.cfg.Anonymizer.Hash(topic.Topic), marker.Result()).Inc()
+					}
+				}
+
+				if h.cfg.Verbose.On() {
+					log.Printf("coordinator %s wrote %s marker of producer %d", src, marker.Result(), marker.ProducerID)
+				}
+			}
+		case *kafka.JoinGroupRequest:
+			if h.cfg.Verbose.On() {
+				if body.IsStaticMember() {
+					log.Printf("client %s joins group %s as static member %s", src, h.cfg.Anonymizer.Hash(body.GroupID), h.cfg.Anonymizer.Hash(body.InstanceID()))
+				} else {
+					log.Printf("client %s joins group %s as dynamic member", src, h.cfg.Anonymizer.Hash(body.GroupID))
+				}
+			}
 
-				// add consumer and topic relation info into metric
-				h.metricsStorage.AddConsumerTopicRelationInfo(h.net.Src().String(), topic)
+			audit(metrics.ACLGroup, body.GroupID, metrics.ACLRead)
+		case *kafka.SaslAuthenticateRequest:
+			if h.cfg.Verbose.On() && principal != "" {
+				log.Printf("client %s authenticated as %s", src, h.cfg.Anonymizer.Hash(principal))
 			}
 		}
+
+		// group ids are hashed in the request, so they are anonymized in metrics of requests, handlers and events
+		if h.cfg.Anonymizer != nil {
+			anonymizeGroup(req.Body, h.cfg.Anonymizer)
+		}
+
+		req.Body.CollectClientMetrics(clientIP)
+
+		// requests are counted by reported topics, so filtered and skipped internal topics aren't counted
+		for _, topic := range topics {
+			metrics.TopicRequestsCount.WithLabelValues(topic, requestType).Inc()
+		}
+
+		if filtered && len(topics) == 0 {
+			continue
+		}
+
+		// relation metrics are reported by the built-in handler
+		conn := ConnInfo{
+			Client:     src,
+			Broker:     dst,
+			ClientIP:   clientIP,
+			Principal:  h.cfg.Anonymizer.Hash(principal),
+			Connection: connType,
+			Encrypted:  encrypted,
+			Seen:       seen,
+		}
+		for _, handler := range h.handlers {
+			handler.HandleRequest(conn, req, topics)
+		}
+
+		if h.sink != nil {
+			outputStart := time.Now()
+			e := h.newEvent(req, seen, readBytes, topics, connType)
+			e.ACLUnexpected = aclUnexpected
+			e.Schemas = schemas
+			e.InvalidTopics = invalidTopics
+
+			// traced event is written when the response is decoded, so its span ends at the response
+			if !h.cfg.Tracing || !expectsResponse(req) || !h.conn.trace(req.CorrelationID, e) {
+				h.writeEvents([]*events.Event{e})
+			}
+			metrics.InternalStageDuration.WithLabelValues("output").Observe(time.Since(outputStart).Seconds())
+		}
+	}
+}
+
+// writeEvents writes events into the sink
+func (h *KafkaStream) writeEvents(evts []*events.Event) {
+	for _, e := range evts {
+		if err := h.sink.Write(e); err != nil {
+			log.Printf("could not write event: %s\n", err)
+		}
+	}
+}
+
+// expectsResponse returns false if broker doesn't respond to the request, i.e. to produce request without acks
+func expectsResponse(req *kafka.Request) bool {
+	produce, ok := req.Body.(*kafka.ProduceRequest)
+	return !ok || produce.RequiredAcks != 0
+}
+
+// filterRequest returns fields of request matched by filter expression, topics are all topics of the request
+// including internal and filtered out ones
+func filterRequest(req *kafka.Request, size int, clientIP, principal string, connType ConnectionType) *filter.Request {
+	r := &filter.Request{
+		APIKey:     req.Key,
+		APIVersion: req.Version,
+		ClientID:   req.ClientID,
+		ClientIP:   clientIP,
+		Principal:  principal,
+		Connection: string(connType),
+		Size:       size,
+	}
+
+	switch body := req.Body.(type) {
+	case *kafka.ProduceRequest:
+		r.Topics = body.ExtractTopics()
+	case *kafka.FetchRequest:
+		r.Topics = body.ExtractTopics()
+	case *kafka.WriteTxnMarkersRequest:
+		r.Topics = body.ExtractTopics()
+	case *kafka.JoinGroupRequest:
+		r.Group = body.GroupID
+	case *kafka.SyncGroupRequest:
+		r.Group = body.GroupID
+	}
+
+	return r
+}
+
+// reportSchemas adds relations of producer with schemas of records of topic and returns schemas of the event
+// appended with them, schemas which aren't resolved by registry are reported by ids
+func (h *KafkaStream) reportSchemas(clientIP, topic string, refs *kafka.SchemaRefs, schemas []string) []string {
+	report := func(ids []int32, part string) {
+		for _, id := range ids {
+			subject, ok := h.cfg.SchemaRegistry.Resolve(id, topic, part == "key")
+			subject.Subject = h.cfg.Anonymizer.Hash(subject.Subject)
+			h.metricsStorage.AddProducerSchemaRelationInfo(clientIP, h.cfg.Anonymizer.Hash(topic), part, id, subject)
+
+			if ok {
+				schemas = append(schemas, subject.String())
+			} else {
+				schemas = append(schemas, strconv.Itoa(int(id)))
+			}
+		}
+	}
+
+	report(refs.Keys, "key")
+	report(refs.Values, "value")
+
+	return schemas
+}
+
+// runResponses reads responses and pairs them with requests of the connection
+func (h *KafkaStream) runResponses() {
+	buf := bufio.NewReaderSize(h.responses, h.cfg.bufferSize())
+
+	// data written after decoder stops is dropped
+	defer h.responses.done()
+
+	position := func(offset int64) int64 { return offset }
+
+	// broker answers TLS ClientHello with ServerHello
+	if header, _ := buf.Peek(tlsRecordHeaderSize); isTLSHandshake(header, tlsServerHello) {
+		h.conn.setEncrypted()
+		if h.cfg.TLSKeys == nil {
+			return
+		}
+
+		tls := h.decrypt(buf, false)
+		defer tls.close()
+
+		buf = bufio.NewReaderSize(tls, h.cfg.bufferSize())
+		position = tls.position
+	}
+
+	clientIP := h.cfg.Anonymizer.Hash(h.cfg.ClientIP.Format(h.net.Src()))
+
+	var offset int64
+
+	for {
+		h.responses.release(position(offset))
+
+		var body interface{}
+		resp, readBytes, err := kafka.ReadResponse(buf, func(correlationID int32, r io.Reader) {
+			body = h.readResponseBody(correlationID, r)
+		})
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+
+		// responses can't be skipped without their lengths, errors of TLS connections which aren't decrypted
+		// and of connections joined in the middle of response are expected
+		if err != nil {
+			if h.conn.isEncrypted() && h.cfg.TLSKeys == nil || h.joined && offset == 0 {
+				return
+			}
+			log.Printf("unable to read response from Broker - skipping connection: %s\n", err)
+			return
+		}
+
+		seen := h.responses.seenAt(position(offset))
+		offset += int64(readBytes)
+
+		req, ok := h.conn.response(resp.CorrelationID, seen)
+		if !ok {
+			continue
+		}
+
+		responseTime := seen.Sub(req.seen)
+		if h.cfg.Verbose.On() {
+			log.Printf("got response, key: %d, version: %d, correlationID: %d, time: %s\n", req.key, req.version, resp.CorrelationID, responseTime)
+		}
+
+		metrics.ObserveWithTrace(metrics.ResponseTime.WithLabelValues(kafka.APIKeyName(req.key)), responseTime.Seconds(), req.traceID)
+
+		if req.event != nil {
+			req.event.ResponseTime = responseTime
+			h.writeEvents([]*events.Event{req.event})
+		}
+
+		if body != nil {
+			h.decodeResponse(req, body, clientIP)
+		}
+	}
+}
+
+// readResponseBody decodes body of response with correlation id read from reader, it returns nil if the body isn't
+// decoded: metadata responses teach brokers, errors of produce and fetch responses are counted and fetch responses
+// estimate lag of consumers
+func (h *KafkaStream) readResponseBody(correlationID int32, r io.Reader) interface{} {
+	key, version, ok := h.conn.pendingAPI(correlationID)
+	if !ok {
+		return nil
+	}
+
+	var (
+		body interface{}
+		err  error
+	)
+	switch key {
+	case kafka.MetadataKey, kafka.ProduceKey:
+		var data []byte
+		if data, err = ioutil.ReadAll(r); err != nil {
+			break
+		}
+		if key == kafka.MetadataKey {
+			body, err = kafka.DecodeMetadataResponse(data, version)
+		} else {
+			body, err = kafka.DecodeProduceResponse(data, version)
+		}
+	case kafka.FetchKey:
+		// records of fetch responses aren't buffered
+		body, err = kafka.ReadFetchResponse(r, version)
+	default:
+		return nil
+	}
+
+	// truncated responses are reported by reader of responses
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	if err != nil {
+		log.Printf("unable to decode %s response: %s\n", kafka.APIKeyName(key), err)
+		return nil
+	}
+	return body
+}
+
+// decodeResponse handles decoded body of response to the request of client
+func (h *KafkaStream) decodeResponse(req pendingRequest, body interface{}, clientIP string) {
+	switch resp := body.(type) {
+	case *kafka.MetadataResponse:
+		for _, broker := range resp.Brokers {
+			h.cfg.Brokers.learn(broker.Host)
+		}
+	case *kafka.ProduceResponse:
+		h.reportResponseErrors(clientIP, "produce", resp.Partitions)
+	case *kafka.FetchResponse:
+		if resp.ErrorCode != 0 {
+			h.reportResponseError(clientIP, "", "fetch", resp.ErrorCode)
+		}
+		h.reportResponseErrors(clientIP, "fetch", resp.Partitions)
+
+		for _, p := range resp.Partitions {
+			offset, ok := req.fetchOffsets[p.Topic][p.Partition]
+			if !ok || p.ErrorCode != 0 || !h.reportedTopic(p.Topic) {
+				continue
+			}
+			if lag := p.HighWatermark - offset; lag >= 0 {
+				h.metricsStorage.SetConsumerLag(clientIP, h.cfg.Anonymizer.Hash(p.Topic), p.Partition, lag)
+			}
+		}
+	}
+}
+
+// reportResponseErrors counts errors of partitions of response to request of the type
+func (h *KafkaStream) reportResponseErrors(clientIP, requestType string, partitions []kafka.PartitionResult) {
+	for _, p := range partitions {
+		if p.ErrorCode != 0 && h.reportedTopic(p.Topic) {
+			h.reportResponseError(clientIP, h.cfg.Anonymizer.Hash(p.Topic), requestType, p.ErrorCode)
+		}
+	}
+}
+
+// reportResponseError counts error of response to request of the type in metrics and top error sources, topic is
+// hashed in anonymization mode
+func (h *KafkaStream) reportResponseError(clientIP, topic, requestType string, code int16) {
+	name := kafka.ErrorName(code)
+	metrics.ResponseErrors.WithLabelValues(clientIP, topic, requestType, name).Inc()
+	h.cfg.TopN.AddError(clientIP, topic, name)
+}
+
+// reportedTopic returns true if metrics of topic are reported, i.e. it's allowed and it isn't a skipped internal topic
+func (h *KafkaStream) reportedTopic(topic string) bool {
+	if h.cfg.InternalTopics != InternalTopicsInclude && kafka.IsInternalTopic(topic) {
+		return false
+	}
+	return h.cfg.Topics.allows(topic)
+}
+
+// addresses returns client and broker addresses of the connection for logs, client address is hashed in
+// anonymization mode
+func (h *KafkaStream) addresses() (string, string) {
+	src := net.JoinHostPort(h.cfg.Anonymizer.Hash(h.net.Src().String()), h.transport.Src().String())
+	dst := net.JoinHostPort(h.net.Dst().String(), h.transport.Dst().String())
+	return src, dst
+}
+
+// decrypt returns reader of decrypted data of requests or responses direction of TLS connection
+func (h *KafkaStream) decrypt(buf *bufio.Reader, fromClient bool) *tlsReader {
+	src, dst := h.addresses()
+	if !fromClient {
+		src, dst = dst, src
+	}
+
+	return newTLSReader(buf, h.conn.tlsSession(h.cfg.TLSKeys), fromClient, func(err error) {
+		metrics.TLSDecryptionErrors.Inc()
+		log.Printf("%s -> %s: could not decrypt TLS connection: %s\n", src, dst, err)
+	})
+}
+
+// newEvent creates event of the decoded request captured at time seen
+func (h *KafkaStream) newEvent(req *kafka.Request, seen time.Time, size int, topics []string, connType ConnectionType) *events.Event {
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+	e := &events.Event{
+		Time:          seen,
+		SrcIP:         h.cfg.Anonymizer.Hash(h.net.Src().String()),
+		SrcPort:       h.transport.Src().String(),
+		DstIP:         h.net.Dst().String(),
+		DstPort:       h.transport.Dst().String(),
+		APIKey:        req.Key,
+		APIName:       kafka.APIKeyName(req.Key),
+		APIVersion:    req.Version,
+		CorrelationID: req.CorrelationID,
+		ClientID:      h.cfg.Anonymizer.Hash(req.ClientID),
+		Size:          size,
+		Topics:        topics,
+		Connection:    string(connType),
+		Owner:         h.cfg.Owners.Owner(h.net.Src().String()),
+	}
+	if h.cfg.GeoIP != nil {
+		geo := h.cfg.GeoIP.Lookup(h.net.Src().String())
+		e.Country, e.ASN, e.ASOrg = geo.Country, geo.ASN, geo.ASOrg
+	}
+
+	// session state of the connection is attached to every event
+	session := h.conn.session()
+	if e.ClientID == "" {
+		e.ClientID = h.cfg.Anonymizer.Hash(session.clientID)
+	}
+	e.Principal = h.cfg.Anonymizer.Hash(session.principal)
+	e.APIVersions = make(map[string]int16, len(session.apiVersions))
+	for key, version := range session.apiVersions {
+		e.APIVersions[kafka.APIKeyName(key)] = version
+	}
+
+	// response time of the request is observed with trace id of its span as exemplar
+	if h.cfg.Tracing {
+		e.TraceID = events.NewTraceID()
+	}
+
+	// request is rendered before it's released, its buffer is reused by the next request
+	if h.cfg.RenderRequests {
+		e.Request = req.String()
+	}
+
+	switch body := req.Body.(type) {
+	case *kafka.ProduceRequest:
+		e.RecordsCount = body.RecordsLen()
+		e.RecordsSize = body.RecordsSize()
+	case *kafka.JoinGroupRequest:
+		e.Group = body.GroupID
+		e.GroupInstanceID = body.InstanceID()
+	case *kafka.SyncGroupRequest:
+		e.Group = body.GroupID
+		if body.GroupInstanceID != nil {
+			e.GroupInstanceID = *body.GroupInstanceID
+		}
+	}
+
+	return e
+}
+
+// skipInternalTopic returns true if the topic mustn't be reported as a regular topic. In separate mode
+// internal topics are reported with their own metric.
+func (h *KafkaStream) skipInternalTopic(clientIP, principal, topic, role string) bool {
+	if h.cfg.InternalTopics == InternalTopicsInclude || !kafka.IsInternalTopic(topic) {
+		return false
+	}
+
+	if h.cfg.InternalTopics == InternalTopicsSeparate {
+		h.metricsStorage.AddInternalTopicRelationInfo(clientIP, h.cfg.Anonymizer.Hash(topic), role, h.cfg.Anonymizer.Hash(principal))
+	}
+
+	return true
+}
+
+// checkTopicName reports naming rules violated by topic and returns invalid topics of the event appended with it
+func (h *KafkaStream) checkTopicName(clientIP, topic, role string, invalid []string) []string {
+	rules := h.cfg.TopicNaming.Check(topic)
+	if len(rules) == 0 {
+		return invalid
+	}
+
+	name := h.cfg.Anonymizer.Hash(topic)
+	for _, rule := range rules {
+		h.metricsStorage.AddTopicNameViolationInfo(clientIP, name, role, rule)
+	}
+	return append(invalid, name)
+}
+
+// checkBatchSizes counts record batches of topic larger than max message size, brokers reject them, so they are
+// caught at their producers
+func (h *KafkaStream) checkBatchSizes(clientIP, src, topic string, sizes []int) {
+	if h.cfg.MaxMessageSize <= 0 {
+		return
+	}
+
+	for _, size := range sizes {
+		if size <= h.cfg.MaxMessageSize {
+			continue
+		}
+
+		metrics.OversizedBatches.WithLabelValues(clientIP, h.cfg.Anonymizer.Hash(topic)).Inc()
+		if h.cfg.Verbose.On() {
+			log.Printf("client %s produced batch of %d bytes to topic %s exceeding max message size %d", src, size, h.cfg.Anonymizer.Hash(topic), h.cfg.MaxMessageSize)
+		}
+	}
+}
+
+// anonymizeGroup hashes group id and instance id of group requests in place
+func anonymizeGroup(body kafka.ProtocolBody, a *metrics.Anonymizer) {
+	switch body := body.(type) {
+	case *kafka.JoinGroupRequest:
+		body.GroupID = a.Hash(body.GroupID)
+		if body.GroupInstanceID != nil {
+			id := a.Hash(*body.GroupInstanceID)
+			body.GroupInstanceID = &id
+		}
+	case *kafka.SyncGroupRequest:
+		body.GroupID = a.Hash(body.GroupID)
+		if body.GroupInstanceID != nil {
+			id := a.Hash(*body.GroupInstanceID)
+			body.GroupInstanceID = &id
+		}
 	}
 }
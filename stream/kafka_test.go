@@ -0,0 +1,469 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/events"
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var (
+	testClient = net.IPv4(10, 0, 0, 1)
+	testBroker = net.IPv4(10, 0, 0, 100)
+)
+
+// testSink keeps events in memory
+type testSink struct {
+	mux    sync.Mutex
+	events []*events.Event
+}
+
+func (s *testSink) Write(e *events.Event) error {
+	s.mux.Lock()
+	s.events = append(s.events, e)
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *testSink) Close() error {
+	return nil
+}
+
+// testContext implements reassembly.AssemblerContext
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// testConn passes segments of a connection of the client to the broker through assembler
+type testConn struct {
+	t         *testing.T
+	assembler *reassembly.Assembler
+	ts        time.Time
+	seq, ack  uint32
+}
+
+func newTestConn(t *testing.T, factory *KafkaStreamFactory) *testConn {
+	c := &testConn{
+		t:         t,
+		assembler: reassembly.NewAssembler(reassembly.NewStreamPool(factory)),
+		ts:        time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC),
+		seq:       1000,
+		ack:       5000,
+	}
+	c.segment(layers.TCP{SYN: true}, c.seq, nil)
+	c.seq++
+	c.reply(layers.TCP{SYN: true, ACK: true})
+	c.ack++
+	c.segment(layers.TCP{ACK: true}, c.seq, nil)
+	return c
+}
+
+// segment passes segment of the client with sequence number seq
+func (c *testConn) segment(tcp layers.TCP, seq uint32, payload []byte) {
+	tcp.SrcPort, tcp.DstPort = 50001, 9092
+	tcp.Seq, tcp.Ack = seq, c.ack
+	c.assemble(testClient, testBroker, tcp, payload)
+}
+
+// reply passes segment of the broker without payload
+func (c *testConn) reply(tcp layers.TCP) {
+	tcp.SrcPort, tcp.DstPort = 9092, 50001
+	tcp.Seq, tcp.Ack = c.ack, c.seq
+	c.assemble(testBroker, testClient, tcp, nil)
+}
+
+// assemble serializes segment into packet and passes its decoded layers to assembler, so flows of layers are set
+func (c *testConn) assemble(src, dst net.IP, tcp layers.TCP, payload []byte) {
+	tcp.Window = 65535
+	ip := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+	if err := tcp.SetNetworkLayerForChecksum(&ip); err != nil {
+		c.t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &ip, &tcp, gopacket.Payload(payload)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	pkt := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	c.ts = c.ts.Add(time.Millisecond)
+	ctx := testContext(gopacket.CaptureInfo{Timestamp: c.ts, CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())})
+	c.assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), pkt.TransportLayer().(*layers.TCP), &ctx)
+}
+
+// respond passes response of the broker with correlation id and body in a single segment
+func (c *testConn) respond(correlationID int32, body []byte) {
+	payload := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(payload, uint32(4+len(body)))
+	binary.BigEndian.PutUint32(payload[4:], uint32(correlationID))
+	payload = append(payload, body...)
+
+	tcp := layers.TCP{PSH: true, ACK: true, SrcPort: 9092, DstPort: 50001, Seq: c.ack, Ack: c.seq}
+	c.assemble(testBroker, testClient, tcp, payload)
+	c.ack += uint32(len(payload))
+}
+
+func (c *testConn) close() {
+	c.segment(layers.TCP{FIN: true, ACK: true}, c.seq, nil)
+	c.seq++
+	c.reply(layers.TCP{FIN: true, ACK: true})
+	c.ack++
+	c.segment(layers.TCP{ACK: true}, c.seq, nil)
+	c.assembler.FlushAll()
+}
+
+func newTestFactory(sink events.Sink, cfg Config) *KafkaStreamFactory {
+	registry := prometheus.NewRegistry()
+	cfg.BrokerPorts = map[uint16]bool{9092: true}
+	return NewKafkaStreamFactory(
+		metrics.NewStorage(registry, time.Hour),
+		metrics.NewRebalanceDetector(registry, 0),
+		sink,
+		cfg,
+	)
+}
+
+func testProduce(t *testing.T, correlationID int32, partition int32, records int) []byte {
+	b := &kafka.RecordBatch{Version: 2, Codec: kafka.CompressionNone, ProducerID: -1, ProducerEpoch: -1, FirstSequence: -1}
+	for i := 0; i < records; i++ {
+		b.AddRecord(&kafka.Record{OffsetDelta: int64(i), Value: []byte(`{"id":1,"status":"created"}`)})
+	}
+	b.LastOffsetDelta = int32(records - 1)
+
+	p := &kafka.ProduceRequest{Version: 3, RequiredAcks: 1, Timeout: 30000}
+	p.AddBatch("orders", partition, b)
+	data, err := kafka.EncodeRequest(kafka.NewRequest(correlationID, "sarama", p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestRetransmittedSegments splits a request into segments, retransmits one of them and reorders the last two
+// ones: the request is decoded once and bytes of the retransmitted segment are counted as retransmitted
+func TestRetransmittedSegments(t *testing.T) {
+	sink := &testSink{}
+	factory := newTestFactory(sink, Config{})
+	retransmittedBefore := testutil.ToFloat64(metrics.RetransmittedBytes)
+
+	data := testProduce(t, 1, 0, 80)
+	const mss = 536
+	var segments [][]byte
+	for len(data) > mss {
+		segments = append(segments, data[:mss])
+		data = data[mss:]
+	}
+	segments = append(segments, data)
+	if len(segments) < 4 {
+		t.Fatalf("request is split into %d segments, at least 4 are needed", len(segments))
+	}
+
+	offsets := make([]uint32, len(segments))
+	var offset uint32
+	for i, s := range segments {
+		offsets[i] = offset
+		offset += uint32(len(s))
+	}
+
+	c := newTestConn(t, factory)
+	order := []int{0, 1, 1}
+	for i := 2; i < len(segments)-2; i++ {
+		order = append(order, i)
+	}
+	order = append(order, len(segments)-1, len(segments)-2)
+	for _, i := range order {
+		c.segment(layers.TCP{PSH: true, ACK: true}, c.seq+offsets[i], segments[i])
+	}
+	c.seq += offset
+
+	// the next request follows in a single segment
+	next := testProduce(t, 2, 1, 1)
+	c.segment(layers.TCP{PSH: true, ACK: true}, c.seq, next)
+	c.seq += uint32(len(next))
+
+	c.close()
+	factory.Wait()
+
+	if len(sink.events) != 2 {
+		t.Fatalf("%d requests are decoded, want 2", len(sink.events))
+	}
+	sort.Slice(sink.events, func(i, j int) bool { return sink.events[i].CorrelationID < sink.events[j].CorrelationID })
+	for i, e := range sink.events {
+		if e.CorrelationID != int32(i+1) || len(e.Topics) != 1 || e.Topics[0] != "orders" {
+			t.Errorf("request %d is decoded as correlation id %d of topics %v", i+1, e.CorrelationID, e.Topics)
+		}
+	}
+	if e := sink.events[0]; e.RecordsCount != 80 {
+		t.Errorf("request of retransmitted segments has %d records, want 80", e.RecordsCount)
+	}
+
+	if got := testutil.ToFloat64(metrics.RetransmittedBytes) - retransmittedBefore; got != float64(len(segments[1])) {
+		t.Errorf("%v bytes are counted as retransmitted, want %d", got, len(segments[1]))
+	}
+}
+
+// TestOverlappingSegment retransmits a segment overlapping the previous one partially, only new bytes of it
+// are passed to the decoder
+func TestOverlappingSegment(t *testing.T) {
+	sink := &testSink{}
+	factory := newTestFactory(sink, Config{})
+	retransmittedBefore := testutil.ToFloat64(metrics.RetransmittedBytes)
+
+	data := testProduce(t, 1, 0, 10)
+	half := len(data) / 2
+	const overlap = 17
+
+	c := newTestConn(t, factory)
+	c.segment(layers.TCP{PSH: true, ACK: true}, c.seq, data[:half])
+	c.segment(layers.TCP{PSH: true, ACK: true}, c.seq+uint32(half-overlap), data[half-overlap:])
+	c.seq += uint32(len(data))
+	c.close()
+	factory.Wait()
+
+	if len(sink.events) != 1 || sink.events[0].RecordsCount != 10 {
+		t.Fatalf("request of overlapping segments isn't decoded: %d events", len(sink.events))
+	}
+	if got := testutil.ToFloat64(metrics.RetransmittedBytes) - retransmittedBefore; got != overlap {
+		t.Errorf("%v bytes are counted as retransmitted, want %d", got, overlap)
+	}
+}
+
+// TestEventTime checks events are timed by capture of the first segment of their requests, so events of pcap
+// files are timed as they were captured
+func TestEventTime(t *testing.T) {
+	sink := &testSink{}
+	factory := newTestFactory(sink, Config{})
+
+	c := newTestConn(t, factory)
+	var want []time.Time
+	for i := int32(1); i <= 2; i++ {
+		data := testProduce(t, i, 0, 20)
+		c.segment(layers.TCP{PSH: true, ACK: true}, c.seq, data[:100])
+		want = append(want, c.ts)
+		c.segment(layers.TCP{PSH: true, ACK: true}, c.seq+100, data[100:])
+		c.seq += uint32(len(data))
+	}
+	c.close()
+	factory.Wait()
+
+	if len(sink.events) != 2 {
+		t.Fatalf("%d requests are decoded, want 2", len(sink.events))
+	}
+	sort.Slice(sink.events, func(i, j int) bool { return sink.events[i].CorrelationID < sink.events[j].CorrelationID })
+	for i, e := range sink.events {
+		if !e.Time.Equal(want[i]) {
+			t.Errorf("request %d is timed %s, want %s", i+1, e.Time, want[i])
+		}
+	}
+}
+
+// waitPending waits until request with correlation id is decoded, responses of requests which aren't decoded
+// yet aren't paired
+func waitPending(t *testing.T, factory *KafkaStreamFactory, correlationID int32) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		factory.streamsMux.Lock()
+		for s := range factory.streams {
+			if _, _, ok := s.conn.pendingAPI(correlationID); ok {
+				factory.streamsMux.Unlock()
+				return
+			}
+		}
+		factory.streamsMux.Unlock()
+	}
+	t.Fatalf("request %d isn't decoded", correlationID)
+}
+
+// TestTracedEventResponseTime checks traced events are written when responses are decoded with response times,
+// events of requests without responses are written immediately or when the connection is over
+func TestTracedEventResponseTime(t *testing.T) {
+	sink := &testSink{}
+	factory := newTestFactory(sink, Config{Responses: true, Tracing: true})
+	c := newTestConn(t, factory)
+
+	request := func(correlationID int32, acks kafka.RequiredAcks) time.Time {
+		b := &kafka.RecordBatch{Version: 2, Codec: kafka.CompressionNone, ProducerID: -1, ProducerEpoch: -1, FirstSequence: -1}
+		b.AddRecord(&kafka.Record{Value: []byte("created")})
+		p := &kafka.ProduceRequest{Version: 3, RequiredAcks: acks, Timeout: 30000}
+		p.AddBatch("orders", 0, b)
+		data, err := kafka.EncodeRequest(kafka.NewRequest(correlationID, "sarama", p))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.segment(layers.TCP{PSH: true, ACK: true}, c.seq, data)
+		c.seq += uint32(len(data))
+		return c.ts
+	}
+
+	acked := request(1, 1)
+	request(2, 0)
+	request(3, 1)
+	waitPending(t, factory, 1)
+	c.respond(1, []byte{0, 0, 0, 0})
+	responded := c.ts
+	c.close()
+	factory.Wait()
+
+	if len(sink.events) != 3 {
+		t.Fatalf("%d events are written, want 3", len(sink.events))
+	}
+	sort.Slice(sink.events, func(i, j int) bool { return sink.events[i].CorrelationID < sink.events[j].CorrelationID })
+	if got, want := sink.events[0].ResponseTime, responded.Sub(acked); got != want {
+		t.Errorf("response time of responded request %s, want %s", got, want)
+	}
+	for _, e := range sink.events {
+		if e.TraceID == "" {
+			t.Errorf("event of request %d isn't traced", e.CorrelationID)
+		}
+		if e.CorrelationID != 1 && e.ResponseTime != 0 {
+			t.Errorf("response time of request %d without response is %s", e.CorrelationID, e.ResponseTime)
+		}
+	}
+}
+
+// TestAnonymizedLogs checks verbose logs and diagnostics contain hashed client addresses and topics only
+func TestAnonymizedLogs(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	anonymizer := metrics.NewAnonymizer("secret")
+	factory := newTestFactory(&testSink{}, Config{Anonymizer: anonymizer, Verbose: NewSwitch(true), Responses: true})
+
+	c := newTestConn(t, factory)
+	data := testProduce(t, 1, 0, 1)
+	c.segment(layers.TCP{PSH: true, ACK: true}, c.seq, data)
+	c.seq += uint32(len(data))
+	waitPending(t, factory, 1)
+
+	connections := factory.Connections()
+	if len(connections) != 1 || strings.Contains(connections[0].Client, testClient.String()+":") || connections[0].ClientID != anonymizer.Hash("sarama") {
+		t.Errorf("connection of diagnostics isn't anonymized: %+v", connections)
+	}
+
+	c.close()
+	factory.Wait()
+
+	for _, raw := range []string{testClient.String() + ":", "orders", "sarama"} {
+		if strings.Contains(logs.String(), raw) {
+			t.Errorf("logs contain %q:\n%s", raw, logs.String())
+		}
+	}
+	if !strings.Contains(logs.String(), anonymizer.Hash("orders")) {
+		t.Errorf("logs don't contain hashed topic:\n%s", logs.String())
+	}
+}
+
+// TestResponseErrorsAndLag checks errors of partitions of produce and fetch responses are counted and lag of
+// consumer is estimated by offsets of fetch request and high watermarks of its response
+func TestResponseErrorsAndLag(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	factory := NewKafkaStreamFactory(
+		metrics.NewStorage(registry, time.Hour),
+		metrics.NewRebalanceDetector(registry, 0),
+		&testSink{},
+		Config{BrokerPorts: map[uint16]bool{9092: true}, Responses: true, TopN: metrics.NewTopN(10)},
+	)
+
+	produceErrors := metrics.ResponseErrors.WithLabelValues(testClient.String(), "orders", "produce", "NOT_LEADER_OR_FOLLOWER")
+	fetchErrors := metrics.ResponseErrors.WithLabelValues(testClient.String(), "orders", "fetch", "OFFSET_OUT_OF_RANGE")
+	produceBefore, fetchBefore := testutil.ToFloat64(produceErrors), testutil.ToFloat64(fetchErrors)
+
+	body := func(values ...interface{}) []byte {
+		var buf bytes.Buffer
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				_ = binary.Write(&buf, binary.BigEndian, int16(len(s)))
+				buf.WriteString(s)
+				continue
+			}
+			_ = binary.Write(&buf, binary.BigEndian, v)
+		}
+		return buf.Bytes()
+	}
+
+	c := newTestConn(t, factory)
+	send := func(data []byte) {
+		c.segment(layers.TCP{PSH: true, ACK: true}, c.seq, data)
+		c.seq += uint32(len(data))
+	}
+
+	send(testProduce(t, 1, 0, 1))
+	waitPending(t, factory, 1)
+	// produce response v3: partition 0 of orders isn't led by the broker
+	c.respond(1, body(int32(1), "orders", int32(1), int32(0), int16(6), int64(-1), int64(-1), int32(0)))
+
+	fetch := &kafka.FetchRequest{Version: 4, ReplicaID: -1, MaxWaitTime: 500, MinBytes: 1, MaxBytes: 1 << 20}
+	fetch.AddBlock("orders", 0, 400, 1<<20)
+	fetch.AddBlock("orders", 1, 10, 1<<20)
+	data, err := kafka.EncodeRequest(kafka.NewRequest(2, "sarama", fetch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(data)
+	waitPending(t, factory, 2)
+	// fetch response v4: partition 0 of orders is behind by 100 records, offset of partition 1 is out of range
+	c.respond(2, body(int32(0), int32(1), "orders", int32(2),
+		int32(0), int16(0), int64(500), int64(500), int32(-1), int32(3), []byte{1, 2, 3},
+		int32(1), int16(1), int64(-1), int64(-1), int32(-1), int32(-1)))
+
+	c.close()
+	factory.Wait()
+
+	if got := testutil.ToFloat64(produceErrors) - produceBefore; got != 1 {
+		t.Errorf("%v produce errors are counted, want 1", got)
+	}
+	if got := testutil.ToFloat64(fetchErrors) - fetchBefore; got != 1 {
+		t.Errorf("%v fetch errors are counted, want 1", got)
+	}
+
+	sources := factory.cfg.TopN.Last().ErrorSources
+	want := []metrics.TopNEntry{
+		{Name: testClient.String() + " orders NOT_LEADER_OR_FOLLOWER", Value: 1},
+		{Name: testClient.String() + " orders OFFSET_OUT_OF_RANGE", Value: 1},
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("top error sources are %+v, want %+v", sources, want)
+	}
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lags := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != "kafka_sniffer_consumer_lag_estimate" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			lags[labels["client_ip"]+" "+labels["topic"]+" "+labels["partition"]] = m.GetGauge().GetValue()
+		}
+	}
+	if want := map[string]float64{testClient.String() + " orders 0": 100}; !reflect.DeepEqual(lags, want) {
+		t.Errorf("lag estimates are %v, want %v", lags, want)
+	}
+}
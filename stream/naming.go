@@ -0,0 +1,135 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+)
+
+// maxTopicLength is a max length of topic name accepted by kafka
+const maxTopicLength = 249
+
+// rules of topic names reported by TopicNaming
+const (
+	topicRuleInvalidChars    = "invalid_chars"
+	topicRuleTooLong         = "too_long"
+	topicRuleReserved        = "reserved"
+	topicRuleMixedSeparators = "mixed_separators"
+	topicRulePrefix          = "prefix"
+	topicRulePattern         = "pattern"
+)
+
+// TopicNaming validates observed topic names against naming rules of kafka and conventions of the cluster, so
+// typo'd topics are caught before they are auto-created and pollute the cluster. Kafka rules are legal
+// characters (ASCII letters, digits, '.', '_' and '-'), max length, reserved names "." and ".." and mixing of
+// '.' and '_' which collide in metric names. Conventions are allowed prefixes, e.g. per team, max length and
+// a pattern, internal topics aren't checked by them.
+type TopicNaming struct {
+	prefixes  []string
+	maxLength int
+	pattern   *regexp.Regexp
+
+	// Anonymizer hashes logged topics in anonymization mode, may be nil
+	Anonymizer *metrics.Anonymizer
+
+	// checked caches violated rules by topic, topics are checked and violations are logged once
+	checked sync.Map
+}
+
+// NewTopicNaming creates validator of topic names, empty prefixes and pattern aren't checked, max length is
+// limited by kafka's one and it's used if maxLength is zero
+func NewTopicNaming(prefixes []string, maxLength int, pattern string) (*TopicNaming, error) {
+	if maxLength < 0 || maxLength > maxTopicLength {
+		return nil, fmt.Errorf("max length of topic names must be between 1 and %d", maxTopicLength)
+	}
+	if maxLength == 0 {
+		maxLength = maxTopicLength
+	}
+
+	n := &TopicNaming{maxLength: maxLength}
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			n.prefixes = append(n.prefixes, prefix)
+		}
+	}
+
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of topic names %q: %s", pattern, err)
+		}
+		n.pattern = re
+	}
+
+	return n, nil
+}
+
+// Check returns rules violated by topic name: invalid_chars, too_long, reserved, mixed_separators, prefix or
+// pattern. Nothing is violated if n is nil.
+func (n *TopicNaming) Check(topic string) []string {
+	if n == nil {
+		return nil
+	}
+
+	if rules, ok := n.checked.Load(topic); ok {
+		return rules.([]string)
+	}
+
+	rules := n.check(topic)
+	if _, loaded := n.checked.LoadOrStore(topic, rules); !loaded && len(rules) > 0 {
+		log.Printf("topic %q violates naming rules: %s", n.Anonymizer.Hash(topic), strings.Join(rules, ", "))
+	}
+	return rules
+}
+
+func (n *TopicNaming) check(topic string) []string {
+	var rules []string
+
+	for _, c := range topic {
+		if !isLegalTopicChar(c) {
+			rules = append(rules, topicRuleInvalidChars)
+			break
+		}
+	}
+	if len(topic) > n.maxLength {
+		rules = append(rules, topicRuleTooLong)
+	}
+	if topic == "." || topic == ".." {
+		rules = append(rules, topicRuleReserved)
+	}
+
+	if kafka.IsInternalTopic(topic) {
+		return rules
+	}
+
+	if strings.Contains(topic, ".") && strings.Contains(topic, "_") {
+		rules = append(rules, topicRuleMixedSeparators)
+	}
+	if len(n.prefixes) > 0 && !hasAnyPrefix(topic, n.prefixes) {
+		rules = append(rules, topicRulePrefix)
+	}
+	if n.pattern != nil && !n.pattern.MatchString(topic) {
+		rules = append(rules, topicRulePattern)
+	}
+
+	return rules
+}
+
+// isLegalTopicChar returns true for characters allowed in topic names by kafka
+func isLegalTopicChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-'
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
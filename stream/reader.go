@@ -0,0 +1,289 @@
+package stream
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// maxTruncations limits count of truncated segments waiting for reassembly
+const maxTruncations = 1000
+
+// chunk is reassembled data with capture time of its first byte
+type chunk struct {
+	data []byte
+	seen time.Time
+
+	// damaged are spans of data missing in capture, offsets are relative to the chunk
+	damaged []span
+}
+
+// span is a range of bytes [start, end)
+type span struct {
+	start, end int64
+}
+
+// truncation is a range of sequence numbers of bytes cut by snaplen, they are filled with zeros
+type truncation struct {
+	start, end reassembly.Sequence
+}
+
+// chunkTime is a capture time of read chunk ending at offset of the stream
+type chunkTime struct {
+	end  int64
+	seen time.Time
+}
+
+// streamReader passes reassembled data of one direction from assembler goroutine to decoder goroutine.
+// Written data is queued, so slow decoder doesn't block assembler, and accounted in memory budget until
+// decoder releases it.
+type streamReader struct {
+	mux     sync.Mutex
+	cond    *sync.Cond
+	queue   []chunk
+	queued  int64
+	closed  bool
+	evicted bool
+
+	current []byte
+
+	// next is a sequence number of the next reassembled byte, it's known after the first segment
+	started     bool
+	next        reassembly.Sequence
+	truncations []truncation
+
+	// capture times and damaged spans of read chunks, they are used to find capture time and damage
+	// of decoded messages
+	read    int64
+	times   []chunkTime
+	damaged []span
+
+	// released is an offset of the stream, bytes before it are decoded and released from budget
+	released int64
+
+	budget *memoryBudget
+
+	// buffered is a count of written and not released bytes, lastActive is a capture time of the last
+	// written data in unix nanoseconds, both are accessed atomically
+	buffered   int64
+	lastActive int64
+}
+
+func newStreamReader(budget *memoryBudget) *streamReader {
+	r := &streamReader{budget: budget}
+	r.cond = sync.NewCond(&r.mux)
+	budget.register(r)
+	return r
+}
+
+// Read implements io.Reader, it returns io.EOF when the direction is closed
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		c, ok := r.pop()
+		if !ok {
+			return 0, io.EOF
+		}
+		for _, d := range c.damaged {
+			r.damaged = append(r.damaged, span{start: r.read + d.start, end: r.read + d.end})
+		}
+		r.current = c.data
+		r.read += int64(len(c.data))
+		r.times = append(r.times, chunkTime{end: r.read, seen: c.seen})
+	}
+
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+
+	return n, nil
+}
+
+// pop waits for the next written chunk, it returns false when the direction is closed and its data is read
+func (r *streamReader) pop() (chunk, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for len(r.queue) == 0 && !r.closed {
+		r.cond.Wait()
+	}
+	if len(r.queue) == 0 {
+		return chunk{}, false
+	}
+
+	c := r.queue[0]
+	r.queue[0] = chunk{}
+	r.queue = r.queue[1:]
+	r.queued -= int64(len(c.data))
+
+	return c, true
+}
+
+// release releases bytes of the stream before offset from memory budget, it's called by decoder when
+// messages before the offset are decoded. Offsets must grow between calls.
+func (r *streamReader) release(offset int64) {
+	if offset > r.released {
+		r.budget.add(r, r.released-offset)
+		r.released = offset
+	}
+}
+
+// done drops data of the reader and removes it from memory budget, it's called by decoder when it stops
+func (r *streamReader) done() {
+	r.evict()
+	r.release(r.read)
+	r.budget.unregister(r)
+}
+
+// seenAt returns capture time of byte at offset of the stream. Offsets must grow between calls, since
+// times of bytes before the offset are forgotten.
+func (r *streamReader) seenAt(offset int64) time.Time {
+	for len(r.times) > 1 && r.times[0].end <= offset {
+		r.times = r.times[1:]
+	}
+	if len(r.times) == 0 {
+		return time.Time{}
+	}
+	return r.times[0].seen
+}
+
+// isDamaged returns true if bytes of the stream between offsets are missing in capture. Offsets must grow
+// between calls, since damage of bytes before the start is forgotten.
+func (r *streamReader) isDamaged(start, end int64) bool {
+	for len(r.damaged) > 0 && r.damaged[0].end <= start {
+		r.damaged = r.damaged[1:]
+	}
+	for _, d := range r.damaged {
+		if d.start >= end {
+			break
+		}
+		if d.end > start {
+			return true
+		}
+	}
+	return false
+}
+
+// accept starts sequence numbers of the direction with the first accepted segment and fills payload
+// cut by snaplen with zeros, so lengths of messages still point to the next messages. It must be called
+// from assembler goroutine only.
+func (r *streamReader) accept(tcp *layers.TCP, ci gopacket.CaptureInfo) {
+	if !r.started {
+		r.started = true
+		r.next = reassembly.Sequence(tcp.Seq)
+		if tcp.SYN {
+			r.next = r.next.Add(1)
+		}
+	}
+
+	missing := ci.Length - ci.CaptureLength
+	if missing <= 0 || len(r.truncations) >= maxTruncations {
+		return
+	}
+
+	start := reassembly.Sequence(tcp.Seq).Add(len(tcp.Payload))
+	if tcp.SYN {
+		start = start.Add(1)
+	}
+	t := truncation{start: start, end: start.Add(missing)}
+
+	// truncation of retransmitted segment may be already known or its bytes may be already reassembled
+	if r.next.Difference(t.end) > 0 && !r.truncated(t) {
+		r.truncations = append(r.truncations, t)
+	}
+
+	payload := make([]byte, len(tcp.Payload)+missing)
+	copy(payload, tcp.Payload)
+	tcp.Payload = payload
+}
+
+// truncated returns true if truncation is already waiting for reassembly
+func (r *streamReader) truncated(t truncation) bool {
+	for _, known := range r.truncations {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+// write queues copy of data reassembled after skip of lost bytes for the reader. It must be called from
+// assembler goroutine only, since data is reused by assembler after the call.
+func (r *streamReader) write(data []byte, seen time.Time, skip int) {
+	var damaged []span
+
+	// message containing lost bytes is damaged
+	if skip > 0 {
+		r.next = r.next.Add(skip)
+		damaged = append(damaged, span{start: 0, end: 1})
+	}
+
+	end := r.next.Add(len(data))
+	truncations := r.truncations[:0]
+	for _, t := range r.truncations {
+		start, stop := r.next.Difference(t.start), r.next.Difference(t.end)
+		if start < 0 {
+			start = 0
+		}
+		if stop > len(data) {
+			stop = len(data)
+		}
+		if start < stop {
+			damaged = append(damaged, span{start: int64(start), end: int64(stop)})
+		}
+
+		// truncations after the data are kept until they are reassembled
+		if end.Difference(t.end) > 0 {
+			truncations = append(truncations, t)
+		}
+	}
+	r.truncations = truncations
+	r.next = end
+
+	if len(data) == 0 {
+		return
+	}
+
+	r.mux.Lock()
+	if r.closed {
+		r.mux.Unlock()
+		return
+	}
+	r.queue = append(r.queue, chunk{data: append([]byte(nil), data...), seen: seen, damaged: damaged})
+	r.queued += int64(len(data))
+	r.cond.Signal()
+	r.mux.Unlock()
+
+	atomic.StoreInt64(&r.lastActive, seen.UnixNano())
+	r.budget.add(r, int64(len(data)))
+}
+
+// close makes the reader return io.EOF after the written data, it may be called several times
+func (r *streamReader) close() {
+	r.mux.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mux.Unlock()
+}
+
+// evict drops queued data and makes the reader return io.EOF, data written later is dropped too.
+// It returns false if the reader is already evicted.
+func (r *streamReader) evict() bool {
+	r.mux.Lock()
+	if r.evicted {
+		r.mux.Unlock()
+		return false
+	}
+	r.evicted, r.closed = true, true
+	dropped := r.queued
+	r.queue, r.queued = nil, 0
+	r.cond.Broadcast()
+	r.mux.Unlock()
+
+	r.budget.add(r, -dropped)
+
+	return true
+}
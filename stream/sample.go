@@ -0,0 +1,68 @@
+package stream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+)
+
+// ParseSampleRate parses sample rate "1/N", it's N: every Nth request of connection is decoded
+func ParseSampleRate(s string) (int, error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid sample rate %q, expected 1/N", s)
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid sample rate %q, expected 1/N with positive N", s)
+	}
+
+	return n, nil
+}
+
+// SampleRate is N of sample rate 1/N shared by all streams, it may be changed at any time, e.g. by watchdog
+type SampleRate struct {
+	n int32
+}
+
+// NewSampleRate creates sample rate 1/n
+func NewSampleRate(n int) *SampleRate {
+	r := &SampleRate{}
+	r.Set(n)
+	return r
+}
+
+// Set changes sample rate to 1/n
+func (r *SampleRate) Set(n int) {
+	atomic.StoreInt32(&r.n, int32(n))
+}
+
+// Get returns N of sample rate 1/N, it's 1 for nil rate
+func (r *SampleRate) Get() int {
+	if r == nil {
+		return 1
+	}
+	return int(atomic.LoadInt32(&r.n))
+}
+
+// sampler chooses requests of connection decoded with sample rate, other requests are counted by header only
+type sampler struct {
+	rate  *SampleRate
+	count int
+}
+
+// decode returns true if the next request with api key must be decoded. The first request of connection is
+// decoded, SASL requests are always decoded since principal of connection is taken from them.
+func (s *sampler) decode(key int16) bool {
+	rate := s.rate.Get()
+	if rate <= 1 || kafka.IsSASLRequest(key) {
+		return true
+	}
+
+	s.count++
+	return s.count%rate == 1
+}
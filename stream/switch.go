@@ -0,0 +1,31 @@
+package stream
+
+import (
+	"sync/atomic"
+)
+
+// Switch is a setting shared by all streams which is enabled or disabled at runtime, e.g. by control API
+type Switch struct {
+	on int32
+}
+
+// NewSwitch creates switch in the state
+func NewSwitch(on bool) *Switch {
+	s := &Switch{}
+	s.Set(on)
+	return s
+}
+
+// Set enables or disables the switch
+func (s *Switch) Set(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&s.on, v)
+}
+
+// On returns true if the switch is enabled, nil switch is disabled
+func (s *Switch) On() bool {
+	return s != nil && atomic.LoadInt32(&s.on) == 1
+}
@@ -0,0 +1,288 @@
+//go:build ignore
+// +build ignore
+
+// Gen writes pcap files of TLS connections of a client producing to the broker with key logs of their sessions,
+// connections are made by crypto/tls in memory. Run from stream directory of repository:
+//
+//	go run testdata/tls/gen.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var (
+	dir = filepath.Join("testdata", "tls")
+
+	// start is a time of the first packet of every case
+	start = time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+	client = net.IPv4(10, 0, 0, 1)
+	broker = net.IPv4(10, 0, 0, 100)
+)
+
+// requests are sizes of records of produce requests sent by every connection, the last one is split into
+// several TLS records
+var requests = []int{1, 3, 1000}
+
+func main() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "broker"},
+		NotBefore:    start,
+		NotAfter:     start.AddDate(10, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	gen("tls12-ecdhe-gcm", cert, &tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	gen("tls12-ecdhe-cbc", cert, &tls.Config{
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA},
+	})
+	gen("tls13-aes128-gcm", cert, &tls.Config{
+		MinVersion: tls.VersionTLS13,
+	})
+}
+
+// gen writes pcap file and key log of a connection, client produces requests and broker answers them
+func gen(name string, cert tls.Certificate, cfg *tls.Config) {
+	f, err := os.Create(filepath.Join(dir, name+".pcap"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		log.Fatalln(err)
+	}
+
+	c := dial(w)
+	clientConn, brokerConn := net.Pipe()
+
+	clientCfg := cfg.Clone()
+	clientCfg.InsecureSkipVerify = true
+	var keys bytes.Buffer
+	clientCfg.KeyLogWriter = &keys
+	brokerCfg := cfg.Clone()
+	brokerCfg.Certificates = []tls.Certificate{cert}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(tls.Server(&capturedConn{Conn: brokerConn, c: c, fromClient: false}, brokerCfg))
+	}()
+
+	tc := tls.Client(&capturedConn{Conn: clientConn, c: c, fromClient: true}, clientCfg)
+	for i, records := range requests {
+		if _, err := tc.Write(produce(int32(i+1), records)); err != nil {
+			log.Fatalln(err)
+		}
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(tc, header); err != nil {
+			log.Fatalln(err)
+		}
+		if _, err := io.ReadFull(tc, make([]byte, binary.BigEndian.Uint32(header))); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	tc.Close()
+	wg.Wait()
+
+	c.close()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".keylog"), keys.Bytes(), 0644); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// serve answers produce requests of the connection until it's closed
+func serve(conn *tls.Conn) {
+	defer conn.Close()
+
+	for {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			log.Fatalln(err)
+		}
+
+		// request header is api key, api version and correlation id
+		if _, err := conn.Write(produceResponse(binary.BigEndian.Uint32(req[4:]))); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// capturedConn writes data written to the connection as segments of one side
+type capturedConn struct {
+	net.Conn
+	c          *conn
+	fromClient bool
+}
+
+func (cc *capturedConn) Write(p []byte) (int, error) {
+	cc.c.send(cc.fromClient, p)
+	return cc.Conn.Write(p)
+}
+
+// conn writes packets of a single TCP connection of the client to the broker
+type conn struct {
+	w   *pcapgo.Writer
+	mux sync.Mutex
+	ts  time.Time
+	seq uint32
+	ack uint32
+}
+
+// dial writes handshake of the connection
+func dial(w *pcapgo.Writer) *conn {
+	c := &conn{w: w, ts: start, seq: 1000, ack: 5000}
+	c.packet(true, layers.TCP{SYN: true}, nil)
+	c.seq++
+	c.packet(false, layers.TCP{SYN: true, ACK: true}, nil)
+	c.ack++
+	c.packet(true, layers.TCP{ACK: true}, nil)
+	return c
+}
+
+// send writes data of either side in a single segment
+func (c *conn) send(fromClient bool, data []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.packet(fromClient, layers.TCP{PSH: true, ACK: true}, data)
+	if fromClient {
+		c.seq += uint32(len(data))
+	} else {
+		c.ack += uint32(len(data))
+	}
+}
+
+// close writes FIN of both sides
+func (c *conn) close() {
+	c.packet(true, layers.TCP{FIN: true, ACK: true}, nil)
+	c.seq++
+	c.packet(false, layers.TCP{FIN: true, ACK: true}, nil)
+	c.ack++
+	c.packet(true, layers.TCP{ACK: true}, nil)
+}
+
+// packet writes segment of either side with current sequence numbers
+func (c *conn) packet(fromClient bool, tcp layers.TCP, payload []byte) {
+	src, dst := client, broker
+	tcp.SrcPort, tcp.DstPort = 50001, 9092
+	tcp.Seq, tcp.Ack = c.seq, c.ack
+	if !fromClient {
+		src, dst = broker, client
+		tcp.SrcPort, tcp.DstPort = 9092, 50001
+		tcp.Seq, tcp.Ack = c.ack, c.seq
+	}
+
+	tcp.Window = 65535
+	eth := layers.Ethernet{SrcMAC: mac(src), DstMAC: mac(dst), EthernetType: layers.EthernetTypeIPv4}
+	ip := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+	if err := tcp.SetNetworkLayerForChecksum(&ip); err != nil {
+		log.Fatalln(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &tcp, gopacket.Payload(payload)); err != nil {
+		log.Fatalln(err)
+	}
+
+	c.ts = c.ts.Add(time.Millisecond)
+	data := buf.Bytes()
+	ci := gopacket.CaptureInfo{Timestamp: c.ts, CaptureLength: len(data), Length: len(data)}
+	if err := c.w.WritePacket(ci, data); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func mac(ip net.IP) net.HardwareAddr {
+	return net.HardwareAddr{2, 0, 0, 0, 0, ip.To4()[3]}
+}
+
+// produce encodes produce request of the records to topic orders
+func produce(correlationID int32, records int) []byte {
+	b := &kafka.RecordBatch{Version: 2, Codec: kafka.CompressionNone, ProducerID: -1, ProducerEpoch: -1, FirstSequence: -1}
+	for i := 0; i < records; i++ {
+		b.AddRecord(&kafka.Record{OffsetDelta: int64(i), Value: []byte(`{"id":1,"status":"created"}`)})
+	}
+	b.LastOffsetDelta = int32(records - 1)
+
+	p := &kafka.ProduceRequest{Version: 3, RequiredAcks: 1, Timeout: 30000}
+	p.AddBatch("orders", 0, b)
+	data, err := kafka.EncodeRequest(kafka.NewRequest(correlationID, "sarama", p))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return data
+}
+
+// produceResponse encodes successful produce response v3 to partition 0 of topic orders
+func produceResponse(correlationID uint32) []byte {
+	var buf bytes.Buffer
+	write := func(v interface{}) {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// size is filled at the end
+	write(int32(0))
+	write(correlationID)
+
+	// topics, partitions, error code, base offset, log append time and throttle time
+	write(int32(1))
+	write(int16(len("orders")))
+	buf.WriteString("orders")
+	write(int32(1))
+	write(int32(0))
+	write(int16(0))
+	write(int64(0))
+	write(int64(-1))
+	write(int32(0))
+
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	return data
+}
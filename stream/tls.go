@@ -0,0 +1,38 @@
+package stream
+
+const (
+	// tlsRecordHeaderSize is a size of TLS record header and handshake message type
+	tlsRecordHeaderSize = 6
+
+	tlsHandshakeRecord = 0x16
+	tlsAlertRecord     = 0x15
+
+	tlsClientHello = 0x01
+	tlsServerHello = 0x02
+)
+
+// isTLSHandshake checks if payload starts with TLS (or SSL 3.0) handshake record of the message type,
+// e.g. ClientHello sent by client to SSL listener of broker
+func isTLSHandshake(payload []byte, messageType byte) bool {
+	if len(payload) < tlsRecordHeaderSize {
+		return false
+	}
+
+	// record type, major and minor version of protocol, then length of record and handshake message type
+	return payload[0] == tlsHandshakeRecord && payload[1] == 3 && payload[2] <= 4 && payload[5] == messageType
+}
+
+// isTLSRecord checks if payload starts with header of any TLS record, e.g. application data of connection
+// joined in the middle. Lengths of kafka requests never start with these bytes.
+func isTLSRecord(payload []byte) bool {
+	if len(payload) < tlsRecordHeaderSize {
+		return false
+	}
+
+	switch payload[0] {
+	case tlsChangeCipherSpecRecord, tlsAlertRecord, tlsHandshakeRecord, tlsApplicationDataRecord:
+	default:
+		return false
+	}
+	return payload[1] == 3 && payload[2] <= 4 && int(payload[3])<<8|int(payload[4]) <= tlsMaxRecordSize
+}
@@ -0,0 +1,382 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d-ulyanov/kafka-sniffer/metrics"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/reassembly"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestTLS12PRF checks PRF of TLS 1.2 with SHA-256 against the known answer published for TLS implementations
+func TestTLS12PRF(t *testing.T) {
+	secret := unhex(t, "9bbe436ba940f017b17652849a71db35")
+	seed := unhex(t, "a0ba9f936cda311827a6f796ffd5198c")
+	want := unhex(t, "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a"+
+		"6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab"+
+		"4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff701"+
+		"87347b66")
+
+	if got := tls12PRF(sha256.New, secret, "test label", seed, len(want)); !bytes.Equal(got, want) {
+		t.Errorf("PRF is %x, want %x", got, want)
+	}
+}
+
+// TestHKDFExpandLabel checks derivation of traffic keys and IVs of TLS 1.3 against the handshake of RFC 8448,
+// section 3
+func TestHKDFExpandLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		key, iv string
+	}{
+		{
+			name:   "server handshake traffic",
+			secret: "b67b7d690cc16c4e75e54213cb2d37b4e9c912bcded9105d42befd59d391ad38",
+			key:    "3fce516009c21727d0f2e4e86ee403bc",
+			iv:     "5d313eb2671276ee13000b30",
+		},
+		{
+			name:   "server application traffic",
+			secret: "a11af9f05531f856ad47116b45a950328204b4f44bfb6b3a4b4f1f3fcb631643",
+			key:    "9f02283b6c9c07efc26bb9f2ac92e356",
+			iv:     "cf782b88dd83549aadf1e984",
+		},
+	}
+
+	for _, test := range tests {
+		secret := unhex(t, test.secret)
+		if key := hkdfExpandLabel(sha256.New, secret, "key", 16); hex.EncodeToString(key) != test.key {
+			t.Errorf("%s: key is %x, want %s", test.name, key, test.key)
+		}
+		if iv := hkdfExpandLabel(sha256.New, secret, "iv", 12); hex.EncodeToString(iv) != test.iv {
+			t.Errorf("%s: iv is %x, want %s", test.name, iv, test.iv)
+		}
+	}
+}
+
+// sealTLS13 encrypts TLS 1.3 record of content type with key and iv derived from secret and sequence number
+func sealTLS13(t *testing.T, secret []byte, seq uint64, typ byte, data []byte) []byte {
+	block, err := aes.NewCipher(hkdfExpandLabel(sha256.New, secret, "key", 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := hkdfExpandLabel(sha256.New, secret, "iv", aead.NonceSize())
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(seq >> (8 * i))
+	}
+
+	plain := append(append([]byte(nil), data...), typ)
+	header := []byte{tlsApplicationDataRecord, 0x03, 0x03, 0, 0}
+	binary.BigEndian.PutUint16(header[3:], uint16(len(plain)+aead.Overhead()))
+	return aead.Seal(header, nonce, plain, header)
+}
+
+// TestTLS13SequenceNumbers checks that nonces of records are derived from their sequence numbers: records are
+// decrypted in order only
+func TestTLS13SequenceNumbers(t *testing.T) {
+	secret := unhex(t, "a11af9f05531f856ad47116b45a950328204b4f44bfb6b3a4b4f1f3fcb631643")
+	first := sealTLS13(t, secret, 0, tlsApplicationDataRecord, []byte("first"))
+	second := sealTLS13(t, secret, 1, tlsApplicationDataRecord, []byte("second"))
+
+	d, err := newTLS13Decryptor(tlsCipherSuites[0x1301], secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.decrypt(second[:tlsRecordSize], append([]byte(nil), second[tlsRecordSize:]...)); err != errTLSDecrypt {
+		t.Errorf("record 1 is decrypted as record 0, error %v", err)
+	}
+
+	d, _ = newTLS13Decryptor(tlsCipherSuites[0x1301], secret)
+	for i, record := range [][]byte{first, second} {
+		typ, data, err := d.decrypt(record[:tlsRecordSize], append([]byte(nil), record[tlsRecordSize:]...))
+		if err != nil {
+			t.Fatalf("record %d: %s", i, err)
+		}
+		if typ != tlsApplicationDataRecord {
+			t.Errorf("record %d: content type is %d", i, typ)
+		}
+		if want := []string{"first", "second"}[i]; string(data) != want {
+			t.Errorf("record %d: data is %q, want %q", i, data, want)
+		}
+	}
+}
+
+// TestTLS13KeyUpdate decrypts client direction of TLS 1.3 connection: Finished switches handshake secret to
+// application secret, KeyUpdate switches to the next secret and resets sequence numbers
+func TestTLS13KeyUpdate(t *testing.T) {
+	clientRandom := bytes.Repeat([]byte{0x5a}, tlsRandomSize)
+	handshakeSecret := bytes.Repeat([]byte{1}, sha256.Size)
+	trafficSecret := bytes.Repeat([]byte{2}, sha256.Size)
+	updatedSecret := hkdfExpandLabel(sha256.New, trafficSecret, "traffic upd", sha256.Size)
+
+	keys := testTLSKeys(t, "CLIENT_HANDSHAKE_TRAFFIC_SECRET "+hex.EncodeToString(clientRandom)+" "+hex.EncodeToString(handshakeSecret)+"\n"+
+		"CLIENT_TRAFFIC_SECRET_0 "+hex.EncodeToString(clientRandom)+" "+hex.EncodeToString(trafficSecret)+"\n")
+
+	session := newTLSSession(keys)
+	session.clientRandom = clientRandom
+	session.hello = &tlsServerParams{version: tlsVersion13, suite: tlsCipherSuites[0x1301]}
+
+	finished := append([]byte{tlsFinished, 0, 0, sha256.Size}, make([]byte, sha256.Size)...)
+	keyUpdate := []byte{tlsKeyUpdate, 0, 0, 1, 0}
+
+	var stream []byte
+	stream = append(stream, sealTLS13(t, handshakeSecret, 0, tlsHandshakeRecord, finished)...)
+	stream = append(stream, sealTLS13(t, trafficSecret, 0, tlsApplicationDataRecord, []byte("hello "))...)
+	stream = append(stream, sealTLS13(t, trafficSecret, 1, tlsApplicationDataRecord, []byte("world "))...)
+	stream = append(stream, sealTLS13(t, trafficSecret, 2, tlsHandshakeRecord, keyUpdate)...)
+	stream = append(stream, sealTLS13(t, updatedSecret, 0, tlsApplicationDataRecord, []byte("again"))...)
+
+	r := newTLSReader(bufio.NewReader(bytes.NewReader(stream)), session, true, func(err error) {
+		t.Errorf("could not decrypt: %s", err)
+	})
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != "hello world again" {
+		t.Errorf("decrypted data is %q", data)
+	}
+}
+
+// TestTLS12RSAKeyExchange computes master secret of TLS 1.2 session from pre-master secret decrypted by RSA key
+// of the broker, extended master secret is computed over handshake messages up to ClientKeyExchange
+func TestTLS12RSAKeyExchange(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "broker.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadTLSKeys("", []string{f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientRandom := bytes.Repeat([]byte{0xc1}, tlsRandomSize)
+	serverRandom := bytes.Repeat([]byte{0x5e}, tlsRandomSize)
+	preMaster := append([]byte{0x03, 0x03}, bytes.Repeat([]byte{0x42}, tlsPreMasterSecretSize-2)...)
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, preMaster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := func(typ byte, body []byte) []byte {
+		return append([]byte{typ, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	}
+	clientHello := handshake(tlsClientHello, append(append([]byte{0x03, 0x03}, clientRandom...), 0, 0, 2, 0x00, 0x9c, 1, 0))
+	certificate := handshake(0x0b, []byte{0, 0, 0})
+	serverHelloDone := handshake(tlsServerHelloDone, nil)
+	clientKeyExchange := handshake(tlsClientKeyExchange, append([]byte{byte(len(encrypted) >> 8), byte(len(encrypted))}, encrypted...))
+
+	for _, ems := range []bool{false, true} {
+		extensions := []byte{0, 0}
+		if ems {
+			extensions = []byte{0, 4, 0x00, 0x17, 0, 0}
+		}
+		serverHello := handshake(tlsServerHello, append(append(append([]byte{0x03, 0x03}, serverRandom...), 0, 0x00, 0x9c, 0), extensions...))
+
+		session := newTLSSession(keys)
+		for _, msg := range [][]byte{clientHello, clientKeyExchange} {
+			if err := session.clientHandshake(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, msg := range [][]byte{serverHello, certificate, serverHelloDone} {
+			if err := session.serverHandshake(msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := session.tls12Decryptor(true); err != nil {
+			t.Fatalf("extended master secret %t: %s", ems, err)
+		}
+
+		want := tls12PRF(sha256.New, preMaster, "master secret", append(append([]byte(nil), clientRandom...), serverRandom...), tlsMasterSecretSize)
+		if ems {
+			transcript := sha256.New()
+			for _, msg := range [][]byte{clientHello, serverHello, certificate, serverHelloDone, clientKeyExchange} {
+				transcript.Write(msg)
+			}
+			want = tls12PRF(sha256.New, preMaster, "extended master secret", transcript.Sum(nil), tlsMasterSecretSize)
+		}
+		if !bytes.Equal(session.master, want) {
+			t.Errorf("extended master secret %t: master secret is %x, want %x", ems, session.master, want)
+		}
+	}
+}
+
+func testTLSKeys(t *testing.T, keyLog string) *TLSKeys {
+	f, err := ioutil.TempFile("", "keylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.WriteString(keyLog); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadTLSKeys(f.Name(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// readTLSCapture returns TCP payloads of both directions of the connection captured in pcap file
+func readTLSCapture(t *testing.T, path string) (client, broker []byte, packets []gopacket.Packet) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := gopacket.NewPacketSource(r, r.LinkType())
+	for pkt := range source.Packets() {
+		tcp := pkt.TransportLayer().(*layers.TCP)
+		if tcp.DstPort == 9092 {
+			client = append(client, tcp.Payload...)
+		} else {
+			broker = append(broker, tcp.Payload...)
+		}
+		packets = append(packets, pkt)
+	}
+
+	return client, broker, packets
+}
+
+// tlsCaptures are connections of testdata/tls written by gen.go: crypto/tls client produces 3 requests of 1, 3
+// and 1000 records to partition 0 of topic orders and broker answers them, the last request is split into
+// several TLS records
+var tlsCaptures = []string{"tls12-ecdhe-gcm", "tls12-ecdhe-cbc", "tls13-aes128-gcm"}
+
+// TestTLSDecryption decrypts both directions of captured connections by their key logs
+func TestTLSDecryption(t *testing.T) {
+	for _, name := range tlsCaptures {
+		t.Run(name, func(t *testing.T) {
+			keys, err := LoadTLSKeys(filepath.Join("testdata", "tls", name+".keylog"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, broker, _ := readTLSCapture(t, filepath.Join("testdata", "tls", name+".pcap"))
+
+			session := newTLSSession(keys)
+			requests := newTLSReader(bufio.NewReader(bytes.NewReader(client)), session, true, func(err error) {
+				t.Errorf("could not decrypt requests: %s", err)
+			})
+			responses := newTLSReader(bufio.NewReader(bytes.NewReader(broker)), session, false, func(err error) {
+				t.Errorf("could not decrypt responses: %s", err)
+			})
+
+			// directions wait for handshake messages of each other
+			done := make(chan []byte)
+			go func() {
+				defer responses.close()
+				data, _ := ioutil.ReadAll(responses)
+				done <- data
+			}()
+			requestsData, _ := ioutil.ReadAll(requests)
+			requests.close()
+			responsesData := <-done
+
+			var want []byte
+			for i, records := range []int{1, 3, 1000} {
+				want = append(want, testProduce(t, int32(i+1), 0, records)...)
+			}
+			if !bytes.Equal(requestsData, want) {
+				t.Errorf("decrypted requests differ from produced ones: %d bytes, want %d", len(requestsData), len(want))
+			}
+
+			for correlationID := int32(1); correlationID <= 3; correlationID++ {
+				if len(responsesData) < 8 {
+					t.Fatalf("response %d is missing", correlationID)
+				}
+				if id := int32(binary.BigEndian.Uint32(responsesData[4:])); id != correlationID {
+					t.Errorf("correlation id of response is %d, want %d", id, correlationID)
+				}
+				responsesData = responsesData[4+binary.BigEndian.Uint32(responsesData):]
+			}
+			if len(responsesData) != 0 {
+				t.Errorf("%d bytes follow responses", len(responsesData))
+			}
+		})
+	}
+}
+
+// TestTLSCaptures replays captured connections through assembler, requests are decoded from decrypted data
+func TestTLSCaptures(t *testing.T) {
+	for _, name := range tlsCaptures {
+		t.Run(name, func(t *testing.T) {
+			keys, err := LoadTLSKeys(filepath.Join("testdata", "tls", name+".keylog"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, packets := readTLSCapture(t, filepath.Join("testdata", "tls", name+".pcap"))
+
+			errorsBefore := testutil.ToFloat64(metrics.TLSDecryptionErrors)
+			sink := &testSink{}
+			factory := newTestFactory(sink, Config{TLSKeys: keys, Responses: true})
+			assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+			for _, pkt := range packets {
+				ctx := testContext(pkt.Metadata().CaptureInfo)
+				assembler.AssembleWithContext(pkt.NetworkLayer().NetworkFlow(), pkt.TransportLayer().(*layers.TCP), &ctx)
+			}
+			assembler.FlushAll()
+			factory.Wait()
+
+			if errors := testutil.ToFloat64(metrics.TLSDecryptionErrors) - errorsBefore; errors != 0 {
+				t.Errorf("%v decryption errors", errors)
+			}
+			if len(sink.events) != 3 {
+				t.Fatalf("%d requests are decoded, want 3", len(sink.events))
+			}
+			for i, e := range sink.events {
+				if e.APIName != "Produce" || e.CorrelationID != int32(i+1) || len(e.Topics) != 1 || e.Topics[0] != "orders" {
+					t.Errorf("request %d is %s %d to %v", i, e.APIName, e.CorrelationID, e.Topics)
+				}
+			}
+		})
+	}
+}
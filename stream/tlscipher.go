@@ -0,0 +1,270 @@
+package stream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+const (
+	tlsVersion12 = 0x0303
+	tlsVersion13 = 0x0304
+
+	tlsPreMasterSecretSize = 48
+	tlsMasterSecretSize    = 48
+
+	// tlsGCMFixedIVSize and tlsGCMExplicitNonceSize are sizes of parts of AES-GCM nonce of TLS 1.2
+	tlsGCMFixedIVSize       = 4
+	tlsGCMExplicitNonceSize = 8
+)
+
+var errTLSDecrypt = errors.New("could not decrypt TLS record")
+
+// tlsCipherSuite is a cipher suite supported by decryption, suites with ChaCha20-Poly1305 aren't supported
+type tlsCipherSuite struct {
+	keyLen int
+
+	// hash is a hash of PRF (TLS 1.2) or HKDF (TLS 1.3)
+	hash func() hash.Hash
+
+	// mac is a hash of HMAC of CBC suites, it's nil for AES-GCM suites
+	mac func() hash.Hash
+
+	// rsa is true for RSA key exchange, pre-master secret of such suites is decrypted with RSA key of broker
+	rsa bool
+}
+
+var tlsCipherSuites = map[uint16]*tlsCipherSuite{
+	// TLS 1.3
+	0x1301: {keyLen: 16, hash: sha256.New},
+	0x1302: {keyLen: 32, hash: sha512.New384},
+
+	// TLS 1.2 with RSA key exchange
+	0x002f: {keyLen: 16, hash: sha256.New, mac: sha1.New, rsa: true},
+	0x0035: {keyLen: 32, hash: sha256.New, mac: sha1.New, rsa: true},
+	0x003c: {keyLen: 16, hash: sha256.New, mac: sha256.New, rsa: true},
+	0x003d: {keyLen: 32, hash: sha256.New, mac: sha256.New, rsa: true},
+	0x009c: {keyLen: 16, hash: sha256.New, rsa: true},
+	0x009d: {keyLen: 32, hash: sha512.New384, rsa: true},
+
+	// TLS 1.2 with DHE and ECDHE key exchanges, they are decrypted with key log only
+	0x009e: {keyLen: 16, hash: sha256.New},
+	0x009f: {keyLen: 32, hash: sha512.New384},
+	0xc009: {keyLen: 16, hash: sha256.New, mac: sha1.New},
+	0xc00a: {keyLen: 32, hash: sha256.New, mac: sha1.New},
+	0xc013: {keyLen: 16, hash: sha256.New, mac: sha1.New},
+	0xc014: {keyLen: 32, hash: sha256.New, mac: sha1.New},
+	0xc023: {keyLen: 16, hash: sha256.New, mac: sha256.New},
+	0xc027: {keyLen: 16, hash: sha256.New, mac: sha256.New},
+	0xc02b: {keyLen: 16, hash: sha256.New},
+	0xc02c: {keyLen: 32, hash: sha512.New384},
+	0xc02f: {keyLen: 16, hash: sha256.New},
+	0xc030: {keyLen: 32, hash: sha512.New384},
+}
+
+// macLen returns size of HMAC of CBC suite, it's zero for AES-GCM suites
+func (s *tlsCipherSuite) macLen() int {
+	if s.mac == nil {
+		return 0
+	}
+	return s.mac().Size()
+}
+
+// tlsDecryptor decrypts records of one direction of TLS connection
+type tlsDecryptor struct {
+	version uint16
+	seq     uint64
+
+	// aead and iv decrypt AES-GCM suites, block and macLen decrypt CBC suites
+	aead   cipher.AEAD
+	iv     []byte
+	block  cipher.Block
+	macLen int
+
+	// encryptThenMAC is true if MAC of CBC suite is computed over encrypted data (RFC 7366)
+	encryptThenMAC bool
+}
+
+// newTLS12Decryptor creates decryptor of TLS 1.2 direction by master secret of the session
+func newTLS12Decryptor(suite *tlsCipherSuite, master, clientRandom, serverRandom []byte, fromClient, encryptThenMAC bool) (*tlsDecryptor, error) {
+	macLen, ivLen := suite.macLen(), 0
+	if suite.mac == nil {
+		ivLen = tlsGCMFixedIVSize
+	}
+
+	// key block is client MAC key, server MAC key, client key, server key, client IV, server IV
+	seed := append(append([]byte(nil), serverRandom...), clientRandom...)
+	keyBlock := tls12PRF(suite.hash, master, "key expansion", seed, 2*(macLen+suite.keyLen+ivLen))
+
+	key := keyBlock[2*macLen : 2*macLen+suite.keyLen]
+	iv := keyBlock[2*(macLen+suite.keyLen) : 2*(macLen+suite.keyLen)+ivLen]
+	if !fromClient {
+		key = keyBlock[2*macLen+suite.keyLen : 2*(macLen+suite.keyLen)]
+		iv = keyBlock[2*(macLen+suite.keyLen)+ivLen:]
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &tlsDecryptor{version: tlsVersion12, block: block, macLen: macLen, encryptThenMAC: encryptThenMAC}
+	if suite.mac == nil {
+		if d.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+		d.iv = iv
+	}
+
+	return d, nil
+}
+
+// newTLS13Decryptor creates decryptor of TLS 1.3 direction by its traffic secret
+func newTLS13Decryptor(suite *tlsCipherSuite, secret []byte) (*tlsDecryptor, error) {
+	block, err := aes.NewCipher(hkdfExpandLabel(suite.hash, secret, "key", suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsDecryptor{
+		version: tlsVersion13,
+		aead:    aead,
+		iv:      hkdfExpandLabel(suite.hash, secret, "iv", aead.NonceSize()),
+	}, nil
+}
+
+// decrypt decrypts payload of record with the header, it returns content type and data of the record. Content
+// type of TLS 1.3 record is hidden in encrypted data.
+func (d *tlsDecryptor) decrypt(header, payload []byte) (byte, []byte, error) {
+	defer func() { d.seq++ }()
+
+	typ := header[0]
+
+	switch {
+	case d.version == tlsVersion13:
+		nonce := make([]byte, len(d.iv))
+		copy(nonce, d.iv)
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-1-i] ^= byte(d.seq >> (8 * i))
+		}
+
+		data, err := d.aead.Open(payload[:0], nonce, payload, header)
+		if err != nil {
+			return 0, nil, errTLSDecrypt
+		}
+
+		// content is followed by content type and zero padding
+		for i := len(data) - 1; i >= 0; i-- {
+			if data[i] != 0 {
+				return data[i], data[:i], nil
+			}
+		}
+		return 0, nil, errTLSDecrypt
+	case d.aead != nil:
+		if len(payload) < tlsGCMExplicitNonceSize+d.aead.Overhead() {
+			return 0, nil, errTLSDecrypt
+		}
+
+		nonce := append(append([]byte(nil), d.iv...), payload[:tlsGCMExplicitNonceSize]...)
+		payload = payload[tlsGCMExplicitNonceSize:]
+
+		data, err := d.aead.Open(payload[:0], nonce, payload, d.additionalData(typ, len(payload)-d.aead.Overhead()))
+		if err != nil {
+			return 0, nil, errTLSDecrypt
+		}
+		return typ, data, nil
+	default:
+		// MACs aren't verified, TLS connection would be broken if they were wrong
+		if d.encryptThenMAC {
+			if len(payload) < d.macLen {
+				return 0, nil, errTLSDecrypt
+			}
+			payload = payload[:len(payload)-d.macLen]
+		}
+
+		size := d.block.BlockSize()
+		if len(payload) < 2*size || len(payload)%size != 0 {
+			return 0, nil, errTLSDecrypt
+		}
+
+		data := payload[size:]
+		cipher.NewCBCDecrypter(d.block, payload[:size]).CryptBlocks(data, data)
+
+		// data is followed by MAC (unless it's encrypt-then-MAC), padding and length of padding
+		padding := int(data[len(data)-1]) + 1
+		if !d.encryptThenMAC {
+			padding += d.macLen
+		}
+		if padding > len(data) {
+			return 0, nil, errTLSDecrypt
+		}
+		return typ, data[:len(data)-padding], nil
+	}
+}
+
+// additionalData returns additional data of TLS 1.2 AEAD record
+func (d *tlsDecryptor) additionalData(typ byte, length int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, d.seq)
+	ad[8] = typ
+	binary.BigEndian.PutUint16(ad[9:], d.version)
+	binary.BigEndian.PutUint16(ad[11:], uint16(length))
+	return ad
+}
+
+// tls12PRF is a pseudorandom function of TLS 1.2 (RFC 5246, section 5)
+func tls12PRF(h func() hash.Hash, secret []byte, label string, seed []byte, length int) []byte {
+	seed = append([]byte(label), seed...)
+
+	mac := hmac.New(h, secret)
+	mac.Write(seed)
+	a := mac.Sum(nil)
+
+	var out []byte
+	for len(out) < length {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(seed)
+		out = mac.Sum(out)
+
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(nil)
+	}
+
+	return out[:length]
+}
+
+// hkdfExpandLabel is HKDF-Expand-Label of TLS 1.3 with empty context (RFC 8446, section 7.1)
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+
+	info := make([]byte, 0, 4+len(label))
+	info = append(info, byte(length>>8), byte(length), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0)
+
+	mac := hmac.New(h, secret)
+
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+
+	return out[:length]
+}
@@ -0,0 +1,155 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSKeys contains secrets decrypting TLS connections: per-session secrets of key log file written by clients or
+// brokers (SSLKEYLOGFILE format) and static RSA private keys of brokers
+type TLSKeys struct {
+	keyLogPath string
+	rsaKeys    []*rsa.PrivateKey
+
+	mux sync.Mutex
+
+	// secrets are secrets of key log by label and hex encoded client random
+	secrets map[string][]byte
+
+	// size and modification time of loaded key log, it's reloaded when they change
+	size    int64
+	modTime time.Time
+}
+
+// LoadTLSKeys loads key log file and PEM encoded RSA private keys, both are optional
+func LoadTLSKeys(keyLogPath string, rsaKeyPaths []string) (*TLSKeys, error) {
+	k := &TLSKeys{
+		keyLogPath: keyLogPath,
+		secrets:    make(map[string][]byte),
+	}
+
+	for _, path := range rsaKeyPaths {
+		key, err := loadRSAKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load RSA key %s: %s", path, err)
+		}
+		k.rsaKeys = append(k.rsaKeys, key)
+	}
+
+	if keyLogPath != "" {
+		if err := k.reload(); err != nil {
+			return nil, fmt.Errorf("could not load key log %s: %s", keyLogPath, err)
+		}
+	}
+
+	return k, nil
+}
+
+func loadRSAKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an RSA key")
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+}
+
+// lookup returns secret of key log by label and client random of the session. Clients append secrets to the
+// key log during handshake, so the file is reloaded if it has changed since the last load.
+func (k *TLSKeys) lookup(label string, clientRandom []byte) ([]byte, bool) {
+	if k.keyLogPath == "" {
+		return nil, false
+	}
+
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	key := label + " " + hex.EncodeToString(clientRandom)
+	if secret, ok := k.secrets[key]; ok {
+		return secret, true
+	}
+
+	if err := k.reload(); err != nil {
+		return nil, false
+	}
+
+	secret, ok := k.secrets[key]
+	return secret, ok
+}
+
+// reload parses key log if it has changed, lines are "<label> <client random hex> <secret hex>", comments
+// and unknown lines are ignored. It must be called with locked mutex.
+func (k *TLSKeys) reload() error {
+	info, err := os.Stat(k.keyLogPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() == k.size && info.ModTime().Equal(k.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(k.keyLogPath)
+	if err != nil {
+		return err
+	}
+	k.size, k.modTime = info.Size(), info.ModTime()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		k.secrets[fields[0]+" "+strings.ToLower(fields[1])] = secret
+	}
+
+	return scanner.Err()
+}
+
+// decryptPreMasterSecret decrypts pre-master secret of RSA key exchange with one of RSA keys
+func (k *TLSKeys) decryptPreMasterSecret(encrypted []byte) ([]byte, bool) {
+	for _, key := range k.rsaKeys {
+		secret, err := rsa.DecryptPKCS1v15(rand.Reader, key, encrypted)
+		if err == nil && len(secret) == tlsPreMasterSecretSize {
+			return secret, true
+		}
+	}
+	return nil, false
+}
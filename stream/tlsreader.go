@@ -0,0 +1,260 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	tlsRecordSize = 5
+
+	// tlsMaxRecordSize is a max size of encrypted record payload
+	tlsMaxRecordSize = 16384 + 2048
+
+	tlsChangeCipherSpecRecord = 0x14
+	tlsApplicationDataRecord  = 0x17
+)
+
+// tlsRecordOffset maps end of decrypted data of record to offset of the record in captured stream
+type tlsRecordOffset struct {
+	end, raw int64
+}
+
+// tlsReader decrypts application data of one direction of TLS connection, handshake messages are passed
+// to the session shared with the other direction
+type tlsReader struct {
+	src        *bufio.Reader
+	session    *tlsSession
+	fromClient bool
+
+	decryptor *tlsDecryptor
+
+	// secret is a current traffic secret of TLS 1.3 direction, application traffic secrets are used after
+	// Finished of the direction
+	secret   []byte
+	finished bool
+
+	// handshake buffers handshake message split between records
+	handshake []byte
+
+	plain []byte
+	err   error
+
+	// onError is called once when decryption fails
+	onError func(error)
+
+	// raw is an offset of the next record in captured stream, decrypted is a count of decrypted bytes
+	raw       int64
+	decrypted int64
+	offsets   []tlsRecordOffset
+}
+
+func newTLSReader(src *bufio.Reader, session *tlsSession, fromClient bool, onError func(error)) *tlsReader {
+	return &tlsReader{src: src, session: session, fromClient: fromClient, onError: onError}
+}
+
+// Read implements io.Reader, it returns decrypted application data
+func (r *tlsReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readRecord()
+		if r.err != nil && r.err != io.EOF && r.err != io.ErrUnexpectedEOF {
+			r.onError(r.err)
+		}
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+
+	return n, nil
+}
+
+// close stops waiting of decryptor of the other direction
+func (r *tlsReader) close() {
+	r.session.close()
+}
+
+// position returns offset in captured stream of record containing byte at offset of decrypted data. Offsets
+// must grow between calls, since offsets of records before the offset are forgotten.
+func (r *tlsReader) position(offset int64) int64 {
+	for len(r.offsets) > 0 && r.offsets[0].end <= offset {
+		r.offsets = r.offsets[1:]
+	}
+	if len(r.offsets) == 0 {
+		return r.raw
+	}
+	return r.offsets[0].raw
+}
+
+// readRecord reads the next record and decrypts it. Records are decrypted after ChangeCipherSpec in TLS 1.2
+// and since the first application data record in TLS 1.3.
+func (r *tlsReader) readRecord() error {
+	header := make([]byte, tlsRecordSize)
+	if _, err := io.ReadFull(r.src, header); err != nil {
+		return err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[3:]))
+	if length > tlsMaxRecordSize {
+		return errors.New("TLS record is too large")
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r.src, payload); err != nil {
+		return err
+	}
+
+	raw := r.raw
+	r.raw += int64(tlsRecordSize + length)
+
+	typ := header[0]
+
+	if r.decryptor == nil && typ == tlsApplicationDataRecord {
+		if err := r.startTLS13(); err != nil {
+			return err
+		}
+	}
+
+	if r.decryptor != nil {
+		var err error
+		if typ, payload, err = r.decryptor.decrypt(header, payload); err != nil {
+			return err
+		}
+	}
+
+	switch typ {
+	case tlsChangeCipherSpecRecord:
+		return r.changeCipherSpec()
+	case tlsHandshakeRecord:
+		return r.handshakeRecord(payload)
+	case tlsApplicationDataRecord:
+		r.plain = payload
+		r.decrypted += int64(len(payload))
+		r.offsets = append(r.offsets, tlsRecordOffset{end: r.decrypted, raw: raw})
+	}
+
+	return nil
+}
+
+// changeCipherSpec starts decryption of TLS 1.2, in TLS 1.3 it's sent for compatibility only
+func (r *tlsReader) changeCipherSpec() error {
+	if r.decryptor != nil {
+		return nil
+	}
+
+	hello, err := r.session.serverHello()
+	if err != nil {
+		return err
+	}
+	if hello.version != tlsVersion12 {
+		return nil
+	}
+
+	r.decryptor, err = r.session.tls12Decryptor(r.fromClient)
+	return err
+}
+
+// startTLS13 starts decryption of TLS 1.3 with handshake traffic secret
+func (r *tlsReader) startTLS13() error {
+	hello, err := r.session.serverHello()
+	if err != nil {
+		return err
+	}
+	if hello.version != tlsVersion13 {
+		return errors.New("unexpected TLS application data before ChangeCipherSpec")
+	}
+
+	return r.useTLS13Secret(r.label("HANDSHAKE_TRAFFIC_SECRET"))
+}
+
+// label returns key log label of traffic secret of the direction
+func (r *tlsReader) label(secret string) string {
+	if r.fromClient {
+		return "CLIENT_" + secret
+	}
+	return "SERVER_" + secret
+}
+
+func (r *tlsReader) useTLS13Secret(label string) error {
+	secret, err := r.session.tls13Secret(label)
+	if err != nil {
+		return err
+	}
+	return r.setTLS13Secret(secret)
+}
+
+func (r *tlsReader) setTLS13Secret(secret []byte) error {
+	hello, err := r.session.serverHello()
+	if err != nil {
+		return err
+	}
+
+	r.secret = secret
+	r.decryptor, err = newTLS13Decryptor(hello.suite, secret)
+	return err
+}
+
+// handshakeRecord passes handshake messages to the session, encrypted messages of TLS 1.3 switch traffic
+// secrets of the direction
+func (r *tlsReader) handshakeRecord(payload []byte) error {
+	r.handshake = append(r.handshake, payload...)
+
+	for len(r.handshake) >= tlsHandshakeHeaderSize {
+		length := int(r.handshake[1])<<16 | int(r.handshake[2])<<8 | int(r.handshake[3])
+		if length > tlsMaxRecordSize*4 {
+			return errTLSMalformed
+		}
+		if len(r.handshake) < tlsHandshakeHeaderSize+length {
+			return nil
+		}
+
+		msg := r.handshake[:tlsHandshakeHeaderSize+length]
+		r.handshake = r.handshake[tlsHandshakeHeaderSize+length:]
+
+		if r.secret != nil {
+			if err := r.tls13Handshake(msg[0]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Finished of TLS 1.2 is encrypted
+		if r.decryptor != nil {
+			continue
+		}
+
+		var err error
+		if r.fromClient {
+			err = r.session.clientHandshake(msg)
+		} else {
+			err = r.session.serverHandshake(msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tls13Handshake switches to application traffic secret after Finished and to the next secret after KeyUpdate
+func (r *tlsReader) tls13Handshake(typ byte) error {
+	switch typ {
+	case tlsFinished:
+		if !r.finished {
+			r.finished = true
+			return r.useTLS13Secret(r.label("TRAFFIC_SECRET_0"))
+		}
+	case tlsKeyUpdate:
+		hello, err := r.session.serverHello()
+		if err != nil {
+			return err
+		}
+		return r.setTLS13Secret(hkdfExpandLabel(hello.suite.hash, r.secret, "traffic upd", hello.suite.hash().Size()))
+	}
+	return nil
+}
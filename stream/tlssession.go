@@ -0,0 +1,301 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	tlsHandshakeHeaderSize = 4
+	tlsRandomSize          = 32
+
+	tlsClientKeyExchange = 0x10
+	tlsServerHelloDone   = 0x0e
+	tlsFinished          = 0x14
+	tlsKeyUpdate         = 0x18
+
+	tlsExtensionEncryptThenMAC       = 0x0016
+	tlsExtensionExtendedMasterSecret = 0x0017
+	tlsExtensionSupportedVersions    = 0x002b
+)
+
+var (
+	errTLSHandshakeMissing = errors.New("handshake of TLS connection isn't captured")
+	errTLSNoKeys           = errors.New("no keys of TLS session")
+	errTLSMalformed        = errors.New("malformed TLS handshake")
+)
+
+// tlsHelloRetryRequest is a random of ServerHello which is actually HelloRetryRequest of TLS 1.3
+var tlsHelloRetryRequest = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// tlsServerParams is a negotiated state of TLS session sent by broker in ServerHello
+type tlsServerParams struct {
+	random  []byte
+	version uint16
+	suite   *tlsCipherSuite
+
+	extendedMasterSecret, encryptThenMAC bool
+}
+
+// tlsSession is a state of TLS handshake shared by decryptors of both directions of the connection. Each
+// direction waits for the handshake messages of the other one, e.g. client waits for ServerHello to know
+// the cipher suite.
+type tlsSession struct {
+	keys *TLSKeys
+
+	mux  sync.Mutex
+	cond *sync.Cond
+
+	clientRandom []byte
+	hello        *tlsServerParams
+
+	// transcript of TLS 1.2 handshake used by extended master secret: ClientHello, messages of broker up to
+	// ServerHelloDone and messages of client up to ClientKeyExchange
+	clientHello     []byte
+	serverMessages  []byte
+	clientMessages  []byte
+	serverHelloDone bool
+
+	// preMasterSecret is encrypted pre-master secret of RSA key exchange
+	preMasterSecret []byte
+
+	master []byte
+
+	// closed is true when decryptor of any direction stops, the other one doesn't wait for it anymore
+	closed bool
+}
+
+func newTLSSession(keys *TLSKeys) *tlsSession {
+	s := &tlsSession{keys: keys}
+	s.cond = sync.NewCond(&s.mux)
+	return s
+}
+
+// wait waits until the state is ready or decryptor of the other direction stops, it must be called with
+// locked mutex
+func (s *tlsSession) wait(ready func() bool) bool {
+	for !ready() && !s.closed {
+		s.cond.Wait()
+	}
+	return ready()
+}
+
+// close stops waiting of decryptor of the other direction
+func (s *tlsSession) close() {
+	s.mux.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mux.Unlock()
+}
+
+// clientHandshake remembers handshake message sent by client
+func (s *tlsSession) clientHandshake(msg []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	defer s.cond.Broadcast()
+
+	switch msg[0] {
+	case tlsClientHello:
+		// ClientHello: message header, version and random
+		if len(msg) < tlsHandshakeHeaderSize+2+tlsRandomSize {
+			return errTLSMalformed
+		}
+		s.clientRandom = append([]byte(nil), msg[tlsHandshakeHeaderSize+2:tlsHandshakeHeaderSize+2+tlsRandomSize]...)
+		s.clientHello = append([]byte(nil), msg...)
+		s.clientMessages = nil
+	case tlsClientKeyExchange:
+		if s.preMasterSecret != nil {
+			return nil
+		}
+		s.clientMessages = append(s.clientMessages, msg...)
+
+		// RSA encrypted pre-master secret is prefixed by its length
+		body := msg[tlsHandshakeHeaderSize:]
+		if len(body) < 2 || int(binary.BigEndian.Uint16(body)) != len(body)-2 {
+			s.preMasterSecret = []byte{}
+			return nil
+		}
+		s.preMasterSecret = append([]byte(nil), body[2:]...)
+	default:
+		if s.preMasterSecret == nil {
+			s.clientMessages = append(s.clientMessages, msg...)
+		}
+	}
+
+	return nil
+}
+
+// serverHandshake remembers handshake message sent by broker
+func (s *tlsSession) serverHandshake(msg []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	defer s.cond.Broadcast()
+
+	switch {
+	case msg[0] == tlsServerHello:
+		hello, err := parseServerHello(msg[tlsHandshakeHeaderSize:])
+		if err != nil {
+			return err
+		}
+
+		// client sends the second ClientHello after HelloRetryRequest
+		if bytes.Equal(hello.random, tlsHelloRetryRequest) {
+			return nil
+		}
+		s.hello = hello
+		s.serverMessages = append([]byte(nil), msg...)
+	case msg[0] == tlsServerHelloDone:
+		s.serverMessages = append(s.serverMessages, msg...)
+		s.serverHelloDone = true
+	case !s.serverHelloDone:
+		s.serverMessages = append(s.serverMessages, msg...)
+	}
+
+	return nil
+}
+
+// parseServerHello parses body of ServerHello: version, random, session id, cipher suite, compression
+// method and extensions
+func parseServerHello(body []byte) (*tlsServerParams, error) {
+	if len(body) < 2+tlsRandomSize+1 {
+		return nil, errTLSMalformed
+	}
+
+	hello := &tlsServerParams{
+		version: binary.BigEndian.Uint16(body),
+		random:  append([]byte(nil), body[2:2+tlsRandomSize]...),
+	}
+
+	body = body[2+tlsRandomSize:]
+	sessionIDLen := int(body[0])
+	if len(body) < 1+sessionIDLen+3 {
+		return nil, errTLSMalformed
+	}
+	body = body[1+sessionIDLen:]
+
+	suiteID := binary.BigEndian.Uint16(body)
+	body = body[3:]
+
+	if len(body) >= 2 {
+		extensions := body[2:]
+		if int(binary.BigEndian.Uint16(body)) < len(extensions) {
+			extensions = extensions[:binary.BigEndian.Uint16(body)]
+		}
+
+		for len(extensions) >= 4 {
+			typ, length := binary.BigEndian.Uint16(extensions), int(binary.BigEndian.Uint16(extensions[2:]))
+			if len(extensions) < 4+length {
+				return nil, errTLSMalformed
+			}
+			data := extensions[4 : 4+length]
+			extensions = extensions[4+length:]
+
+			switch typ {
+			case tlsExtensionSupportedVersions:
+				if len(data) == 2 {
+					hello.version = binary.BigEndian.Uint16(data)
+				}
+			case tlsExtensionExtendedMasterSecret:
+				hello.extendedMasterSecret = true
+			case tlsExtensionEncryptThenMAC:
+				hello.encryptThenMAC = true
+			}
+		}
+	}
+
+	if hello.version != tlsVersion12 && hello.version != tlsVersion13 {
+		return nil, fmt.Errorf("unsupported TLS version %#04x", hello.version)
+	}
+
+	var ok bool
+	if hello.suite, ok = tlsCipherSuites[suiteID]; !ok {
+		return nil, fmt.Errorf("unsupported TLS cipher suite %#04x", suiteID)
+	}
+
+	return hello, nil
+}
+
+// serverHello waits for ServerHello of the session
+func (s *tlsSession) serverHello() (*tlsServerParams, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.wait(func() bool { return s.hello != nil }) {
+		return nil, errTLSHandshakeMissing
+	}
+	return s.hello, nil
+}
+
+// tls12Decryptor creates decryptor of TLS 1.2 direction, master secret is taken from key log or computed from
+// pre-master secret decrypted by RSA key
+func (s *tlsSession) tls12Decryptor(fromClient bool) (*tlsDecryptor, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.wait(func() bool { return s.clientRandom != nil && s.hello != nil }) {
+		return nil, errTLSHandshakeMissing
+	}
+
+	if s.master == nil {
+		master, err := s.masterSecret()
+		if err != nil {
+			return nil, err
+		}
+		s.master = master
+	}
+
+	return newTLS12Decryptor(s.hello.suite, s.master, s.clientRandom, s.hello.random, fromClient, s.hello.encryptThenMAC)
+}
+
+// masterSecret returns master secret of TLS 1.2 session, it must be called with locked mutex
+func (s *tlsSession) masterSecret() ([]byte, error) {
+	if master, ok := s.keys.lookup("CLIENT_RANDOM", s.clientRandom); ok {
+		return master, nil
+	}
+
+	if !s.hello.suite.rsa || len(s.keys.rsaKeys) == 0 {
+		return nil, errTLSNoKeys
+	}
+
+	if !s.wait(func() bool { return s.preMasterSecret != nil && (!s.hello.extendedMasterSecret || s.serverHelloDone) }) {
+		return nil, errTLSHandshakeMissing
+	}
+
+	preMaster, ok := s.keys.decryptPreMasterSecret(s.preMasterSecret)
+	if !ok {
+		return nil, errTLSNoKeys
+	}
+
+	if s.hello.extendedMasterSecret {
+		h := s.hello.suite.hash()
+		h.Write(s.clientHello)
+		h.Write(s.serverMessages)
+		h.Write(s.clientMessages)
+		return tls12PRF(s.hello.suite.hash, preMaster, "extended master secret", h.Sum(nil), tlsMasterSecretSize), nil
+	}
+
+	seed := append(append([]byte(nil), s.clientRandom...), s.hello.random...)
+	return tls12PRF(s.hello.suite.hash, preMaster, "master secret", seed, tlsMasterSecretSize), nil
+}
+
+// tls13Secret returns traffic secret of TLS 1.3 session from key log by its label
+func (s *tlsSession) tls13Secret(label string) ([]byte, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.wait(func() bool { return s.clientRandom != nil }) {
+		return nil, errTLSHandshakeMissing
+	}
+
+	secret, ok := s.keys.lookup(label, s.clientRandom)
+	if !ok {
+		return nil, errTLSNoKeys
+	}
+	return secret, nil
+}
@@ -0,0 +1,165 @@
+package stream
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TopicFilter selects topics reported in metrics and events by include and exclude patterns, patterns may be
+// added and removed at runtime. Pattern is a glob with * and ? wildcards matching the whole topic name, or
+// a regular expression in slashes, e.g. /^payments\./, matching any part of it.
+type TopicFilter struct {
+	mux     sync.RWMutex
+	include []topicPattern
+	exclude []topicPattern
+}
+
+// topicPattern is a compiled pattern of topics
+type topicPattern struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// NewTopicFilter creates filter of topics, a topic is reported if it matches any include pattern (or there
+// are no include patterns) and doesn't match any exclude pattern. Empty patterns are ignored.
+func NewTopicFilter(include, exclude []string) (*TopicFilter, error) {
+	f := &TopicFilter{}
+
+	for _, pattern := range include {
+		if err := f.Include(pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range exclude {
+		if err := f.Exclude(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Include adds include pattern
+func (f *TopicFilter) Include(pattern string) error {
+	return f.add(&f.include, pattern)
+}
+
+// Exclude adds exclude pattern
+func (f *TopicFilter) Exclude(pattern string) error {
+	return f.add(&f.exclude, pattern)
+}
+
+// RemoveInclude removes include pattern, it returns false if there is no such pattern
+func (f *TopicFilter) RemoveInclude(pattern string) bool {
+	return f.remove(&f.include, pattern)
+}
+
+// RemoveExclude removes exclude pattern, it returns false if there is no such pattern
+func (f *TopicFilter) RemoveExclude(pattern string) bool {
+	return f.remove(&f.exclude, pattern)
+}
+
+// Patterns returns current include and exclude patterns
+func (f *TopicFilter) Patterns() (include, exclude []string) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	include, exclude = make([]string, 0, len(f.include)), make([]string, 0, len(f.exclude))
+	for _, p := range f.include {
+		include = append(include, p.pattern)
+	}
+	for _, p := range f.exclude {
+		exclude = append(exclude, p.pattern)
+	}
+	return include, exclude
+}
+
+func (f *TopicFilter) add(patterns *[]topicPattern, pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil
+	}
+
+	expr := globToRegexp(pattern)
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr = pattern[1 : len(pattern)-1]
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid topic pattern %q: %s", pattern, err)
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	for _, p := range *patterns {
+		if p.pattern == pattern {
+			return nil
+		}
+	}
+	*patterns = append(*patterns, topicPattern{pattern: pattern, re: re})
+
+	return nil
+}
+
+func (f *TopicFilter) remove(patterns *[]topicPattern, pattern string) bool {
+	pattern = strings.TrimSpace(pattern)
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	for i, p := range *patterns {
+		if p.pattern == pattern {
+			*patterns = append((*patterns)[:i:i], (*patterns)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts glob pattern to regular expression matching the whole string
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// allows returns true if topic must be reported, nil filter allows all topics
+func (f *TopicFilter) allows(topic string) bool {
+	if f == nil {
+		return true
+	}
+
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	for _, p := range f.exclude {
+		if p.re.MatchString(topic) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.re.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
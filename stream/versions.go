@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+)
+
+// minVersionPresets are minimal versions of apis supported by broker releases by api key, older versions are
+// deprecated. Versions removed by Kafka 4.0 are listed in KIP-896.
+var minVersionPresets = map[string]map[int16]int16{
+	"kafka-4.0": {
+		0:  3, // Produce
+		1:  4, // Fetch
+		2:  1, // ListOffsets
+		8:  2, // OffsetCommit
+		9:  1, // OffsetFetch
+		11: 2, // JoinGroup
+		19: 2, // CreateTopics
+		23: 2, // OffsetForLeaderEpoch
+		29: 1, // DescribeAcls
+		30: 1, // CreateAcls
+		31: 1, // DeleteAcls
+		32: 1, // DescribeConfigs
+		34: 1, // AlterReplicaLogDirs
+		35: 1, // DescribeLogDirs
+		37: 1, // CreatePartitions
+		38: 1, // CreateDelegationToken
+		39: 1, // RenewDelegationToken
+		40: 1, // ExpireDelegationToken
+		41: 1, // DescribeDelegationToken
+		42: 1, // DeleteGroups
+	},
+}
+
+// ParseMinVersions parses comma separated list of minimal versions of apis by presets of broker releases
+// (kafka-4.0) and <api>=<version> items, api is a name or a number of api key. Later items override earlier
+// ones, e.g. "kafka-4.0,Produce=5".
+func ParseMinVersions(s string) (map[int16]int16, error) {
+	versions := make(map[int16]int16)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if preset, ok := minVersionPresets[strings.ToLower(item)]; ok {
+			for key, version := range preset {
+				versions[key] = version
+			}
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid min version %q, expected preset (kafka-4.0) or <api>=<version>", item)
+		}
+
+		key, ok := kafka.APIKeyByName(parts[0])
+		if !ok {
+			number, err := strconv.ParseInt(parts[0], 10, 16)
+			if err != nil || number < 0 {
+				return nil, fmt.Errorf("unknown api key %q", parts[0])
+			}
+			key = int16(number)
+		}
+
+		version, err := strconv.ParseInt(parts[1], 10, 16)
+		if err != nil || version < 0 {
+			return nil, fmt.Errorf("invalid version %q of api %s", parts[1], parts[0])
+		}
+		versions[key] = int16(version)
+	}
+
+	return versions, nil
+}
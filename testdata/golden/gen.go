@@ -0,0 +1,345 @@
+//go:build ignore
+// +build ignore
+
+// Gen writes synthesized pcap files of golden cases, requests are encoded by encoders of kafka package with
+// client ids, versions and compression of common client libraries. Recordings of real clients are added next
+// to them without generator. Run from the root of repository:
+//
+//	go run testdata/golden/gen.go
+//	go run ./cmd/golden -update
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/d-ulyanov/kafka-sniffer/kafka"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var (
+	dir = filepath.Join("testdata", "golden")
+
+	// start is a time of the first packet of every case, so files don't change between runs
+	start = time.Date(2020, 9, 13, 12, 26, 40, 0, time.UTC)
+
+	broker = net.IPv4(10, 0, 0, 100)
+)
+
+// conn writes packets of a single TCP connection to the broker
+type conn struct {
+	w       *pcapgo.Writer
+	ts      time.Time
+	src     net.IP
+	srcPort layers.TCPPort
+	seq     uint32
+	ack     uint32
+}
+
+func main() {
+	gen("produce-v7-codecs", func(w *pcapgo.Writer) {
+		c := dial(w, 1, 50001)
+		for i, codec := range []kafka.CompressionCodec{kafka.CompressionNone, kafka.CompressionGZIP, kafka.CompressionSnappy, kafka.CompressionLZ4, kafka.CompressionZSTD} {
+			p := &kafka.ProduceRequest{Version: 7, RequiredAcks: -1, Timeout: 30000}
+			p.AddBatch("orders", int32(i%3), batch(codec, 3))
+			p.AddBatch("payments", 0, batch(codec, 1))
+			c.send(kafka.NewRequest(int32(i+1), "sarama", p))
+		}
+		c.close()
+	})
+
+	gen("produce-legacy-message-sets", func(w *pcapgo.Writer) {
+		c := dial(w, 2, 50002)
+		for i, codec := range []kafka.CompressionCodec{kafka.CompressionNone, kafka.CompressionGZIP, kafka.CompressionSnappy} {
+			p := &kafka.ProduceRequest{Version: 2, RequiredAcks: 1, Timeout: 5000}
+			p.AddSet("clicks", 0, messageSet(codec, 2))
+			c.send(kafka.NewRequest(int32(i+1), "rdkafka", p))
+		}
+		c.close()
+	})
+
+	gen("fetch-consumer", func(w *pcapgo.Writer) {
+		c := dial(w, 3, 50003)
+		f4 := &kafka.FetchRequest{Version: 4, ReplicaID: -1, MaxWaitTime: 500, MinBytes: 1, MaxBytes: 52428800}
+		f4.AddBlock("orders", 0, 1200, 1048576)
+		f4.AddBlock("orders", 1, 980, 1048576)
+		c.send(kafka.NewRequest(1, "consumer-orders-1", f4))
+
+		f11 := &kafka.FetchRequest{Version: 11, ReplicaID: -1, MaxWaitTime: 500, MinBytes: 1, MaxBytes: 52428800, SessionID: 0, SessionEpoch: -1, RackID: "eu-west-1a"}
+		f11.AddBlock("orders", 2, 15, 1048576)
+		f11.AddBlock("payments", 0, 7, 1048576)
+		c.send(kafka.NewRequest(2, "consumer-orders-1", f11))
+		c.close()
+	})
+
+	gen("consumer-group", func(w *pcapgo.Writer) {
+		c := dial(w, 4, 50004)
+		instance := "billing-0"
+		join := &kafka.JoinGroupRequest{
+			Version:          5,
+			GroupID:          "billing",
+			SessionTimeout:   10000,
+			RebalanceTimeout: 300000,
+			GroupInstanceID:  &instance,
+			ProtocolType:     "consumer",
+			GroupProtocols:   []*kafka.GroupProtocol{{Name: "range", Metadata: []byte{0, 1, 0, 0, 0, 1, 0, 6, 'o', 'r', 'd', 'e', 'r', 's', 0, 0, 0, 0}}},
+		}
+		c.send(kafka.NewRequest(1, "consumer-billing-1", join))
+
+		sync := &kafka.SyncGroupRequest{
+			Version:          3,
+			GroupID:          "billing",
+			GenerationID:     1,
+			MemberID:         "consumer-billing-1-5f0c",
+			GroupInstanceID:  &instance,
+			GroupAssignments: []*kafka.SyncGroupAssignment{{MemberID: "consumer-billing-1-5f0c", Assignment: []byte{0, 1}}},
+		}
+		c.send(kafka.NewRequest(2, "consumer-billing-1", sync))
+		c.close()
+	})
+
+	gen("sasl-plain", func(w *pcapgo.Writer) {
+		c := dial(w, 5, 50005)
+		c.send(kafka.NewRequest(1, "producer-alice", &kafka.SaslHandshakeRequest{Version: 1, Mechanism: "PLAIN"}))
+		c.send(kafka.NewRequest(2, "producer-alice", &kafka.SaslAuthenticateRequest{Version: 1, AuthBytes: []byte("\x00alice\x00alice-secret")}))
+
+		p := &kafka.ProduceRequest{Version: 8, RequiredAcks: -1, Timeout: 30000}
+		p.AddBatch("audit", 0, batch(kafka.CompressionLZ4, 2))
+		c.send(kafka.NewRequest(3, "producer-alice", p))
+		c.close()
+	})
+
+	gen("segmented-retransmitted", func(w *pcapgo.Writer) {
+		c := dial(w, 6, 50006)
+		p := &kafka.ProduceRequest{Version: 3, RequiredAcks: 1, Timeout: 30000}
+		p.AddBatch("orders", 0, batch(kafka.CompressionNone, 40))
+		data := encode(kafka.NewRequest(1, "sarama", p))
+
+		// the request is split into segments, the second segment is retransmitted and the last two segments
+		// are reordered
+		const mss = 536
+		var segments [][]byte
+		for len(data) > mss {
+			segments = append(segments, data[:mss])
+			data = data[mss:]
+		}
+		segments = append(segments, data)
+
+		offsets := make([]uint32, len(segments))
+		var offset uint32
+		for i, s := range segments {
+			offsets[i] = offset
+			offset += uint32(len(s))
+		}
+
+		base := c.seq
+		order := []int{0, 1, 1}
+		for i := 2; i < len(segments)-2; i++ {
+			order = append(order, i)
+		}
+		order = append(order, len(segments)-1, len(segments)-2)
+		for _, i := range order {
+			c.seq = base + offsets[i]
+			c.packet(layers.TCP{PSH: true, ACK: true}, segments[i])
+		}
+		c.seq = base + offset
+
+		p2 := &kafka.ProduceRequest{Version: 3, RequiredAcks: 1, Timeout: 30000}
+		p2.AddBatch("orders", 1, batch(kafka.CompressionNone, 1))
+		c.send(kafka.NewRequest(2, "sarama", p2))
+		c.close()
+	})
+
+	gen("txn-markers", func(w *pcapgo.Writer) {
+		// transaction coordinator on another broker writes markers to leaders of partitions of transactions
+		c := dial(w, 101, 50007)
+		v0 := &kafka.WriteTxnMarkersRequest{Version: 0, Markers: []*kafka.TxnMarker{
+			{ProducerID: 4001, ProducerEpoch: 2, Commit: true, CoordinatorEpoch: 5, Topics: []kafka.TxnMarkerTopic{
+				{Topic: "orders", Partitions: []int32{0, 2}},
+				{Topic: "__consumer_offsets", Partitions: []int32{17}},
+			}},
+			{ProducerID: 4002, Commit: false, CoordinatorEpoch: 5, Topics: []kafka.TxnMarkerTopic{{Topic: "payments", Partitions: []int32{1}}}},
+		}}
+		c.send(kafka.NewRequest(1, "broker-1001-txn-marker-sender", v0))
+
+		v1 := &kafka.WriteTxnMarkersRequest{Version: 1, Markers: []*kafka.TxnMarker{
+			{ProducerID: 4001, ProducerEpoch: 2, Commit: false, CoordinatorEpoch: 5, Topics: []kafka.TxnMarkerTopic{{Topic: "orders", Partitions: []int32{1}}}},
+		}}
+		c.send(kafka.NewRequest(2, "broker-1001-txn-marker-sender", v1))
+		c.close()
+	})
+
+	gen("producer-epochs", func(w *pcapgo.Writer) {
+		// idempotent and transactional producers of a client, the transactional one bumps its epoch after an
+		// abortable error. Fencing of zombies isn't a case here: it spans connections, which are decoded
+		// concurrently, so their order isn't deterministic.
+		txnID := "billing-tx"
+		c := dial(w, 7, 50008)
+		produce := func(correlationID int32, transactionalID *string, id int64, epoch int16) {
+			p := &kafka.ProduceRequest{TransactionalID: transactionalID, Version: 8, RequiredAcks: -1, Timeout: 30000}
+			b := batch(kafka.CompressionNone, 1)
+			b.ProducerID, b.ProducerEpoch, b.FirstSequence = id, epoch, 0
+			b.IsTransactional = transactionalID != nil
+			p.AddBatch("invoices", 0, b)
+			c.send(kafka.NewRequest(correlationID, "billing", p))
+		}
+
+		produce(1, &txnID, 5001, 0)
+		produce(2, nil, 5002, 0)
+		produce(3, &txnID, 5001, 1)
+		c.close()
+	})
+
+	gen("oversized-batch", func(w *pcapgo.Writer) {
+		// golden cases run with max message size of 16 KiB, the batch of the first partition exceeds it
+		c := dial(w, 9, 50009)
+		large := batch(kafka.CompressionNone, 1)
+		large.Records[0].Value = bytes.Repeat([]byte("x"), 20<<10)
+		p := &kafka.ProduceRequest{Version: 7, RequiredAcks: -1, Timeout: 30000}
+		p.AddBatch("reports", 0, large)
+		p.AddBatch("reports", 1, batch(kafka.CompressionNone, 2))
+		c.send(kafka.NewRequest(1, "reports-exporter", p))
+		c.close()
+	})
+}
+
+// gen writes packets of a case into its pcap file
+func gen(name string, fn func(w *pcapgo.Writer)) {
+	f, err := os.Create(filepath.Join(dir, name+".pcap"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		log.Fatalln(err)
+	}
+	fn(w)
+}
+
+// dial writes handshake of a connection from client 10.0.0.host
+func dial(w *pcapgo.Writer, host byte, port layers.TCPPort) *conn {
+	c := &conn{w: w, ts: start, src: net.IPv4(10, 0, 0, host), srcPort: port, seq: 1000, ack: 5000}
+	c.packet(layers.TCP{SYN: true}, nil)
+	c.seq++
+	c.reply(layers.TCP{SYN: true, ACK: true})
+	c.ack++
+	c.packet(layers.TCP{ACK: true}, nil)
+	return c
+}
+
+// send writes request in a single segment and acknowledgement of the broker
+func (c *conn) send(req *kafka.Request) {
+	data := encode(req)
+	c.packet(layers.TCP{PSH: true, ACK: true}, data)
+	c.seq += uint32(len(data))
+	c.reply(layers.TCP{ACK: true})
+}
+
+// close writes FIN of both sides
+func (c *conn) close() {
+	c.packet(layers.TCP{FIN: true, ACK: true}, nil)
+	c.seq++
+	c.reply(layers.TCP{FIN: true, ACK: true})
+	c.ack++
+	c.packet(layers.TCP{ACK: true}, nil)
+}
+
+// packet writes segment of the client with current sequence numbers
+func (c *conn) packet(tcp layers.TCP, payload []byte) {
+	tcp.SrcPort, tcp.DstPort = c.srcPort, 9092
+	tcp.Seq, tcp.Ack = c.seq, c.ack
+	c.write(c.src, broker, tcp, payload)
+}
+
+// reply writes segment of the broker without payload, responses aren't a part of golden cases
+func (c *conn) reply(tcp layers.TCP) {
+	tcp.SrcPort, tcp.DstPort = 9092, c.srcPort
+	tcp.Seq, tcp.Ack = c.ack, c.seq
+	c.write(broker, c.src, tcp, nil)
+}
+
+func (c *conn) write(src, dst net.IP, tcp layers.TCP, payload []byte) {
+	tcp.Window = 65535
+	eth := layers.Ethernet{SrcMAC: mac(src), DstMAC: mac(dst), EthernetType: layers.EthernetTypeIPv4}
+	ip := layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+	if err := tcp.SetNetworkLayerForChecksum(&ip); err != nil {
+		log.Fatalln(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &tcp, gopacket.Payload(payload)); err != nil {
+		log.Fatalln(err)
+	}
+
+	c.ts = c.ts.Add(time.Millisecond)
+	data := buf.Bytes()
+	ci := gopacket.CaptureInfo{Timestamp: c.ts, CaptureLength: len(data), Length: len(data)}
+	if err := c.w.WritePacket(ci, data); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func mac(ip net.IP) net.HardwareAddr {
+	return net.HardwareAddr{2, 0, 0, 0, 0, ip.To4()[3]}
+}
+
+func encode(req *kafka.Request) []byte {
+	data, err := kafka.EncodeRequest(req)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return data
+}
+
+// batch returns record batch of n records with keys, values and a header
+func batch(codec kafka.CompressionCodec, n int) *kafka.RecordBatch {
+	b := &kafka.RecordBatch{
+		Version:          2,
+		Codec:            codec,
+		CompressionLevel: kafka.CompressionLevelDefault,
+		FirstTimestamp:   start,
+		MaxTimestamp:     start,
+		ProducerID:       -1,
+		ProducerEpoch:    -1,
+		FirstSequence:    -1,
+		LastOffsetDelta:  int32(n - 1),
+	}
+	for i := 0; i < n; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		b.AddRecord(&kafka.Record{
+			OffsetDelta: int64(i),
+			Key:         key,
+			Value:       []byte(`{"id":` + strconv.Itoa(i) + `,"status":"created"}`),
+			Headers:     []*kafka.RecordHeader{{Key: []byte("trace-id"), Value: []byte("4bf92f3577b34da6")}},
+		})
+	}
+	return b
+}
+
+// messageSet returns legacy message set of n messages v1, it's wrapped by a compressed message unless codec is none
+func messageSet(codec kafka.CompressionCodec, n int) *kafka.MessageSet {
+	set := &kafka.MessageSet{}
+	for i := 0; i < n; i++ {
+		set.AddMessage(&kafka.Message{Version: 1, Timestamp: start, Key: []byte{byte('a' + i)}, Value: []byte("click")})
+	}
+	if codec == kafka.CompressionNone {
+		return set
+	}
+
+	wrapper := &kafka.MessageSet{}
+	wrapper.AddMessage(&kafka.Message{Version: 1, Timestamp: start, Codec: codec, CompressionLevel: kafka.CompressionLevelDefault, Set: set})
+	return wrapper
+}